@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"dietsense/internal/api"
+	"dietsense/internal/api/handlers"
+	"dietsense/internal/jobs"
 	"dietsense/internal/repositories"
 	"dietsense/internal/repositories/postgres"
 	"dietsense/internal/repositories/sqlite"
@@ -50,8 +52,12 @@ func main() {
 	router.Use(gin.Recovery())            // Adds built-in recovery middleware
 	router.Use(logging.GinLogger(logger)) // Use custom Logrus-based logger middleware
 
+	// Start the workers running background analyses, resuming unfinished jobs
+	queue := jobs.NewQueue(db, config.Config.JobWorkers, config.Config.JobQueueSize, handlers.RunAnalysisJob(factory, db))
+	queue.Start()
+
 	// Set up API routes, pass the db instance to handlers or services
-	api.SetupRoutes(router, factory, db)
+	api.SetupRoutes(router, factory, db, queue)
 
 	// Create the HTTP server
 	server := &http.Server{
@@ -79,6 +85,10 @@ func main() {
 	if err := server.Shutdown(ctx); err != nil {
 		logger.Fatalf("Server forced to shutdown: %s", err)
 	}
+	// Let queued analyses finish; those cut short are resumed on restart
+	if err := queue.Stop(ctx); err != nil {
+		logger.Warnf("Job queue stopped before all jobs finished: %s", err)
+	}
 
 	logger.Info("Server exiting")
 }
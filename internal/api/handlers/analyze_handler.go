@@ -1,80 +1,707 @@
 package handlers
 
 import (
+	"bytes"
+	"dietsense/internal/jobs"
+	"dietsense/internal/middleware"
+	"dietsense/internal/models"
+	"dietsense/internal/nutrition"
 	"dietsense/internal/repositories"
 	"dietsense/internal/services"
+	"dietsense/pkg/apperrors"
 	"dietsense/pkg/config"
+	"dietsense/pkg/logging"
+	"dietsense/pkg/utils"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
+	"mime/multipart"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/google/uuid"
 )
 
-func AnalyzeFood(factory *services.ServiceFactory, db repositories.Database) gin.HandlerFunc {
+// Limits of the images of an /analyze request.
+const (
+	maxAnalysisImages  = 5        // Images of one item analyzed together
+	maxImageSize       = 10 << 20 // Bytes of one image
+	maxImageSizeString = "10 MB"
+
+	// Base64 takes 4 bytes for 3, and the other fields some more
+	maxJSONBodySize = maxAnalysisImages*maxImageSize*4/3 + 1<<20
+)
+
+// MaxRequestBodySize is the largest request body the analysis endpoints
+// accept: a JSON /analyze body with all its images base64 encoded.
+const MaxRequestBodySize = maxJSONBodySize
+
+// AnalyzeRequest holds the fields of an /analyze request. Forms send their
+// images as "image" and "images" files; JSON bodies send them base64 encoded
+// or as the IDs of uploaded blobs. Context is limited to 4000 characters.
+type AnalyzeRequest struct {
+	Context   string         `form:"context" json:"context" binding:"max=4000"`
+	InputType string         `form:"input_type" json:"input_type" binding:"omitempty,oneof=food_image nutrition_label barcode text"`
+	EatenAt   string         `form:"eaten_at" json:"eaten_at"`
+	MealType  string         `form:"meal_type" json:"meal_type"`
+	Save      bool           `form:"save" json:"save"`
+	Async     bool           `form:"async" json:"async"`
+	Image     string         `form:"-" json:"image"`
+	BlobID    string         `form:"-" json:"blob_id"`
+	Images    []AnalyzeImage `form:"-" json:"images"`
+}
+
+// AnalyzeImage is an image of a JSON /analyze request: either base64 data,
+// plain or as a data: URL, or the ID of a blob uploaded to /blobs.
+type AnalyzeImage struct {
+	Data   string `json:"data"`
+	BlobID string `json:"blob_id"`
+}
+
+func AnalyzeFood(factory *services.ServiceFactory, db repositories.Database, queue *jobs.Queue) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Stage 1: Determine input type
-		fileHeader, _ := c.FormFile("image")
-		inputText := c.PostForm("context")
+		// Stage 1: Read the input, one or more images of the same item
+		req, ok := readAnalysisRequest(c, db)
+		if !ok {
+			return
+		}
 
-		var inputType services.InputType
-		var err error
+		// Slow analyses can run in the background, for callers that poll or
+		// take a webhook instead of waiting
+		if req.async {
+			enqueueAnalysis(c, queue, req.apiKey, req.input)
+			return
+		}
 
-		if fileHeader == nil {
-			inputType = services.InputTypeText
-		} else {
-			// Use the image classifier service to determine input type
-			classifierService, err := factory.GetImageClassifierService()
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get image classifier service", "details": err.Error()})
-				return
-			}
+		// Stage 2: Classify and analyze the input, and keep the result
+		response, err := runAnalysis(factory, db, req.apiKey, req.input, req.dvTable, req.userConfig)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// analysisRequest is a read and validated /analyze request, with what is
+// needed to compile its response.
+type analysisRequest struct {
+	apiKey     string
+	async      bool
+	userConfig *models.UserConfig
+	dvTable    nutrition.ReferenceIntakes
+	input      models.AnalysisJobInput
+}
+
+// readAnalysisRequest reads and validates the form of an /analyze request and
+// resolves the eaten time, meal type and personal context. A request needs an
+// image or some context. It writes the error response itself.
+func readAnalysisRequest(c *gin.Context, db repositories.Database) (*analysisRequest, bool) {
+	apiKey := middleware.CurrentAPIKey(c)
+	isJSON := false
+	switch c.ContentType() {
+	case binding.MIMEJSON:
+		isJSON = true
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxJSONBodySize)
+	case binding.MIMEMultipartPOSTForm, binding.MIMEPOSTForm, "":
+	default:
+		c.Error(apperrors.New(apperrors.UnsupportedMediaType, "Send the request as multipart/form-data, application/x-www-form-urlencoded or application/json"))
+		return nil, false
+	}
+
+	var form AnalyzeRequest
+	if err := c.ShouldBind(&form); err != nil {
+		c.Error(bindingError(err))
+		return nil, false
+	}
+	meal, problem := newMealFields(form.EatenAt, form.MealType)
+	if problem != nil {
+		c.Error(problem)
+		return nil, false
+	}
+	var images [][]byte
+	var err error
+	if isJSON {
+		images, err = jsonImages(db, apiKey, form)
+	} else {
+		images, err = readUploads(c, "image", "images")
+	}
+	if err != nil {
+		c.Error(err)
+		return nil, false
+	}
+	if len(images) == 0 && strings.TrimSpace(form.Context) == "" {
+		c.Error(invalidField("context", "is required when no image is sent"))
+		return nil, false
+	}
+	// The client may know what its images show, sparing their classification
+	if form.InputType == services.InputTypeText.String() && len(images) > 0 {
+		c.Error(invalidField("input_type", "must be an image type when images are sent"))
+		return nil, false
+	}
+	if form.InputType != "" && form.InputType != services.InputTypeText.String() && len(images) == 0 {
+		c.Error(invalidField("input_type", "must be text when no image is sent"))
+		return nil, false
+	}
+
+	dvRegion, dvTable, err := resolveDailyValues(c, db)
+	if err != nil {
+		c.Error(err)
+		return nil, false
+	}
+
+	// Saving to the food log is opt-in and needs an owner
+	saveToLog := form.Save
+	if saveToLog && (apiKey == "" || db == nil) {
+		c.Error(apperrors.New(apperrors.Unauthorized, "An API key is required to save to the food log"))
+		return nil, false
+	}
+
+	var userConfig *models.UserConfig
+	if apiKey != "" && db != nil {
+		if userConfig, err = loadUserConfig(db, apiKey); err != nil {
+			logging.Log.Error("Failed to load user config: ", err)
+		}
+	}
+	loc, err := analysisLocation(c, userConfig)
+	if err != nil {
+		c.Error(apperrors.New(apperrors.InvalidInput, err.Error()))
+		return nil, false
+	}
+	meal.resolve(images, loc, mealLocale(c, userConfig))
+
+	return &analysisRequest{
+		apiKey:     apiKey,
+		async:      form.Async,
+		userConfig: userConfig,
+		dvTable:    dvTable,
+		input: models.AnalysisJobInput{
+			Images:           images,
+			InputType:        form.InputType,
+			Context:          form.Context,
+			Instructions:     analysisInstructions(c, db, apiKey, userConfig, loc),
+			EatenAt:          meal.EatenAt,
+			EatenAtSource:    meal.EatenAtSource,
+			MealType:         meal.MealType,
+			MealTypeSource:   meal.MealTypeSource,
+			Save:             saveToLog,
+			DailyValueRegion: dvRegion,
+		},
+	}, true
+}
+
+// runAnalysis analyzes the input of an /analyze request and compiles the
+// response. It runs during the request or later as a job. Errors are
+// *apperrors.Error.
+func runAnalysis(factory *services.ServiceFactory, db repositories.Database, apiKey string, input models.AnalysisJobInput, dvTable nutrition.ReferenceIntakes, userConfig *models.UserConfig) (map[string]interface{}, error) {
+	promptContext := services.PromptContext{Instructions: input.Instructions, Text: input.Context}
+	result, labeled, err := analyzeInput(factory, input.Images, input.InputType, promptContext)
+	if err != nil {
+		return nil, err
+	}
+	return completeAnalysis(db, apiKey, input, result, labeled, dvTable, userConfig)
+}
+
+// completeAnalysis compiles the response for an analysis result. For
+// authenticated callers it keeps the analysis, so it can be refined later,
+// and the food log entry if one was asked for. Errors are *apperrors.Error.
+func completeAnalysis(db repositories.Database, apiKey string, input models.AnalysisJobInput, result *services.AnalysisResult, labeled []services.LabeledImage, dvTable nutrition.ReferenceIntakes, userConfig *models.UserConfig) (map[string]interface{}, error) {
+	promptContext := services.PromptContext{Instructions: input.Instructions, Text: input.Context}
+	meal := mealFields{EatenAt: input.EatenAt, EatenAtSource: input.EatenAtSource, MealType: input.MealType, MealTypeSource: input.MealTypeSource}
+	imageData := primaryImageData(labeled)
+	response := analysisResponse(result, labeled, meal, input.DailyValueRegion, dvTable, userConfig)
+
+	analysisID := saveAnalysisRecord(db, apiKey, promptContext, imageData, result)
+	if analysisID != "" {
+		response["analysis_id"] = analysisID
+	}
+	if input.Save {
+		entry := newFoodLogEntry(apiKey, analysisID, meal.EatenAt, meal.MealType, imageData, result)
+		if err := db.SaveFoodLogEntry(entry); err != nil {
+			return nil, apperrors.Wrap(err, "Failed to save food log entry")
+		}
+		response["log_entry_id"] = entry.ID
+	}
+	return response, nil
+}
+
+// analyzeInput runs the analysis pipeline on one input. Images are
+// classified first, unless the client gave their input type: a single image
+// goes to the analyzer for its type, several go together to the analyzer for
+// the type that takes precedence. Without images the input is analyzed as
+// text. It also returns the labeled images; errors are *apperrors.Error.
+func analyzeInput(factory *services.ServiceFactory, images [][]byte, inputType string, promptContext services.PromptContext) (*services.AnalysisResult, []services.LabeledImage, error) {
+	labeled, err := classifyImages(factory, images, inputType)
+	if err != nil {
+		return nil, nil, err
+	}
+	analyzerService, err := analyzerFor(factory, labeled)
+	if err != nil {
+		return nil, nil, err
+	}
+	result, err := analyzeLabeled(analyzerService, labeled, promptContext)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, labeled, nil
+}
+
+// analyzeLabeled sends classified images, or the text when there are none,
+// to an analyzer. Errors are *apperrors.Error.
+func analyzeLabeled(analyzerService services.FoodAnalysisService, labeled []services.LabeledImage, promptContext services.PromptContext) (*services.AnalysisResult, error) {
+	var result *services.AnalysisResult
+	var err error
+	switch len(labeled) {
+	case 0:
+		result, err = analyzerService.AnalyzeFoodText(promptContext)
+	case 1:
+		result, err = analyzerService.AnalyzeFood(bytes.NewReader(labeled[0].Data), promptContext, labeled[0].InputType)
+	default:
+		analyzer, ok := analyzerService.(services.MultiImageAnalyzer)
+		if !ok {
+			return nil, apperrors.Wrap(errors.New("the analyzer service cannot analyze several images at once"), "Failed to analyze")
+		}
+		result, err = analyzer.AnalyzeFoodImages(labeled, promptContext)
+	}
+	if err != nil {
+		return nil, apperrors.Wrap(err, "Failed to analyze")
+	}
+	return result, nil
+}
+
+// analyzedType is the input type an analysis is made for: text without
+// images, otherwise the type of the image that takes precedence.
+func analyzedType(labeled []services.LabeledImage) services.InputType {
+	if len(labeled) == 0 {
+		return services.InputTypeText
+	}
+	return labeled[services.PrimaryImage(labeled)].InputType
+}
+
+// analyzerFor returns the analyzer configured for the analyzed type.
+func analyzerFor(factory *services.ServiceFactory, labeled []services.LabeledImage) (services.FoodAnalysisService, error) {
+	analyzerService, err := factory.GetAnalyzerService(analyzedType(labeled))
+	if err != nil {
+		return nil, apperrors.Wrap(err, "Failed to get analyzer service")
+	}
+	return analyzerService, nil
+}
 
-			file, err := fileHeader.Open()
-			if err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot open uploaded file"})
-				return
+// classifyImages labels each image with its input type. The type the client
+// gave applies to all of them; otherwise the classification strategy decides
+// whether the classifier service is asked, for all images at the same time,
+// the type is left to the analyzer, or the local classifier tries first and
+// only the images it is unsure of go to the classifier service.
+func classifyImages(factory *services.ServiceFactory, images [][]byte, inputType string) ([]services.LabeledImage, error) {
+	if len(images) == 0 {
+		return nil, nil
+	}
+	labeled := make([]services.LabeledImage, len(images))
+	for i := range images {
+		labeled[i].Data = images[i]
+	}
+	if hint, ok := services.ParseInputType(inputType); ok {
+		return labelImages(labeled, hint, services.ClassifiedByHint), nil
+	}
+
+	pending := make([]int, 0, len(images))
+	switch factory.Config.ClassificationStrategy {
+	case "", services.ClassifiedByClassifier:
+		for i := range images {
+			pending = append(pending, i)
+		}
+	case services.ClassifiedByAnalyzer:
+		return labelImages(labeled, services.InputTypeUnknown, services.ClassifiedByAnalyzer), nil
+	case services.ClassifiedByLocal:
+		local := services.NewLocalClassifier()
+		for i := range images {
+			inputType, sure := local.Classify(images[i])
+			if !sure {
+				pending = append(pending, i)
+				continue
 			}
-			defer file.Close()
+			labeled[i].InputType, labeled[i].ClassifiedBy = inputType, services.ClassifiedByLocal
+		}
+	default:
+		return nil, apperrors.Wrap(fmt.Errorf("unknown classification strategy: %s", factory.Config.ClassificationStrategy), "Failed to classify image")
+	}
+	if len(pending) == 0 {
+		return labeled, nil
+	}
 
-			inputType, err = classifierService.ClassifyImage(file)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to classify image", "details": err.Error()})
-				return
+	classifierService, err := factory.GetImageClassifierService()
+	if err != nil {
+		return nil, apperrors.Wrap(err, "Failed to get image classifier service")
+	}
+	errs := make([]error, len(pending))
+	forEachConcurrently(len(pending), len(pending), func(n int) {
+		i := pending[n]
+		// Classifiers run outside the request goroutine, where a panic
+		// would not reach the recovery middleware
+		defer func() {
+			if r := recover(); r != nil {
+				logging.Log.Error("Image classifier panicked: ", r)
+				errs[n] = apperrors.New(apperrors.ParseFailed, "The image classifier's answer could not be read")
 			}
+		}()
+		labeled[i].ClassifiedBy = services.ClassifiedByClassifier
+		labeled[i].InputType, errs[n] = classifierService.ClassifyImage(bytes.NewReader(images[i]))
+	})
+	for _, err := range errs {
+		if err != nil {
+			return nil, apperrors.Wrap(err, "Failed to classify image")
+		}
+	}
+	return labeled, nil
+}
+
+// labelImages gives every image the same input type.
+func labelImages(labeled []services.LabeledImage, inputType services.InputType, classifiedBy string) []services.LabeledImage {
+	for i := range labeled {
+		labeled[i].InputType = inputType
+		labeled[i].ClassifiedBy = classifiedBy
+	}
+	return labeled
+}
+
+// primaryImageData returns the image kept with an analysis and its food log
+// entry: the one whose data took precedence, or nil for text.
+func primaryImageData(labeled []services.LabeledImage) []byte {
+	if len(labeled) == 0 {
+		return nil
+	}
+	return labeled[services.PrimaryImage(labeled)].Data
+}
+
+// readUploads reads the files of the given multipart form fields, at most
+// maxAnalysisImages in all. Requests that are not multipart have none.
+func readUploads(c *gin.Context, fields ...string) ([][]byte, error) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, nil
+	}
+	var fileHeaders []*multipart.FileHeader
+	for _, field := range fields {
+		fileHeaders = append(fileHeaders, form.File[field]...)
+	}
+	return readFiles(fileHeaders)
+}
+
+// readFiles reads uploaded files, at most maxAnalysisImages of them and none
+// larger than maxImageSize. Errors are *apperrors.Error.
+func readFiles(fileHeaders []*multipart.FileHeader) ([][]byte, error) {
+	if len(fileHeaders) > maxAnalysisImages {
+		return nil, invalidField("image", fmt.Sprintf("must be at most %d images", maxAnalysisImages))
+	}
+	images := make([][]byte, 0, len(fileHeaders))
+	for _, fileHeader := range fileHeaders {
+		if err := checkImageSize("image", fmt.Sprintf("%q", fileHeader.Filename), fileHeader.Size); err != nil {
+			return nil, err
+		}
+		data, err := readUpload(fileHeader)
+		if err != nil {
+			return nil, err
 		}
+		images = append(images, data)
+	}
+	return images, nil
+}
+
+// checkImageSize rejects an empty image or one larger than maxImageSize.
+func checkImageSize(field, name string, size int64) error {
+	if size > maxImageSize {
+		return apperrors.New(apperrors.PayloadTooLarge, fmt.Sprintf("Image %s is larger than %s", name, maxImageSizeString)).
+			With("fields", map[string]string{field: "must be at most " + maxImageSizeString})
+	}
+	if size == 0 {
+		return invalidField(field, name+" is empty")
+	}
+	return nil
+}
+
+// jsonImages decodes the images of a JSON /analyze request: "image" and
+// "blob_id" come first, then "images". Blob IDs are looked up among the
+// caller's uploads. Errors are *apperrors.Error.
+func jsonImages(db repositories.Database, apiKey string, req AnalyzeRequest) ([][]byte, error) {
+	type source struct {
+		field string
+		AnalyzeImage
+	}
+	var sources []source
+	if req.Image != "" {
+		sources = append(sources, source{"image", AnalyzeImage{Data: req.Image}})
+	}
+	if req.BlobID != "" {
+		sources = append(sources, source{"blob_id", AnalyzeImage{BlobID: req.BlobID}})
+	}
+	for _, image := range req.Images {
+		sources = append(sources, source{"images", image})
+	}
+	if len(sources) > maxAnalysisImages {
+		return nil, invalidField("images", fmt.Sprintf("must be at most %d images", maxAnalysisImages))
+	}
 
-		// Stage 2: Analyze input based on type
-		analyzerService, err := factory.GetAnalyzerService(inputType)
+	images := make([][]byte, 0, len(sources))
+	for i, src := range sources {
+		var data []byte
+		var err error
+		switch {
+		case src.Data != "" && src.BlobID != "":
+			return nil, invalidField(src.field, "must each have either data or a blob_id, not both")
+		case src.Data != "":
+			data, err = decodeImageData(src.field, src.Data)
+		case src.BlobID != "":
+			data, err = loadBlob(db, apiKey, src.BlobID)
+		default:
+			return nil, invalidField(src.field, "must each have data or a blob_id")
+		}
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get analyzer service", "details": err.Error()})
-			return
+			return nil, err
+		}
+		if err := checkImageSize(src.field, fmt.Sprintf("%d", i+1), int64(len(data))); err != nil {
+			return nil, err
+		}
+		images = append(images, data)
+	}
+	return images, nil
+}
+
+// decodeImageData decodes a base64 image, given plain or as a data: URL.
+func decodeImageData(field, value string) ([]byte, error) {
+	if rest, ok := strings.CutPrefix(value, "data:"); ok {
+		mediaType, data, found := strings.Cut(rest, ",")
+		mediaType, isBase64 := strings.CutSuffix(mediaType, ";base64")
+		if !found || !isBase64 {
+			return nil, invalidField(field, "must be base64 encoded")
+		}
+		if !strings.HasPrefix(mediaType, "image/") {
+			return nil, apperrors.New(apperrors.UnsupportedMediaType, fmt.Sprintf("Only images can be analyzed, not %s", mediaType)).
+				With("fields", map[string]string{field: "must be an image"})
+		}
+		value = data
+	}
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, invalidField(field, "must be base64 encoded")
+	}
+	return data, nil
+}
+
+// readUpload reads an uploaded file into memory.
+func readUpload(fileHeader *multipart.FileHeader) ([]byte, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, apperrors.New(apperrors.InvalidInput, "Cannot open uploaded file").WithCause(err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, apperrors.New(apperrors.InvalidInput, "Cannot read uploaded file").WithCause(err)
+	}
+	return data, nil
+}
+
+// analysisResponse is the response body for an analysis result, with the
+// label of each image when there are several, %DV when a region applies and
+// alerts for the caller's allergies.
+func analysisResponse(result *services.AnalysisResult, labeled []services.LabeledImage, meal mealFields, dvRegion string, dvTable nutrition.ReferenceIntakes, userConfig *models.UserConfig) map[string]interface{} {
+	response := map[string]interface{}{
+		"nutrition_info":   result.NutritionInfo,
+		"summary":          result.Summary,
+		"confidence":       result.Confidence,
+		"input_type":       result.InputType,
+		"service":          result.Service,
+		"ingredients":      result.Ingredients,
+		"allergens":        result.Allergens,
+		"diets":            result.Diets,
+		"eaten_at":         meal.EatenAt,
+		"eaten_at_source":  meal.EatenAtSource,
+		"meal_type":        meal.MealType,
+		"meal_type_source": meal.MealTypeSource,
+	}
+	if len(labeled) > 0 {
+		response["classification"] = labeled[services.PrimaryImage(labeled)].ClassifiedBy
+	}
+	if len(labeled) > 1 {
+		response["images"] = imageLabels(labeled)
+	}
+	if dvTable != nil {
+		response["nutrition_info"] = result.NutritionInfo.WithDailyValues(dvTable)
+		response["daily_value_region"] = dvRegion
+	}
+	if userConfig != nil {
+		if alerts := services.AllergyAlerts(result.Allergens, userConfig.Allergies); len(alerts) > 0 {
+			response["allergy_alerts"] = alerts
 		}
+	}
+	return response
+}
 
-		var result *services.AnalysisResult
-		context := fmt.Sprintf("%s\n%s", inputText, config.Config.ContextString)
+// imageLabels lists the input type of each image and how it was decided.
+func imageLabels(labeled []services.LabeledImage) []gin.H {
+	images := make([]gin.H, len(labeled))
+	for i, image := range labeled {
+		images[i] = gin.H{"index": i, "input_type": image.InputType, "classified_by": image.ClassifiedBy}
+	}
+	return images
+}
 
-		if inputType == services.InputTypeText {
-			result, err = analyzerService.AnalyzeFoodText(context)
+// saveAnalysisRecord stores the analysis for an authenticated caller and
+// returns its ID, or "" when there is no caller or it could not be saved.
+func saveAnalysisRecord(db repositories.Database, apiKey string, promptContext services.PromptContext, imageData []byte, result *services.AnalysisResult) string {
+	if apiKey == "" || db == nil {
+		return ""
+	}
+	analysis := newAnalysisRecord(apiKey, promptContext, imageData, result)
+	if err := db.SaveAnalysis(analysis); err != nil {
+		logging.Log.Error("Failed to save analysis: ", err)
+		return ""
+	}
+	// Full images past their retention are dropped as new ones come in
+	if retention := config.Config.AnalysisImageRetention; retention > 0 {
+		if err := db.ClearAnalysisImagesBefore(time.Now().Add(-retention)); err != nil {
+			logging.Log.Error("Failed to clear analysis images: ", err)
+		}
+	}
+	return analysis.ID
+}
+
+// analysisInstructions combines the configured context string with the
+// caller's profile, goal progress and preferences, unless they opted out.
+// Personal context is best effort: what cannot be loaded is left out.
+func analysisInstructions(c *gin.Context, db repositories.Database, apiKey string, userConfig *models.UserConfig, loc *time.Location) string {
+	instructions := []string{}
+	if config.Config.ContextString != "" {
+		instructions = append(instructions, config.Config.ContextString)
+	}
+	if userConfig == nil || userConfig.DisablePersonalContext {
+		return strings.Join(instructions, "\n")
+	}
+
+	personal := services.PersonalContext{Allergies: userConfig.Allergies, Units: userConfig.Units, Language: userConfig.Language}
+	if profile, err := db.GetProfile(apiKey); err == nil {
+		personal.Profile = profile
+	} else if !errors.Is(err, repositories.ErrNotFound) {
+		logging.Log.Error("Failed to load profile: ", err)
+	}
+	if goals, err := db.GetGoals(apiKey); err != nil {
+		logging.Log.Error("Failed to load goals: ", err)
+	} else if len(goals) > 0 {
+		if summary, err := summarizeDay(db, apiKey, time.Now().In(loc)); err == nil {
+			personal.Progress = services.EvaluateGoals(goals, summary.Totals)
 		} else {
-			file, _ := fileHeader.Open()
-			defer file.Close()
-			result, err = analyzerService.AnalyzeFood(file, context, inputType)
+			logging.Log.Error("Failed to summarize food log: ", err)
 		}
+	}
+	if text := personal.Instructions(); text != "" {
+		instructions = append(instructions, text)
+	}
+	return strings.Join(instructions, "\n")
+}
+
+// Where the eaten time and meal type of an analysis came from.
+const (
+	sourceRequest  = "request"
+	sourceEXIF     = "exif"
+	sourceReceived = "received"
+	sourceInferred = "inferred"
+)
+
+// mealFields are when the analyzed food was eaten and which meal it was.
+type mealFields struct {
+	EatenAt        time.Time
+	EatenAtSource  string
+	MealType       string
+	MealTypeSource string
+}
 
+// newMealFields validates the eaten time (RFC 3339, with a time zone offset)
+// and meal type given by the caller, either of which may be empty.
+func newMealFields(eatenAt, mealType string) (mealFields, *apperrors.Error) {
+	var meal mealFields
+	if eatenAt != "" {
+		parsed, err := time.Parse(time.RFC3339, eatenAt)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to analyze", "details": err.Error()})
-			return
+			return meal, invalidField("eaten_at", "must be an RFC 3339 timestamp with a time zone offset, e.g. 2024-05-01T12:30:00+02:00")
+		}
+		meal.EatenAt, meal.EatenAtSource = parsed, sourceRequest
+	}
+	if mealType != "" {
+		if !models.ValidMealType(mealType) {
+			return meal, invalidField("meal_type", "must be one of "+strings.Join(models.MealTypes, ", ")).With("allowed", models.MealTypes)
 		}
+		meal.MealType, meal.MealTypeSource = mealType, sourceRequest
+	}
+	return meal, nil
+}
 
-		// Stage 3: Compile and send response
-		response := map[string]interface{}{
-			"nutrition_info": result.NutritionInfo,
-			"summary":        result.Summary,
-			"confidence":     result.Confidence,
-			"input_type":     result.InputType,
-			"service":        result.Service,
+// resolve fills in what the request left out: the eaten time from the first
+// EXIF capture time among the images or else the current time, and the meal type from
+// the local time of day. EXIF times without an offset are taken to be in loc.
+func (m *mealFields) resolve(images [][]byte, loc *time.Location, locale string) {
+	if m.EatenAtSource == "" {
+		now := time.Now().In(loc)
+		m.EatenAt, m.EatenAtSource = now, sourceReceived
+		for _, imageData := range images {
+			if taken, ok := utils.ImageCaptureTime(imageData, loc); ok && !taken.After(now) {
+				m.EatenAt, m.EatenAtSource = taken, sourceEXIF
+				break
+			}
 		}
+	}
+	if m.MealTypeSource == "" {
+		m.MealType, m.MealTypeSource = services.InferMealType(m.EatenAt, locale), sourceInferred
+	}
+}
 
-		c.JSON(http.StatusOK, response)
+// analysisLocation returns the caller's time zone: the "tz" query parameter,
+// then the time zone saved in their config, then UTC.
+func analysisLocation(c *gin.Context, userConfig *models.UserConfig) (*time.Location, error) {
+	if c.Query("tz") == "" && userConfig != nil && userConfig.TimeZone != "" {
+		if loc, err := time.LoadLocation(userConfig.TimeZone); err == nil {
+			return loc, nil
+		}
+	}
+	return requestLocation(c)
+}
+
+// mealLocale returns the locale whose meal times apply: the caller's saved
+// language, then the first language of the Accept-Language header.
+func mealLocale(c *gin.Context, userConfig *models.UserConfig) string {
+	if userConfig != nil && userConfig.Language != "" {
+		return userConfig.Language
+	}
+	first, _, _ := strings.Cut(c.GetHeader("Accept-Language"), ",")
+	first, _, _ = strings.Cut(first, ";")
+	return strings.TrimSpace(first)
+}
+
+// newAnalysisRecord builds the stored revision for an analysis result.
+func newAnalysisRecord(apiKey string, context services.PromptContext, imageData []byte, result *services.AnalysisResult) *models.Analysis {
+	analysis := &models.Analysis{
+		ID:            uuid.New().String(),
+		APIKey:        apiKey,
+		InputType:     int(result.InputType),
+		Service:       result.Service,
+		Model:         result.Model,
+		Instructions:  context.Instructions,
+		Context:       context.Text,
+		Summary:       result.Summary,
+		NutritionInfo: result.NutritionInfo,
+		Confidence:    result.Confidence,
+		Ingredients:   result.Ingredients,
+		Allergens:     result.Allergens,
+		Diets:         result.Diets,
+		RawResponse:   result.RawResponse,
+		CreatedAt:     time.Now(),
+	}
+	analysis.ImageHash, analysis.Thumbnail = imageDigest(imageData)
+	if config.Config.AnalysisImageRetention > 0 {
+		analysis.Image = imageData
 	}
+	return analysis
 }
@@ -3,7 +3,7 @@ package handlers
 import (
 	"dietsense/internal/models"
 	"dietsense/internal/repositories"
-	"dietsense/pkg/logging"
+	"dietsense/pkg/apperrors"
 	"net/http"
 	"time"
 
@@ -14,6 +14,7 @@ import (
 type APIKeyRequest struct {
 	Email            string `json:"email" binding:"required"`
 	RateLimitPerHour int    `json:"rate_limit_per_hour" binding:"required"`
+	CallbackURL      string `json:"callback_url"` // Optional webhook for background analyses
 }
 
 // GenerateAPIKey generates a new API key and saves it to the database.
@@ -21,9 +22,15 @@ func GenerateAPIKey(db repositories.Database) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req APIKeyRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			c.Error(bindingError(err))
 			return
 		}
+		if req.CallbackURL != "" {
+			if err := validateCallbackURL(c.Request.Context(), req.CallbackURL); err != nil {
+				c.Error(apperrors.New(apperrors.InvalidInput, err.Error()))
+				return
+			}
+		}
 
 		key := uuid.New().String()
 		apiKey := &models.APIKey{
@@ -32,13 +39,23 @@ func GenerateAPIKey(db repositories.Database) gin.HandlerFunc {
 			RateLimitPerHour: req.RateLimitPerHour,
 			CreatedAt:        time.Now(),
 		}
+		if req.CallbackURL != "" {
+			if err := setWebhook(apiKey, req.CallbackURL); err != nil {
+				c.Error(apperrors.Wrap(err, "Failed to save API key"))
+				return
+			}
+		}
 
 		if err := db.SaveAPIKey(apiKey); err != nil {
-			logging.Log.Error("Failed to save API key: ", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save API key"})
+			c.Error(apperrors.Wrap(err, "Failed to save API key"))
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"api_key": key})
+		response := gin.H{"api_key": key}
+		if apiKey.CallbackURL != "" {
+			response["callback_url"] = apiKey.CallbackURL
+			response["webhook_secret"] = apiKey.WebhookSecret
+		}
+		c.JSON(http.StatusOK, response)
 	}
 }
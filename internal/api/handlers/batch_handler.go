@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"dietsense/internal/middleware"
+	"dietsense/internal/models"
+	"dietsense/internal/nutrition"
+	"dietsense/internal/repositories"
+	"dietsense/internal/services"
+	"dietsense/pkg/apperrors"
+	"dietsense/pkg/logging"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Used when the config leaves the batch limits unset.
+const (
+	defaultBatchWorkers  = 4
+	defaultBatchMaxItems = 20
+)
+
+// BatchAnalysisRequest is the JSON form of a batch of text items. Batches
+// with images are sent as multipart forms instead, with the items in an
+// "items" field and each image in the file field named by its item.
+type BatchAnalysisRequest struct {
+	Items []BatchItemRequest `json:"items"`
+	Save  bool               `json:"save"` // Record every result in the food log
+}
+
+// BatchItemRequest is one input of a batch: an image, text context, or both.
+type BatchItemRequest struct {
+	Image    string `json:"image"` // Name of the multipart file field holding the image, or several images of the item
+	Context  string `json:"context"`
+	EatenAt  string `json:"eaten_at"`
+	MealType string `json:"meal_type"`
+}
+
+// batchItem is a batch input ready to be analyzed.
+type batchItem struct {
+	request BatchItemRequest
+	images  []*multipart.FileHeader
+}
+
+// AnalyzeBatch analyzes many inputs in one request, several at a time. Each
+// item gets its own result or error, in the order sent; a failed item does
+// not fail the others.
+func AnalyzeBatch(factory *services.ServiceFactory, db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		items, saveToLog, err := parseBatchItems(c)
+		if err != nil {
+			c.Error(apperrors.New(apperrors.InvalidInput, err.Error()))
+			return
+		}
+		maxItems := factory.Config.BatchMaxItems
+		if maxItems <= 0 {
+			maxItems = defaultBatchMaxItems
+		}
+		if len(items) == 0 {
+			c.Error(apperrors.New(apperrors.InvalidInput, "A batch needs at least one item"))
+			return
+		}
+		if len(items) > maxItems {
+			c.Error(apperrors.New(apperrors.InvalidInput, fmt.Sprintf("A batch can have at most %d items", maxItems)))
+			return
+		}
+
+		dvRegion, dvTable, err := resolveDailyValues(c, db)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		apiKey := middleware.CurrentAPIKey(c)
+		if saveToLog && (apiKey == "" || db == nil) {
+			c.Error(apperrors.New(apperrors.Unauthorized, "An API key is required to save to the food log"))
+			return
+		}
+		var userConfig *models.UserConfig
+		if apiKey != "" && db != nil {
+			if userConfig, err = loadUserConfig(db, apiKey); err != nil {
+				logging.Log.Error("Failed to load user config: ", err)
+			}
+		}
+		loc, err := analysisLocation(c, userConfig)
+		if err != nil {
+			c.Error(apperrors.New(apperrors.InvalidInput, err.Error()))
+			return
+		}
+
+		// The gin context is not safe to share, so everything the items need
+		// from the request is worked out here
+		batch := batchAnalysis{
+			factory:      factory,
+			db:           db,
+			apiKey:       apiKey,
+			saveToLog:    saveToLog,
+			userConfig:   userConfig,
+			loc:          loc,
+			locale:       mealLocale(c, userConfig),
+			instructions: analysisInstructions(c, db, apiKey, userConfig, loc),
+			dvRegion:     dvRegion,
+			dvTable:      dvTable,
+		}
+
+		workers := factory.Config.BatchWorkers
+		if workers <= 0 {
+			workers = defaultBatchWorkers
+		}
+		results := make([]gin.H, len(items))
+		forEachConcurrently(len(items), workers, func(i int) {
+			results[i] = batch.analyzeItem(items[i])
+			results[i]["index"] = i
+		})
+
+		failed := 0
+		for _, result := range results {
+			if result["status"] != http.StatusOK {
+				failed++
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"items": results, "succeeded": len(items) - failed, "failed": failed})
+	}
+}
+
+// parseBatchItems reads the items of a JSON or multipart batch request. A
+// multipart request without an "items" field has one item per file in its
+// "images" field.
+func parseBatchItems(c *gin.Context) ([]batchItem, bool, error) {
+	if c.ContentType() == gin.MIMEJSON {
+		var req BatchAnalysisRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			return nil, false, err
+		}
+		items := make([]batchItem, len(req.Items))
+		for i, item := range req.Items {
+			items[i] = batchItem{request: item}
+		}
+		return items, req.Save, nil
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, false, errors.New("A batch is sent as JSON or as a multipart form")
+	}
+	saveToLog, _ := strconv.ParseBool(c.PostForm("save"))
+	value := c.PostForm("items")
+	if value == "" {
+		items := make([]batchItem, len(form.File["images"]))
+		for i, image := range form.File["images"] {
+			items[i] = batchItem{request: BatchItemRequest{Image: "images"}, images: []*multipart.FileHeader{image}}
+		}
+		return items, saveToLog, nil
+	}
+
+	var requests []BatchItemRequest
+	if err := json.Unmarshal([]byte(value), &requests); err != nil {
+		return nil, false, fmt.Errorf("Invalid items: %v", err)
+	}
+	items := make([]batchItem, len(requests))
+	for i, request := range requests {
+		items[i] = batchItem{request: request}
+		if request.Image != "" {
+			items[i].images = form.File[request.Image]
+		}
+	}
+	return items, saveToLog, nil
+}
+
+// batchAnalysis is what the items of a batch share.
+type batchAnalysis struct {
+	factory      *services.ServiceFactory
+	db           repositories.Database
+	apiKey       string
+	saveToLog    bool
+	userConfig   *models.UserConfig
+	loc          *time.Location
+	locale       string
+	instructions string
+	dvRegion     string
+	dvTable      nutrition.ReferenceIntakes
+}
+
+// analyzeItem runs one item through the same pipeline as AnalyzeFood and
+// returns its result, or its error, with the HTTP status it would have had
+// on its own.
+func (b *batchAnalysis) analyzeItem(item batchItem) (response gin.H) {
+	defer func() {
+		if r := recover(); r != nil {
+			logging.Log.Error("Batch item panicked: ", r)
+			response = itemError(apperrors.New(apperrors.Internal, "Failed to analyze"))
+		}
+	}()
+
+	meal, problem := newMealFields(item.request.EatenAt, item.request.MealType)
+	if problem != nil {
+		return itemError(problem)
+	}
+	if item.request.Image != "" && len(item.images) == 0 {
+		return itemError(apperrors.New(apperrors.InvalidInput, fmt.Sprintf("No file in the %q field", item.request.Image)))
+	}
+	if len(item.images) == 0 && item.request.Context == "" {
+		return itemError(apperrors.New(apperrors.InvalidInput, "An item needs an image or context"))
+	}
+
+	images, err := readFiles(item.images)
+	if err != nil {
+		return itemError(err)
+	}
+	meal.resolve(images, b.loc, b.locale)
+
+	input := models.AnalysisJobInput{
+		Images:           images,
+		Context:          item.request.Context,
+		Instructions:     b.instructions,
+		EatenAt:          meal.EatenAt,
+		EatenAtSource:    meal.EatenAtSource,
+		MealType:         meal.MealType,
+		MealTypeSource:   meal.MealTypeSource,
+		Save:             b.saveToLog,
+		DailyValueRegion: b.dvRegion,
+	}
+	response, err = runAnalysis(b.factory, b.db, b.apiKey, input, b.dvTable, b.userConfig)
+	if err != nil {
+		return itemError(err)
+	}
+	response["status"] = http.StatusOK
+	return response
+}
+
+// itemError is the result of a failed batch item: the error as an error
+// response has it, with the status the item would have been answered with.
+func itemError(err error) gin.H {
+	response := middleware.ErrorFields(err)
+	response["status"] = apperrors.Status(apperrors.CodeOf(err))
+	return response
+}
+
+// forEachConcurrently calls fn for 0 to n-1 with at most workers calls
+// running at once, and returns when all are done.
+func forEachConcurrently(n, workers int, fn func(i int)) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"dietsense/internal/middleware"
+	"dietsense/internal/models"
+	"dietsense/internal/repositories"
+	"dietsense/pkg/apperrors"
+	"dietsense/pkg/config"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// UploadBlob stores the "image" file of a multipart form so that JSON
+// /analyze requests can refer to it by blob_id. Blobs belong to the caller's
+// API key and expire after the configured retention.
+func UploadBlob(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		images, err := readUploads(c, "image")
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		if len(images) != 1 {
+			c.Error(invalidField("image", "must be one image"))
+			return
+		}
+
+		now := time.Now().UTC()
+		retention := blobRetention()
+		// Expired blobs are dropped as new ones come in
+		if err := db.DeleteBlobsBefore(now.Add(-retention)); err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to store image"))
+			return
+		}
+		blob := &models.Blob{
+			ID:        uuid.New().String(),
+			APIKey:    middleware.CurrentAPIKey(c),
+			Data:      images[0],
+			Size:      len(images[0]),
+			CreatedAt: now,
+		}
+		if err := db.SaveBlob(blob); err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to store image"))
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{
+			"blob_id":    blob.ID,
+			"size":       blob.Size,
+			"expires_at": blob.CreatedAt.Add(retention),
+		})
+	}
+}
+
+// loadBlob returns the data of one of the caller's unexpired blobs.
+func loadBlob(db repositories.Database, apiKey, id string) ([]byte, error) {
+	if apiKey == "" || db == nil {
+		return nil, apperrors.New(apperrors.Unauthorized, "An API key is required to analyze uploaded blobs")
+	}
+	blob, err := db.GetBlob(apiKey, id)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return nil, apperrors.New(apperrors.NotFound, fmt.Sprintf("Blob %q not found", id))
+	}
+	if err != nil {
+		return nil, apperrors.Wrap(err, "Failed to load blob")
+	}
+	if blob.CreatedAt.Before(time.Now().Add(-blobRetention())) {
+		return nil, apperrors.New(apperrors.NotFound, fmt.Sprintf("Blob %q has expired", id))
+	}
+	return blob.Data, nil
+}
+
+// blobRetention is how long uploaded blobs can be referred to.
+func blobRetention() time.Duration {
+	if config.Config.BlobRetention > 0 {
+		return config.Config.BlobRetention
+	}
+	return 24 * time.Hour
+}
@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"dietsense/internal/middleware"
+	"dietsense/internal/models"
+	"dietsense/internal/nutrition"
+	"dietsense/internal/repositories"
+	"dietsense/internal/services"
+	"dietsense/pkg/apperrors"
+	"dietsense/pkg/config"
+	"dietsense/pkg/logging"
+	"dietsense/pkg/utils"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// newFoodLogEntry builds the food log entry for an analysis result. Image
+// inputs are stored as a hash and, when enabled, a thumbnail.
+func newFoodLogEntry(apiKey, analysisID string, eatenAt time.Time, mealType string, imageData []byte, result *services.AnalysisResult) *models.FoodLogEntry {
+	entry := &models.FoodLogEntry{
+		ID:         uuid.New().String(),
+		APIKey:     apiKey,
+		AnalysisID: analysisID,
+		MealType:   mealType,
+		InputType:  result.InputType.String(),
+		Service:    result.Service,
+		Model:      result.Model,
+		Summary:    result.Summary,
+		Allergens:  result.Allergens,
+		Diets:      result.Diets,
+	}
+	entry.SetEatenAt(eatenAt)
+	entry.SetFacts(result.NutritionInfo)
+
+	entry.ImageHash, entry.Thumbnail = imageDigest(imageData)
+	return entry
+}
+
+// imageDigest returns what is kept of an input image: its hash and, when
+// enabled, a thumbnail. Both are empty for text inputs.
+func imageDigest(imageData []byte) (string, []byte) {
+	if len(imageData) == 0 {
+		return "", nil
+	}
+	hash := utils.HashImage(imageData)
+	if config.Config.ThumbnailSize <= 0 {
+		return hash, nil
+	}
+	thumbnail, err := utils.MakeThumbnail(imageData, config.Config.ThumbnailSize)
+	if err != nil {
+		logging.Log.Warn("Failed to create thumbnail: ", err)
+		return hash, nil
+	}
+	return hash, thumbnail
+}
+
+const (
+	defaultLogPageSize = 20
+	maxLogPageSize     = 100
+)
+
+// ListFoodLog lists the caller's food log entries, newest first, with
+// optional filters and cursor-based pagination.
+func ListFoodLog(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		loc, err := callerLocation(c, db)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		filter := repositories.FoodLogFilter{
+			MealType:  c.Query("meal_type"),
+			InputType: c.Query("input_type"),
+			Search:    strings.TrimSpace(c.Query("q")),
+			Limit:     defaultLogPageSize,
+		}
+		if filter.MealType != "" && !models.ValidMealType(filter.MealType) {
+			c.Error(apperrors.New(apperrors.InvalidInput, "Invalid meal_type").With("allowed", models.MealTypes))
+			return
+		}
+		if _, ok := services.ParseInputType(filter.InputType); filter.InputType != "" && !ok {
+			c.Error(apperrors.New(apperrors.InvalidInput, "Invalid input_type"))
+			return
+		}
+		if filter.From, err = parseTimeParam(c.Query("from"), loc, false); err != nil {
+			c.Error(apperrors.New(apperrors.InvalidInput, "Invalid from: "+err.Error()))
+			return
+		}
+		if filter.To, err = parseTimeParam(c.Query("to"), loc, true); err != nil {
+			c.Error(apperrors.New(apperrors.InvalidInput, "Invalid to: "+err.Error()))
+			return
+		}
+		if limit := c.Query("limit"); limit != "" {
+			n, err := strconv.Atoi(limit)
+			if err != nil || n < 1 || n > maxLogPageSize {
+				c.Error(apperrors.New(apperrors.InvalidInput, fmt.Sprintf("limit must be between 1 and %d", maxLogPageSize)))
+				return
+			}
+			filter.Limit = n
+		}
+		if cursor := c.Query("cursor"); cursor != "" {
+			if filter.AfterEatenAt, filter.AfterID, err = decodeLogCursor(cursor); err != nil {
+				c.Error(apperrors.New(apperrors.InvalidInput, "Invalid cursor"))
+				return
+			}
+		}
+
+		// Fetch one extra entry to know whether another page follows
+		pageSize := filter.Limit
+		filter.Limit++
+		entries, err := db.ListFoodLogEntries(middleware.CurrentAPIKey(c), filter)
+		if err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to list food log entries"))
+			return
+		}
+
+		response := gin.H{"entries": []map[string]interface{}{}}
+		if len(entries) > pageSize {
+			entries = entries[:pageSize]
+			last := entries[len(entries)-1]
+			response["next_cursor"] = encodeLogCursor(last.EatenAt, last.ID)
+		}
+		list := make([]map[string]interface{}, 0, len(entries))
+		for i := range entries {
+			list = append(list, foodLogEntryResponse(&entries[i], false))
+		}
+		response["entries"] = list
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// GetFoodLogEntry returns one of the caller's food log entries.
+func GetFoodLogEntry(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		entry, ok := loadFoodLogEntry(c, db)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, foodLogEntryResponse(entry, true))
+	}
+}
+
+// FoodLogUpdateRequest holds the editable fields of a food log entry. Absent
+// fields are left unchanged. Nutrients are merged by name; a null value
+// removes the nutrient.
+type FoodLogUpdateRequest struct {
+	Summary   *string                           `json:"summary"`
+	MealType  *string                           `json:"meal_type"`
+	EatenAt   *time.Time                        `json:"eaten_at"`
+	Nutrients map[string]*FoodLogNutrientUpdate `json:"nutrients"`
+}
+
+type FoodLogNutrientUpdate struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+// UpdateFoodLogEntry applies a user's corrections to a food log entry.
+func UpdateFoodLogEntry(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req FoodLogUpdateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.Error(bindingError(err))
+			return
+		}
+		if req.MealType != nil && *req.MealType != "" && !models.ValidMealType(*req.MealType) {
+			c.Error(apperrors.New(apperrors.InvalidInput, "Invalid meal_type").With("allowed", models.MealTypes))
+			return
+		}
+
+		entry, ok := loadFoodLogEntry(c, db)
+		if !ok {
+			return
+		}
+
+		if req.Summary != nil {
+			entry.Summary = *req.Summary
+		}
+		if req.MealType != nil {
+			entry.MealType = *req.MealType
+		}
+		if req.EatenAt != nil {
+			entry.SetEatenAt(*req.EatenAt)
+		}
+		if req.Nutrients != nil {
+			facts := entry.Facts()
+			for name, update := range req.Nutrients {
+				if update == nil {
+					delete(facts, name)
+					if nutrient, ok := nutrition.Lookup(name); ok {
+						delete(facts, nutrient.ID)
+					}
+					continue
+				}
+				// Values entered by the user are taken as certain
+				corrected := nutrition.Normalize([]nutrition.Component{{Name: name, Value: update.Value, Unit: update.Unit, Confidence: 1}})
+				if len(corrected) == 0 {
+					c.Error(apperrors.New(apperrors.InvalidInput, fmt.Sprintf("Invalid nutrient %q", name)))
+					return
+				}
+				for id, amount := range corrected {
+					facts[id] = amount
+				}
+			}
+			entry.SetFacts(facts)
+		}
+
+		if err := db.SaveFoodLogEntry(entry); err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to save food log entry"))
+			return
+		}
+		c.JSON(http.StatusOK, foodLogEntryResponse(entry, true))
+	}
+}
+
+// DeleteFoodLogEntry removes one of the caller's food log entries.
+func DeleteFoodLogEntry(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := db.DeleteFoodLogEntry(middleware.CurrentAPIKey(c), c.Param("id"))
+		if errors.Is(err, repositories.ErrNotFound) {
+			c.Error(apperrors.New(apperrors.NotFound, "Food log entry not found"))
+			return
+		}
+		if err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to delete food log entry"))
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// loadFoodLogEntry loads the entry named in the path, writing the error
+// response and returning false when it cannot.
+func loadFoodLogEntry(c *gin.Context, db repositories.Database) (*models.FoodLogEntry, bool) {
+	entry, err := db.GetFoodLogEntry(middleware.CurrentAPIKey(c), c.Param("id"))
+	if errors.Is(err, repositories.ErrNotFound) {
+		c.Error(apperrors.New(apperrors.NotFound, "Food log entry not found"))
+		return nil, false
+	}
+	if err != nil {
+		c.Error(apperrors.Wrap(err, "Failed to load food log entry"))
+		return nil, false
+	}
+	return entry, true
+}
+
+// foodLogEntryResponse renders a food log entry. Thumbnails are only included
+// when requested, to keep listings small.
+func foodLogEntryResponse(entry *models.FoodLogEntry, withThumbnail bool) map[string]interface{} {
+	response := map[string]interface{}{
+		"id":             entry.ID,
+		"analysis_id":    entry.AnalysisID,
+		"template_id":    entry.TemplateID,
+		"eaten_at":       entry.LocalEatenAt(),
+		"meal_type":      entry.MealType,
+		"input_type":     entry.InputType,
+		"service":        entry.Service,
+		"model":          entry.Model,
+		"summary":        entry.Summary,
+		"nutrition_info": entry.Facts(),
+		"allergens":      entry.Allergens,
+		"diets":          entry.Diets,
+		"image_hash":     entry.ImageHash,
+		"created_at":     entry.CreatedAt,
+		"updated_at":     entry.UpdatedAt,
+	}
+	if withThumbnail && len(entry.Thumbnail) > 0 {
+		response["thumbnail"] = "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(entry.Thumbnail)
+	}
+	return response
+}
+
+// encodeLogCursor encodes the listing position after an entry.
+func encodeLogCursor(eatenAt time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(eatenAt.UTC().Format(time.RFC3339Nano) + "|" + id))
+}
+
+func decodeLogCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	eatenAt, id, found := strings.Cut(string(raw), "|")
+	if !found || id == "" {
+		return time.Time{}, "", errors.New("malformed cursor")
+	}
+	t, err := time.Parse(time.RFC3339Nano, eatenAt)
+	return t, id, err
+}
+
+// callerLocation returns the time zone days are counted in for the caller,
+// as analysisLocation does, loading their saved config unless the "tz" query
+// parameter names one. Errors are *apperrors.Error.
+func callerLocation(c *gin.Context, db repositories.Database) (*time.Location, error) {
+	var userConfig *models.UserConfig
+	if c.Query("tz") == "" {
+		var err error
+		if userConfig, err = loadUserConfig(db, middleware.CurrentAPIKey(c)); err != nil {
+			return nil, apperrors.Wrap(err, "Failed to load preferences")
+		}
+	}
+	loc, err := analysisLocation(c, userConfig)
+	if err != nil {
+		return nil, apperrors.New(apperrors.InvalidInput, err.Error())
+	}
+	return loc, nil
+}
+
+// requestLocation returns the time zone named by the "tz" query parameter,
+// defaulting to UTC.
+func requestLocation(c *gin.Context) (*time.Location, error) {
+	name := c.Query("tz")
+	if name == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone: %s", name)
+	}
+	return loc, nil
+}
+
+// parseTimeParam parses an RFC 3339 timestamp or a YYYY-MM-DD date in loc.
+// A date used as an exclusive upper bound covers that whole day.
+func parseTimeParam(value string, loc *time.Location, upperBound bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	day, err := time.ParseInLocation("2006-01-02", value, loc)
+	if err != nil {
+		return time.Time{}, errors.New("expected an RFC 3339 timestamp or a YYYY-MM-DD date")
+	}
+	if upperBound {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day, nil
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"dietsense/internal/middleware"
+	"dietsense/internal/models"
+	"dietsense/internal/repositories"
+	"dietsense/internal/services"
+	"dietsense/pkg/apperrors"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type GoalRequest struct {
+	Nutrient  string  `json:"nutrient" binding:"required"`
+	Type      string  `json:"type" binding:"required"`
+	Value     float64 `json:"value" binding:"required"`
+	Unit      string  `json:"unit"`
+	Tolerance float64 `json:"tolerance"`
+}
+
+// ListGoals returns the caller's nutrition goals.
+func ListGoals(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		goals, err := db.GetGoals(middleware.CurrentAPIKey(c))
+		if err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to load goals"))
+			return
+		}
+		if goals == nil {
+			goals = []models.Goal{}
+		}
+		c.JSON(http.StatusOK, gin.H{"goals": goals})
+	}
+}
+
+// CreateGoal adds a nutrition goal. A nutrient can have one goal per type.
+func CreateGoal(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req GoalRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.Error(bindingError(err))
+			return
+		}
+
+		apiKey := middleware.CurrentAPIKey(c)
+		goal := &models.Goal{ID: uuid.New().String(), APIKey: apiKey, CreatedAt: time.Now()}
+		if !applyGoalRequest(c, db, goal, req) {
+			return
+		}
+		c.JSON(http.StatusCreated, goal)
+	}
+}
+
+// GetGoal returns one of the caller's nutrition goals.
+func GetGoal(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		goal, ok := loadGoal(c, db)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, goal)
+	}
+}
+
+// UpdateGoal replaces one of the caller's nutrition goals.
+func UpdateGoal(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req GoalRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.Error(bindingError(err))
+			return
+		}
+		goal, ok := loadGoal(c, db)
+		if !ok {
+			return
+		}
+		if !applyGoalRequest(c, db, goal, req) {
+			return
+		}
+		c.JSON(http.StatusOK, goal)
+	}
+}
+
+// DeleteGoal removes one of the caller's nutrition goals.
+func DeleteGoal(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := db.DeleteGoal(middleware.CurrentAPIKey(c), c.Param("id"))
+		if errors.Is(err, repositories.ErrNotFound) {
+			c.Error(apperrors.New(apperrors.NotFound, "Goal not found"))
+			return
+		}
+		if err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to delete goal"))
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// GoalProgress compares the caller's intake on a day, in their time zone,
+// with their goals.
+func GoalProgress(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		loc, err := callerLocation(c, db)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		day := time.Now().In(loc)
+		if date := c.Query("date"); date != "" {
+			if day, err = time.ParseInLocation("2006-01-02", date, loc); err != nil {
+				c.Error(apperrors.New(apperrors.InvalidInput, "Invalid date: expected a YYYY-MM-DD date"))
+				return
+			}
+		}
+
+		apiKey := middleware.CurrentAPIKey(c)
+		goals, err := db.GetGoals(apiKey)
+		if err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to load goals"))
+			return
+		}
+		summary, err := summarizeDay(db, apiKey, day)
+		if err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to summarize food log"))
+			return
+		}
+
+		progress := services.EvaluateGoals(goals, summary.Totals)
+		counts := map[string]int{services.GoalMet: 0, services.GoalExceeded: 0, services.GoalUnder: 0}
+		for _, p := range progress {
+			counts[p.Status]++
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"date":      summary.Start,
+			"time_zone": loc.String(),
+			"entries":   summary.Entries,
+			"totals":    summary.Totals,
+			"goals":     progress,
+			"met":       counts[services.GoalMet],
+			"exceeded":  counts[services.GoalExceeded],
+			"under":     counts[services.GoalUnder],
+		})
+	}
+}
+
+// summarizeDay totals the food log of the calendar day containing t, in t's
+// location.
+func summarizeDay(db repositories.Database, apiKey string, t time.Time) (*services.NutritionSummary, error) {
+	start := services.PeriodDay.Start(t)
+	days := services.CalendarDays(start, start.AddDate(0, 0, 1), t.Location())
+	totals, err := db.SumFoodLog(apiKey, days)
+	if err != nil {
+		return nil, err
+	}
+	return services.SummarizeNutrition(services.PeriodDay, days, totals)[0], nil
+}
+
+// applyGoalRequest validates the request into the goal and saves it, writing
+// the error response and returning false when it cannot.
+func applyGoalRequest(c *gin.Context, db repositories.Database, goal *models.Goal, req GoalRequest) bool {
+	goal.Nutrient, goal.Type, goal.Value, goal.Unit, goal.Tolerance = req.Nutrient, req.Type, req.Value, req.Unit, req.Tolerance
+	if err := services.NormalizeGoal(goal); err != nil {
+		c.Error(apperrors.New(apperrors.InvalidInput, err.Error()))
+		return false
+	}
+
+	existing, err := db.GetGoals(goal.APIKey)
+	if err != nil {
+		c.Error(apperrors.Wrap(err, "Failed to load goals"))
+		return false
+	}
+	for _, other := range existing {
+		if other.ID != goal.ID && other.Nutrient == goal.Nutrient && other.Type == goal.Type {
+			c.Error(apperrors.New(apperrors.Conflict, "A goal of this type already exists for the nutrient").With("goal_id", other.ID))
+			return false
+		}
+	}
+
+	goal.UpdatedAt = time.Now()
+	if err := db.SaveGoal(goal); err != nil {
+		c.Error(apperrors.Wrap(err, "Failed to save goal"))
+		return false
+	}
+	return true
+}
+
+// loadGoal loads the goal named in the path, writing the error response and
+// returning false when it cannot.
+func loadGoal(c *gin.Context, db repositories.Database) (*models.Goal, bool) {
+	goal, err := db.GetGoal(middleware.CurrentAPIKey(c), c.Param("id"))
+	if errors.Is(err, repositories.ErrNotFound) {
+		c.Error(apperrors.New(apperrors.NotFound, "Goal not found"))
+		return nil, false
+	}
+	if err != nil {
+		c.Error(apperrors.Wrap(err, "Failed to load goal"))
+		return nil, false
+	}
+	return goal, true
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"dietsense/internal/middleware"
+	"dietsense/internal/repositories"
+	"dietsense/internal/services"
+	"dietsense/pkg/apperrors"
+	"dietsense/pkg/logging"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultInsightDays = 7
+	maxInsightDays     = 90
+)
+
+// GetInsights runs the insight rules over the caller's food log for the last
+// few days, including today, in their time zone. With narrative=true the
+// text analyzer service also explains the insights in prose.
+func GetInsights(factory *services.ServiceFactory, db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		loc, err := callerLocation(c, db)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		dayCount := defaultInsightDays
+		if value := c.Query("days"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > maxInsightDays {
+				c.Error(apperrors.New(apperrors.InvalidInput, fmt.Sprintf("days must be between 1 and %d", maxInsightDays)))
+				return
+			}
+			dayCount = n
+		}
+		narrate, _ := strconv.ParseBool(c.Query("narrative"))
+
+		apiKey := middleware.CurrentAPIKey(c)
+		goals, err := db.GetGoals(apiKey)
+		if err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to load goals"))
+			return
+		}
+
+		end := services.PeriodDay.Next(services.PeriodDay.Start(time.Now().In(loc)))
+		days := services.CalendarDays(end.AddDate(0, 0, -dayCount), end, loc)
+		totals, err := db.SumFoodLog(apiKey, days)
+		if err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to summarize food log"))
+			return
+		}
+
+		input := services.InsightInput{Days: services.SummarizeNutrition(services.PeriodDay, days, totals), Goals: goals}
+		insights := services.GenerateInsights(input)
+		response := map[string]interface{}{
+			"from":      days[0].Label,
+			"to":        days[len(days)-1].Label,
+			"time_zone": loc.String(),
+			"insights":  insights,
+		}
+
+		if narrate {
+			// The rule-based insights stand on their own, so a failed
+			// narrative is reported alongside them rather than as an error
+			narrative, service, err := narrateInsights(factory, insights)
+			if err != nil {
+				logging.Log.WithField("request_id", middleware.CurrentRequestID(c)).Error("Failed to narrate insights: ", err)
+				response["narrative_error"] = middleware.ErrorBody(c, apperrors.Wrap(err, "Failed to narrate insights"))
+			} else {
+				response["narrative"] = narrative
+				response["service"] = service
+			}
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// narrateInsights asks the text analyzer service to explain the insights.
+func narrateInsights(factory *services.ServiceFactory, insights []services.Insight) (string, string, error) {
+	service, err := factory.GetAnalyzerService(services.InputTypeText)
+	if err != nil {
+		return "", "", err
+	}
+	narrator, ok := service.(services.InsightNarrator)
+	if !ok {
+		return "", "", fmt.Errorf("the text analyzer service cannot narrate insights")
+	}
+	narrative, err := narrator.NarrateInsights(insights)
+	return narrative, factory.Config.TextAnalyzerService, err
+}
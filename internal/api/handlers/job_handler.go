@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"dietsense/internal/jobs"
+	"dietsense/internal/middleware"
+	"dietsense/internal/models"
+	"dietsense/internal/nutrition"
+	"dietsense/internal/repositories"
+	"dietsense/internal/services"
+	"dietsense/pkg/apperrors"
+	"dietsense/pkg/config"
+	"dietsense/pkg/logging"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// enqueueAnalysis saves an /analyze request as a job for the queue and
+// answers 202 with where to poll for it.
+func enqueueAnalysis(c *gin.Context, queue *jobs.Queue, apiKey string, input models.AnalysisJobInput) {
+	if apiKey == "" {
+		c.Error(apperrors.New(apperrors.Unauthorized, "An API key is required for background analysis"))
+		return
+	}
+	if queue == nil {
+		c.Error(apperrors.New(apperrors.Unavailable, "Background analysis is not available"))
+		return
+	}
+
+	job := &models.AnalysisJob{
+		ID:        uuid.New().String(),
+		APIKey:    apiKey,
+		Input:     input,
+		CreatedAt: time.Now(),
+	}
+	if err := queue.Enqueue(job); err != nil {
+		if errors.Is(err, jobs.ErrQueueFull) {
+			c.Error(apperrors.New(apperrors.Unavailable, "Too many analyses are waiting, try again later"))
+			return
+		}
+		c.Error(apperrors.Wrap(err, "Failed to queue analysis"))
+		return
+	}
+
+	statusURL := "/api/v1/jobs/" + job.ID
+	c.Header("Location", statusURL)
+	c.JSON(http.StatusAccepted, gin.H{"job_id": job.ID, "status": job.Status, "status_url": statusURL})
+}
+
+// RunAnalysisJob returns what the job queue runs for an analysis job: the
+// same analysis as a synchronous /analyze request, from the saved input.
+func RunAnalysisJob(factory *services.ServiceFactory, db repositories.Database) jobs.RunFunc {
+	return func(job *models.AnalysisJob) (map[string]interface{}, error) {
+		userConfig, err := loadUserConfig(db, job.APIKey)
+		if err != nil {
+			logging.Log.Error("Failed to load user config: ", err)
+			userConfig = nil
+		}
+		var dvTable nutrition.ReferenceIntakes
+		if region := job.Input.DailyValueRegion; region != "" {
+			dvTable, _ = nutrition.LookupReferenceIntakes(region, config.Config.DailyValues)
+		}
+		return runAnalysis(factory, db, job.APIKey, job.Input, dvTable, userConfig)
+	}
+}
+
+// GetAnalysisJob returns the status of a background analysis and, once it
+// has finished, its result or error.
+func GetAnalysisJob(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		job, err := db.GetAnalysisJob(middleware.CurrentAPIKey(c), c.Param("id"))
+		if errors.Is(err, repositories.ErrNotFound) {
+			c.Error(apperrors.New(apperrors.NotFound, "Job not found"))
+			return
+		}
+		if err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to load job"))
+			return
+		}
+		c.JSON(http.StatusOK, job)
+	}
+}
@@ -0,0 +1,377 @@
+package handlers
+
+import (
+	"dietsense/internal/middleware"
+	"dietsense/internal/models"
+	"dietsense/internal/repositories"
+	"dietsense/internal/services"
+	"dietsense/pkg/apperrors"
+	"dietsense/pkg/logging"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	maxMealComponents = 20
+	maxTemplateScale  = 20.0
+)
+
+// MealTemplateRequest creates a meal template from exactly one source: a
+// previous analysis, a food log entry, or saved templates composed into a
+// meal.
+type MealTemplateRequest struct {
+	Name       string                 `json:"name" binding:"required"`
+	MealType   string                 `json:"meal_type"`
+	AnalysisID string                 `json:"analysis_id"`
+	LogEntryID string                 `json:"log_entry_id"`
+	Components []MealComponentRequest `json:"components" binding:"omitempty,dive"`
+}
+
+type MealComponentRequest struct {
+	TemplateID string  `json:"template_id" binding:"required"`
+	Scale      float64 `json:"scale"` // Defaults to 1
+}
+
+// MealTemplateUpdateRequest holds the editable fields of a meal template.
+// Absent fields are left unchanged.
+type MealTemplateUpdateRequest struct {
+	Name     *string `json:"name"`
+	MealType *string `json:"meal_type"`
+}
+
+// LogMealTemplateRequest logs a meal template. All fields are optional: the
+// saved portion is logged now, as the template's meal type or else the one
+// inferred from the time of day.
+type LogMealTemplateRequest struct {
+	Scale    float64    `json:"scale"`
+	EatenAt  *time.Time `json:"eaten_at"`
+	MealType string     `json:"meal_type"`
+}
+
+// ListMealTemplates returns the caller's meal templates.
+func ListMealTemplates(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		templates, err := db.GetMealTemplates(middleware.CurrentAPIKey(c))
+		if err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to load meal templates"))
+			return
+		}
+		if templates == nil {
+			templates = []models.MealTemplate{}
+		}
+		c.JSON(http.StatusOK, gin.H{"templates": templates})
+	}
+}
+
+// CreateMealTemplate saves an analysis or food log entry as a named template,
+// or composes a meal from saved templates. No provider is called.
+func CreateMealTemplate(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req MealTemplateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.Error(bindingError(err))
+			return
+		}
+		sources := 0
+		for _, set := range []bool{req.AnalysisID != "", req.LogEntryID != "", len(req.Components) > 0} {
+			if set {
+				sources++
+			}
+		}
+		if sources != 1 {
+			c.Error(apperrors.New(apperrors.InvalidInput, "Exactly one of analysis_id, log_entry_id or components is required"))
+			return
+		}
+		if req.MealType != "" && !models.ValidMealType(req.MealType) {
+			c.Error(apperrors.New(apperrors.InvalidInput, "Invalid meal_type").With("allowed", models.MealTypes))
+			return
+		}
+
+		apiKey := middleware.CurrentAPIKey(c)
+		var template *models.MealTemplate
+		var ok bool
+		switch {
+		case req.AnalysisID != "":
+			template, ok = templateFromAnalysis(c, db, apiKey, req.AnalysisID)
+		case req.LogEntryID != "":
+			template, ok = templateFromLogEntry(c, db, apiKey, req.LogEntryID)
+		default:
+			template, ok = composeMealTemplate(c, db, apiKey, req.Components)
+		}
+		if !ok {
+			return
+		}
+
+		template.ID = uuid.New().String()
+		template.APIKey = apiKey
+		template.Name = strings.TrimSpace(req.Name)
+		template.MealType = req.MealType
+		template.CreatedAt = time.Now()
+		if !saveMealTemplate(c, db, template) {
+			return
+		}
+		c.JSON(http.StatusCreated, template)
+	}
+}
+
+// GetMealTemplate returns one of the caller's meal templates.
+func GetMealTemplate(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		template, ok := loadMealTemplate(c, db, c.Param("id"))
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, template)
+	}
+}
+
+// UpdateMealTemplate renames a meal template or changes its meal type.
+func UpdateMealTemplate(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req MealTemplateUpdateRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.Error(bindingError(err))
+			return
+		}
+		if req.MealType != nil && *req.MealType != "" && !models.ValidMealType(*req.MealType) {
+			c.Error(apperrors.New(apperrors.InvalidInput, "Invalid meal_type").With("allowed", models.MealTypes))
+			return
+		}
+
+		template, ok := loadMealTemplate(c, db, c.Param("id"))
+		if !ok {
+			return
+		}
+		if req.Name != nil {
+			template.Name = strings.TrimSpace(*req.Name)
+		}
+		if req.MealType != nil {
+			template.MealType = *req.MealType
+		}
+		if !saveMealTemplate(c, db, template) {
+			return
+		}
+		c.JSON(http.StatusOK, template)
+	}
+}
+
+// DeleteMealTemplate removes one of the caller's meal templates. Meals
+// composed from it and entries logged from it are kept.
+func DeleteMealTemplate(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := db.DeleteMealTemplate(middleware.CurrentAPIKey(c), c.Param("id"))
+		if errors.Is(err, repositories.ErrNotFound) {
+			c.Error(apperrors.New(apperrors.NotFound, "Meal template not found"))
+			return
+		}
+		if err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to delete meal template"))
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// LogMealTemplate adds a meal template to the caller's food log, optionally
+// scaled, without calling a provider.
+func LogMealTemplate(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req LogMealTemplateRequest
+		// The body is optional
+		if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+			c.Error(bindingError(err))
+			return
+		}
+		scale, err := templateScale(req.Scale)
+		if err != nil {
+			c.Error(apperrors.New(apperrors.InvalidInput, err.Error()))
+			return
+		}
+		if req.MealType != "" && !models.ValidMealType(req.MealType) {
+			c.Error(apperrors.New(apperrors.InvalidInput, "Invalid meal_type").With("allowed", models.MealTypes))
+			return
+		}
+
+		template, ok := loadMealTemplate(c, db, c.Param("id"))
+		if !ok {
+			return
+		}
+
+		apiKey := middleware.CurrentAPIKey(c)
+		userConfig, err := loadUserConfig(db, apiKey)
+		if err != nil {
+			logging.Log.Error("Failed to load user config: ", err)
+		}
+		loc, err := analysisLocation(c, userConfig)
+		if err != nil {
+			c.Error(apperrors.New(apperrors.InvalidInput, err.Error()))
+			return
+		}
+		eatenAt := time.Now().In(loc)
+		if req.EatenAt != nil {
+			eatenAt = *req.EatenAt
+		}
+		mealType := req.MealType
+		if mealType == "" {
+			mealType = template.MealType
+		}
+		if mealType == "" {
+			mealType = services.InferMealType(eatenAt, mealLocale(c, userConfig))
+		}
+
+		summary := template.Summary
+		if summary == "" {
+			summary = template.Name
+		}
+		if scale != 1 {
+			summary = fmt.Sprintf("%s (x%g)", summary, scale)
+		}
+		entry := &models.FoodLogEntry{
+			ID:         uuid.New().String(),
+			APIKey:     apiKey,
+			AnalysisID: template.AnalysisID,
+			TemplateID: template.ID,
+			MealType:   mealType,
+			InputType:  template.InputType,
+			Service:    "template",
+			Summary:    summary,
+			Allergens:  template.Allergens,
+			Diets:      template.Diets,
+		}
+		entry.SetEatenAt(eatenAt)
+		entry.SetFacts(template.NutritionInfo.Scale(scale))
+		if err := db.SaveFoodLogEntry(entry); err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to save food log entry"))
+			return
+		}
+		c.JSON(http.StatusCreated, foodLogEntryResponse(entry, false))
+	}
+}
+
+// templateFromAnalysis copies one of the caller's analysis revisions into a
+// template, writing the error response and returning false when it cannot.
+func templateFromAnalysis(c *gin.Context, db repositories.Database, apiKey, id string) (*models.MealTemplate, bool) {
+	analysis, err := db.GetAnalysis(id)
+	if errors.Is(err, repositories.ErrNotFound) || (err == nil && analysis.APIKey != apiKey) {
+		c.Error(apperrors.New(apperrors.NotFound, "Analysis not found"))
+		return nil, false
+	}
+	if err != nil {
+		c.Error(apperrors.Wrap(err, "Failed to load analysis"))
+		return nil, false
+	}
+	return &models.MealTemplate{
+		Summary:       analysis.Summary,
+		InputType:     services.InputType(analysis.InputType).String(),
+		AnalysisID:    analysis.ID,
+		NutritionInfo: analysis.NutritionInfo,
+		Ingredients:   analysis.Ingredients,
+		Allergens:     analysis.Allergens,
+		Diets:         analysis.Diets,
+	}, true
+}
+
+// templateFromLogEntry copies one of the caller's food log entries, including
+// any corrections made to it, into a template.
+func templateFromLogEntry(c *gin.Context, db repositories.Database, apiKey, id string) (*models.MealTemplate, bool) {
+	entry, err := db.GetFoodLogEntry(apiKey, id)
+	if errors.Is(err, repositories.ErrNotFound) {
+		c.Error(apperrors.New(apperrors.NotFound, "Food log entry not found"))
+		return nil, false
+	}
+	if err != nil {
+		c.Error(apperrors.Wrap(err, "Failed to load food log entry"))
+		return nil, false
+	}
+	return &models.MealTemplate{
+		Summary:       entry.Summary,
+		InputType:     entry.InputType,
+		AnalysisID:    entry.AnalysisID,
+		NutritionInfo: entry.Facts(),
+		Allergens:     entry.Allergens,
+		Diets:         entry.Diets,
+	}, true
+}
+
+// composeMealTemplate combines saved templates, each scaled, into a meal.
+func composeMealTemplate(c *gin.Context, db repositories.Database, apiKey string, components []MealComponentRequest) (*models.MealTemplate, bool) {
+	if len(components) > maxMealComponents {
+		c.Error(apperrors.New(apperrors.InvalidInput, fmt.Sprintf("A meal can have at most %d components", maxMealComponents)))
+		return nil, false
+	}
+	parts := make([]services.ScaledTemplate, 0, len(components))
+	for _, component := range components {
+		scale, err := templateScale(component.Scale)
+		if err != nil {
+			c.Error(apperrors.New(apperrors.InvalidInput, err.Error()).With("template_id", component.TemplateID))
+			return nil, false
+		}
+		template, ok := loadMealTemplate(c, db, component.TemplateID)
+		if !ok {
+			return nil, false
+		}
+		parts = append(parts, services.ScaledTemplate{Template: template, Scale: scale})
+	}
+	return services.ComposeMeal(parts), true
+}
+
+// templateScale checks a portion scale, where 0 means the saved portion.
+func templateScale(scale float64) (float64, error) {
+	if scale == 0 {
+		return 1, nil
+	}
+	if scale < 0 || scale > maxTemplateScale {
+		return 0, fmt.Errorf("scale must be between 0 and %g", maxTemplateScale)
+	}
+	return scale, nil
+}
+
+// saveMealTemplate validates the template name and saves it, writing the
+// error response and returning false when it cannot. Names are unique per
+// API key, ignoring case.
+func saveMealTemplate(c *gin.Context, db repositories.Database, template *models.MealTemplate) bool {
+	if template.Name == "" {
+		c.Error(apperrors.New(apperrors.InvalidInput, "name must not be empty"))
+		return false
+	}
+	existing, err := db.GetMealTemplates(template.APIKey)
+	if err != nil {
+		c.Error(apperrors.Wrap(err, "Failed to load meal templates"))
+		return false
+	}
+	for _, other := range existing {
+		if other.ID != template.ID && strings.EqualFold(other.Name, template.Name) {
+			c.Error(apperrors.New(apperrors.Conflict, "A meal template with this name already exists").With("template_id", other.ID))
+			return false
+		}
+	}
+
+	template.UpdatedAt = time.Now()
+	if err := db.SaveMealTemplate(template); err != nil {
+		c.Error(apperrors.Wrap(err, "Failed to save meal template"))
+		return false
+	}
+	return true
+}
+
+// loadMealTemplate loads one of the caller's meal templates, writing the
+// error response and returning false when it cannot.
+func loadMealTemplate(c *gin.Context, db repositories.Database, id string) (*models.MealTemplate, bool) {
+	template, err := db.GetMealTemplate(middleware.CurrentAPIKey(c), id)
+	if errors.Is(err, repositories.ErrNotFound) {
+		c.Error(apperrors.New(apperrors.NotFound, "Meal template not found").With("template_id", id))
+		return nil, false
+	}
+	if err != nil {
+		c.Error(apperrors.Wrap(err, "Failed to load meal template"))
+		return nil, false
+	}
+	return template, true
+}
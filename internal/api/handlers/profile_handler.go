@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"dietsense/internal/middleware"
+	"dietsense/internal/models"
+	"dietsense/internal/repositories"
+	"dietsense/internal/services"
+	"dietsense/pkg/apperrors"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const maxWeightEntries = 1000
+
+// maxWeightClockSkew is how far in the future a measurement time may be, to
+// allow for clients whose clocks run slightly ahead.
+const maxWeightClockSkew = 5 * time.Minute
+
+// WeightEntryRequest is a weight measurement, taken now unless measured_at
+// says otherwise. Measurements cannot be in the future.
+type WeightEntryRequest struct {
+	WeightKg   float64    `json:"weight_kg" binding:"required,gt=0"`
+	MeasuredAt *time.Time `json:"measured_at"`
+}
+
+// GetProfile returns the caller's body profile with its energy estimate.
+func GetProfile(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		profile, ok := loadProfile(c, db)
+		if !ok {
+			return
+		}
+		respondWithProfile(c, profile)
+	}
+}
+
+// UpdateProfile replaces the caller's body profile. A changed weight is also
+// recorded in the weight history.
+func UpdateProfile(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var profile models.Profile
+		if err := c.ShouldBindJSON(&profile); err != nil {
+			c.Error(bindingError(err))
+			return
+		}
+		if err := services.ValidateProfile(&profile); err != nil {
+			c.Error(apperrors.New(apperrors.InvalidInput, err.Error()))
+			return
+		}
+
+		apiKey := middleware.CurrentAPIKey(c)
+		latest, err := db.ListWeightEntries(apiKey, 1)
+		if err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to load weight history"))
+			return
+		}
+
+		// The profile is saved first, so a failure leaves no weight entry
+		// behind for a profile that was never stored
+		now := time.Now()
+		profile.APIKey = apiKey
+		profile.UpdatedAt = now
+		if err := db.SaveProfile(&profile); err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to save profile"))
+			return
+		}
+		if len(latest) == 0 || latest[0].WeightKg != profile.WeightKg {
+			entry := &models.WeightEntry{ID: uuid.New().String(), APIKey: apiKey, WeightKg: profile.WeightKg, MeasuredAt: now, CreatedAt: now}
+			if err := db.SaveWeightEntry(entry); err != nil {
+				c.Error(apperrors.Wrap(err, "Failed to save weight entry"))
+				return
+			}
+		}
+		respondWithProfile(c, &profile)
+	}
+}
+
+// ListWeightEntries returns the caller's weight history, newest first.
+func ListWeightEntries(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := maxWeightEntries
+		if value := c.Query("limit"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > maxWeightEntries {
+				c.Error(apperrors.New(apperrors.InvalidInput, "Invalid limit"))
+				return
+			}
+			limit = n
+		}
+		entries, err := db.ListWeightEntries(middleware.CurrentAPIKey(c), limit)
+		if err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to load weight history"))
+			return
+		}
+		if entries == nil {
+			entries = []models.WeightEntry{}
+		}
+		c.JSON(http.StatusOK, gin.H{"entries": entries})
+	}
+}
+
+// AddWeightEntry records a weight measurement. When it is the latest one the
+// profile weight follows, so estimates and suggestions are recomputed.
+func AddWeightEntry(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req WeightEntryRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.Error(bindingError(err))
+			return
+		}
+
+		apiKey := middleware.CurrentAPIKey(c)
+		now := time.Now()
+		entry := &models.WeightEntry{ID: uuid.New().String(), APIKey: apiKey, WeightKg: req.WeightKg, MeasuredAt: now, CreatedAt: now}
+		if req.MeasuredAt != nil {
+			if req.MeasuredAt.After(now.Add(maxWeightClockSkew)) {
+				c.Error(apperrors.New(apperrors.InvalidInput, "measured_at cannot be in the future"))
+				return
+			}
+			entry.MeasuredAt = req.MeasuredAt.UTC()
+		}
+
+		// Check the profile the entry would update before saving anything
+		profile, err := db.GetProfile(apiKey)
+		if err != nil && !errors.Is(err, repositories.ErrNotFound) {
+			c.Error(apperrors.Wrap(err, "Failed to load profile"))
+			return
+		}
+		latest, err := db.ListWeightEntries(apiKey, 1)
+		if err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to load weight entries"))
+			return
+		}
+		updatesProfile := profile != nil && (len(latest) == 0 || isLaterWeightEntry(entry, &latest[0]))
+		if updatesProfile {
+			profile.WeightKg = entry.WeightKg
+			profile.UpdatedAt = now
+			if err := services.ValidateProfile(profile); err != nil {
+				c.Error(apperrors.New(apperrors.InvalidInput, err.Error()))
+				return
+			}
+		}
+
+		if err := db.SaveWeightEntry(entry); err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to save weight entry"))
+			return
+		}
+		response := gin.H{"entry": entry}
+		if updatesProfile {
+			if err := db.SaveProfile(profile); err != nil {
+				c.Error(apperrors.Wrap(err, "Failed to save profile"))
+				return
+			}
+			if estimate, err := services.EstimateEnergy(profile, ""); err == nil {
+				response["energy"] = estimate
+			}
+		}
+		c.JSON(http.StatusCreated, response)
+	}
+}
+
+// isLaterWeightEntry reports whether entry lists before other in the weight
+// history, newest first by measurement time and then ID.
+func isLaterWeightEntry(entry, other *models.WeightEntry) bool {
+	if entry.MeasuredAt.Equal(other.MeasuredAt) {
+		return entry.ID > other.ID
+	}
+	return entry.MeasuredAt.After(other.MeasuredAt)
+}
+
+// SuggestGoals proposes goals from the caller's profile. The "formula" query
+// parameter overrides the profile's BMR equation.
+func SuggestGoals(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		profile, estimate, ok := profileEstimate(c, db)
+		if !ok {
+			return
+		}
+		// Suggestions are shaped like goal requests so they can be posted as is
+		suggestions, err := services.SuggestGoals(profile, estimate)
+		if err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to suggest goals"))
+			return
+		}
+		goals := make([]GoalRequest, 0, len(suggestions))
+		for _, goal := range suggestions {
+			goals = append(goals, GoalRequest{Nutrient: goal.Nutrient, Type: goal.Type, Value: goal.Value, Unit: goal.Unit, Tolerance: goal.Tolerance})
+		}
+		c.JSON(http.StatusOK, gin.H{"energy": estimate, "goals": goals})
+	}
+}
+
+// AdoptSuggestedGoals saves the suggested goals, replacing the caller's goals
+// of the same nutrient and type and keeping the others.
+func AdoptSuggestedGoals(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		profile, estimate, ok := profileEstimate(c, db)
+		if !ok {
+			return
+		}
+
+		apiKey := middleware.CurrentAPIKey(c)
+		existing, err := db.GetGoals(apiKey)
+		if err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to load goals"))
+			return
+		}
+
+		adopted, err := services.SuggestGoals(profile, estimate)
+		if err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to suggest goals"))
+			return
+		}
+		now := time.Now()
+		for i := range adopted {
+			goal := &adopted[i]
+			goal.ID, goal.APIKey, goal.CreatedAt, goal.UpdatedAt = uuid.New().String(), apiKey, now, now
+			for _, other := range existing {
+				if other.Nutrient == goal.Nutrient && other.Type == goal.Type {
+					goal.ID, goal.CreatedAt = other.ID, other.CreatedAt
+				}
+			}
+			if err := db.SaveGoal(goal); err != nil {
+				c.Error(apperrors.Wrap(err, "Failed to save goal"))
+				return
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"energy": estimate, "goals": adopted})
+	}
+}
+
+// profileEstimate loads the caller's profile and its energy estimate, writing
+// the error response and returning false when it cannot.
+func profileEstimate(c *gin.Context, db repositories.Database) (*models.Profile, *services.EnergyEstimate, bool) {
+	profile, ok := loadProfile(c, db)
+	if !ok {
+		return nil, nil, false
+	}
+	estimate, err := services.EstimateEnergy(profile, c.Query("formula"))
+	if err != nil {
+		c.Error(apperrors.New(apperrors.InvalidInput, err.Error()))
+		return nil, nil, false
+	}
+	return profile, estimate, true
+}
+
+// loadProfile loads the caller's profile, writing the error response and
+// returning false when it cannot.
+func loadProfile(c *gin.Context, db repositories.Database) (*models.Profile, bool) {
+	profile, err := db.GetProfile(middleware.CurrentAPIKey(c))
+	if errors.Is(err, repositories.ErrNotFound) {
+		c.Error(apperrors.New(apperrors.NotFound, "Profile not found"))
+		return nil, false
+	}
+	if err != nil {
+		c.Error(apperrors.Wrap(err, "Failed to load profile"))
+		return nil, false
+	}
+	return profile, true
+}
+
+func respondWithProfile(c *gin.Context, profile *models.Profile) {
+	estimate, err := services.EstimateEnergy(profile, "")
+	if err != nil {
+		c.Error(apperrors.Wrap(err, "Failed to estimate energy"))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"profile": profile, "energy": estimate})
+}
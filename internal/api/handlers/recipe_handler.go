@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"dietsense/internal/middleware"
+	"dietsense/internal/nutrition"
+	"dietsense/internal/repositories"
+	"dietsense/internal/services"
+	"dietsense/pkg/apperrors"
+	"dietsense/pkg/logging"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxRecipeIngredients = 50
+	maxRecipeServings    = 100
+)
+
+// RecipeRequest is a recipe to analyze: a structured ingredient list, a free
+// text one with one ingredient per line, or both.
+type RecipeRequest struct {
+	Ingredients []RecipeIngredientRequest `json:"ingredients"`
+	Text        string                    `json:"text"`
+	Servings    int                       `json:"servings"`  // Defaults to 1
+	UseModel    *bool                     `json:"use_model"` // Ask the text analyzer about unknown ingredients, true by default
+}
+
+// RecipeIngredientRequest is either a line of text such as "2 tbsp olive
+// oil", or a food name with an optional amount and unit.
+type RecipeIngredientRequest struct {
+	Text   string  `json:"text"`
+	Name   string  `json:"name"`
+	Amount float64 `json:"amount"`
+	Unit   string  `json:"unit"`
+}
+
+// AnalyzeRecipe computes the total and per-serving nutrition of a recipe from
+// its ingredients, using the local food composition table and, for
+// ingredients it does not know, the text analyzer service.
+func AnalyzeRecipe(factory *services.ServiceFactory, db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Bound the body before its lines are parsed and counted
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxJSONBodySize)
+		var req RecipeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.Error(bindingError(err))
+			return
+		}
+		dvRegion, dvTable, err := resolveDailyValues(c, db)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		quantities := recipeQuantities(req)
+		if len(quantities) == 0 {
+			c.Error(apperrors.New(apperrors.InvalidInput, "A recipe needs at least one ingredient in ingredients or text"))
+			return
+		}
+		if len(quantities) > maxRecipeIngredients {
+			c.Error(apperrors.New(apperrors.InvalidInput, fmt.Sprintf("A recipe can have at most %d ingredients", maxRecipeIngredients)))
+			return
+		}
+		if req.Servings < 0 || req.Servings > maxRecipeServings {
+			c.Error(apperrors.New(apperrors.InvalidInput, fmt.Sprintf("servings must be between 1 and %d", maxRecipeServings)))
+			return
+		}
+
+		var estimator services.IngredientEstimator
+		service := ""
+		if req.UseModel == nil || *req.UseModel {
+			if estimator, err = ingredientEstimator(factory); err != nil {
+				logging.Log.Warn("Recipe ingredients will not be estimated: ", err)
+			} else {
+				service = factory.Config.TextAnalyzerService
+			}
+		}
+
+		analysis := services.AnalyzeRecipe(quantities, req.Servings, estimator)
+		response := gin.H{
+			"servings":    analysis.Servings,
+			"ingredients": analysis.Ingredients,
+			"total":       analysis.Total,
+			"per_serving": analysis.PerServing,
+			"unresolved":  analysis.Unresolved,
+		}
+		if dvTable != nil {
+			response["per_serving"] = analysis.PerServing.WithDailyValues(dvTable)
+			response["daily_value_region"] = dvRegion
+		}
+		for _, ingredient := range analysis.Ingredients {
+			if ingredient.Source == services.IngredientSourceModel {
+				response["service"] = service
+			}
+		}
+		if analysis.ModelError != nil {
+			logging.Log.WithField("request_id", middleware.CurrentRequestID(c)).Error("Failed to estimate recipe ingredients: ", analysis.ModelError)
+			response["model_error"] = middleware.ErrorBody(c, apperrors.Wrap(analysis.ModelError, "Failed to estimate unknown ingredients"))
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// recipeQuantities parses the structured ingredients followed by the lines
+// of the free text list, skipping blank lines.
+func recipeQuantities(req RecipeRequest) []nutrition.IngredientQuantity {
+	var quantities []nutrition.IngredientQuantity
+	for _, ingredient := range req.Ingredients {
+		if ingredient.Name == "" {
+			if strings.TrimSpace(ingredient.Text) != "" {
+				quantities = append(quantities, nutrition.ParseIngredient(ingredient.Text))
+			}
+			continue
+		}
+		line := strings.Join(strings.Fields(fmt.Sprintf("%g %s %s", ingredient.Amount, ingredient.Unit, ingredient.Name)), " ")
+		if ingredient.Amount == 0 {
+			line = ingredient.Name
+		}
+		quantities = append(quantities, nutrition.ParseIngredient(line))
+	}
+	for _, line := range strings.Split(req.Text, "\n") {
+		if strings.TrimSpace(line) != "" {
+			quantities = append(quantities, nutrition.ParseIngredient(line))
+		}
+	}
+	return quantities
+}
+
+// ingredientEstimator returns the text analyzer service if it can estimate
+// recipe ingredients.
+func ingredientEstimator(factory *services.ServiceFactory) (services.IngredientEstimator, error) {
+	service, err := factory.GetAnalyzerService(services.InputTypeText)
+	if err != nil {
+		return nil, err
+	}
+	estimator, ok := service.(services.IngredientEstimator)
+	if !ok {
+		return nil, fmt.Errorf("the text analyzer service cannot estimate recipe ingredients")
+	}
+	return estimator, nil
+}
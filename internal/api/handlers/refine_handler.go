@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"bytes"
+	"dietsense/internal/middleware"
+	"dietsense/internal/models"
+	"dietsense/internal/repositories"
+	"dietsense/internal/services"
+	"dietsense/pkg/apperrors"
+	"dietsense/pkg/config"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxRefinementDepth bounds how many revisions a single analysis can accumulate.
+const maxRefinementDepth = 20
+
+// RefineRequest holds a user's correction of an analysis, such as "it was a
+// large glass". Corrections are limited to 4000 characters.
+type RefineRequest struct {
+	Correction string `json:"correction" binding:"required,max=4000"`
+}
+
+// RefineAnalysis sends a user's correction for a stored analysis back to the
+// provider that produced it and stores the answer as a new revision.
+func RefineAnalysis(factory *services.ServiceFactory, db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req RefineRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.Error(bindingError(err))
+			return
+		}
+
+		dvRegion, dvTable, err := resolveDailyValues(c, db)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		apiKey := middleware.CurrentAPIKey(c)
+		chain, err := loadRevisionChain(db, c.Param("id"), apiKey)
+		if errors.Is(err, repositories.ErrNotFound) {
+			c.Error(apperrors.New(apperrors.NotFound, "Analysis not found"))
+			return
+		}
+		if err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to load analysis"))
+			return
+		}
+		if len(chain) >= maxRefinementDepth {
+			c.Error(apperrors.New(apperrors.InvalidInput, "Analysis has reached the maximum number of refinements"))
+			return
+		}
+
+		root, parent := chain[0], chain[len(chain)-1]
+		service, err := factory.GetServiceByName(root.Service, root.Model)
+		if err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to get analyzer service"))
+			return
+		}
+		refiner, ok := service.(services.AnalysisRefiner)
+		if !ok {
+			c.Error(apperrors.New(apperrors.InvalidInput, "Analyzer service does not support refinement"))
+			return
+		}
+
+		// Each earlier revision contributes its answer followed by the
+		// correction that produced the next one; the new correction closes the list.
+		turns := make([]services.RefinementTurn, 0, len(chain))
+		for i, revision := range chain {
+			turn := services.RefinementTurn{Answer: revision.RawResponse, Correction: req.Correction}
+			if i+1 < len(chain) {
+				turn.Correction = chain[i+1].Correction
+			}
+			turns = append(turns, turn)
+		}
+
+		var image io.Reader
+		if data := refinementImage(root); len(data) > 0 {
+			image = bytes.NewReader(data)
+		}
+		context := services.PromptContext{Instructions: root.Instructions, Text: root.Context}
+		result, err := refiner.RefineFood(image, context, services.InputType(root.InputType), turns)
+		if err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to refine analysis"))
+			return
+		}
+
+		revision := newAnalysisRecord(apiKey, context, nil, result)
+		revision.ParentID = parent.ID
+		revision.Correction = req.Correction
+		if err := db.SaveAnalysis(revision); err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to save analysis revision"))
+			return
+		}
+
+		response := map[string]interface{}{
+			"analysis_id":    revision.ID,
+			"parent_id":      parent.ID,
+			"revision":       len(chain),
+			"nutrition_info": result.NutritionInfo,
+			"summary":        result.Summary,
+			"confidence":     result.Confidence,
+			"input_type":     result.InputType,
+			"service":        result.Service,
+			"ingredients":    result.Ingredients,
+			"allergens":      result.Allergens,
+			"diets":          result.Diets,
+			"changes":        services.DiffNutrition(parent.NutritionInfo, result.NutritionInfo),
+		}
+		if dvTable != nil {
+			response["nutrition_info"] = result.NutritionInfo.WithDailyValues(dvTable)
+			response["daily_value_region"] = dvRegion
+		}
+
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// refinementImage returns the image a refinement sends again: the full image
+// while it is retained, otherwise the thumbnail.
+func refinementImage(root *models.Analysis) []byte {
+	retention := config.Config.AnalysisImageRetention
+	if len(root.Image) > 0 && retention > 0 && time.Since(root.CreatedAt) < retention {
+		return root.Image
+	}
+	return root.Thumbnail
+}
+
+// loadRevisionChain returns the revisions from the root analysis down to the
+// given one. Revisions owned by another API key are reported as not found.
+func loadRevisionChain(db repositories.Database, id, apiKey string) ([]*models.Analysis, error) {
+	var chain []*models.Analysis
+	for id != "" {
+		if len(chain) > maxRefinementDepth {
+			return nil, errors.New("analysis revision chain is too long")
+		}
+		analysis, err := db.GetAnalysis(id)
+		if err != nil {
+			return nil, err
+		}
+		if analysis.APIKey != apiKey {
+			return nil, repositories.ErrNotFound
+		}
+		chain = append([]*models.Analysis{analysis}, chain...)
+		id = analysis.ParentID
+	}
+	return chain, nil
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"dietsense/internal/middleware"
+	"dietsense/internal/nutrition"
+	"dietsense/internal/repositories"
+	"dietsense/internal/services"
+	"dietsense/pkg/apperrors"
+	"dietsense/pkg/logging"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AnalyzeFoodStream is /analyze answered with server-sent events as the
+// analysis progresses: "classified" once the input type is known,
+// "analyzing" when the analyzer starts, "summary" with each new piece of the
+// summary text, "nutrient" with each nutrient once the model has written it,
+// and "result" with the body /analyze returns. A failure ends the stream with
+// an "error" event. Analyzers that cannot stream only send the result. When
+// the client disconnects the analysis is abandoned and nothing is saved.
+func AnalyzeFoodStream(factory *services.ServiceFactory, db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Requests that cannot be read are still answered with a JSON error
+		req, ok := readAnalysisRequest(c, db)
+		if !ok {
+			return
+		}
+
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+		send := func(event string, data interface{}) {
+			c.SSEvent(event, data)
+			c.Writer.Flush()
+		}
+		fail := func(err error) {
+			send("error", middleware.ErrorBody(c, err))
+		}
+
+		labeled, err := classifyImages(factory, req.input.Images, req.input.InputType)
+		if err != nil {
+			fail(err)
+			return
+		}
+		inputType := analyzedType(labeled)
+		send("classified", gin.H{"input_type": inputType, "images": imageLabels(labeled)})
+
+		analyzerService, err := analyzerFor(factory, labeled)
+		if err != nil {
+			fail(err)
+			return
+		}
+		promptContext := services.PromptContext{Instructions: req.input.Instructions, Text: req.input.Context}
+
+		var result *services.AnalysisResult
+		streamer, streaming := analyzerService.(services.StreamingAnalyzer)
+		send("analyzing", gin.H{"input_type": inputType, "streaming": streaming})
+		if streaming {
+			var decoder services.StreamDecoder
+			result, err = streamer.StreamFood(c.Request.Context(), labeled, promptContext, func(text string) {
+				update := decoder.Write(text)
+				if update.Summary != "" {
+					send("summary", gin.H{"text": update.Summary})
+				}
+				for _, nutrient := range nutrientEvents(update.Nutrients, req.dvTable) {
+					send("nutrient", nutrient)
+				}
+			})
+			if err != nil {
+				err = apperrors.Wrap(err, "Failed to analyze")
+			}
+		} else {
+			result, err = analyzeLabeled(analyzerService, labeled, promptContext)
+		}
+		if ctxErr := c.Request.Context().Err(); ctxErr != nil {
+			logging.Log.Info("Streamed analysis abandoned: ", ctxErr)
+			return
+		}
+		if err != nil {
+			fail(err)
+			return
+		}
+
+		response, err := completeAnalysis(db, req.apiKey, req.input, result, labeled, req.dvTable, req.userConfig)
+		if err != nil {
+			fail(err)
+			return
+		}
+		send("result", response)
+	}
+}
+
+// nutrientEvents turns the nutrients revealed by a piece of a streamed
+// answer into event data, ordered by ID, with %DV when a region applies.
+func nutrientEvents(facts nutrition.Facts, dvTable nutrition.ReferenceIntakes) []gin.H {
+	if len(facts) == 0 {
+		return nil
+	}
+	if dvTable != nil {
+		facts = facts.WithDailyValues(dvTable)
+	}
+	ids := make([]string, 0, len(facts))
+	for id := range facts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	events := make([]gin.H, len(ids))
+	for i, id := range ids {
+		amount := facts[id]
+		events[i] = gin.H{"id": id, "name": amount.Name, "value": amount.Value, "unit": amount.Unit, "confidence": amount.Confidence}
+		if amount.DailyValue != nil {
+			events[i]["daily_value_percent"] = *amount.DailyValue
+		}
+	}
+	return events
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"dietsense/internal/middleware"
+	"dietsense/internal/repositories"
+	"dietsense/internal/services"
+	"dietsense/pkg/apperrors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultSummaryPeriods is how many periods a summary covers when no
+	// range is given, ending with the current one.
+	defaultSummaryPeriods = 7
+	maxSummaryDays        = 400
+)
+
+// SummarizeFoodLog returns the caller's nutrient totals per day, week or month
+// in their time zone, with daily averages and per-meal breakdowns.
+func SummarizeFoodLog(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		period, err := services.ParseSummaryPeriod(c.DefaultQuery("period", string(services.PeriodDay)))
+		if err != nil {
+			c.Error(apperrors.New(apperrors.InvalidInput, err.Error()))
+			return
+		}
+		loc, err := callerLocation(c, db)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		from, to, err := summaryRange(c, period, loc)
+		if err != nil {
+			c.Error(apperrors.New(apperrors.InvalidInput, err.Error()))
+			return
+		}
+		dvRegion, dvTable, err := resolveDailyValues(c, db)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		// Check the span before listing its days, so huge ranges are never
+		// enumerated. The extra day allows for DST and a partial first day.
+		tooLong := to.Sub(from) > time.Duration(maxSummaryDays+1)*24*time.Hour
+		var days []repositories.TimeRange
+		if !tooLong {
+			days = services.CalendarDays(from, to, loc)
+			tooLong = len(days) > maxSummaryDays
+		}
+		if tooLong {
+			c.Error(apperrors.New(apperrors.InvalidInput, fmt.Sprintf("Summaries cover at most %d days", maxSummaryDays)))
+			return
+		}
+		totals, err := db.SumFoodLog(middleware.CurrentAPIKey(c), days)
+		if err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to summarize food log"))
+			return
+		}
+
+		summaries := services.SummarizeNutrition(period, days, totals)
+		overall := services.CombineSummaries(summaries)
+		response := map[string]interface{}{
+			"period":    period,
+			"time_zone": loc.String(),
+			"summaries": summaries,
+			"overall":   overall,
+		}
+		if dvTable != nil {
+			// Percent of Daily Value is only meaningful for a day's intake
+			for _, summary := range append(summaries, overall) {
+				summary.DailyAverage = summary.DailyAverage.WithDailyValues(dvTable)
+			}
+			response["daily_value_region"] = dvRegion
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// summaryRange resolves the from and to query parameters, both inclusive
+// YYYY-MM-DD dates in loc, to whole periods. By default the range ends with
+// the current period.
+func summaryRange(c *gin.Context, period services.SummaryPeriod, loc *time.Location) (time.Time, time.Time, error) {
+	last := time.Now().In(loc)
+	if value := c.Query("to"); value != "" {
+		day, err := time.ParseInLocation("2006-01-02", value, loc)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid to: expected a YYYY-MM-DD date")
+		}
+		last = day
+	}
+	to := period.Next(period.Start(last))
+
+	from := period.Start(last)
+	for i := 1; i < defaultSummaryPeriods; i++ {
+		from = period.Start(from.AddDate(0, 0, -1))
+	}
+	if value := c.Query("from"); value != "" {
+		day, err := time.ParseInLocation("2006-01-02", value, loc)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid from: expected a YYYY-MM-DD date")
+		}
+		from = period.Start(day)
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("from must not be after to")
+	}
+	return from, to, nil
+}
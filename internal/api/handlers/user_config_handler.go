@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"dietsense/internal/middleware"
+	"dietsense/internal/models"
+	"dietsense/internal/nutrition"
+	"dietsense/internal/repositories"
+	"dietsense/pkg/apperrors"
+	"dietsense/pkg/config"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetUserConfig returns the preferences stored for the caller's API key.
+func GetUserConfig(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userConfig, err := loadUserConfig(db, middleware.CurrentAPIKey(c))
+		if err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to load user config"))
+			return
+		}
+		c.JSON(http.StatusOK, userConfig)
+	}
+}
+
+// UpdateUserConfig replaces the preferences stored for the caller's API key.
+func UpdateUserConfig(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var userConfig models.UserConfig
+		if err := c.ShouldBindJSON(&userConfig); err != nil {
+			c.Error(bindingError(err))
+			return
+		}
+		if err := validateUserConfig(&userConfig); err != nil {
+			c.Error(err)
+			return
+		}
+
+		apiKey := middleware.CurrentAPIKey(c)
+		userConfig.UserID = apiKey
+		if err := db.SaveUserConfig(apiKey, &userConfig); err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to save user config"))
+			return
+		}
+		c.JSON(http.StatusOK, userConfig)
+	}
+}
+
+// languageTag matches BCP 47 style language tags such as "fr" or "pt-BR".
+// Preferences end up in analysis prompts, so only plain tags are accepted.
+var languageTag = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// validateUserConfig checks the preferences and normalizes allergies to
+// allergen IDs. Errors are *apperrors.Error.
+func validateUserConfig(userConfig *models.UserConfig) error {
+	if userConfig.DailyValueRegion != "" {
+		if _, ok := nutrition.LookupReferenceIntakes(userConfig.DailyValueRegion, config.Config.DailyValues); !ok {
+			return unknownRegionError(userConfig.DailyValueRegion)
+		}
+	}
+	for i, name := range userConfig.Allergies {
+		allergen, ok := nutrition.LookupAllergen(name)
+		if !ok {
+			return apperrors.New(apperrors.InvalidInput, fmt.Sprintf("Unknown allergen: %s", name))
+		}
+		userConfig.Allergies[i] = allergen.ID
+	}
+	if userConfig.Units != "" && !slices.Contains(models.UnitSystems, userConfig.Units) {
+		return apperrors.New(apperrors.InvalidInput, fmt.Sprintf("Unknown units: %s, expected one of %v", userConfig.Units, models.UnitSystems)).
+			With("allowed", models.UnitSystems)
+	}
+	if userConfig.TimeZone != "" {
+		if _, err := time.LoadLocation(userConfig.TimeZone); err != nil {
+			return apperrors.New(apperrors.InvalidInput, fmt.Sprintf("Unknown time zone: %s", userConfig.TimeZone))
+		}
+	}
+	if userConfig.Language != "" && !languageTag.MatchString(userConfig.Language) {
+		return apperrors.New(apperrors.InvalidInput, fmt.Sprintf("Invalid language tag: %s", userConfig.Language))
+	}
+	return nil
+}
+
+// unknownRegionError reports a %DV region without a reference table, with
+// the regions there are.
+func unknownRegionError(region string) error {
+	return apperrors.New(apperrors.InvalidInput, fmt.Sprintf("Unknown daily value region: %s", region)).
+		With("allowed", nutrition.ReferenceRegions(config.Config.DailyValues))
+}
+
+// loadUserConfig returns the stored preferences for an API key, or empty
+// preferences when none were saved.
+func loadUserConfig(db repositories.Database, apiKey string) (*models.UserConfig, error) {
+	userConfig, err := db.GetUserConfig(apiKey)
+	if errors.Is(err, repositories.ErrNotFound) {
+		return &models.UserConfig{UserID: apiKey}, nil
+	}
+	return userConfig, err
+}
+
+// resolveDailyValues picks the %DV region from the "region" query parameter,
+// the caller's saved preference or the configured default, and returns its
+// reference table. An empty region disables %DV annotation. Errors are
+// *apperrors.Error.
+func resolveDailyValues(c *gin.Context, db repositories.Database) (string, nutrition.ReferenceIntakes, error) {
+	region := c.Query("region")
+	if apiKey := middleware.CurrentAPIKey(c); region == "" && apiKey != "" && db != nil {
+		if userConfig, err := loadUserConfig(db, apiKey); err == nil {
+			region = userConfig.DailyValueRegion
+		}
+	}
+	if region == "" {
+		region = config.Config.DefaultDailyValueRegion
+	}
+	if region == "" {
+		return "", nil, nil
+	}
+
+	table, ok := nutrition.LookupReferenceIntakes(region, config.Config.DailyValues)
+	if !ok {
+		return "", nil, unknownRegionError(region)
+	}
+	return region, table, nil
+}
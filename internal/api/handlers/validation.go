@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"dietsense/pkg/apperrors"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+func init() {
+	// Name fields in validation errors as clients send them
+	if validate, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		validate.RegisterTagNameFunc(requestFieldName)
+	}
+}
+
+// requestFieldName is the name of a request field: its form name, or else
+// its JSON name.
+func requestFieldName(field reflect.StructField) string {
+	for _, tag := range []string{"form", "json"} {
+		name, _, _ := strings.Cut(field.Tag.Get(tag), ",")
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+// bindingError turns an error of gin binding into an invalid_input error,
+// or payload_too_large when the body was cut off at its limit.
+// Failed validations are listed under "fields", by field name, so clients
+// can show each next to its input.
+func bindingError(err error) *apperrors.Error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return apperrors.New(apperrors.PayloadTooLarge, fmt.Sprintf("Request body is larger than %d bytes", tooLarge.Limit))
+	}
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return apperrors.New(apperrors.InvalidInput, err.Error())
+	}
+	fields := map[string]string{}
+	for _, fieldErr := range validationErrs {
+		fields[fieldErr.Field()] = validationMessage(fieldErr)
+	}
+	return invalidFields(fields)
+}
+
+// invalidField is an invalid_input error for one field.
+func invalidField(field, problem string) *apperrors.Error {
+	return invalidFields(map[string]string{field: problem})
+}
+
+// invalidFields is an invalid_input error naming the invalid fields, with the
+// problem of each under "fields".
+func invalidFields(fields map[string]string) *apperrors.Error {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return apperrors.New(apperrors.InvalidInput, "Invalid "+strings.Join(names, ", ")).With("fields", fields)
+}
+
+// validationMessage describes a failed validation the way it reads after the
+// field name.
+func validationMessage(fieldErr validator.FieldError) string {
+	switch fieldErr.Tag() {
+	case "required":
+		return "is required"
+	case "max":
+		if fieldErr.Kind() == reflect.String {
+			return fmt.Sprintf("must be at most %s characters", fieldErr.Param())
+		}
+		return "must be at most " + fieldErr.Param()
+	case "min":
+		if fieldErr.Kind() == reflect.String {
+			return fmt.Sprintf("must be at least %s characters", fieldErr.Param())
+		}
+		return "must be at least " + fieldErr.Param()
+	case "gt":
+		return "must be greater than " + fieldErr.Param()
+	case "oneof":
+		return "must be one of " + strings.Join(strings.Fields(fieldErr.Param()), ", ")
+	default:
+		return "is invalid"
+	}
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"dietsense/internal/jobs"
+	"dietsense/internal/middleware"
+	"dietsense/internal/models"
+	"dietsense/internal/repositories"
+	"dietsense/pkg/apperrors"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WebhookRequest registers where finished background analyses are posted.
+type WebhookRequest struct {
+	CallbackURL string `json:"callback_url" binding:"required"`
+}
+
+// GetWebhook returns the callback URL registered on the caller's API key, but
+// not its signing secret, which is only returned when the URL is registered.
+func GetWebhook(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiKey, ok := loadCurrentAPIKey(c, db)
+		if !ok {
+			return
+		}
+		if apiKey.CallbackURL == "" {
+			c.Error(apperrors.New(apperrors.NotFound, "No webhook registered"))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"callback_url": apiKey.CallbackURL})
+	}
+}
+
+// UpdateWebhook registers a callback URL on the caller's API key with a new
+// signing secret, which is returned only here.
+func UpdateWebhook(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req WebhookRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.Error(bindingError(err))
+			return
+		}
+		if err := validateCallbackURL(c.Request.Context(), req.CallbackURL); err != nil {
+			c.Error(apperrors.New(apperrors.InvalidInput, err.Error()))
+			return
+		}
+		apiKey, ok := loadCurrentAPIKey(c, db)
+		if !ok {
+			return
+		}
+		if err := setWebhook(apiKey, req.CallbackURL); err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to register webhook"))
+			return
+		}
+		if err := db.SaveAPIKey(apiKey); err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to register webhook"))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"callback_url": apiKey.CallbackURL, "webhook_secret": apiKey.WebhookSecret})
+	}
+}
+
+// DeleteWebhook stops posting finished analyses for the caller's API key.
+func DeleteWebhook(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiKey, ok := loadCurrentAPIKey(c, db)
+		if !ok {
+			return
+		}
+		apiKey.CallbackURL, apiKey.WebhookSecret = "", ""
+		if err := db.SaveAPIKey(apiKey); err != nil {
+			c.Error(apperrors.Wrap(err, "Failed to remove webhook"))
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// setWebhook sets the callback URL of an API key with a new signing secret.
+func setWebhook(apiKey *models.APIKey, callbackURL string) error {
+	secret, err := jobs.NewWebhookSecret()
+	if err != nil {
+		return err
+	}
+	apiKey.CallbackURL, apiKey.WebhookSecret = callbackURL, secret
+	return nil
+}
+
+// validateCallbackURL accepts absolute http and https URLs whose host
+// resolves to public addresses only.
+func validateCallbackURL(ctx context.Context, callbackURL string) error {
+	parsed, err := url.Parse(callbackURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return fmt.Errorf("callback_url must be an absolute http or https URL")
+	}
+	return jobs.CheckCallbackHost(ctx, parsed.Hostname())
+}
+
+// loadCurrentAPIKey loads the caller's API key record, writing the error
+// response itself.
+func loadCurrentAPIKey(c *gin.Context, db repositories.Database) (*models.APIKey, bool) {
+	apiKey, err := db.GetAPIKey(middleware.CurrentAPIKey(c))
+	if errors.Is(err, repositories.ErrNotFound) {
+		c.Error(apperrors.New(apperrors.NotFound, "API key not found"))
+		return nil, false
+	}
+	if err != nil {
+		c.Error(apperrors.Wrap(err, "Failed to load API key"))
+		return nil, false
+	}
+	return apiKey, true
+}
@@ -0,0 +1,180 @@
+package openapi
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema is an OpenAPI schema object. An object schema with properties
+// allows no others unless AdditionalProperties is set; one without either
+// allows any.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	MaxLength            int                `json:"maxLength,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+}
+
+// String returns a string schema.
+func String() *Schema { return &Schema{Type: "string"} }
+
+// Integer returns an integer schema.
+func Integer() *Schema { return &Schema{Type: "integer"} }
+
+// Number returns a number schema.
+func Number() *Schema { return &Schema{Type: "number"} }
+
+// Boolean returns a boolean schema.
+func Boolean() *Schema { return &Schema{Type: "boolean"} }
+
+// Binary returns the schema of an uploaded file.
+func Binary() *Schema { return &Schema{Type: "string", Format: "binary"} }
+
+// Any returns a schema any value matches.
+func Any() *Schema { return &Schema{} }
+
+// ArrayOf returns the schema of an array of items.
+func ArrayOf(items *Schema) *Schema { return &Schema{Type: "array", Items: items} }
+
+// MapOf returns the schema of an object whose properties all have a schema.
+func MapOf(values *Schema) *Schema { return &Schema{Type: "object", AdditionalProperties: values} }
+
+// Object returns the schema of an object with the given properties, of
+// which the named ones are required.
+func Object(properties map[string]*Schema, required ...string) *Schema {
+	return &Schema{Type: "object", Properties: properties, Required: required}
+}
+
+// Describe sets the description of a schema and returns it.
+func (s *Schema) Describe(description string) *Schema {
+	s.Description = description
+	return s
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// SchemaOf returns the schema of the JSON encoding of v's type. Named struct
+// types are added to the components and referred to. Properties follow the
+// json tags, and those with a binding:"required" tag are required. The oneof
+// and max bindings of strings become their enum and maxLength.
+func (d *Document) SchemaOf(v interface{}) *Schema {
+	return d.schemaFor(reflect.TypeOf(v))
+}
+
+func (d *Document) schemaFor(t reflect.Type) *Schema {
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := d.schemaFor(t.Elem())
+		if schema.Ref != "" {
+			return &Schema{AllOf: []*Schema{schema}, Nullable: true}
+		}
+		schema.Nullable = true
+		return schema
+	case reflect.Bool:
+		return Boolean()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Integer()
+	case reflect.Float32, reflect.Float64:
+		return Number()
+	case reflect.String:
+		return String()
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte", Nullable: true}
+		}
+		// Nil slices are encoded as null
+		schema := ArrayOf(d.schemaFor(t.Elem()))
+		schema.Nullable = true
+		return schema
+	case reflect.Array:
+		return ArrayOf(d.schemaFor(t.Elem()))
+	case reflect.Map:
+		schema := MapOf(d.schemaFor(t.Elem()))
+		schema.Nullable = true
+		return schema
+	case reflect.Struct:
+		if t.Name() == "" {
+			return d.structSchema(t)
+		}
+		return d.component(t)
+	default:
+		return Any()
+	}
+}
+
+// component adds a named struct type to the components, once, and returns a
+// reference to it. Types of different packages with the same name are told
+// apart by their package name.
+func (d *Document) component(t reflect.Type) *Schema {
+	name, ok := d.names[t]
+	if !ok {
+		name = t.Name()
+		if _, taken := d.Components.Schemas[name]; taken {
+			pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+			name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+		}
+		d.names[t] = name
+		d.Components.Schemas[name] = nil // Reserved while recursive types are reflected
+		d.Components.Schemas[name] = d.structSchema(t)
+	}
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+// structSchema returns the schema of a struct type, as encoding/json writes
+// it: embedded structs are flattened and fields tagged "-" left out.
+func (d *Document) structSchema(t reflect.Type) *Schema {
+	schema := Object(map[string]*Schema{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			embedded := d.structSchema(field.Type)
+			for property, propertySchema := range embedded.Properties {
+				schema.Properties[property] = propertySchema
+			}
+			schema.Required = append(schema.Required, embedded.Required...)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		property := d.schemaFor(field.Type)
+		for _, rule := range strings.Split(field.Tag.Get("binding"), ",") {
+			rule, param, _ := strings.Cut(rule, "=")
+			switch {
+			case rule == "required":
+				schema.Required = append(schema.Required, name)
+			case rule == "oneof" && property.Type == "string":
+				property.Enum = strings.Fields(param)
+			case rule == "max" && property.Type == "string":
+				property.MaxLength, _ = strconv.Atoi(param)
+			}
+		}
+		schema.Properties[name] = property
+	}
+	return schema
+}
+
+// PropertiesOf returns the property schemas of a struct, for response
+// objects built from some of its fields.
+func (d *Document) PropertiesOf(v interface{}) map[string]*Schema {
+	return d.structSchema(reflect.TypeOf(v)).Properties
+}
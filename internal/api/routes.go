@@ -2,6 +2,7 @@ package api
 
 import (
 	"dietsense/internal/api/handlers"
+	"dietsense/internal/jobs"
 	"dietsense/internal/middleware"
 	"dietsense/internal/repositories"
 	"dietsense/internal/services"
@@ -10,8 +11,14 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// SetupRoutes configures the API routes
-func SetupRoutes(router *gin.Engine, factory *services.ServiceFactory, db repositories.Database) {
+// SetupRoutes configures the API routes. Background analyses are handed to
+// queue, which the caller starts and stops; without one, async requests are
+// refused.
+func SetupRoutes(router *gin.Engine, factory *services.ServiceFactory, db repositories.Database, queue *jobs.Queue) {
+	// Every request gets an ID, and errors handlers record are written as one
+	// envelope
+	router.Use(middleware.RequestID(), middleware.HandleErrors())
+
 	router.GET("/ping", func(c *gin.Context) {
 		c.JSON(200, gin.H{"message": "pong"})
 	})
@@ -19,9 +26,60 @@ func SetupRoutes(router *gin.Engine, factory *services.ServiceFactory, db reposi
 	//allowedIPs := []string{"127.0.0.1", "::1", "your_allowed_ip1", "your_allowed_ip2"}
 	allowedIPs := config.Config.AllowedIPs
 
+	// Repeats of a request sent with an Idempotency-Key get the first response
+	idempotent := middleware.Idempotent(db, config.Config.IdempotencyWindow, handlers.MaxRequestBodySize)
+
 	api := router.Group("/api/v1")
 	{
-		api.POST("/analyze", handlers.AnalyzeFood(factory, db))
-		api.POST("/generate-api-key", middleware.RestrictToIPs(allowedIPs), handlers.GenerateAPIKey(db))
+		api.POST("/analyze", middleware.IdentifyAPIKey(db), idempotent, handlers.AnalyzeFood(factory, db, queue))
+		api.POST("/analyze/recipe", middleware.IdentifyAPIKey(db), handlers.AnalyzeRecipe(factory, db))
+		api.POST("/analyze/batch", middleware.IdentifyAPIKey(db), idempotent, handlers.AnalyzeBatch(factory, db))
+		api.POST("/analyze/stream", middleware.IdentifyAPIKey(db), handlers.AnalyzeFoodStream(factory, db))
+		api.POST("/generate-api-key", middleware.RestrictToIPs(allowedIPs), idempotent, handlers.GenerateAPIKey(db))
+
+		api.GET("/openapi.json", handlers.GetOpenAPISpec(Spec()))
+		api.GET("/docs", handlers.APIDocs())
+	}
+
+	// Endpoints scoped to the caller's API key
+	authorized := api.Group("", middleware.RequireAPIKey(db))
+	{
+		authorized.POST("/analyses/:id/refine", handlers.RefineAnalysis(factory, db))
+		authorized.GET("/jobs/:id", handlers.GetAnalysisJob(db))
+		authorized.POST("/blobs", handlers.UploadBlob(db))
+		authorized.GET("/webhook", handlers.GetWebhook(db))
+		authorized.PUT("/webhook", handlers.UpdateWebhook(db))
+		authorized.DELETE("/webhook", handlers.DeleteWebhook(db))
+
+		authorized.GET("/config", handlers.GetUserConfig(db))
+		authorized.PUT("/config", handlers.UpdateUserConfig(db))
+
+		authorized.GET("/logs", handlers.ListFoodLog(db))
+		authorized.GET("/logs/:id", handlers.GetFoodLogEntry(db))
+		authorized.PATCH("/logs/:id", handlers.UpdateFoodLogEntry(db))
+		authorized.DELETE("/logs/:id", handlers.DeleteFoodLogEntry(db))
+		authorized.GET("/summaries", handlers.SummarizeFoodLog(db))
+		authorized.GET("/insights", handlers.GetInsights(factory, db))
+
+		authorized.GET("/templates", handlers.ListMealTemplates(db))
+		authorized.POST("/templates", handlers.CreateMealTemplate(db))
+		authorized.GET("/templates/:id", handlers.GetMealTemplate(db))
+		authorized.PATCH("/templates/:id", handlers.UpdateMealTemplate(db))
+		authorized.DELETE("/templates/:id", handlers.DeleteMealTemplate(db))
+		authorized.POST("/templates/:id/log", handlers.LogMealTemplate(db))
+
+		authorized.GET("/goals", handlers.ListGoals(db))
+		authorized.POST("/goals", handlers.CreateGoal(db))
+		authorized.GET("/goals/progress", handlers.GoalProgress(db))
+		authorized.GET("/goals/:id", handlers.GetGoal(db))
+		authorized.PUT("/goals/:id", handlers.UpdateGoal(db))
+		authorized.DELETE("/goals/:id", handlers.DeleteGoal(db))
+
+		authorized.GET("/profile", handlers.GetProfile(db))
+		authorized.PUT("/profile", handlers.UpdateProfile(db))
+		authorized.GET("/profile/weights", handlers.ListWeightEntries(db))
+		authorized.POST("/profile/weights", handlers.AddWeightEntry(db))
+		authorized.GET("/profile/suggestions", handlers.SuggestGoals(db))
+		authorized.POST("/profile/suggestions/adopt", handlers.AdoptSuggestedGoals(db))
 	}
 }
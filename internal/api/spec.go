@@ -0,0 +1,630 @@
+package api
+
+import (
+	"dietsense/internal/api/handlers"
+	"dietsense/internal/api/openapi"
+	"dietsense/internal/jobs"
+	"dietsense/internal/middleware"
+	"dietsense/internal/models"
+	"dietsense/internal/nutrition"
+	"dietsense/internal/services"
+	"dietsense/pkg/apperrors"
+	"net/http"
+)
+
+// Spec describes the HTTP API. Request bodies and typed responses are
+// reflected from the Go types; responses the handlers build as maps are
+// described here from the types of their fields. The contract tests check
+// real responses against it, so keep it in step with the routes.
+func Spec() *openapi.Document {
+	d := openapi.NewDocument(openapi.Info{
+		Title:   "DietSense API",
+		Version: "1.0.0",
+		Description: "Nutrition analysis of food photos, nutrition labels, barcodes and text, " +
+			"with a food log, goals and insights.",
+	})
+	d.Components.SecuritySchemes = map[string]openapi.SecurityScheme{
+		"apiKey": {Type: "apiKey", In: "header", Name: middleware.APIKeyHeader},
+	}
+	d.Components.Schemas["Error"] = errorSchema
+	s := newSpecSchemas(d)
+
+	d.Add("GET", "/ping", &openapi.Operation{
+		Summary:   "Check that the server is up",
+		Tags:      []string{"health"},
+		Responses: ok(openapi.Object(map[string]*openapi.Schema{"message": openapi.String()}, "message")),
+	})
+	d.Add("GET", "/api/v1/openapi.json", &openapi.Operation{
+		Summary:   "This document",
+		Tags:      []string{"docs"},
+		Responses: ok(openapi.Any()),
+	})
+	d.Add("GET", "/api/v1/docs", &openapi.Operation{
+		Summary:   "Browse this document",
+		Tags:      []string{"docs"},
+		Responses: map[string]*openapi.Response{"200": {Description: "HTML page"}},
+	})
+
+	// Analysis
+	d.Add("POST", "/api/v1/analyze", &openapi.Operation{
+		Summary: "Analyze a food photo, nutrition label, barcode or description",
+		Description: "Several images of the same item are analyzed together. With async=true the analysis " +
+			"runs in the background and 202 is returned with the job to poll. JSON bodies send images " +
+			"base64 encoded, or as the IDs of blobs uploaded to /blobs.",
+		Tags:        []string{"analysis"},
+		Security:    optionalAPIKey,
+		Parameters:  append(analysisParams(), idempotencyKey),
+		RequestBody: s.analyzeBody,
+		Responses: withError(map[string]*openapi.Response{
+			"200": {Description: "The analysis", Content: openapi.JSONContent(s.analysis)},
+			"202": {Description: "The analysis job was queued", Content: openapi.JSONContent(s.jobAccepted)},
+		}),
+	})
+	d.Add("POST", "/api/v1/analyze/stream", &openapi.Operation{
+		Summary: "Analyze as /analyze, streaming progress as server-sent events",
+		Description: "Events: classified, analyzing, summary (each piece of the summary), nutrient (each " +
+			"nutrition entry once written), then result (the /analyze response) or error.",
+		Tags:        []string{"analysis"},
+		Security:    optionalAPIKey,
+		Parameters:  analysisParams(),
+		RequestBody: s.analyzeBody,
+		Responses: withError(map[string]*openapi.Response{
+			"200": {Description: "Event stream", Content: map[string]openapi.MediaType{"text/event-stream": {Schema: openapi.String()}}},
+		}),
+	})
+	d.Add("POST", "/api/v1/analyze/batch", &openapi.Operation{
+		Summary:    "Analyze several items in one request",
+		Tags:       []string{"analysis"},
+		Security:   optionalAPIKey,
+		Parameters: append(analysisParams(), idempotencyKey),
+		RequestBody: &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{
+			"application/json":    {Schema: d.SchemaOf(handlers.BatchAnalysisRequest{})},
+			"multipart/form-data": {Schema: s.batchForm},
+		}},
+		Responses: withError(ok(s.batch)),
+	})
+	d.Add("POST", "/api/v1/analyze/recipe", &openapi.Operation{
+		Summary:     "Analyze a recipe from its ingredient list",
+		Tags:        []string{"analysis"},
+		Security:    optionalAPIKey,
+		Parameters:  []openapi.Parameter{regionParam},
+		RequestBody: jsonBody(d.SchemaOf(handlers.RecipeRequest{})),
+		Responses:   withError(ok(s.recipe)),
+	})
+	d.Add("POST", "/api/v1/analyses/:id/refine", &openapi.Operation{
+		Summary:     "Correct an analysis, keeping the earlier revision",
+		Tags:        []string{"analysis"},
+		Security:    requiredAPIKey,
+		Parameters:  []openapi.Parameter{regionParam},
+		RequestBody: jsonBody(d.SchemaOf(handlers.RefineRequest{})),
+		Responses:   withError(ok(s.refined)),
+	})
+	d.Add("POST", "/api/v1/blobs", &openapi.Operation{
+		Summary:     "Upload an image to analyze later by its ID",
+		Description: "Blobs are only visible to the API key that uploaded them and expire after a day by default.",
+		Tags:        []string{"analysis"},
+		Security:    requiredAPIKey,
+		RequestBody: multipartBody(openapi.Object(map[string]*openapi.Schema{
+			"image": openapi.Binary().Describe("The image, at most 10 MB"),
+		}, "image")),
+		Responses: withError(created(s.blob)),
+	})
+	d.Add("GET", "/api/v1/jobs/:id", &openapi.Operation{
+		Summary:   "Poll a background analysis",
+		Tags:      []string{"analysis"},
+		Security:  requiredAPIKey,
+		Responses: withError(ok(d.SchemaOf(models.AnalysisJob{}))),
+	})
+
+	// API keys and webhooks
+	d.Add("POST", "/api/v1/generate-api-key", &openapi.Operation{
+		Summary:     "Create an API key",
+		Description: "Only allowed from the configured addresses.",
+		Tags:        []string{"keys"},
+		Parameters:  []openapi.Parameter{idempotencyKey},
+		RequestBody: jsonBody(d.SchemaOf(handlers.APIKeyRequest{})),
+		Responses:   withError(ok(s.apiKey)),
+	})
+	d.Add("GET", "/api/v1/webhook", &openapi.Operation{
+		Summary:   "The callback URL finished background analyses are posted to",
+		Tags:      []string{"keys"},
+		Security:  requiredAPIKey,
+		Responses: withError(ok(s.webhook)),
+	})
+	d.Add("PUT", "/api/v1/webhook", &openapi.Operation{
+		Summary:     "Register a callback URL with a new signing secret",
+		Description: "Webhooks are signed in the " + jobs.SignatureHeader + " header; the secret is only returned here.",
+		Tags:        []string{"keys"},
+		Security:    requiredAPIKey,
+		RequestBody: jsonBody(d.SchemaOf(handlers.WebhookRequest{})),
+		Responses:   withError(ok(s.webhookSecret)),
+	})
+	d.Add("DELETE", "/api/v1/webhook", &openapi.Operation{
+		Summary:   "Stop posting finished background analyses",
+		Tags:      []string{"keys"},
+		Security:  requiredAPIKey,
+		Responses: withError(noContent()),
+	})
+	d.Add("GET", "/api/v1/config", &openapi.Operation{
+		Summary:   "The caller's preferences",
+		Tags:      []string{"keys"},
+		Security:  requiredAPIKey,
+		Responses: withError(ok(d.SchemaOf(models.UserConfig{}))),
+	})
+	d.Add("PUT", "/api/v1/config", &openapi.Operation{
+		Summary:     "Replace the caller's preferences",
+		Tags:        []string{"keys"},
+		Security:    requiredAPIKey,
+		RequestBody: jsonBody(d.SchemaOf(models.UserConfig{})),
+		Responses:   withError(ok(d.SchemaOf(models.UserConfig{}))),
+	})
+
+	// Food log
+	d.Add("GET", "/api/v1/logs", &openapi.Operation{
+		Summary:  "List food log entries, newest first",
+		Tags:     []string{"food log"},
+		Security: requiredAPIKey,
+		Parameters: []openapi.Parameter{
+			query("from", "Start, an RFC 3339 time or a YYYY-MM-DD date"),
+			query("to", "End, an RFC 3339 time or a YYYY-MM-DD date (inclusive)"),
+			query("meal_type", "Only entries of this meal type"),
+			query("input_type", "Only entries of this input type"),
+			query("q", "Only entries whose summary contains this text"),
+			{Name: "limit", In: "query", Description: "Entries per page", Schema: openapi.Integer()},
+			query("cursor", "The next_cursor of the previous page"),
+			tzParam,
+		},
+		Responses: withError(ok(s.logPage)),
+	})
+	d.Add("GET", "/api/v1/logs/:id", &openapi.Operation{
+		Summary:   "A food log entry",
+		Tags:      []string{"food log"},
+		Security:  requiredAPIKey,
+		Responses: withError(ok(s.logEntry)),
+	})
+	d.Add("PATCH", "/api/v1/logs/:id", &openapi.Operation{
+		Summary:     "Correct a food log entry",
+		Tags:        []string{"food log"},
+		Security:    requiredAPIKey,
+		RequestBody: jsonBody(d.SchemaOf(handlers.FoodLogUpdateRequest{})),
+		Responses:   withError(ok(s.logEntry)),
+	})
+	d.Add("DELETE", "/api/v1/logs/:id", &openapi.Operation{
+		Summary:   "Delete a food log entry",
+		Tags:      []string{"food log"},
+		Security:  requiredAPIKey,
+		Responses: withError(noContent()),
+	})
+	d.Add("GET", "/api/v1/summaries", &openapi.Operation{
+		Summary:  "Nutrient totals and daily averages per day, week or month",
+		Tags:     []string{"food log"},
+		Security: requiredAPIKey,
+		Parameters: []openapi.Parameter{
+			{Name: "period", In: "query", Schema: &openapi.Schema{Type: "string", Enum: []string{"day", "week", "month"}}},
+			query("from", "First day, YYYY-MM-DD"),
+			query("to", "Last day, YYYY-MM-DD"),
+			tzParam,
+			regionParam,
+		},
+		Responses: withError(ok(s.summaries)),
+	})
+	d.Add("GET", "/api/v1/insights", &openapi.Operation{
+		Summary:  "Patterns found in the recent food log",
+		Tags:     []string{"food log"},
+		Security: requiredAPIKey,
+		Parameters: []openapi.Parameter{
+			{Name: "days", In: "query", Description: "Days to look at, including today", Schema: openapi.Integer()},
+			{Name: "narrative", In: "query", Description: "Also explain the insights in prose", Schema: openapi.Boolean()},
+			tzParam,
+		},
+		Responses: withError(ok(s.insights)),
+	})
+
+	// Meal templates
+	d.Add("GET", "/api/v1/templates", &openapi.Operation{
+		Summary:   "List meal templates",
+		Tags:      []string{"templates"},
+		Security:  requiredAPIKey,
+		Responses: withError(ok(openapi.Object(map[string]*openapi.Schema{"templates": d.SchemaOf([]models.MealTemplate{})}, "templates"))),
+	})
+	d.Add("POST", "/api/v1/templates", &openapi.Operation{
+		Summary:     "Save a meal template from an analysis, a log entry or other templates",
+		Tags:        []string{"templates"},
+		Security:    requiredAPIKey,
+		RequestBody: jsonBody(d.SchemaOf(handlers.MealTemplateRequest{})),
+		Responses:   withError(created(d.SchemaOf(models.MealTemplate{}))),
+	})
+	d.Add("GET", "/api/v1/templates/:id", &openapi.Operation{
+		Summary:   "A meal template",
+		Tags:      []string{"templates"},
+		Security:  requiredAPIKey,
+		Responses: withError(ok(d.SchemaOf(models.MealTemplate{}))),
+	})
+	d.Add("PATCH", "/api/v1/templates/:id", &openapi.Operation{
+		Summary:     "Rename a meal template or change its meal type",
+		Tags:        []string{"templates"},
+		Security:    requiredAPIKey,
+		RequestBody: jsonBody(d.SchemaOf(handlers.MealTemplateUpdateRequest{})),
+		Responses:   withError(ok(d.SchemaOf(models.MealTemplate{}))),
+	})
+	d.Add("DELETE", "/api/v1/templates/:id", &openapi.Operation{
+		Summary:   "Delete a meal template",
+		Tags:      []string{"templates"},
+		Security:  requiredAPIKey,
+		Responses: withError(noContent()),
+	})
+	d.Add("POST", "/api/v1/templates/:id/log", &openapi.Operation{
+		Summary:     "Log a meal template to the food log",
+		Tags:        []string{"templates"},
+		Security:    requiredAPIKey,
+		RequestBody: &openapi.RequestBody{Content: openapi.JSONContent(d.SchemaOf(handlers.LogMealTemplateRequest{}))},
+		Responses:   withError(created(s.logEntry)),
+	})
+
+	// Goals
+	d.Add("GET", "/api/v1/goals", &openapi.Operation{
+		Summary:   "List nutrition goals",
+		Tags:      []string{"goals"},
+		Security:  requiredAPIKey,
+		Responses: withError(ok(openapi.Object(map[string]*openapi.Schema{"goals": d.SchemaOf([]models.Goal{})}, "goals"))),
+	})
+	d.Add("POST", "/api/v1/goals", &openapi.Operation{
+		Summary:     "Add a nutrition goal",
+		Tags:        []string{"goals"},
+		Security:    requiredAPIKey,
+		RequestBody: jsonBody(d.SchemaOf(handlers.GoalRequest{})),
+		Responses:   withError(created(d.SchemaOf(models.Goal{}))),
+	})
+	d.Add("GET", "/api/v1/goals/progress", &openapi.Operation{
+		Summary:    "Progress towards the goals on a day",
+		Tags:       []string{"goals"},
+		Security:   requiredAPIKey,
+		Parameters: []openapi.Parameter{query("date", "The day, YYYY-MM-DD; today by default"), tzParam},
+		Responses:  withError(ok(s.goalProgress)),
+	})
+	d.Add("GET", "/api/v1/goals/:id", &openapi.Operation{
+		Summary:   "A nutrition goal",
+		Tags:      []string{"goals"},
+		Security:  requiredAPIKey,
+		Responses: withError(ok(d.SchemaOf(models.Goal{}))),
+	})
+	d.Add("PUT", "/api/v1/goals/:id", &openapi.Operation{
+		Summary:     "Replace a nutrition goal",
+		Tags:        []string{"goals"},
+		Security:    requiredAPIKey,
+		RequestBody: jsonBody(d.SchemaOf(handlers.GoalRequest{})),
+		Responses:   withError(ok(d.SchemaOf(models.Goal{}))),
+	})
+	d.Add("DELETE", "/api/v1/goals/:id", &openapi.Operation{
+		Summary:   "Delete a nutrition goal",
+		Tags:      []string{"goals"},
+		Security:  requiredAPIKey,
+		Responses: withError(noContent()),
+	})
+
+	// Profile
+	d.Add("GET", "/api/v1/profile", &openapi.Operation{
+		Summary:   "The body profile and its energy estimate",
+		Tags:      []string{"profile"},
+		Security:  requiredAPIKey,
+		Responses: withError(ok(s.profile)),
+	})
+	d.Add("PUT", "/api/v1/profile", &openapi.Operation{
+		Summary:     "Replace the body profile",
+		Tags:        []string{"profile"},
+		Security:    requiredAPIKey,
+		RequestBody: jsonBody(d.SchemaOf(models.Profile{})),
+		Responses:   withError(ok(s.profile)),
+	})
+	d.Add("GET", "/api/v1/profile/weights", &openapi.Operation{
+		Summary:    "The weight history, newest first",
+		Tags:       []string{"profile"},
+		Security:   requiredAPIKey,
+		Parameters: []openapi.Parameter{{Name: "limit", In: "query", Schema: openapi.Integer()}},
+		Responses:  withError(ok(openapi.Object(map[string]*openapi.Schema{"entries": d.SchemaOf([]models.WeightEntry{})}, "entries"))),
+	})
+	d.Add("POST", "/api/v1/profile/weights", &openapi.Operation{
+		Summary:     "Record a weight",
+		Tags:        []string{"profile"},
+		Security:    requiredAPIKey,
+		RequestBody: jsonBody(d.SchemaOf(handlers.WeightEntryRequest{})),
+		Responses:   withError(created(s.weightEntry)),
+	})
+	d.Add("GET", "/api/v1/profile/suggestions", &openapi.Operation{
+		Summary:    "Goals suggested by the profile",
+		Tags:       []string{"profile"},
+		Security:   requiredAPIKey,
+		Parameters: []openapi.Parameter{formulaParam},
+		Responses:  withError(ok(s.suggestions)),
+	})
+	d.Add("POST", "/api/v1/profile/suggestions/adopt", &openapi.Operation{
+		Summary:    "Replace the goals with the suggested ones",
+		Tags:       []string{"profile"},
+		Security:   requiredAPIKey,
+		Parameters: []openapi.Parameter{formulaParam},
+		Responses:  withError(ok(s.suggestions)),
+	})
+	return d
+}
+
+// specSchemas holds the schemas of the request forms and of the responses
+// built as maps.
+type specSchemas struct {
+	analyzeForm, batchForm, blob            *openapi.Schema
+	analyzeBody                             *openapi.RequestBody
+	analysis, jobAccepted, batch, recipe    *openapi.Schema
+	refined, apiKey, webhook, webhookSecret *openapi.Schema
+	logEntry, logPage, summaries, insights  *openapi.Schema
+	goalProgress, profile, weightEntry      *openapi.Schema
+	suggestions                             *openapi.Schema
+}
+
+func newSpecSchemas(d *openapi.Document) specSchemas {
+	var s specSchemas
+	facts := d.SchemaOf(nutrition.Facts{})
+	allergens := d.SchemaOf([]nutrition.AllergenFlag{})
+	energy := d.SchemaOf(&services.EnergyEstimate{})
+
+	analyzeFields := d.PropertiesOf(handlers.AnalyzeRequest{})
+	mealType := openapi.String().Describe("Meal type; guessed from the time by default")
+	mealType.Enum = models.MealTypes
+	s.analyzeForm = openapi.Object(map[string]*openapi.Schema{
+		"image":      openapi.Binary().Describe("A photo of the food, its nutrition label or its barcode, at most 10 MB"),
+		"images":     openapi.ArrayOf(openapi.Binary()).Describe("Several images of the same item, at most 5"),
+		"context":    analyzeFields["context"].Describe("What was eaten, or more about the images; required without an image"),
+		"input_type": analyzeFields["input_type"].Describe("What the images show; skips their classification"),
+		"eaten_at":   openapi.String().Describe("When it was eaten, RFC 3339; read from the photo by default"),
+		"meal_type":  mealType,
+		"save":       openapi.Boolean().Describe("Record the analysis in the food log"),
+		"async":      openapi.Boolean().Describe("Run in the background and return the job"),
+	})
+	s.analyzeBody = &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{
+		"multipart/form-data": {Schema: s.analyzeForm},
+		"application/json":    {Schema: d.SchemaOf(handlers.AnalyzeRequest{})},
+	}}
+	s.blob = openapi.Object(map[string]*openapi.Schema{
+		"blob_id":    openapi.String().Describe("The ID to send as blob_id to /analyze"),
+		"size":       openapi.Integer(),
+		"expires_at": &openapi.Schema{Type: "string", Format: "date-time"},
+	}, "blob_id", "size", "expires_at")
+	s.batchForm = openapi.Object(map[string]*openapi.Schema{
+		"items":  openapi.String().Describe("JSON array of items, as in the JSON body, naming their file fields"),
+		"images": openapi.ArrayOf(openapi.Binary()).Describe("One image per item, without items"),
+		"save":   openapi.Boolean(),
+	})
+
+	result := d.PropertiesOf(services.AnalysisResult{})
+	classifiedBy := openapi.String()
+	classifiedBy.Enum = []string{services.ClassifiedByHint, services.ClassifiedByClassifier, services.ClassifiedByAnalyzer, services.ClassifiedByLocal}
+	analysis := map[string]*openapi.Schema{
+		"analysis_id":        openapi.String().Describe("Set for callers with an API key, to refine the analysis"),
+		"log_entry_id":       openapi.String().Describe("The food log entry, with save=true"),
+		"nutrition_info":     facts,
+		"summary":            result["summary"],
+		"confidence":         result["confidence"],
+		"input_type":         result["input_type"],
+		"service":            result["service"],
+		"ingredients":        result["ingredients"],
+		"allergens":          allergens,
+		"diets":              result["diets"],
+		"allergy_alerts":     d.SchemaOf([]nutrition.AllergenFlag{}).Describe("Allergens found that the caller is allergic to"),
+		"daily_value_region": openapi.String(),
+		"eaten_at":           &openapi.Schema{Type: "string", Format: "date-time"},
+		"eaten_at_source":    openapi.String(),
+		"meal_type":          openapi.String(),
+		"meal_type_source":   openapi.String(),
+		"classification":     classifiedBy.Describe("How the input type of the primary image was decided; not sent for text"),
+		"images": openapi.ArrayOf(openapi.Object(map[string]*openapi.Schema{
+			"index":         openapi.Integer(),
+			"input_type":    openapi.Integer(),
+			"classified_by": classifiedBy,
+		}, "index", "input_type", "classified_by")),
+	}
+	s.analysis = component(d, "AnalysisResponse", openapi.Object(analysis,
+		"nutrition_info", "summary", "confidence", "input_type", "service", "allergens", "diets",
+		"eaten_at", "eaten_at_source", "meal_type", "meal_type_source"))
+	s.jobAccepted = openapi.Object(map[string]*openapi.Schema{
+		"job_id":     openapi.String(),
+		"status":     openapi.String(),
+		"status_url": openapi.String(),
+	}, "job_id", "status", "status_url")
+
+	batchItem := map[string]*openapi.Schema{
+		"index":   openapi.Integer(),
+		"status":  openapi.Integer().Describe("HTTP status the item would have had on its own"),
+		"error":   openapi.String(),
+		"code":    errorCodeSchema(),
+		"details": openapi.String(),
+		"allowed": openapi.ArrayOf(openapi.String()),
+		"fields":  fieldErrorsSchema(),
+	}
+	for name, schema := range analysis {
+		batchItem[name] = schema
+	}
+	s.batch = openapi.Object(map[string]*openapi.Schema{
+		"items":     openapi.ArrayOf(openapi.Object(batchItem, "index", "status")),
+		"succeeded": openapi.Integer(),
+		"failed":    openapi.Integer(),
+	}, "items", "succeeded", "failed")
+
+	recipe := d.PropertiesOf(services.RecipeAnalysis{})
+	recipe["daily_value_region"] = openapi.String()
+	recipe["service"] = openapi.String().Describe("Service that estimated unknown ingredients")
+	recipe["model_error"] = &openapi.Schema{Ref: "#/components/schemas/Error"}
+	s.recipe = openapi.Object(recipe, "servings", "ingredients", "total", "per_serving", "unresolved")
+
+	refined := map[string]*openapi.Schema{
+		"analysis_id":        openapi.String(),
+		"parent_id":          openapi.String(),
+		"revision":           openapi.Integer(),
+		"changes":            d.SchemaOf([]services.NutrientChange{}),
+		"daily_value_region": openapi.String(),
+	}
+	for _, name := range []string{"nutrition_info", "summary", "confidence", "input_type", "service", "ingredients", "allergens", "diets"} {
+		refined[name] = analysis[name]
+	}
+	s.refined = openapi.Object(refined, "analysis_id", "parent_id", "revision", "nutrition_info", "summary", "changes")
+
+	s.apiKey = openapi.Object(map[string]*openapi.Schema{
+		"api_key":        openapi.String(),
+		"callback_url":   openapi.String(),
+		"webhook_secret": openapi.String(),
+	}, "api_key")
+	s.webhook = openapi.Object(map[string]*openapi.Schema{"callback_url": openapi.String()}, "callback_url")
+	s.webhookSecret = openapi.Object(map[string]*openapi.Schema{
+		"callback_url":   openapi.String(),
+		"webhook_secret": openapi.String(),
+	}, "callback_url", "webhook_secret")
+
+	timestamp := &openapi.Schema{Type: "string", Format: "date-time"}
+	logEntry := map[string]*openapi.Schema{
+		"id":             openapi.String(),
+		"analysis_id":    openapi.String(),
+		"template_id":    openapi.String(),
+		"eaten_at":       timestamp.Describe("In the time zone it was eaten in"),
+		"meal_type":      openapi.String(),
+		"input_type":     openapi.String(),
+		"service":        openapi.String(),
+		"model":          openapi.String(),
+		"summary":        openapi.String(),
+		"nutrition_info": facts,
+		"allergens":      allergens,
+		"diets":          result["diets"],
+		"image_hash":     openapi.String(),
+		"thumbnail":      openapi.String().Describe("JPEG data URL, on single entries"),
+		"created_at":     &openapi.Schema{Type: "string", Format: "date-time"},
+		"updated_at":     &openapi.Schema{Type: "string", Format: "date-time"},
+	}
+	s.logEntry = component(d, "FoodLogEntryResponse", openapi.Object(logEntry, "id", "eaten_at", "meal_type", "summary", "nutrition_info"))
+	s.logPage = openapi.Object(map[string]*openapi.Schema{
+		"entries":     openapi.ArrayOf(s.logEntry),
+		"next_cursor": openapi.String().Describe("Set when another page follows"),
+	}, "entries")
+
+	summary := d.SchemaOf(&services.NutritionSummary{})
+	s.summaries = openapi.Object(map[string]*openapi.Schema{
+		"period":             openapi.String(),
+		"time_zone":          openapi.String(),
+		"summaries":          openapi.ArrayOf(summary),
+		"overall":            summary,
+		"daily_value_region": openapi.String(),
+	}, "period", "time_zone", "summaries", "overall")
+	s.insights = openapi.Object(map[string]*openapi.Schema{
+		"from":            openapi.String(),
+		"to":              openapi.String(),
+		"time_zone":       openapi.String(),
+		"insights":        d.SchemaOf([]services.Insight{}),
+		"narrative":       openapi.String(),
+		"service":         openapi.String(),
+		"narrative_error": &openapi.Schema{Ref: "#/components/schemas/Error"},
+	}, "from", "to", "time_zone", "insights")
+
+	s.goalProgress = openapi.Object(map[string]*openapi.Schema{
+		"date":      openapi.String(),
+		"time_zone": openapi.String(),
+		"entries":   openapi.Integer(),
+		"totals":    facts,
+		"goals":     d.SchemaOf([]services.GoalProgress{}),
+		"met":       openapi.Integer(),
+		"exceeded":  openapi.Integer(),
+		"under":     openapi.Integer(),
+	}, "date", "time_zone", "entries", "totals", "goals", "met", "exceeded", "under")
+	s.profile = openapi.Object(map[string]*openapi.Schema{
+		"profile": d.SchemaOf(&models.Profile{}),
+		"energy":  energy,
+	}, "profile", "energy")
+	s.weightEntry = openapi.Object(map[string]*openapi.Schema{
+		"entry":  d.SchemaOf(&models.WeightEntry{}),
+		"energy": energy,
+	}, "entry")
+	s.suggestions = openapi.Object(map[string]*openapi.Schema{
+		"energy": energy,
+		"goals":  d.SchemaOf([]models.Goal{}),
+	}, "energy", "goals")
+	return s
+}
+
+// component adds a schema to the components and returns a reference to it.
+func component(d *openapi.Document, name string, schema *openapi.Schema) *openapi.Schema {
+	d.Components.Schemas[name] = schema
+	return &openapi.Schema{Ref: "#/components/schemas/" + name}
+}
+
+var (
+	optionalAPIKey = []map[string][]string{{}, {"apiKey": {}}}
+	requiredAPIKey = []map[string][]string{{"apiKey": {}}}
+
+	idempotencyKey = openapi.Parameter{
+		Name: middleware.IdempotencyKeyHeader, In: "header", Schema: openapi.String(),
+		Description: "Repeats of the request with the same key return the first response",
+	}
+	tzParam      = query("tz", "IANA time zone, e.g. Europe/Paris; the saved preference by default")
+	regionParam  = query("region", "Region of the %DV reference intakes: fda, eu, ca or a configured one")
+	formulaParam = query("formula", "BMR equation")
+)
+
+func analysisParams() []openapi.Parameter {
+	return []openapi.Parameter{tzParam, regionParam}
+}
+
+func query(name, description string) openapi.Parameter {
+	return openapi.Parameter{Name: name, In: "query", Description: description, Schema: openapi.String()}
+}
+
+func jsonBody(schema *openapi.Schema) *openapi.RequestBody {
+	return &openapi.RequestBody{Required: true, Content: openapi.JSONContent(schema)}
+}
+
+func multipartBody(schema *openapi.Schema) *openapi.RequestBody {
+	return &openapi.RequestBody{Required: true, Content: map[string]openapi.MediaType{"multipart/form-data": {Schema: schema}}}
+}
+
+func ok(schema *openapi.Schema) map[string]*openapi.Response {
+	return map[string]*openapi.Response{"200": {Description: http.StatusText(http.StatusOK), Content: openapi.JSONContent(schema)}}
+}
+
+func created(schema *openapi.Schema) map[string]*openapi.Response {
+	return map[string]*openapi.Response{"201": {Description: http.StatusText(http.StatusCreated), Content: openapi.JSONContent(schema)}}
+}
+
+func noContent() map[string]*openapi.Response {
+	return map[string]*openapi.Response{"204": {Description: http.StatusText(http.StatusNoContent)}}
+}
+
+// withError adds the error response every operation can return.
+func withError(responses map[string]*openapi.Response) map[string]*openapi.Response {
+	responses["default"] = &openapi.Response{
+		Description: "Error",
+		Content:     openapi.JSONContent(&openapi.Schema{Ref: "#/components/schemas/Error"}),
+	}
+	return responses
+}
+
+// errorSchema is the body of error responses. Some carry more context, such
+// as the allowed values of an invalid field.
+var errorSchema = &openapi.Schema{
+	Type: "object",
+	Properties: map[string]*openapi.Schema{
+		"error":      openapi.String().Describe("Message for people"),
+		"code":       errorCodeSchema(),
+		"request_id": openapi.String().Describe("ID of the request, also sent in the " + middleware.RequestIDHeader + " header"),
+		"details":    openapi.String().Describe("Underlying cause; not sent in production"),
+		"fields":     fieldErrorsSchema(),
+	},
+	Required:             []string{"error", "code", "request_id"},
+	AdditionalProperties: openapi.Any(),
+}
+
+// fieldErrorsSchema is the schema of the problems of invalid request fields.
+func fieldErrorsSchema() *openapi.Schema {
+	return openapi.MapOf(openapi.String()).Describe("Problem of each invalid field, by field name")
+}
+
+// errorCodeSchema is the schema of the stable code of an error.
+func errorCodeSchema() *openapi.Schema {
+	schema := openapi.String().Describe("Stable code of the error, for programs")
+	for _, code := range apperrors.Codes {
+		schema.Enum = append(schema.Enum, string(code))
+	}
+	return schema
+}
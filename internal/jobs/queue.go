@@ -0,0 +1,183 @@
+package jobs
+
+import (
+	"context"
+	"dietsense/internal/models"
+	"dietsense/internal/repositories"
+	"dietsense/pkg/apperrors"
+	"dietsense/pkg/logging"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrQueueFull is returned by Enqueue when no more jobs can wait.
+var ErrQueueFull = errors.New("the job queue is full")
+
+// RunFunc does the work of a job and returns its result.
+type RunFunc func(job *models.AnalysisJob) (map[string]interface{}, error)
+
+// Queue runs analysis jobs in the background, a few at a time. Jobs are saved
+// before they are queued, so those still unfinished when the server stops are
+// queued again by Start.
+type Queue struct {
+	db       repositories.Database
+	run      RunFunc
+	workers  int
+	pending  chan *models.AnalysisJob
+	client   *http.Client
+	quit     chan struct{}
+	stopOnce sync.Once
+	running  sync.WaitGroup
+}
+
+// NewQueue returns a queue of up to size waiting jobs, run by the given
+// number of workers once started.
+func NewQueue(db repositories.Database, workers, size int, run RunFunc) *Queue {
+	return &Queue{
+		db:      db,
+		run:     run,
+		workers: max(workers, 1),
+		pending: make(chan *models.AnalysisJob, max(size, 1)),
+		client:  newWebhookClient(),
+		quit:    make(chan struct{}),
+	}
+}
+
+// Start launches the workers and queues the jobs left unfinished by an
+// earlier run of the server.
+func (q *Queue) Start() {
+	q.running.Add(q.workers)
+	for i := 0; i < q.workers; i++ {
+		go q.work()
+	}
+
+	unfinished, err := q.db.ListUnfinishedAnalysisJobs()
+	if err != nil {
+		logging.Log.Error("Failed to load unfinished analysis jobs: ", err)
+		return
+	}
+	if len(unfinished) > 0 {
+		logging.Log.Infof("Resuming %d analysis jobs", len(unfinished))
+	}
+	go func() {
+		for i := range unfinished {
+			unfinished[i].Status = models.JobQueued
+			select {
+			case q.pending <- &unfinished[i]:
+			case <-q.quit:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops taking jobs and waits until the workers have finished those
+// already queued, or until ctx is done. Jobs left unfinished stay saved and
+// are resumed by the next Start.
+func (q *Queue) Stop(ctx context.Context) error {
+	q.stopOnce.Do(func() { close(q.quit) })
+	done := make(chan struct{})
+	go func() {
+		q.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Enqueue saves a new job and queues a copy of it, so the caller can keep
+// reading the job it passed. When the queue is full the job is saved as
+// failed and ErrQueueFull is returned. Once the queue is stopped jobs are
+// only saved, for the next Start to run.
+func (q *Queue) Enqueue(job *models.AnalysisJob) error {
+	job.Status = models.JobQueued
+	if err := q.db.SaveAnalysisJob(job); err != nil {
+		return fmt.Errorf("failed to save job: %w", err)
+	}
+	queued := *job
+	select {
+	case <-q.quit:
+		return nil
+	default:
+	}
+	select {
+	case q.pending <- &queued:
+		return nil
+	default:
+		q.finish(job, nil, ErrQueueFull)
+		return ErrQueueFull
+	}
+}
+
+// work runs queued jobs until the queue is stopped, then finishes those
+// still waiting.
+func (q *Queue) work() {
+	defer q.running.Done()
+	for {
+		select {
+		case job := <-q.pending:
+			q.process(job)
+		case <-q.quit:
+			for {
+				select {
+				case job := <-q.pending:
+					q.process(job)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// process runs a job, saves its outcome and posts it to the callback URL of
+// the job's API key, if there is one.
+func (q *Queue) process(job *models.AnalysisJob) {
+	started := time.Now()
+	job.Status, job.StartedAt = models.JobRunning, &started
+	if err := q.db.SaveAnalysisJob(job); err != nil {
+		logging.Log.Error("Failed to save analysis job: ", err)
+	}
+
+	result, err := q.runSafely(job)
+	q.finish(job, result, err)
+	q.notify(job)
+}
+
+// runSafely runs a job, turning a panic into an error so one job cannot stop
+// a worker.
+func (q *Queue) runSafely(job *models.AnalysisJob) (result map[string]interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			logging.Log.Error("Analysis job panicked: ", r)
+			result, err = nil, apperrors.New(apperrors.Internal, "The job failed unexpectedly")
+		}
+	}()
+	return q.run(job)
+}
+
+// finish records the outcome of a job. Its images are dropped: the analysis
+// keeps the one it needs.
+func (q *Queue) finish(job *models.AnalysisJob, result map[string]interface{}, err error) {
+	finished := time.Now()
+	job.FinishedAt = &finished
+	job.Input.Images = nil
+	if err != nil {
+		// The cause can hold provider internals: callers get the message
+		logging.Log.WithField("job_id", job.ID).Error("Analysis job failed: ", err)
+		failure := apperrors.From(err)
+		job.Status, job.Error, job.ErrorCode = models.JobFailed, failure.Message, string(failure.Code)
+	} else {
+		job.Status, job.Result = models.JobSucceeded, result
+	}
+	if err := q.db.SaveAnalysisJob(job); err != nil {
+		logging.Log.Error("Failed to save analysis job: ", err)
+	}
+}
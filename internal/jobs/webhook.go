@@ -0,0 +1,210 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"dietsense/internal/models"
+	"dietsense/pkg/config"
+	"dietsense/pkg/logging"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Headers of webhook requests.
+const (
+	SignatureHeader = "X-DietSense-Signature"
+	TimestampHeader = "X-DietSense-Timestamp"
+)
+
+// Deliveries are tried webhookAttempts times, waiting webhookBackoff before
+// the second attempt and twice as long after each further failure.
+const (
+	webhookTimeout  = 10 * time.Second
+	webhookAttempts = 3
+	webhookBackoff  = 2 * time.Second
+)
+
+// ErrPrivateCallback is returned for callback URLs that resolve to loopback,
+// private or otherwise internal addresses.
+var ErrPrivateCallback = errors.New("callback_url must not point at a private or internal address")
+
+// internalPrefixes are the non-public ranges not covered by the netip.Addr
+// predicates used in isPublicAddr.
+var internalPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// isPublicAddr reports whether webhooks may be sent to addr.
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() ||
+		addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range internalPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckCallbackHost resolves the host of a callback URL and returns
+// ErrPrivateCallback unless all its addresses are public. Deliveries check
+// the address actually dialed again, so hosts that later resolve elsewhere
+// are still refused.
+func CheckCallbackHost(ctx context.Context, host string) error {
+	if config.Config.AllowPrivateWebhooks {
+		return nil
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		if !isPublicAddr(addr) {
+			return ErrPrivateCallback
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return fmt.Errorf("callback_url host %s could not be resolved", host)
+	}
+	for _, addr := range addrs {
+		if !isPublicAddr(addr) {
+			return ErrPrivateCallback
+		}
+	}
+	return nil
+}
+
+// dialControl refuses connections to non-public addresses unless private
+// webhooks are allowed. It runs after name resolution, on the address
+// about to be dialed.
+func dialControl(network, address string, _ syscall.RawConn) error {
+	if config.Config.AllowPrivateWebhooks {
+		return nil
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil || !isPublicAddr(addrPort.Addr()) {
+		return ErrPrivateCallback
+	}
+	return nil
+}
+
+// newWebhookClient returns the client webhooks are delivered with. It does
+// not follow redirects and only dials public addresses.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout, Control: dialControl}
+	return &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// NewWebhookSecret returns a random key to sign an API key's webhooks with.
+func NewWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// Sign returns the signature of a webhook body sent at the given Unix time:
+// "sha256=" and the hex HMAC-SHA256, keyed with the secret, of the timestamp,
+// a period and the body. Receivers recompute it to check the sender, and
+// reject old timestamps to stop replays.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookEvent is the body of a webhook request: the job as returned by
+// GET /api/v1/jobs/{id}.
+type webhookEvent struct {
+	Event string `json:"event"`
+	*models.AnalysisJob
+}
+
+// notify posts a finished job to the callback URL of its API key, retrying
+// failed deliveries, and records the outcome on the job.
+func (q *Queue) notify(job *models.AnalysisJob) {
+	apiKey, err := q.db.GetAPIKey(job.APIKey)
+	if err != nil {
+		logging.Log.Error("Failed to load API key for webhook: ", err)
+		return
+	}
+	if apiKey.CallbackURL == "" {
+		return
+	}
+
+	body, err := json.Marshal(webhookEvent{Event: "analysis_job.finished", AnalysisJob: job})
+	if err != nil {
+		logging.Log.Error("Failed to encode webhook: ", err)
+		return
+	}
+
+	job.WebhookStatus = models.WebhookFailed
+	backoff := webhookBackoff
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if err = q.deliver(apiKey, body); err == nil {
+			job.WebhookStatus = models.WebhookDelivered
+			break
+		}
+		logging.Log.Warnf("Webhook for job %s failed (attempt %d): %s", job.ID, attempt, err)
+		if errors.Is(err, ErrPrivateCallback) {
+			break
+		}
+		if attempt < webhookAttempts {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	if err := q.db.SaveAnalysisJob(job); err != nil {
+		logging.Log.Error("Failed to save analysis job: ", err)
+	}
+}
+
+// deliver makes one webhook request; any 2xx answer counts as delivered and
+// redirects are not followed.
+func (q *Queue) deliver(apiKey *models.APIKey, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, apiKey.CallbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(apiKey.WebhookSecret, timestamp, body))
+
+	resp, err := q.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("callback answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"dietsense/internal/repositories"
+	"dietsense/pkg/apperrors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHeader is the request header carrying the caller's API key.
+const APIKeyHeader = "X-API-Key"
+
+// apiKeyContextKey is the gin context key the authenticated API key is stored under.
+const apiKeyContextKey = "api_key"
+
+// IdentifyAPIKey resolves the API key header, if present, and stores it on the
+// context. Requests without a key pass through anonymously.
+func IdentifyAPIKey(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !resolveAPIKey(c, db) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireAPIKey rejects requests that do not carry a valid API key.
+func RequireAPIKey(db repositories.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !resolveAPIKey(c, db) {
+			return
+		}
+		if CurrentAPIKey(c) == "" {
+			abortWithError(c, apperrors.New(apperrors.Unauthorized, "API key required"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// CurrentAPIKey returns the API key authenticated for this request, or an
+// empty string for anonymous requests.
+func CurrentAPIKey(c *gin.Context) string {
+	return c.GetString(apiKeyContextKey)
+}
+
+// resolveAPIKey validates the API key header and stores it on the context.
+// It aborts the request and returns false when the key is unknown.
+func resolveAPIKey(c *gin.Context, db repositories.Database) bool {
+	key := c.GetHeader(APIKeyHeader)
+	if key == "" || db == nil {
+		return true
+	}
+	if _, err := db.GetAPIKey(key); err != nil {
+		abortWithError(c, apperrors.New(apperrors.Unauthorized, "Invalid API key"))
+		return false
+	}
+	c.Set(apiKeyContextKey, key)
+	return true
+}
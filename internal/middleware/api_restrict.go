@@ -1,7 +1,7 @@
 package middleware
 
 import (
-	"net/http"
+	"dietsense/pkg/apperrors"
 
 	"github.com/gin-gonic/gin"
 )
@@ -16,7 +16,6 @@ func RestrictToIPs(allowedIPs []string) gin.HandlerFunc {
 				return
 			}
 		}
-		c.JSON(http.StatusForbidden, gin.H{"error": "Access restricted"})
-		c.Abort()
+		abortWithError(c, apperrors.New(apperrors.Forbidden, "Access restricted"))
 	}
 }
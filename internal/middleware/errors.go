@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"dietsense/pkg/apperrors"
+	"dietsense/pkg/config"
+	"dietsense/pkg/logging"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the ID of a request. Clients may set it to trace a
+// request of theirs; otherwise one is generated. It is echoed on the response
+// and in error bodies.
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDContextKey is the gin context key the request ID is stored under.
+const requestIDContextKey = "request_id"
+
+// validRequestID limits client-chosen request IDs to what is safe to log and
+// echo back.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// RequestID assigns every request an ID.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = uuid.New().String()
+		}
+		c.Set(requestIDContextKey, id)
+		c.Header(RequestIDHeader, id)
+		c.Next()
+	}
+}
+
+// CurrentRequestID returns the ID of the request.
+func CurrentRequestID(c *gin.Context) string {
+	return c.GetString(requestIDContextKey)
+}
+
+// HandleErrors writes the error a handler recorded with c.Error, when it did
+// not write a response itself. The status follows the error's code.
+func HandleErrors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		renderError(c)
+	}
+}
+
+// ErrorBody returns the error envelope of err:
+//
+//	{"error": "message", "code": "invalid_input", "request_id": "...", "details": "cause"}
+//
+// Details, the text of the underlying cause, are left out in production, as
+// they can hold provider or database internals. Fields of the error are added
+// alongside.
+func ErrorBody(c *gin.Context, err error) gin.H {
+	body := ErrorFields(err)
+	body["request_id"] = CurrentRequestID(c)
+	return body
+}
+
+// ErrorFields returns the envelope of err without the request ID, for errors
+// reported within a response, such as those of batch items.
+func ErrorFields(err error) gin.H {
+	appErr := apperrors.From(err)
+	fields := gin.H{}
+	for key, value := range appErr.Fields {
+		fields[key] = value
+	}
+	fields["error"] = appErr.Message
+	fields["code"] = appErr.Code
+	if appErr.Err != nil && config.Config.Environment != "production" {
+		fields["details"] = appErr.Err.Error()
+	}
+	return fields
+}
+
+// renderError writes the last error recorded on the context, if the response
+// has not been written yet.
+func renderError(c *gin.Context) {
+	if len(c.Errors) == 0 || c.Writer.Written() {
+		return
+	}
+	err := c.Errors.Last().Err
+	status := apperrors.Status(apperrors.CodeOf(err))
+	if status >= 500 {
+		logging.Log.WithField("request_id", CurrentRequestID(c)).Error(err)
+	}
+	c.JSON(status, ErrorBody(c, err))
+}
+
+// abortWithError records err for HandleErrors and stops the request.
+func abortWithError(c *gin.Context, err error) {
+	c.Error(err)
+	c.Abort()
+}
@@ -0,0 +1,198 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"dietsense/internal/models"
+	"dietsense/internal/repositories"
+	"dietsense/pkg/apperrors"
+	"dietsense/pkg/logging"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IdempotencyKeyHeader is the request header that makes a repeated request
+// return the response of the first one instead of being processed again.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayedHeader is set on responses replayed from an earlier request.
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+const (
+	maxIdempotencyKeyLength  = 255
+	defaultIdempotencyWindow = 24 * time.Hour
+)
+
+// Idempotent honors the Idempotency-Key header. The first request with a key
+// is processed and its response stored for the window; repeats of the same
+// request within it get the stored response back. Keys are scoped to the
+// caller's API key, so this runs after IdentifyAPIKey, or to the client's
+// address without one. Server errors and panics are not stored, so a request
+// that failed that way can be retried with its key. Bodies are read to
+// fingerprint the request, so those over maxBody bytes are refused.
+func Idempotent(db repositories.Database, window time.Duration, maxBody int64) gin.HandlerFunc {
+	if window <= 0 {
+		window = defaultIdempotencyWindow
+	}
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" || db == nil {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			abortWithError(c, apperrors.New(apperrors.InvalidInput, "Idempotency-Key is too long"))
+			return
+		}
+		fingerprint, err := requestFingerprint(c, maxBody)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			abortWithError(c, apperrors.New(apperrors.PayloadTooLarge, fmt.Sprintf("Request body is larger than %d bytes", tooLarge.Limit)))
+			return
+		}
+		if err != nil {
+			abortWithError(c, apperrors.New(apperrors.InvalidInput, "Failed to read request body").WithCause(err))
+			return
+		}
+
+		record := &models.IdempotencyRecord{Scope: idempotencyScope(c), Key: key, Fingerprint: fingerprint}
+		existing, err := claimIdempotencyKey(db, record, window)
+		if err != nil {
+			abortWithError(c, apperrors.Wrap(err, "Failed to check Idempotency-Key"))
+			return
+		}
+		if existing != nil {
+			replay(c, existing, fingerprint)
+			c.Abort()
+			return
+		}
+
+		// A panicking handler leaves no response to store, so the key is
+		// released before the panic reaches the recovery middleware
+		defer func() {
+			if p := recover(); p != nil {
+				releaseIdempotencyKey(db, record)
+				panic(p)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+		// Render a recorded error here rather than in HandleErrors, so that
+		// it is part of the stored response
+		renderError(c)
+
+		if recorder.Status() >= http.StatusInternalServerError {
+			releaseIdempotencyKey(db, record)
+			return
+		}
+		record.StatusCode = recorder.Status()
+		record.Header = recorder.Header().Clone()
+		record.Body = recorder.body.Bytes()
+		if err := db.CompleteIdempotencyRecord(record); err != nil {
+			logging.Log.Error("Failed to save idempotent response: ", err)
+		}
+	}
+}
+
+// idempotencyScope is what keys are scoped to: the caller's API key, or the
+// client's address for requests without one.
+func idempotencyScope(c *gin.Context) string {
+	if apiKey := CurrentAPIKey(c); apiKey != "" {
+		return apiKey
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// releaseIdempotencyKey deletes the record of a request that produced no
+// response worth replaying, so its key can be used again.
+func releaseIdempotencyKey(db repositories.Database, record *models.IdempotencyRecord) {
+	if err := db.DeleteIdempotencyRecord(record.Scope, record.Key); err != nil {
+		logging.Log.Error("Failed to release idempotency key: ", err)
+	}
+}
+
+// claimIdempotencyKey saves a record for a key not used within the window.
+// When the key is taken it returns the record already stored for it.
+func claimIdempotencyKey(db repositories.Database, record *models.IdempotencyRecord, window time.Duration) (*models.IdempotencyRecord, error) {
+	if err := db.DeleteIdempotencyRecordsBefore(time.Now().Add(-window)); err != nil {
+		return nil, err
+	}
+	created, err := db.CreateIdempotencyRecord(record)
+	if err != nil || created {
+		return nil, err
+	}
+	existing, err := db.GetIdempotencyRecord(record.Scope, record.Key)
+	if errors.Is(err, repositories.ErrNotFound) {
+		// Released by a request that failed in the meantime: take it over
+		return claimIdempotencyKey(db, record, window)
+	}
+	return existing, err
+}
+
+// replay writes the stored response of a key, unless the key was first used
+// with a different request or that request has not finished yet.
+func replay(c *gin.Context, record *models.IdempotencyRecord, fingerprint string) {
+	if record.Fingerprint != fingerprint {
+		c.Error(apperrors.New(apperrors.Unprocessable, "Idempotency-Key was already used with a different request"))
+		return
+	}
+	if !record.Completed() {
+		c.Error(apperrors.New(apperrors.Conflict, "A request with this Idempotency-Key is still being processed"))
+		return
+	}
+	for name, values := range record.Header {
+		if name == RequestIDHeader {
+			continue
+		}
+		c.Writer.Header()[name] = values
+	}
+	c.Header(IdempotentReplayedHeader, "true")
+	c.Status(record.StatusCode)
+	c.Writer.Write(record.Body)
+}
+
+// requestFingerprint hashes the method, URL and body of a request, leaving
+// the body readable by the handler. The multipart boundary is left out, as
+// clients pick a new one each time they encode the same form. Bodies over
+// maxBody bytes fail with an *http.MaxBytesError.
+func requestFingerprint(c *gin.Context, maxBody int64) (string, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBody))
+	if err != nil {
+		return "", err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	hashed := body
+	if mediaType, params, err := mime.ParseMediaType(c.GetHeader("Content-Type")); err == nil && mediaType == "multipart/form-data" && params["boundary"] != "" {
+		hashed = bytes.ReplaceAll(body, []byte(params["boundary"]), nil)
+	}
+	hash := sha256.New()
+	io.WriteString(hash, c.Request.Method+" "+c.Request.URL.RequestURI()+"\n")
+	hash.Write(hashed)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// responseRecorder keeps a copy of the response body as it is written.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseRecorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
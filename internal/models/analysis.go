@@ -0,0 +1,33 @@
+package models
+
+import (
+	"dietsense/internal/nutrition"
+	"time"
+)
+
+// Analysis is a stored provider answer for one revision of an analysis.
+// Refinements create a new Analysis whose ParentID points at the revision
+// that was refined; the image is only kept on the root revision, as a hash
+// and a thumbnail, and in full only for the configured retention.
+type Analysis struct {
+	ID            string `gorm:"primaryKey"`
+	ParentID      string `gorm:"index"`
+	APIKey        string `gorm:"index"`
+	InputType     int
+	Service       string
+	Model         string
+	Instructions  string // Trusted context sent as system instructions
+	Context       string // The caller's own text
+	Correction    string
+	Summary       string
+	NutritionInfo nutrition.Facts `gorm:"serializer:json"`
+	Confidence    float64
+	Ingredients   []string                 `gorm:"serializer:json"`
+	Allergens     []nutrition.AllergenFlag `gorm:"serializer:json"`
+	Diets         []nutrition.DietFlag     `gorm:"serializer:json"`
+	RawResponse   string
+	ImageHash     string
+	Thumbnail     []byte
+	Image         []byte    // Cleared once analysis_image_retention has passed
+	CreatedAt     time.Time `gorm:"index"`
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+)
+
+// Analysis job statuses, in the order a job goes through them.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// Webhook delivery outcomes of a finished job.
+const (
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"
+)
+
+// AnalysisJob is an /analyze request run in the background. The input is
+// kept until the job finishes, so jobs interrupted by a restart run again.
+type AnalysisJob struct {
+	ID            string                 `gorm:"primaryKey" json:"job_id"`
+	APIKey        string                 `gorm:"index" json:"-"`
+	Status        string                 `gorm:"index" json:"status"`
+	Input         AnalysisJobInput       `gorm:"serializer:json" json:"-"`
+	Result        map[string]interface{} `gorm:"serializer:json" json:"result,omitempty"`
+	Error         string                 `json:"error,omitempty"`
+	ErrorCode     string                 `json:"error_code,omitempty"`
+	WebhookStatus string                 `json:"webhook_status,omitempty"`
+	CreatedAt     time.Time              `json:"created_at"`
+	StartedAt     *time.Time             `json:"started_at,omitempty"`
+	FinishedAt    *time.Time             `json:"finished_at,omitempty"`
+}
+
+// AnalysisJobInput is what an /analyze request asked for, once read and
+// validated. The eaten time and meal type are already resolved.
+type AnalysisJobInput struct {
+	Images           [][]byte
+	InputType        string // What the client said the images show, if anything
+	Context          string
+	Instructions     string
+	EatenAt          time.Time
+	EatenAtSource    string
+	MealType         string
+	MealTypeSource   string
+	Save             bool
+	DailyValueRegion string
+}
+
+// Finished reports whether the job has succeeded or failed.
+func (j *AnalysisJob) Finished() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed
+}
@@ -9,5 +9,11 @@ type APIKey struct {
 	Key              string `gorm:"primaryKey"`
 	Email            string `gorm:"index"`
 	RateLimitPerHour int    `gorm:"default:20"`
-	CreatedAt        time.Time
+
+	// Where finished analysis jobs are posted, and the key their HMAC
+	// signatures are made with
+	CallbackURL   string
+	WebhookSecret string
+
+	CreatedAt time.Time
 }
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// Blob is an image uploaded ahead of an analysis, so that JSON requests can
+// refer to it by ID instead of embedding it. Blobs expire after the
+// retention window.
+type Blob struct {
+	ID        string `gorm:"primaryKey"`
+	APIKey    string `gorm:"index"`
+	Data      []byte
+	Size      int
+	CreatedAt time.Time `gorm:"index"`
+}
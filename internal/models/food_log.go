@@ -0,0 +1,96 @@
+package models
+
+import (
+	"dietsense/internal/nutrition"
+	"sort"
+	"time"
+)
+
+// MealTypes lists the accepted meal types.
+var MealTypes = []string{"breakfast", "lunch", "dinner", "snack"}
+
+// ValidMealType reports whether mealType is one of MealTypes.
+func ValidMealType(mealType string) bool {
+	for _, m := range MealTypes {
+		if m == mealType {
+			return true
+		}
+	}
+	return false
+}
+
+// FoodLogEntry is one item in a user's food log, usually created from an analysis.
+type FoodLogEntry struct {
+	ID         string    `gorm:"primaryKey"`
+	APIKey     string    `gorm:"index:idx_food_log_key_eaten,priority:1"`
+	AnalysisID string    `gorm:"index"`
+	TemplateID string    `gorm:"index"` // Meal template the entry was logged from
+	EatenAt    time.Time `gorm:"index:idx_food_log_key_eaten,priority:2"`
+	UTCOffset  int       // Seconds east of UTC where the food was eaten
+	MealType   string
+	InputType  string
+	Service    string
+	Model      string
+	Summary    string
+	Nutrients  []FoodLogNutrient        `gorm:"foreignKey:EntryID;constraint:OnDelete:CASCADE"`
+	Allergens  []nutrition.AllergenFlag `gorm:"serializer:json"`
+	Diets      []nutrition.DietFlag     `gorm:"serializer:json"`
+	ImageHash  string                   `gorm:"index"`
+	Thumbnail  []byte
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// FoodLogNutrient is one canonical nutrient amount of a food log entry. Keeping
+// nutrients in their own table lets summaries aggregate them in SQL.
+type FoodLogNutrient struct {
+	ID         uint   `gorm:"primaryKey"`
+	EntryID    string `gorm:"index"`
+	Nutrient   string `gorm:"index"`
+	Name       string
+	Value      float64
+	Unit       string
+	Confidence float64
+}
+
+// LocalEatenAt returns EatenAt in the time zone the food was eaten in.
+func (e *FoodLogEntry) LocalEatenAt() time.Time {
+	return e.EatenAt.In(time.FixedZone("", e.UTCOffset))
+}
+
+// SetEatenAt sets EatenAt and remembers the UTC offset of t.
+func (e *FoodLogEntry) SetEatenAt(t time.Time) {
+	_, e.UTCOffset = t.Zone()
+	e.EatenAt = t.UTC()
+}
+
+// Facts returns the entry's nutrients keyed by canonical nutrient ID.
+func (e *FoodLogEntry) Facts() nutrition.Facts {
+	facts := make(nutrition.Facts, len(e.Nutrients))
+	for _, n := range e.Nutrients {
+		facts[n.Nutrient] = nutrition.Amount{Name: n.Name, Value: n.Value, Unit: n.Unit, Confidence: n.Confidence}
+	}
+	return facts
+}
+
+// SetFacts replaces the entry's nutrients.
+func (e *FoodLogEntry) SetFacts(facts nutrition.Facts) {
+	ids := make([]string, 0, len(facts))
+	for id := range facts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	e.Nutrients = make([]FoodLogNutrient, 0, len(facts))
+	for _, id := range ids {
+		amount := facts[id]
+		e.Nutrients = append(e.Nutrients, FoodLogNutrient{
+			EntryID:    e.ID,
+			Nutrient:   id,
+			Name:       amount.Name,
+			Value:      amount.Value,
+			Unit:       amount.Unit,
+			Confidence: amount.Confidence,
+		})
+	}
+}
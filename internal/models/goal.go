@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// Goal types. Targets are met within their tolerance, ceilings while intake
+// stays at or below the value and floors once intake reaches it. Energy
+// shares set a macronutrient's percent of calories, e.g. for a macro split.
+const (
+	GoalTarget      = "target"
+	GoalMaximum     = "max"
+	GoalMinimum     = "min"
+	GoalEnergyShare = "energy_share"
+)
+
+// GoalTypes lists the supported goal types.
+var GoalTypes = []string{GoalTarget, GoalMaximum, GoalMinimum, GoalEnergyShare}
+
+// Goal is a daily nutrition goal for one canonical nutrient.
+type Goal struct {
+	ID        string    `gorm:"primaryKey" json:"id"`
+	APIKey    string    `gorm:"index" json:"-"`
+	Nutrient  string    `json:"nutrient"`
+	Type      string    `json:"type"`
+	Value     float64   `json:"value"`
+	Unit      string    `json:"unit"`      // Catalog unit, or percent for energy shares
+	Tolerance float64   `json:"tolerance"` // Percent either side of a target
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
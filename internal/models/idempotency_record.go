@@ -0,0 +1,24 @@
+package models
+
+import (
+	"net/http"
+	"time"
+)
+
+// IdempotencyRecord is the response to a request sent with an
+// Idempotency-Key, kept so that a repeat of the request gets the same
+// response instead of being processed again.
+type IdempotencyRecord struct {
+	Scope       string      `gorm:"primaryKey"` // API key of the caller, or "ip:" and the client address without one
+	Key         string      `gorm:"primaryKey"`
+	Fingerprint string      // Hash of the request the key was first used with
+	StatusCode  int         // 0 while the first request is still being processed
+	Header      http.Header `gorm:"serializer:json"`
+	Body        []byte
+	CreatedAt   time.Time `gorm:"index"`
+}
+
+// Completed reports whether the response has been stored.
+func (r *IdempotencyRecord) Completed() bool {
+	return r.StatusCode != 0
+}
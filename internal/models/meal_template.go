@@ -0,0 +1,34 @@
+package models
+
+import (
+	"dietsense/internal/nutrition"
+	"time"
+)
+
+// MealTemplate is a saved food or meal that can be logged again without a new
+// analysis. Templates composed from other templates keep a snapshot of their
+// nutrients, so they are unaffected when a component is changed or deleted.
+type MealTemplate struct {
+	ID            string                   `gorm:"primaryKey" json:"id"`
+	APIKey        string                   `gorm:"index" json:"-"`
+	Name          string                   `json:"name"`
+	MealType      string                   `json:"meal_type,omitempty"` // Meal type used when logged, unless overridden
+	Summary       string                   `json:"summary"`
+	InputType     string                   `json:"input_type"`            // Input type of the source analysis
+	AnalysisID    string                   `json:"analysis_id,omitempty"` // Source analysis, if any
+	NutritionInfo nutrition.Facts          `gorm:"serializer:json" json:"nutrition_info"`
+	Ingredients   []string                 `gorm:"serializer:json" json:"ingredients"`
+	Allergens     []nutrition.AllergenFlag `gorm:"serializer:json" json:"allergens"`
+	Diets         []nutrition.DietFlag     `gorm:"serializer:json" json:"diets"`
+	Components    []MealComponent          `gorm:"serializer:json" json:"components,omitempty"`
+	CreatedAt     time.Time                `json:"created_at"`
+	UpdatedAt     time.Time                `json:"updated_at"`
+}
+
+// MealComponent is one saved item of a composed meal and its portion relative
+// to the saved item.
+type MealComponent struct {
+	TemplateID string  `json:"template_id"`
+	Name       string  `json:"name"`
+	Scale      float64 `json:"scale"`
+}
@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// Sexes used by the energy equations.
+var Sexes = []string{"male", "female"}
+
+// ActivityLevels maps each activity level to its physical activity factor,
+// the multiplier from basal to total daily energy expenditure.
+var ActivityLevels = map[string]float64{
+	"sedentary":   1.2,   // Little or no exercise
+	"light":       1.375, // Exercise 1-3 days a week
+	"moderate":    1.55,  // Exercise 3-5 days a week
+	"active":      1.725, // Exercise 6-7 days a week
+	"very_active": 1.9,   // Hard exercise daily or a physical job
+}
+
+// Objectives a profile can pursue.
+var Objectives = []string{"lose", "maintain", "gain"}
+
+// Profile holds the body measurements and objective of an API key's user.
+type Profile struct {
+	APIKey        string    `gorm:"primaryKey" json:"-"`
+	Age           int       `json:"age"`
+	Sex           string    `json:"sex"`
+	HeightCm      float64   `json:"height_cm"`
+	WeightKg      float64   `json:"weight_kg"` // Latest weight history entry
+	ActivityLevel string    `json:"activity_level"`
+	Objective     string    `json:"objective"`
+	Formula       string    `json:"formula"` // BMR equation; see services.BMRFormulas
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// WeightEntry is a weight measurement in a user's history.
+type WeightEntry struct {
+	ID         string    `gorm:"primaryKey" json:"id"`
+	APIKey     string    `gorm:"index:idx_weight_key_measured" json:"-"`
+	WeightKg   float64   `json:"weight_kg"`
+	MeasuredAt time.Time `gorm:"index:idx_weight_key_measured" json:"measured_at"`
+	CreatedAt  time.Time `json:"created_at"`
+}
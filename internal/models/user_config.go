@@ -1,9 +1,20 @@
 package models
 
+// Unit systems for portion sizes in summaries.
+var UnitSystems = []string{"metric", "imperial"}
+
 // UserConfig represents a user's configuration preferences.
 type UserConfig struct {
-	UserID       string `gorm:"primaryKey"`
-	DefaultModel string
-	MaxTokens    int
-	Temperature  float64
+	UserID           string  `gorm:"primaryKey" json:"-"`
+	DefaultModel     string  `json:"default_model"`
+	MaxTokens        int     `json:"max_tokens"`
+	Temperature      float64 `json:"temperature"`
+	DailyValueRegion string  `json:"daily_value_region"`
+	TimeZone         string  `json:"time_zone"` // IANA name, e.g. "Europe/Paris"
+
+	// Personal context added to analysis prompts
+	Allergies              []string `gorm:"serializer:json" json:"allergies"` // Allergen IDs
+	Units                  string   `json:"units"`                            // One of UnitSystems
+	Language               string   `json:"language"`                         // Language tag for summaries, e.g. "fr"
+	DisablePersonalContext bool     `json:"disable_personal_context"`
 }
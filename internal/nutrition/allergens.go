@@ -0,0 +1,249 @@
+package nutrition
+
+import (
+	"sort"
+	"strings"
+)
+
+// Sources of allergen and diet verdicts.
+const (
+	SourceModel       = "model"
+	SourceIngredients = "ingredients"
+	SourceNutrients   = "nutrients"
+	SourceCombined    = "model+ingredients"
+)
+
+// Allergen is an entry in the allergen catalog. Regions lists the labelling
+// regimes that make it a mandatory allergen: the 14 EU allergens and the 9
+// major US food allergens.
+type Allergen struct {
+	ID       string
+	Name     string
+	Regions  []string
+	Synonyms []string // Names a model may use for the allergen itself
+	rule     keywordRule
+}
+
+// AllergenFlag is the verdict for one allergen.
+type AllergenFlag struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Present    bool     `json:"present"`
+	Confidence float64  `json:"confidence"`
+	Source     string   `json:"source"`
+	Evidence   []string `json:"evidence,omitempty"`
+}
+
+// Allergens lists the allergens DietSense detects.
+var Allergens = []Allergen{
+	{ID: "gluten", Name: "Cereals containing gluten", Regions: []string{"eu"}, Synonyms: []string{"cereals containing gluten", "gluten"}, rule: keywordRule{
+		Keywords: []string{"gluten", "wheat", "barley", "rye", "oat", "spelt", "kamut", "triticale", "malt", "semolina", "durum", "couscous", "bulgur", "seitan", "farro", "einkorn", "emmer", "farina"},
+	}},
+	{ID: "wheat", Name: "Wheat", Regions: []string{"us"}, Synonyms: []string{"wheat"}, rule: keywordRule{
+		Keywords: []string{"wheat", "spelt", "durum", "semolina", "farina", "couscous", "bulgur", "seitan", "kamut", "einkorn", "emmer", "farro"},
+	}},
+	{ID: "crustaceans", Name: "Crustaceans", Regions: []string{"eu", "us"}, Synonyms: []string{"crustacean", "crustacean shellfish", "shellfish"}, rule: keywordRule{
+		Keywords: []string{"crustacean", "shrimp", "prawn", "crab", "lobster", "crayfish", "langoustine", "krill", "scampi"},
+	}},
+	{ID: "eggs", Name: "Eggs", Regions: []string{"eu", "us"}, Synonyms: []string{"egg"}, rule: keywordRule{
+		Keywords: []string{"egg", "albumin", "albumen", "ovalbumin", "lysozyme", "mayonnaise", "meringue"},
+		Except:   []string{"eggplant"},
+	}},
+	{ID: "fish", Name: "Fish", Regions: []string{"eu", "us"}, Synonyms: []string{"fish"}, rule: keywordRule{
+		Keywords: []string{"fish", "anchovy", "anchovies", "cod", "salmon", "tuna", "sardine", "mackerel", "haddock", "pollock", "tilapia", "trout", "bonito", "herring"},
+	}},
+	{ID: "peanuts", Name: "Peanuts", Regions: []string{"eu", "us"}, Synonyms: []string{"peanut", "groundnut"}, rule: keywordRule{
+		Keywords: []string{"peanut", "groundnut", "arachis"},
+	}},
+	{ID: "soy", Name: "Soybeans", Regions: []string{"eu", "us"}, Synonyms: []string{"soya", "soybean", "soybeans"}, rule: keywordRule{
+		Keywords: []string{"soy", "soya", "soybean", "tofu", "edamame", "miso", "tempeh", "shoyu", "tamari"},
+	}},
+	{ID: "milk", Name: "Milk", Regions: []string{"eu", "us"}, Synonyms: []string{"dairy", "lactose"}, rule: keywordRule{
+		Keywords: []string{"milk", "cream", "butter", "cheese", "whey", "casein", "caseinate", "lactose", "yogurt", "yoghurt", "ghee", "buttermilk", "lactalbumin", "curd"},
+		Except: []string{
+			"coconut milk", "almond milk", "soy milk", "soya milk", "rice milk", "oat milk", "cashew milk", "hazelnut milk",
+			"coconut cream", "cocoa butter", "cacao butter", "peanut butter", "shea butter", "nut butter", "apple butter",
+			"bean curd", "cream of tartar",
+		},
+	}},
+	{ID: "tree_nuts", Name: "Tree nuts", Regions: []string{"eu", "us"}, Synonyms: []string{"nuts", "tree nut", "tree nuts"}, rule: keywordRule{
+		Keywords: []string{"almond", "hazelnut", "walnut", "cashew", "pecan", "brazil nut", "pistachio", "macadamia", "queensland nut", "marzipan", "praline"},
+	}},
+	{ID: "celery", Name: "Celery", Regions: []string{"eu"}, Synonyms: []string{"celery"}, rule: keywordRule{
+		Keywords: []string{"celery", "celeriac"},
+	}},
+	{ID: "mustard", Name: "Mustard", Regions: []string{"eu"}, Synonyms: []string{"mustard"}, rule: keywordRule{
+		Keywords: []string{"mustard"},
+	}},
+	{ID: "sesame", Name: "Sesame", Regions: []string{"eu", "us"}, Synonyms: []string{"sesame seeds"}, rule: keywordRule{
+		Keywords: []string{"sesame", "tahini", "tahina", "gomasio"},
+	}},
+	{ID: "sulphites", Name: "Sulphur dioxide and sulphites", Regions: []string{"eu"}, Synonyms: []string{"sulfites", "sulphites", "sulfite", "sulphite", "sulphur dioxide", "sulfur dioxide"}, rule: keywordRule{
+		Keywords: []string{"sulphite", "sulfite", "sulphur dioxide", "sulfur dioxide", "metabisulphite", "metabisulfite", "bisulphite", "bisulfite", "e220", "e221", "e222", "e223", "e224", "e226", "e227", "e228"},
+	}},
+	{ID: "lupin", Name: "Lupin", Regions: []string{"eu"}, Synonyms: []string{"lupine"}, rule: keywordRule{
+		Keywords: []string{"lupin", "lupine"},
+	}},
+	{ID: "molluscs", Name: "Molluscs", Regions: []string{"eu"}, Synonyms: []string{"mollusc", "mollusk", "mollusks"}, rule: keywordRule{
+		Keywords: []string{"mollusc", "mollusk", "mussel", "oyster", "clam", "scallop", "squid", "octopus", "snail", "calamari", "cuttlefish", "abalone", "whelk", "escargot"},
+	}},
+}
+
+// Confidence of keyword verdicts, by where the ingredient list came from.
+const (
+	LabelIngredientConfidence     = 0.95 // Transcribed from a printed ingredient list
+	EstimatedIngredientConfidence = 0.7  // Guessed by the model from a photo or description
+	precautionaryConfidence       = 0.5  // "May contain" statements
+)
+
+// LookupAllergen resolves an allergen name as reported by a model.
+func LookupAllergen(name string) (*Allergen, bool) {
+	key := normalizeName(name)
+	for i := range Allergens {
+		a := &Allergens[i]
+		if key == normalizeName(a.ID) || key == normalizeName(a.Name) {
+			return a, true
+		}
+		for _, synonym := range a.Synonyms {
+			if key == normalizeName(synonym) {
+				return a, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// DetectAllergens scans an ingredient list for allergen keywords. Matches in a
+// "may contain" statement are reported with reduced confidence.
+func DetectAllergens(ingredients []string, confidence float64) []AllergenFlag {
+	contains, mayContain := splitPrecautionary(ingredients)
+
+	var flags []AllergenFlag
+	for _, allergen := range Allergens {
+		if evidence := allergen.rule.match(contains); len(evidence) > 0 {
+			flags = append(flags, AllergenFlag{ID: allergen.ID, Name: allergen.Name, Present: true, Confidence: confidence, Source: SourceIngredients, Evidence: evidence})
+		} else if evidence := allergen.rule.match(mayContain); len(evidence) > 0 {
+			flags = append(flags, AllergenFlag{ID: allergen.ID, Name: allergen.Name, Present: true, Confidence: precautionaryConfidence, Source: SourceIngredients, Evidence: evidence})
+		}
+	}
+	return flags
+}
+
+// MergeAllergens combines the flags reported by the model with the keyword
+// verdicts. Keyword evidence wins over a model saying an allergen is absent.
+func MergeAllergens(model, rules []AllergenFlag) []AllergenFlag {
+	merged := make(map[string]AllergenFlag, len(model)+len(rules))
+	for _, flag := range model {
+		merged[flag.ID] = flag
+	}
+	for _, rule := range rules {
+		existing, ok := merged[rule.ID]
+		switch {
+		case !ok || !existing.Present:
+			merged[rule.ID] = rule
+		default:
+			existing.Source = SourceCombined
+			existing.Evidence = rule.Evidence
+			if rule.Confidence > existing.Confidence {
+				existing.Confidence = rule.Confidence
+			}
+			merged[rule.ID] = existing
+		}
+	}
+	return sortedAllergens(merged)
+}
+
+func sortedAllergens(flags map[string]AllergenFlag) []AllergenFlag {
+	list := make([]AllergenFlag, 0, len(flags))
+	for _, flag := range flags {
+		list = append(list, flag)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// splitPrecautionary separates precautionary statements ("may contain traces
+// of nuts") from the ingredients proper.
+func splitPrecautionary(ingredients []string) (contains, mayContain []string) {
+	for _, ingredient := range ingredients {
+		lower := strings.ToLower(ingredient)
+		cut := -1
+		for _, marker := range []string{"may contain", "traces of", "produced in a facility", "made in a factory", "may also contain"} {
+			if i := strings.Index(lower, marker); i != -1 && (cut == -1 || i < cut) {
+				cut = i
+			}
+		}
+		if cut == -1 {
+			contains = append(contains, ingredient)
+			continue
+		}
+		contains = append(contains, ingredient[:cut])
+		mayContain = append(mayContain, ingredient[cut:])
+	}
+	return contains, mayContain
+}
+
+// keywordRule matches whole words or phrases in ingredient text, tolerating
+// plurals and ignoring negations such as "gluten free" or "no egg".
+type keywordRule struct {
+	Keywords []string
+	// Except lists phrases that contain a keyword but mean something else,
+	// e.g. "coconut milk"
+	Except []string
+}
+
+// match returns the keywords found in the text, in keyword order.
+func (r keywordRule) match(texts []string) []string {
+	var found []string
+	for _, keyword := range r.Keywords {
+		phrase := strings.Fields(normalizeName(keyword))
+		for _, text := range texts {
+			if containsPhrase(r.words(text), phrase) {
+				found = append(found, keyword)
+				break
+			}
+		}
+	}
+	return found
+}
+
+// words normalizes text into words with the rule's exceptions removed.
+func (r keywordRule) words(text string) []string {
+	normalized := " " + normalizeName(text) + " "
+	for _, except := range r.Except {
+		normalized = strings.ReplaceAll(normalized, " "+normalizeName(except)+" ", " ")
+	}
+	return strings.Fields(normalized)
+}
+
+func containsPhrase(words, phrase []string) bool {
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		matched := true
+		for j, part := range phrase {
+			if !sameWord(words[i+j], part) {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		if i > 0 && isNegation(words[i-1]) {
+			continue
+		}
+		if end := i + len(phrase); end < len(words) && words[end] == "free" {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// sameWord compares a word against a keyword, accepting simple plurals.
+func sameWord(word, keyword string) bool {
+	return word == keyword || word == keyword+"s" || word == keyword+"es"
+}
+
+func isNegation(word string) bool {
+	return word == "no" || word == "non" || word == "without"
+}
@@ -0,0 +1,196 @@
+package nutrition
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Nutrient is an entry in the canonical nutrient catalog.
+type Nutrient struct {
+	ID       string
+	Name     string
+	Unit     string   // Canonical unit values are converted to
+	IU       float64  // Amount in Unit equal to one International Unit, 0 when IU is not defined
+	Synonyms []string // Alternative names, including other languages
+}
+
+// Catalog lists the nutrients DietSense understands, in display order.
+var Catalog = []Nutrient{
+	{ID: "calories", Name: "Calories", Unit: UnitKcal, Synonyms: []string{
+		"calorie", "energy", "energy value", "kcal", "kilocalories", "total calories",
+		"energía", "energia", "valor energético", "valor energetico", "calorías", "calorias",
+		"énergie", "energie", "valeur énergétique", "valeur energetique", "brennwert",
+		"valore energetico", "エネルギー", "熱量", "能量", "热量",
+	}},
+	{ID: "total_fat", Name: "Total Fat", Unit: UnitGram, Synonyms: []string{
+		"fat", "fats", "lipids", "total lipids", "grasa", "grasas", "grasas totales", "lípidos", "lipidos",
+		"matières grasses", "matieres grasses", "lipides", "fett", "grassi", "gordura", "gorduras totais",
+		"脂質", "脂肪",
+	}},
+	{ID: "saturated_fat", Name: "Saturated Fat", Unit: UnitGram, Synonyms: []string{
+		"saturates", "saturated fatty acids", "sat fat", "grasas saturadas", "ácidos grasos saturados",
+		"acides gras saturés", "acides gras satures", "gesättigte fettsäuren", "gesaettigte fettsaeuren",
+		"davon gesättigte fettsäuren", "grassi saturi", "acidi grassi saturi", "gorduras saturadas",
+		"飽和脂肪酸", "饱和脂肪",
+	}},
+	{ID: "trans_fat", Name: "Trans Fat", Unit: UnitGram, Synonyms: []string{
+		"trans fatty acids", "grasas trans", "acides gras trans", "transfettsäuren", "transfettsaeuren",
+		"grassi trans", "gorduras trans", "トランス脂肪酸", "反式脂肪",
+	}},
+	{ID: "monounsaturated_fat", Name: "Monounsaturated Fat", Unit: UnitGram, Synonyms: []string{
+		"monounsaturates", "monounsaturated fatty acids", "grasas monoinsaturadas",
+		"acides gras mono insaturés", "einfach ungesättigte fettsäuren", "grassi monoinsaturi",
+	}},
+	{ID: "polyunsaturated_fat", Name: "Polyunsaturated Fat", Unit: UnitGram, Synonyms: []string{
+		"polyunsaturates", "polyunsaturated fatty acids", "grasas poliinsaturadas",
+		"acides gras polyinsaturés", "mehrfach ungesättigte fettsäuren", "grassi polinsaturi",
+	}},
+	{ID: "cholesterol", Name: "Cholesterol", Unit: UnitMilligram, Synonyms: []string{
+		"colesterol", "cholestérol", "cholesterin", "colesterolo", "コレステロール", "胆固醇",
+	}},
+	{ID: "sodium", Name: "Sodium", Unit: UnitMilligram, Synonyms: []string{
+		"sodio", "natrium", "sódio", "ナトリウム", "钠",
+	}},
+	{ID: "salt", Name: "Salt", Unit: UnitGram, Synonyms: []string{
+		"sal", "sel", "salz", "sale", "食塩相当量", "盐",
+	}},
+	{ID: "potassium", Name: "Potassium", Unit: UnitMilligram, Synonyms: []string{
+		"potasio", "kalium", "potassio", "potássio", "カリウム", "钾",
+	}},
+	{ID: "carbohydrates", Name: "Total Carbohydrates", Unit: UnitGram, Synonyms: []string{
+		"total carbohydrate", "carbohydrate", "carbohydrates", "carbs", "carb", "total carbs",
+		"total carbohydrates", "hidratos de carbono", "carbohidratos", "glucides", "kohlenhydrate",
+		"carboidrati", "carboidratos", "炭水化物", "碳水化合物",
+	}},
+	{ID: "fiber", Name: "Dietary Fiber", Unit: UnitGram, Synonyms: []string{
+		"fiber", "fibre", "dietary fibre", "total fiber", "fibra", "fibra alimentaria", "fibra dietética",
+		"fibres alimentaires", "fibres", "ballaststoffe", "fibre alimentari", "fibra alimentar",
+		"食物繊維", "膳食纤维",
+	}},
+	{ID: "sugars", Name: "Sugars", Unit: UnitGram, Synonyms: []string{
+		"sugar", "total sugars", "total sugar", "azúcares", "azucares", "sucres", "zucker",
+		"davon zucker", "zuccheri", "açúcares", "acucares", "糖類", "糖",
+	}},
+	{ID: "added_sugars", Name: "Added Sugars", Unit: UnitGram, Synonyms: []string{
+		"added sugar", "includes added sugars", "azúcares añadidos", "azucares anadidos",
+		"sucres ajoutés", "zugesetzter zucker", "zuccheri aggiunti", "açúcares adicionados",
+	}},
+	{ID: "protein", Name: "Protein", Unit: UnitGram, Synonyms: []string{
+		"proteins", "proteína", "proteina", "proteínas", "proteinas", "protéines", "proteines",
+		"eiweiß", "eiweiss", "proteine", "たんぱく質", "蛋白質", "蛋白质",
+	}},
+	{ID: "vitamin_a", Name: "Vitamin A", Unit: UnitMicrogram, IU: 0.3, Synonyms: []string{
+		"vitamin a rae", "retinol", "vitamina a", "vitamine a", "ビタミンa",
+	}},
+	{ID: "vitamin_c", Name: "Vitamin C", Unit: UnitMilligram, Synonyms: []string{
+		"ascorbic acid", "vitamina c", "vitamine c", "ビタミンc",
+	}},
+	{ID: "vitamin_d", Name: "Vitamin D", Unit: UnitMicrogram, IU: 0.025, Synonyms: []string{
+		"cholecalciferol", "vitamin d3", "vitamina d", "vitamine d", "ビタミンd",
+	}},
+	{ID: "vitamin_e", Name: "Vitamin E", Unit: UnitMilligram, IU: 0.67, Synonyms: []string{
+		"alpha tocopherol", "tocopherol", "vitamina e", "vitamine e", "ビタミンe",
+	}},
+	{ID: "vitamin_k", Name: "Vitamin K", Unit: UnitMicrogram, Synonyms: []string{
+		"phylloquinone", "vitamina k", "vitamine k", "ビタミンk",
+	}},
+	{ID: "thiamin", Name: "Thiamin", Unit: UnitMilligram, Synonyms: []string{
+		"thiamine", "vitamin b1", "tiamina", "vitamine b1",
+	}},
+	{ID: "riboflavin", Name: "Riboflavin", Unit: UnitMilligram, Synonyms: []string{
+		"vitamin b2", "riboflavina", "vitamine b2",
+	}},
+	{ID: "niacin", Name: "Niacin", Unit: UnitMilligram, Synonyms: []string{
+		"vitamin b3", "niacina", "niacine", "vitamine b3",
+	}},
+	{ID: "vitamin_b6", Name: "Vitamin B6", Unit: UnitMilligram, Synonyms: []string{
+		"pyridoxine", "vitamina b6", "vitamine b6",
+	}},
+	{ID: "folate", Name: "Folate", Unit: UnitMicrogram, Synonyms: []string{
+		"folic acid", "vitamin b9", "folato", "ácido fólico", "acide folique", "folsäure",
+	}},
+	{ID: "vitamin_b12", Name: "Vitamin B12", Unit: UnitMicrogram, Synonyms: []string{
+		"cobalamin", "vitamina b12", "vitamine b12",
+	}},
+	{ID: "calcium", Name: "Calcium", Unit: UnitMilligram, Synonyms: []string{
+		"calcio", "kalzium", "cálcio", "カルシウム", "钙",
+	}},
+	{ID: "iron", Name: "Iron", Unit: UnitMilligram, Synonyms: []string{
+		"hierro", "fer", "eisen", "ferro", "鉄", "铁",
+	}},
+	{ID: "magnesium", Name: "Magnesium", Unit: UnitMilligram, Synonyms: []string{
+		"magnesio", "magnésium", "magnésio", "マグネシウム", "镁",
+	}},
+	{ID: "zinc", Name: "Zinc", Unit: UnitMilligram, Synonyms: []string{
+		"zink", "zinco", "亜鉛", "锌",
+	}},
+	{ID: "phosphorus", Name: "Phosphorus", Unit: UnitMilligram, Synonyms: []string{
+		"fósforo", "fosforo", "phosphore", "phosphor", "リン", "磷",
+	}},
+}
+
+// index maps normalized names and synonyms to catalog entries.
+var index = buildIndex()
+
+func buildIndex() map[string]*Nutrient {
+	idx := make(map[string]*Nutrient)
+	for i := range Catalog {
+		n := &Catalog[i]
+		idx[normalizeName(n.ID)] = n
+		idx[normalizeName(n.Name)] = n
+		for _, synonym := range n.Synonyms {
+			idx[normalizeName(synonym)] = n
+		}
+	}
+	return idx
+}
+
+// Lookup resolves a free-form nutrient name to its catalog entry.
+func Lookup(name string) (*Nutrient, bool) {
+	key := normalizeName(name)
+	if n, ok := index[key]; ok {
+		return n, true
+	}
+	// Labels often prefix sub-rows, e.g. "of which sugars" or "includes added sugars"
+	for _, prefix := range []string{"of which ", "includes ", "davon ", "dont ", "de las cuales ", "di cui "} {
+		if strings.HasPrefix(key, prefix) {
+			if n, ok := index[strings.TrimPrefix(key, prefix)]; ok {
+				return n, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Get returns the catalog entry with the given canonical ID.
+func Get(id string) (*Nutrient, bool) {
+	for i := range Catalog {
+		if Catalog[i].ID == id {
+			return &Catalog[i], true
+		}
+	}
+	return nil, false
+}
+
+// normalizeName lowercases a name and collapses punctuation and spacing.
+func normalizeName(name string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteRune(r)
+			space = false
+		} else {
+			space = true
+		}
+	}
+	return b.String()
+}
+
+// slug turns a free-form name into an identifier for nutrients outside the catalog.
+func slug(name string) string {
+	return strings.ReplaceAll(normalizeName(name), " ", "_")
+}
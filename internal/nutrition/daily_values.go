@@ -0,0 +1,97 @@
+package nutrition
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// ReferenceIntakes maps canonical nutrient IDs to the daily reference amount
+// in the catalog unit.
+type ReferenceIntakes map[string]float64
+
+// DefaultReferenceIntakes holds the built-in reference tables by region.
+var DefaultReferenceIntakes = map[string]ReferenceIntakes{
+	// US FDA Daily Values for adults and children 4 years and older (21 CFR 101.9)
+	"fda": {
+		"calories": 2000, "total_fat": 78, "saturated_fat": 20, "cholesterol": 300, "sodium": 2300,
+		"carbohydrates": 275, "fiber": 28, "added_sugars": 50, "protein": 50,
+		"vitamin_a": 900, "vitamin_c": 90, "vitamin_d": 20, "vitamin_e": 15, "vitamin_k": 120,
+		"thiamin": 1.2, "riboflavin": 1.3, "niacin": 16, "vitamin_b6": 1.7, "folate": 400, "vitamin_b12": 2.4,
+		"calcium": 1300, "iron": 18, "magnesium": 420, "zinc": 11, "phosphorus": 1250, "potassium": 4700,
+	},
+	// EU Reference Intakes for an average adult (Regulation (EU) No 1169/2011, Annex XIII)
+	"eu": {
+		"calories": 2000, "total_fat": 70, "saturated_fat": 20, "carbohydrates": 260, "sugars": 90,
+		"protein": 50, "salt": 6,
+		"vitamin_a": 800, "vitamin_c": 80, "vitamin_d": 5, "vitamin_e": 12, "vitamin_k": 75,
+		"thiamin": 1.1, "riboflavin": 1.4, "niacin": 16, "vitamin_b6": 1.4, "folate": 200, "vitamin_b12": 2.5,
+		"calcium": 800, "iron": 14, "magnesium": 375, "zinc": 10, "phosphorus": 700, "potassium": 2000,
+	},
+	// Health Canada Daily Values (Food and Drug Regulations, Table of Daily Values)
+	"ca": {
+		"total_fat": 75, "saturated_fat": 20, "cholesterol": 300, "sodium": 2300, "fiber": 28, "sugars": 100,
+		"vitamin_a": 900, "vitamin_c": 90, "vitamin_d": 20, "vitamin_e": 15, "vitamin_k": 120,
+		"thiamin": 1.2, "riboflavin": 1.3, "niacin": 16, "vitamin_b6": 1.7, "folate": 400, "vitamin_b12": 2.4,
+		"calcium": 1300, "iron": 18, "magnesium": 420, "zinc": 11, "phosphorus": 1250, "potassium": 3400,
+	},
+}
+
+// LookupReferenceIntakes returns the reference table for a region. Entries in
+// overrides replace or extend the built-in values, and may define new regions.
+func LookupReferenceIntakes(region string, overrides map[string]map[string]float64) (ReferenceIntakes, bool) {
+	region = strings.ToLower(strings.TrimSpace(region))
+	builtin, hasBuiltin := DefaultReferenceIntakes[region]
+	custom, hasCustom := overrides[region]
+	if !hasBuiltin && !hasCustom {
+		return nil, false
+	}
+
+	table := make(ReferenceIntakes, len(builtin)+len(custom))
+	for id, amount := range builtin {
+		table[id] = amount
+	}
+	for id, amount := range custom {
+		table[id] = amount
+	}
+	return table, true
+}
+
+// ReferenceRegions lists the regions available with the given overrides.
+func ReferenceRegions(overrides map[string]map[string]float64) []string {
+	var regions []string
+	for region := range DefaultReferenceIntakes {
+		regions = append(regions, region)
+	}
+	for region := range overrides {
+		if _, ok := DefaultReferenceIntakes[region]; !ok {
+			regions = append(regions, region)
+		}
+	}
+	sort.Strings(regions)
+	return regions
+}
+
+// WithDailyValues returns a copy of the facts with the percent of the daily
+// reference intake set on every nutrient the table defines.
+func (f Facts) WithDailyValues(table ReferenceIntakes) Facts {
+	annotated := make(Facts, len(f))
+	for id, amount := range f {
+		amount.DailyValue = nil
+		if reference, ok := table[id]; ok && reference > 0 && amount.Unit == catalogUnit(id) {
+			percent := math.Round(amount.Value/reference*1000) / 10
+			amount.DailyValue = &percent
+		}
+		annotated[id] = amount
+	}
+	return annotated
+}
+
+// catalogUnit returns the catalog unit of a nutrient, or an empty string for
+// nutrients outside the catalog.
+func catalogUnit(id string) string {
+	if nutrient, ok := Get(id); ok {
+		return nutrient.Unit
+	}
+	return ""
+}
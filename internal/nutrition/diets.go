@@ -0,0 +1,186 @@
+package nutrition
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Diet is an entry in the diet catalog. A diet is incompatible when the
+// ingredients match its keywords or contain one of its excluded allergens.
+type Diet struct {
+	ID                string
+	Name              string
+	Synonyms          []string
+	ExcludedAllergens []string
+	rule              keywordRule
+}
+
+// DietFlag is the compatibility verdict for one diet.
+type DietFlag struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Compatible bool     `json:"compatible"`
+	Confidence float64  `json:"confidence"`
+	Source     string   `json:"source"`
+	Evidence   []string `json:"evidence,omitempty"`
+}
+
+// ketoDietID is assessed from the nutrients rather than from ingredients.
+const ketoDietID = "keto_friendly"
+
+var meatKeywords = []string{
+	"meat", "beef", "pork", "chicken", "turkey", "lamb", "mutton", "veal", "duck", "goose", "venison",
+	"bacon", "ham", "salami", "pepperoni", "prosciutto", "pancetta", "chorizo", "gelatin", "gelatine",
+	"lard", "tallow", "suet", "animal rennet", "isinglass", "bone broth",
+}
+
+// Diets lists the diets DietSense assesses.
+var Diets = []Diet{
+	{ID: "vegan", Name: "Vegan", Synonyms: []string{"plant based"},
+		ExcludedAllergens: []string{"milk", "eggs", "fish", "crustaceans", "molluscs"},
+		rule: keywordRule{
+			Keywords: append([]string{"honey", "beeswax", "shellac", "carmine", "cochineal", "e120", "e904", "lanolin"}, meatKeywords...),
+			Except:   []string{"honeydew", "honeydew melon"},
+		}},
+	{ID: "vegetarian", Name: "Vegetarian",
+		ExcludedAllergens: []string{"fish", "crustaceans", "molluscs"},
+		rule:              keywordRule{Keywords: meatKeywords}},
+	{ID: "gluten_free", Name: "Gluten-free", Synonyms: []string{"gluten free", "coeliac", "celiac"},
+		ExcludedAllergens: []string{"gluten"}},
+	{ID: "halal", Name: "Halal",
+		rule: keywordRule{Keywords: []string{
+			"pork", "bacon", "ham", "lard", "prosciutto", "pancetta", "chorizo", "salami", "pepperoni", "gelatin", "gelatine",
+			"alcohol", "ethanol", "wine", "beer", "rum", "brandy", "whisky", "whiskey", "vodka", "liqueur", "sake", "mirin",
+		}}},
+	{ID: ketoDietID, Name: "Keto-friendly", Synonyms: []string{"keto", "ketogenic", "low carb"}},
+}
+
+// Keto-friendly thresholds: at most this share of energy from net carbs, or
+// at most this many grams of net carbs when calories are unknown.
+const (
+	ketoMaxCarbEnergyShare = 0.10
+	ketoMaxNetCarbs        = 5.0
+)
+
+// LookupDiet resolves a diet name as reported by a model.
+func LookupDiet(name string) (*Diet, bool) {
+	key := normalizeName(name)
+	for i := range Diets {
+		d := &Diets[i]
+		if key == normalizeName(d.ID) || key == normalizeName(d.Name) {
+			return d, true
+		}
+		for _, synonym := range d.Synonyms {
+			if key == normalizeName(synonym) {
+				return d, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// AssessDiets derives diet verdicts from an ingredient list, the allergens
+// found in it and the nutrients. Keyword matches make a diet incompatible; a
+// complete ingredient list without matches makes it compatible with reduced
+// confidence, since absence of evidence is weaker than a match.
+func AssessDiets(ingredients []string, allergens []AllergenFlag, facts Facts, confidence float64) []DietFlag {
+	contains, _ := splitPrecautionary(ingredients)
+	present := make(map[string]AllergenFlag)
+	for _, allergen := range allergens {
+		if allergen.Present && allergen.Confidence > precautionaryConfidence {
+			present[allergen.ID] = allergen
+		}
+	}
+
+	var flags []DietFlag
+	for _, diet := range Diets {
+		if diet.ID == ketoDietID {
+			if flag, ok := assessKeto(facts); ok {
+				flags = append(flags, flag)
+			}
+			continue
+		}
+
+		flag := DietFlag{ID: diet.ID, Name: diet.Name, Source: SourceIngredients, Confidence: confidence}
+		flag.Evidence = diet.rule.match(contains)
+		for _, id := range diet.ExcludedAllergens {
+			if allergen, ok := present[id]; ok {
+				flag.Evidence = append(flag.Evidence, allergen.Name)
+				if len(flag.Evidence) == 1 {
+					flag.Source, flag.Confidence = allergen.Source, allergen.Confidence
+				}
+			}
+		}
+
+		switch {
+		case len(flag.Evidence) > 0:
+			flag.Compatible = false
+		case len(contains) > 0:
+			flag.Compatible = true
+			flag.Confidence = round(confidence * 0.7)
+		default:
+			continue
+		}
+		flags = append(flags, flag)
+	}
+	return flags
+}
+
+// assessKeto judges keto-friendliness from net carbohydrates.
+func assessKeto(facts Facts) (DietFlag, bool) {
+	carbs, ok := facts["carbohydrates"]
+	if !ok {
+		return DietFlag{}, false
+	}
+	netCarbs := carbs.Value
+	if fiber, ok := facts["fiber"]; ok {
+		netCarbs -= fiber.Value
+	}
+	if netCarbs < 0 {
+		netCarbs = 0
+	}
+
+	flag := DietFlag{ID: ketoDietID, Name: "Keto-friendly", Source: SourceNutrients, Confidence: carbs.Confidence}
+	if flag.Confidence == 0 {
+		flag.Confidence = 0.5
+	}
+	if calories, ok := facts["calories"]; ok && calories.Value > 0 {
+		share := netCarbs * 4 / calories.Value
+		flag.Compatible = share <= ketoMaxCarbEnergyShare
+		flag.Evidence = []string{fmt.Sprintf("%.0f%% of energy from net carbs", round(share*100))}
+	} else {
+		flag.Compatible = netCarbs <= ketoMaxNetCarbs
+		flag.Evidence = []string{fmt.Sprintf("%g g net carbs", round(netCarbs))}
+	}
+	return flag, true
+}
+
+// MergeDiets combines the verdicts reported by the model with the rule-based
+// ones. Keyword or allergen evidence of incompatibility and nutrient-based
+// verdicts win; otherwise the model's verdict stands.
+func MergeDiets(model, rules []DietFlag) []DietFlag {
+	merged := make(map[string]DietFlag, len(model)+len(rules))
+	for _, flag := range model {
+		merged[flag.ID] = flag
+	}
+	for _, rule := range rules {
+		existing, ok := merged[rule.ID]
+		switch {
+		case !ok, !rule.Compatible, rule.Source == SourceNutrients:
+			merged[rule.ID] = rule
+		case existing.Compatible:
+			existing.Source = SourceCombined
+			if rule.Confidence > existing.Confidence {
+				existing.Confidence = rule.Confidence
+			}
+			merged[rule.ID] = existing
+		}
+	}
+
+	list := make([]DietFlag, 0, len(merged))
+	for _, flag := range merged {
+		list = append(list, flag)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
@@ -0,0 +1,28 @@
+package nutrition
+
+// EnergyPerGram gives the Atwater factors, in kcal per gram, of the nutrients
+// that make up a macro split.
+var EnergyPerGram = map[string]float64{
+	"protein":       4,
+	"carbohydrates": 4,
+	"total_fat":     9,
+}
+
+// EnergyShare returns the percent of calories provided by a macronutrient.
+// It fails when the nutrient has no energy factor, or the nutrient or
+// calories are missing.
+func (f Facts) EnergyShare(id string) (float64, bool) {
+	factor, ok := EnergyPerGram[id]
+	if !ok {
+		return 0, false
+	}
+	amount, ok := f[id]
+	if !ok {
+		return 0, false
+	}
+	calories, ok := f["calories"]
+	if !ok || calories.Value <= 0 {
+		return 0, false
+	}
+	return round(amount.Value * factor / calories.Value * 100), true
+}
@@ -0,0 +1,227 @@
+package nutrition
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Component is a nutrient as reported by a provider, before normalization.
+type Component struct {
+	Name       string
+	Value      interface{} // Number, or a string such as "12 g" or "<1"
+	Unit       string
+	Confidence float64
+}
+
+// Amount is a normalized nutrient value.
+type Amount struct {
+	Name       string  `json:"name"`
+	Value      float64 `json:"value"`
+	Unit       string  `json:"unit"`
+	Confidence float64 `json:"confidence"`
+
+	// DailyValue is the percent of the daily reference intake, set by WithDailyValues
+	DailyValue *float64 `json:"daily_value_percent,omitempty"`
+}
+
+// Facts maps canonical nutrient IDs to their normalized amounts. Nutrients
+// outside the catalog are keyed by a slug of their reported name.
+type Facts map[string]Amount
+
+// Normalize maps provider components onto the canonical catalog and converts
+// their values to catalog units. When a nutrient is reported more than once,
+// the entry with the highest confidence wins. Catalog nutrients whose unit
+// cannot be converted (e.g. "% DV") are dropped.
+func Normalize(components []Component) Facts {
+	facts := make(Facts, len(components))
+	for _, component := range components {
+		value, embeddedUnit, ok := ParseValue(component.Value)
+		if !ok {
+			continue
+		}
+		unit := component.Unit
+		if strings.TrimSpace(unit) == "" {
+			unit = embeddedUnit
+		}
+
+		var id string
+		var amount Amount
+		if nutrient, found := Lookup(component.Name); found {
+			if strings.TrimSpace(unit) == "" {
+				unit = nutrient.Unit
+			}
+			converted, err := Convert(value, unit, nutrient.Unit, nutrient)
+			if err != nil {
+				continue
+			}
+			id = nutrient.ID
+			amount = Amount{Name: nutrient.Name, Value: round(converted), Unit: nutrient.Unit, Confidence: component.Confidence}
+		} else {
+			id = slug(component.Name)
+			if id == "" {
+				continue
+			}
+			amount = Amount{Name: strings.TrimSpace(component.Name), Value: value, Unit: CanonicalUnit(unit), Confidence: component.Confidence}
+		}
+
+		if existing, ok := facts[id]; ok && existing.Confidence >= amount.Confidence {
+			continue
+		}
+		facts[id] = amount
+	}
+	return facts
+}
+
+// Value returns the amount of a nutrient in its catalog unit.
+func (f Facts) Value(id string) (float64, bool) {
+	amount, ok := f[id]
+	return amount.Value, ok
+}
+
+// Add sums other into f and returns f, allocating it when nil. Amounts in a
+// different unit are converted, or skipped when they cannot be; the summed
+// confidence is the lower of the two.
+func (f Facts) Add(other Facts) Facts {
+	if f == nil {
+		f = make(Facts, len(other))
+	}
+	for id, amount := range other {
+		amount.DailyValue = nil
+		existing, ok := f[id]
+		if !ok {
+			f[id] = amount
+			continue
+		}
+		nutrient, _ := Get(id)
+		value, err := Convert(amount.Value, amount.Unit, existing.Unit, nutrient)
+		if err != nil {
+			continue
+		}
+		existing.Value = round(existing.Value + value)
+		existing.Confidence = math.Min(existing.Confidence, amount.Confidence)
+		f[id] = existing
+	}
+	return f
+}
+
+// Scale returns a copy of the facts with every amount multiplied by factor,
+// e.g. to go from a total to a per-serving or per-day average.
+func (f Facts) Scale(factor float64) Facts {
+	scaled := make(Facts, len(f))
+	for id, amount := range f {
+		amount.Value = round(amount.Value * factor)
+		amount.DailyValue = nil
+		scaled[id] = amount
+	}
+	return scaled
+}
+
+// ParseValue extracts a number from a provider value. Strings may carry a
+// qualifier and a unit, e.g. "<1 g" or "~250kcal"; the unit is returned
+// alongside the number.
+func ParseValue(v interface{}) (float64, string, bool) {
+	switch value := v.(type) {
+	case float64:
+		return value, "", true
+	case float32:
+		return float64(value), "", true
+	case int:
+		return float64(value), "", true
+	case int64:
+		return float64(value), "", true
+	case string:
+		s := strings.TrimLeft(strings.TrimSpace(value), "<>~≈ ")
+		end := numberEnd(s, "")
+		number, ok := parseDecimal(s[:end])
+		if !ok {
+			return 0, "", false
+		}
+		return number, strings.TrimSpace(s[end:]), true
+	default:
+		return 0, "", false
+	}
+}
+
+// groupSeparators may separate the thousands of a number, as in "2 000 kJ".
+var groupSeparators = []string{" ", "\u00a0", "\u2009", "\u202f"}
+
+// Numbers whose separators all set off groups of three digits, such as
+// "1,250" or "1.000.000", group thousands; a single dot is a decimal point.
+var (
+	commaGroups = regexp.MustCompile(`^[1-9]\d{0,2}(,\d{3})+$`)
+	dotGroups   = regexp.MustCompile(`^[1-9]\d{0,2}(\.\d{3}){2,}$`)
+)
+
+// numberEnd returns where the number at the start of s ends. Besides digits,
+// dots and commas, it takes the runes of extra, and a group separator
+// between a digit and a group of exactly three digits.
+func numberEnd(s string, extra string) int {
+	end := 0
+	for end < len(s) {
+		r, size := utf8.DecodeRuneInString(s[end:])
+		switch {
+		case unicode.IsDigit(r) || r == '.' || r == ',' || strings.ContainsRune(extra, r):
+		case end > 0 && isASCIIDigit(s[end-1]) && isGroupSeparator(string(r)) && isDigitGroup(s[end+size:]):
+		default:
+			return end
+		}
+		end += size
+	}
+	return end
+}
+
+// parseDecimal reads a number written with either decimal separator and
+// optional thousands separators: "12,5", "1,250", "1.250,5", "2 000".
+func parseDecimal(number string) (float64, bool) {
+	for _, separator := range groupSeparators {
+		number = strings.ReplaceAll(number, separator, "")
+	}
+	lastDot, lastComma := strings.LastIndex(number, "."), strings.LastIndex(number, ",")
+	switch {
+	case lastDot >= 0 && lastComma >= 0:
+		// The last separator is the decimal one: "1,250.5" or "1.250,5"
+		decimal, group := ".", ","
+		if lastComma > lastDot {
+			decimal, group = ",", "."
+		}
+		number = strings.Replace(strings.ReplaceAll(number, group, ""), decimal, ".", 1)
+	case commaGroups.MatchString(number):
+		number = strings.ReplaceAll(number, ",", "")
+	case dotGroups.MatchString(number):
+		number = strings.ReplaceAll(number, ".", "")
+	case lastComma >= 0:
+		number = strings.ReplaceAll(number, ",", ".")
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	return value, err == nil
+}
+
+func isGroupSeparator(s string) bool {
+	for _, separator := range groupSeparators {
+		if s == separator {
+			return true
+		}
+	}
+	return false
+}
+
+// isDigitGroup reports whether s starts with exactly three digits.
+func isDigitGroup(s string) bool {
+	if len(s) < 3 || !isASCIIDigit(s[0]) || !isASCIIDigit(s[1]) || !isASCIIDigit(s[2]) {
+		return false
+	}
+	return len(s) == 3 || !isASCIIDigit(s[3])
+}
+
+func isASCIIDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+// round trims conversion noise to three decimal places.
+func round(v float64) float64 {
+	return math.Round(v*1000) / 1000
+}
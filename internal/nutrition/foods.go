@@ -0,0 +1,134 @@
+package nutrition
+
+import "strings"
+
+// TableConfidence is the confidence given to nutrients computed from the
+// food composition table.
+const TableConfidence = 0.85
+
+// Food is an entry of the local food composition table. Nutrients are per
+// 100 g of the food as listed, keyed by canonical nutrient ID and in catalog
+// units. Values are rounded from USDA FoodData Central.
+type Food struct {
+	ID       string
+	Name     string
+	Synonyms []string
+	Per100g  map[string]float64
+
+	// Density in grams per milliliter, to weigh volumes; 0 when unknown
+	Density float64
+
+	// UnitWeights are the grams of one counted unit, e.g. a piece or a clove
+	UnitWeights map[string]float64
+}
+
+// macros builds the usual per-100 g nutrients of a food.
+func macros(calories, protein, fat, saturatedFat, carbohydrates, fiber, sugars, sodium float64) map[string]float64 {
+	return map[string]float64{
+		"calories":      calories,
+		"protein":       protein,
+		"total_fat":     fat,
+		"saturated_fat": saturatedFat,
+		"carbohydrates": carbohydrates,
+		"fiber":         fiber,
+		"sugars":        sugars,
+		"sodium":        sodium,
+	}
+}
+
+// Foods is the local food composition table used to resolve recipe
+// ingredients without a provider.
+var Foods = []Food{
+	{ID: "olive_oil", Name: "Olive oil", Synonyms: []string{"extra virgin olive oil", "evoo"}, Per100g: macros(884, 0, 100, 13.8, 0, 0, 0, 2), Density: 0.91},
+	{ID: "vegetable_oil", Name: "Vegetable oil", Synonyms: []string{"canola oil", "sunflower oil", "rapeseed oil", "oil"}, Per100g: macros(884, 0, 100, 7.4, 0, 0, 0, 0), Density: 0.92},
+	{ID: "butter", Name: "Butter", Synonyms: []string{"unsalted butter", "salted butter"}, Per100g: macros(717, 0.9, 81, 51, 0.1, 0, 0.1, 11), Density: 0.96},
+	{ID: "rice_cooked", Name: "White rice, cooked", Synonyms: []string{"cooked rice", "cooked white rice", "rice", "white rice"}, Per100g: macros(130, 2.7, 0.3, 0.1, 28.2, 0.4, 0.1, 1), Density: 0.67},
+	{ID: "rice_raw", Name: "White rice, raw", Synonyms: []string{"uncooked rice", "raw rice", "dry rice", "uncooked white rice"}, Per100g: macros(365, 7.1, 0.7, 0.2, 80, 1.3, 0.1, 5), Density: 0.78},
+	{ID: "pasta_dry", Name: "Pasta, dry", Synonyms: []string{"pasta", "spaghetti", "penne", "macaroni", "dry pasta"}, Per100g: macros(371, 13, 1.5, 0.3, 75, 3.2, 2.7, 6)},
+	{ID: "pasta_cooked", Name: "Pasta, cooked", Synonyms: []string{"cooked pasta", "cooked spaghetti"}, Per100g: macros(158, 5.8, 0.9, 0.2, 31, 1.8, 0.6, 1), Density: 0.59},
+	{ID: "flour", Name: "Wheat flour", Synonyms: []string{"all purpose flour", "all-purpose flour", "plain flour", "flour", "white flour"}, Per100g: macros(364, 10.3, 1, 0.2, 76.3, 2.7, 0.3, 2), Density: 0.53},
+	{ID: "sugar", Name: "Sugar", Synonyms: []string{"white sugar", "granulated sugar", "caster sugar"}, Per100g: macros(387, 0, 0, 0, 100, 0, 100, 1), Density: 0.85},
+	{ID: "honey", Name: "Honey", Per100g: macros(304, 0.3, 0, 0, 82.4, 0.2, 82.1, 4), Density: 1.42},
+	{ID: "salt", Name: "Salt", Synonyms: []string{"table salt", "sea salt", "kosher salt"}, Per100g: macros(0, 0, 0, 0, 0, 0, 0, 38758), Density: 1.22},
+	{ID: "water", Name: "Water", Per100g: macros(0, 0, 0, 0, 0, 0, 0, 0), Density: 1},
+	{ID: "milk", Name: "Whole milk", Synonyms: []string{"milk", "whole milk"}, Per100g: macros(61, 3.2, 3.3, 1.9, 4.8, 0, 5.1, 43), Density: 1.03},
+	{ID: "heavy_cream", Name: "Heavy cream", Synonyms: []string{"cream", "double cream", "whipping cream"}, Per100g: macros(340, 2.8, 36.1, 23, 2.7, 0, 2.9, 27), Density: 1},
+	{ID: "greek_yogurt", Name: "Greek yogurt, plain", Synonyms: []string{"greek yogurt", "yogurt", "yoghurt", "plain yogurt"}, Per100g: macros(59, 10.2, 0.4, 0.1, 3.6, 0, 3.2, 36), Density: 1.03},
+	{ID: "cheddar", Name: "Cheddar cheese", Synonyms: []string{"cheddar", "shredded cheddar", "cheddar cheese"}, Per100g: macros(403, 24.9, 33.1, 21.1, 1.3, 0, 0.5, 621), Density: 0.48, UnitWeights: map[string]float64{UnitSlice: 21}},
+	{ID: "parmesan", Name: "Parmesan cheese", Synonyms: []string{"parmesan", "grated parmesan", "parmigiano"}, Per100g: macros(420, 38, 29, 17.3, 4.1, 0, 0.9, 1529), Density: 0.42},
+	{ID: "egg", Name: "Egg", Synonyms: []string{"eggs", "whole egg"}, Per100g: macros(143, 12.6, 9.5, 3.1, 0.7, 0, 0.4, 142), UnitWeights: map[string]float64{UnitPiece: 50}},
+	{ID: "chicken_breast", Name: "Chicken breast, raw", Synonyms: []string{"chicken breast", "chicken breasts", "boneless chicken breast"}, Per100g: macros(120, 22.5, 2.6, 0.6, 0, 0, 0, 45), UnitWeights: map[string]float64{UnitPiece: 174}},
+	{ID: "ground_beef", Name: "Ground beef, 80% lean, raw", Synonyms: []string{"ground beef", "minced beef", "beef mince"}, Per100g: macros(254, 17.2, 20, 7.6, 0, 0, 0, 66)},
+	{ID: "salmon", Name: "Salmon, raw", Synonyms: []string{"salmon fillet", "salmon fillets"}, Per100g: macros(208, 20.4, 13.4, 3.1, 0, 0, 0, 59), UnitWeights: map[string]float64{UnitPiece: 170}},
+	{ID: "tofu", Name: "Tofu, firm", Synonyms: []string{"firm tofu"}, Per100g: macros(144, 17.3, 8.7, 1.3, 2.8, 2.3, 0.6, 14)},
+	{ID: "chickpeas", Name: "Chickpeas, cooked", Synonyms: []string{"chickpea", "garbanzo beans", "canned chickpeas"}, Per100g: macros(164, 8.9, 2.6, 0.3, 27.4, 7.6, 4.8, 7), Density: 0.69, UnitWeights: map[string]float64{UnitCan: 240}},
+	{ID: "black_beans", Name: "Black beans, cooked", Synonyms: []string{"black beans", "canned black beans"}, Per100g: macros(132, 8.9, 0.5, 0.1, 23.7, 8.7, 0.3, 1), Density: 0.73, UnitWeights: map[string]float64{UnitCan: 240}},
+	{ID: "lentils", Name: "Lentils, cooked", Synonyms: []string{"lentil", "cooked lentils"}, Per100g: macros(116, 9, 0.4, 0.1, 20.1, 7.9, 1.8, 2), Density: 0.84},
+	{ID: "oats", Name: "Rolled oats", Synonyms: []string{"oats", "oatmeal", "porridge oats", "rolled oats"}, Per100g: macros(379, 13.2, 6.5, 1.1, 67.7, 10.1, 1, 6), Density: 0.34},
+	{ID: "bread", Name: "White bread", Synonyms: []string{"bread", "white bread", "sandwich bread"}, Per100g: macros(266, 7.6, 3.3, 0.7, 49, 2.7, 5.7, 491), UnitWeights: map[string]float64{UnitSlice: 25}},
+	{ID: "onion", Name: "Onion", Synonyms: []string{"onions", "yellow onion", "red onion", "white onion"}, Per100g: macros(40, 1.1, 0.1, 0, 9.3, 1.7, 4.2, 4), Density: 0.67, UnitWeights: map[string]float64{UnitPiece: 110}},
+	{ID: "garlic", Name: "Garlic", Synonyms: []string{"garlic clove", "garlic cloves"}, Per100g: macros(149, 6.4, 0.5, 0.1, 33, 2.1, 1, 17), UnitWeights: map[string]float64{UnitClove: 3, UnitPiece: 3}},
+	{ID: "tomato", Name: "Tomato", Synonyms: []string{"tomatoes", "diced tomatoes", "canned tomatoes"}, Per100g: macros(18, 0.9, 0.2, 0, 3.9, 1.2, 2.6, 5), Density: 0.76, UnitWeights: map[string]float64{UnitPiece: 123, UnitCan: 400}},
+	{ID: "potato", Name: "Potato", Synonyms: []string{"potatoes"}, Per100g: macros(77, 2, 0.1, 0, 17.5, 2.1, 0.8, 6), UnitWeights: map[string]float64{UnitPiece: 213}},
+	{ID: "carrot", Name: "Carrot", Synonyms: []string{"carrots"}, Per100g: macros(41, 0.9, 0.2, 0, 9.6, 2.8, 4.7, 69), Density: 0.54, UnitWeights: map[string]float64{UnitPiece: 61}},
+	{ID: "bell_pepper", Name: "Bell pepper", Synonyms: []string{"bell peppers", "red pepper", "green pepper", "capsicum"}, Per100g: macros(26, 1, 0.3, 0, 6, 2.1, 4.2, 4), UnitWeights: map[string]float64{UnitPiece: 119}},
+	{ID: "broccoli", Name: "Broccoli", Synonyms: []string{"broccoli florets"}, Per100g: macros(34, 2.8, 0.4, 0, 6.6, 2.6, 1.7, 33), Density: 0.38},
+	{ID: "spinach", Name: "Spinach", Synonyms: []string{"baby spinach"}, Per100g: macros(23, 2.9, 0.4, 0.1, 3.6, 2.2, 0.4, 79), Density: 0.13},
+	{ID: "avocado", Name: "Avocado", Synonyms: []string{"avocados"}, Per100g: macros(160, 2, 14.7, 2.1, 8.5, 6.7, 0.7, 7), UnitWeights: map[string]float64{UnitPiece: 150}},
+	{ID: "banana", Name: "Banana", Synonyms: []string{"bananas"}, Per100g: macros(89, 1.1, 0.3, 0.1, 22.8, 2.6, 12.2, 1), UnitWeights: map[string]float64{UnitPiece: 118}},
+	{ID: "apple", Name: "Apple", Synonyms: []string{"apples"}, Per100g: macros(52, 0.3, 0.2, 0, 13.8, 2.4, 10.4, 1), UnitWeights: map[string]float64{UnitPiece: 182}},
+	{ID: "lemon_juice", Name: "Lemon juice", Per100g: macros(22, 0.4, 0.2, 0, 6.9, 0.3, 2.5, 1), Density: 1.03},
+	{ID: "peanut_butter", Name: "Peanut butter", Per100g: macros(588, 25, 50, 10, 20, 6, 9.2, 459), Density: 1.08},
+	{ID: "almonds", Name: "Almonds", Synonyms: []string{"almond"}, Per100g: macros(579, 21.2, 49.9, 3.8, 21.6, 12.5, 4.4, 1), Density: 0.6},
+	{ID: "soy_sauce", Name: "Soy sauce", Synonyms: []string{"soya sauce", "shoyu"}, Per100g: macros(53, 8.1, 0.6, 0.1, 4.9, 0.8, 0.4, 5493), Density: 1.1},
+}
+
+// foodIndex maps normalized names and synonyms to table entries.
+var foodIndex = buildFoodIndex()
+
+func buildFoodIndex() map[string]*Food {
+	idx := make(map[string]*Food)
+	for i := range Foods {
+		food := &Foods[i]
+		idx[normalizeName(food.Name)] = food
+		for _, synonym := range food.Synonyms {
+			idx[normalizeName(synonym)] = food
+		}
+	}
+	return idx
+}
+
+// LookupFood finds the table entry for an ingredient description such as
+// "cooked rice" or "onion, finely chopped". The longest name or synonym found
+// in the description as whole words wins, so "uncooked rice" is not read as
+// "rice".
+func LookupFood(description string) (*Food, bool) {
+	words := strings.Fields(normalizeName(description))
+	var best *Food
+	bestLen := 0
+	for start := range words {
+		for end := len(words); end > start; end-- {
+			if end-start <= bestLen {
+				break
+			}
+			if food, ok := foodIndex[strings.Join(words[start:end], " ")]; ok {
+				best, bestLen = food, end-start
+				break
+			}
+		}
+	}
+	return best, best != nil
+}
+
+// FactsFor returns the nutrients in the given weight of the food.
+func (f *Food) FactsFor(grams float64) Facts {
+	facts := make(Facts, len(f.Per100g))
+	for id, per100g := range f.Per100g {
+		name, unit := id, ""
+		if nutrient, ok := Get(id); ok {
+			name, unit = nutrient.Name, nutrient.Unit
+		}
+		facts[id] = Amount{Name: name, Value: round(per100g * grams / 100), Unit: unit, Confidence: TableConfidence}
+	}
+	return facts
+}
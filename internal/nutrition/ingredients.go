@@ -0,0 +1,249 @@
+package nutrition
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Kitchen units of recipe quantities, besides the mass units of the catalog.
+const (
+	UnitOunce      = "oz"
+	UnitPound      = "lb"
+	UnitMilliliter = "ml"
+	UnitLiter      = "l"
+	UnitTeaspoon   = "tsp"
+	UnitTablespoon = "tbsp"
+	UnitCup        = "cup"
+	UnitFluidOunce = "fl oz"
+	UnitPinch      = "pinch"
+	UnitPiece      = "piece"
+	UnitSlice      = "slice"
+	UnitClove      = "clove"
+	UnitCan        = "can"
+)
+
+// kitchenUnitAliases maps lowercase spellings of recipe units to their
+// canonical form. Multi-word aliases are matched before single words.
+var kitchenUnitAliases = map[string]string{
+	"g": UnitGram, "gr": UnitGram, "gram": UnitGram, "grams": UnitGram, "grammes": UnitGram,
+	"kg": UnitKilogram, "kilogram": UnitKilogram, "kilograms": UnitKilogram,
+	"mg": UnitMilligram, "milligram": UnitMilligram, "milligrams": UnitMilligram,
+	"oz": UnitOunce, "ounce": UnitOunce, "ounces": UnitOunce,
+	"lb": UnitPound, "lbs": UnitPound, "pound": UnitPound, "pounds": UnitPound,
+	"ml": UnitMilliliter, "milliliter": UnitMilliliter, "milliliters": UnitMilliliter, "millilitre": UnitMilliliter, "millilitres": UnitMilliliter,
+	"l": UnitLiter, "liter": UnitLiter, "liters": UnitLiter, "litre": UnitLiter, "litres": UnitLiter,
+	"tsp": UnitTeaspoon, "teaspoon": UnitTeaspoon, "teaspoons": UnitTeaspoon,
+	"tbsp": UnitTablespoon, "tbs": UnitTablespoon, "tablespoon": UnitTablespoon, "tablespoons": UnitTablespoon,
+	"cup": UnitCup, "cups": UnitCup,
+	"fl oz": UnitFluidOunce, "fluid ounce": UnitFluidOunce, "fluid ounces": UnitFluidOunce,
+	"pinch": UnitPinch, "pinches": UnitPinch,
+	"piece": UnitPiece, "pieces": UnitPiece, "whole": UnitPiece,
+	"slice": UnitSlice, "slices": UnitSlice,
+	"clove": UnitClove, "cloves": UnitClove,
+	"can": UnitCan, "cans": UnitCan, "tin": UnitCan, "tins": UnitCan,
+}
+
+// Factors from kitchen units to grams and milliliters.
+var (
+	kitchenMassInGrams = map[string]float64{
+		UnitKilogram:  1000,
+		UnitGram:      1,
+		UnitMilligram: 1e-3,
+		UnitOunce:     28.3495,
+		UnitPound:     453.592,
+	}
+	kitchenVolumeInMilliliters = map[string]float64{
+		UnitMilliliter: 1,
+		UnitLiter:      1000,
+		UnitTeaspoon:   4.92892,
+		UnitTablespoon: 14.7868,
+		UnitCup:        236.588,
+		UnitFluidOunce: 29.5735,
+		UnitPinch:      0.31,
+	}
+)
+
+// sizeFactors scale the weight of a counted item, e.g. "2 large eggs".
+var sizeFactors = map[string]float64{"small": 0.75, "medium": 1, "large": 1.25, "extra large": 1.4}
+
+// IngredientQuantity is one line of a recipe's ingredient list, split into an
+// amount, a unit and the food.
+type IngredientQuantity struct {
+	Text   string  `json:"text"`
+	Amount float64 `json:"amount,omitempty"` // 0 when the line gives no amount
+	Unit   string  `json:"unit,omitempty"`   // Canonical kitchen unit, empty for counted items
+	Size   float64 `json:"-"`                // Weight factor of counted items, 1 by default
+	Food   string  `json:"food"`
+
+	// StatedGrams is the weight of the amount when the line states one, e.g.
+	// "1 can (400 g) chickpeas"
+	StatedGrams float64 `json:"-"`
+}
+
+var (
+	unicodeFractions  = map[rune]string{'½': " 1/2", '⅓': " 1/3", '⅔': " 2/3", '¼': " 1/4", '¾': " 3/4", '⅛': " 1/8"}
+	listMarker        = regexp.MustCompile(`^\s*(?:[-*•]|\d+[.)])\s+`)
+	parentheticalMass = regexp.MustCompile(`^\(\s*([\d.,/ ]+)\s*([a-zA-Z]+)\s*\)\s*`)
+)
+
+// ParseIngredient parses a recipe line such as "2 tbsp olive oil",
+// "1 1/2 cups cooked rice", "½ onion, chopped" or "a pinch of salt".
+func ParseIngredient(line string) IngredientQuantity {
+	q := IngredientQuantity{Text: strings.TrimSpace(line), Size: 1}
+
+	text := listMarker.ReplaceAllString(q.Text, "")
+	for r, replacement := range unicodeFractions {
+		text = strings.ReplaceAll(text, string(r), replacement)
+	}
+	text = strings.TrimSpace(text)
+
+	amount, rest := parseAmount(text)
+	q.Amount = amount
+	rest = strings.TrimSpace(rest)
+
+	if size, after := cutPrefixWord(rest, sizeFactors); size != "" {
+		q.Size, rest = sizeFactors[size], after
+	}
+	if unit, after := cutPrefixWord(rest, kitchenUnitAliases); unit != "" && q.Amount > 0 {
+		q.Unit, rest = kitchenUnitAliases[unit], after
+	}
+	if q.Unit == "" {
+		// "2 large eggs" after a unit-less amount
+		if size, after := cutPrefixWord(rest, sizeFactors); size != "" {
+			q.Size, rest = sizeFactors[size], after
+		}
+	}
+
+	// "1 can (400 g) chickpeas" states the weight of the amount
+	if m := parentheticalMass.FindStringSubmatch(rest); m != nil {
+		if value, _ := parseAmount(m[1]); value > 0 {
+			if factor, ok := kitchenMassInGrams[kitchenUnitAliases[strings.ToLower(m[2])]]; ok {
+				q.StatedGrams = value * factor * max(q.Amount, 1)
+				rest = rest[len(m[0]):]
+			}
+		}
+	}
+
+	rest = strings.TrimSpace(rest)
+	rest = strings.TrimPrefix(rest, "of ")
+	q.Food = strings.TrimSpace(rest)
+	return q
+}
+
+// GramsOf returns the weight of the quantity of a food, and false when it
+// cannot be worked out, e.g. a volume of a food without a known density.
+func (q IngredientQuantity) GramsOf(food *Food) (float64, bool) {
+	if q.StatedGrams > 0 {
+		return q.StatedGrams, true
+	}
+	if q.Amount == 0 {
+		return 0, false
+	}
+	if factor, ok := kitchenMassInGrams[q.Unit]; ok {
+		return q.Amount * factor, true
+	}
+	if food == nil {
+		return 0, false
+	}
+	if factor, ok := kitchenVolumeInMilliliters[q.Unit]; ok && food.Density > 0 {
+		return q.Amount * factor * food.Density, true
+	}
+	unit := q.Unit
+	if unit == "" {
+		unit = UnitPiece
+	}
+	if weight, ok := food.UnitWeights[unit]; ok {
+		return q.Amount * weight * q.Size, true
+	}
+	return 0, false
+}
+
+// parseAmount reads a leading amount: a number, a decimal with a point or
+// comma, a fraction, a mixed number, a range ("2-3", "2 to 3", taken as the
+// middle) or an article ("a", "an"). It returns 0 when there is none.
+func parseAmount(text string) (float64, string) {
+	first, rest := parseNumber(text)
+	if first == 0 {
+		for _, article := range []string{"a ", "an ", "one "} {
+			if strings.HasPrefix(strings.ToLower(text), article) {
+				return 1, text[len(article):]
+			}
+		}
+		return 0, text
+	}
+	trimmed := strings.TrimSpace(rest)
+	for _, separator := range []string{"-", "–", "to "} {
+		if strings.HasPrefix(trimmed, separator) {
+			if second, after := parseNumber(strings.TrimSpace(trimmed[len(separator):])); second > first {
+				return (first + second) / 2, after
+			}
+		}
+	}
+	return first, rest
+}
+
+// parseNumber reads a leading number, fraction or mixed number.
+func parseNumber(text string) (float64, string) {
+	var total float64
+	found := false
+	for {
+		text = strings.TrimLeft(text, " ")
+		end := numberEnd(text, "/")
+		if end == 0 {
+			break
+		}
+		token := strings.Trim(text[:end], ".,")
+		value, ok := parseNumberToken(token)
+		if !ok || (found && !strings.Contains(token, "/")) {
+			break
+		}
+		total += value
+		found = true
+		text = text[end:]
+		if strings.Contains(token, "/") {
+			break
+		}
+	}
+	if !found {
+		return 0, text
+	}
+	return total, text
+}
+
+func parseNumberToken(token string) (float64, bool) {
+	if numerator, denominator, ok := strings.Cut(token, "/"); ok {
+		n, err1 := strconv.ParseFloat(numerator, 64)
+		d, err2 := strconv.ParseFloat(denominator, 64)
+		if err1 != nil || err2 != nil || d == 0 {
+			return 0, false
+		}
+		return n / d, true
+	}
+	return parseDecimal(token)
+}
+
+// cutPrefixWord removes the longest key of words that starts text as whole
+// words, ignoring case and a trailing period ("tbsp.").
+func cutPrefixWord[V any](text string, words map[string]V) (string, string) {
+	lower := strings.ToLower(text)
+	best := ""
+	for word := range words {
+		if len(word) <= len(best) || !strings.HasPrefix(lower, word) {
+			continue
+		}
+		next := len(word)
+		if next < len(lower) && lower[next] == '.' {
+			next++
+		}
+		if next == len(lower) || !unicode.IsLetter(rune(lower[next])) {
+			best = word
+		}
+	}
+	if best == "" {
+		return "", text
+	}
+	rest := strings.TrimPrefix(text[len(best):], ".")
+	return best, strings.TrimSpace(rest)
+}
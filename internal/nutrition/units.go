@@ -0,0 +1,89 @@
+package nutrition
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Canonical unit spellings.
+const (
+	UnitGram      = "g"
+	UnitMilligram = "mg"
+	UnitMicrogram = "µg"
+	UnitKilogram  = "kg"
+	UnitKcal      = "kcal"
+	UnitKJ        = "kJ"
+	UnitIU        = "IU"
+	UnitPercent   = "%"
+)
+
+// unitAliases maps lowercase unit spellings to their canonical form.
+var unitAliases = map[string]string{
+	"g": UnitGram, "gr": UnitGram, "gram": UnitGram, "grams": UnitGram, "gramm": UnitGram, "gramos": UnitGram, "grammes": UnitGram,
+	"mg": UnitMilligram, "milligram": UnitMilligram, "milligrams": UnitMilligram, "miligramos": UnitMilligram,
+	"µg": UnitMicrogram, "μg": UnitMicrogram, "ug": UnitMicrogram, "mcg": UnitMicrogram, "microgram": UnitMicrogram, "micrograms": UnitMicrogram,
+	"kg": UnitKilogram, "kilogram": UnitKilogram, "kilograms": UnitKilogram,
+	// Food labels use "Calories" and "cal" for kilocalories
+	"kcal": UnitKcal, "cal": UnitKcal, "cals": UnitKcal, "calorie": UnitKcal, "calories": UnitKcal, "kilocalorie": UnitKcal, "kilocalories": UnitKcal,
+	"kj": UnitKJ, "kilojoule": UnitKJ, "kilojoules": UnitKJ,
+	"iu": UnitIU, "ui": UnitIU, "international units": UnitIU,
+	"%": UnitPercent, "% dv": UnitPercent, "%dv": UnitPercent, "percent": UnitPercent,
+}
+
+// massInGrams and energyInKcal hold the factor to the base unit of each dimension.
+var massInGrams = map[string]float64{
+	UnitKilogram:  1000,
+	UnitGram:      1,
+	UnitMilligram: 1e-3,
+	UnitMicrogram: 1e-6,
+}
+
+var energyInKcal = map[string]float64{
+	UnitKcal: 1,
+	UnitKJ:   1 / 4.184,
+}
+
+// CanonicalUnit returns the canonical spelling of a unit, or the trimmed input
+// when the unit is not recognized.
+func CanonicalUnit(unit string) string {
+	trimmed := strings.TrimSpace(unit)
+	if canonical, ok := unitAliases[strings.ToLower(trimmed)]; ok {
+		return canonical
+	}
+	return trimmed
+}
+
+// Convert converts a value between units. The nutrient is only needed for
+// conversions involving International Units, which are defined per nutrient.
+func Convert(value float64, from, to string, nutrient *Nutrient) (float64, error) {
+	from, to = CanonicalUnit(from), CanonicalUnit(to)
+	if from == to {
+		return value, nil
+	}
+
+	if from == UnitIU || to == UnitIU {
+		if nutrient == nil || nutrient.IU == 0 {
+			return 0, fmt.Errorf("IU is not defined for this nutrient")
+		}
+		if from == UnitIU {
+			return Convert(value*nutrient.IU, nutrient.Unit, to, nutrient)
+		}
+		converted, err := Convert(value, from, nutrient.Unit, nutrient)
+		if err != nil {
+			return 0, err
+		}
+		return converted / nutrient.IU, nil
+	}
+
+	if fromFactor, ok := massInGrams[from]; ok {
+		if toFactor, ok := massInGrams[to]; ok {
+			return value * fromFactor / toFactor, nil
+		}
+	}
+	if fromFactor, ok := energyInKcal[from]; ok {
+		if toFactor, ok := energyInKcal[to]; ok {
+			return value * fromFactor / toFactor, nil
+		}
+	}
+	return 0, fmt.Errorf("cannot convert %q to %q", from, to)
+}
@@ -1,6 +1,15 @@
 package repositories
 
-import "dietsense/internal/models"
+import (
+	"dietsense/internal/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrNotFound is returned by lookups when no matching record exists.
+var ErrNotFound = gorm.ErrRecordNotFound
 
 // Database defines the interface for database operations.
 type Database interface {
@@ -19,4 +28,106 @@ type Database interface {
 	// Save/Retrieve API keys
 	GetAPIKey(key string) (*models.APIKey, error)
 	SaveAPIKey(apiKey *models.APIKey) error
+
+	// Save/Retrieve analysis revisions
+	GetAnalysis(id string) (*models.Analysis, error)
+	SaveAnalysis(analysis *models.Analysis) error
+	ClearAnalysisImagesBefore(before time.Time) error
+
+	// Save/Retrieve food log entries, scoped to the owning API key
+	GetFoodLogEntry(apiKey, id string) (*models.FoodLogEntry, error)
+	SaveFoodLogEntry(entry *models.FoodLogEntry) error
+	ListFoodLogEntries(apiKey string, filter FoodLogFilter) ([]models.FoodLogEntry, error)
+	DeleteFoodLogEntry(apiKey, id string) error
+	SumFoodLog(apiKey string, ranges []TimeRange) (*FoodLogTotals, error)
+
+	// Save/Retrieve nutrition goals, scoped to the owning API key
+	GetGoals(apiKey string) ([]models.Goal, error)
+	GetGoal(apiKey, id string) (*models.Goal, error)
+	SaveGoal(goal *models.Goal) error
+	DeleteGoal(apiKey, id string) error
+
+	// Save/Retrieve body profiles and weight history
+	GetProfile(apiKey string) (*models.Profile, error)
+	SaveProfile(profile *models.Profile) error
+	ListWeightEntries(apiKey string, limit int) ([]models.WeightEntry, error)
+	SaveWeightEntry(entry *models.WeightEntry) error
+
+	// Save/Retrieve meal templates, scoped to the owning API key
+	GetMealTemplates(apiKey string) ([]models.MealTemplate, error)
+	GetMealTemplate(apiKey, id string) (*models.MealTemplate, error)
+	SaveMealTemplate(template *models.MealTemplate) error
+	DeleteMealTemplate(apiKey, id string) error
+
+	// Save/Retrieve background analysis jobs
+	GetAnalysisJob(apiKey, id string) (*models.AnalysisJob, error)
+	SaveAnalysisJob(job *models.AnalysisJob) error
+	ListUnfinishedAnalysisJobs() ([]models.AnalysisJob, error)
+
+	// Save/Retrieve stored responses of requests sent with an idempotency key
+	CreateIdempotencyRecord(record *models.IdempotencyRecord) (bool, error)
+	GetIdempotencyRecord(scope, key string) (*models.IdempotencyRecord, error)
+	CompleteIdempotencyRecord(record *models.IdempotencyRecord) error
+	DeleteIdempotencyRecord(scope, key string) error
+	DeleteIdempotencyRecordsBefore(before time.Time) error
+
+	// Save/Retrieve images uploaded ahead of an analysis
+	SaveBlob(blob *models.Blob) error
+	GetBlob(apiKey, id string) (*models.Blob, error)
+	DeleteBlobsBefore(before time.Time) error
+}
+
+// FoodLogFilter narrows a food log listing. Zero values do not filter.
+// Entries are listed newest first, ordered by EatenAt and then ID.
+type FoodLogFilter struct {
+	From      time.Time // Inclusive
+	To        time.Time // Exclusive
+	MealType  string
+	InputType string
+	Search    string // Case-insensitive match on the summary
+
+	// Cursor position: only entries after this one in listing order
+	AfterEatenAt time.Time
+	AfterID      string
+
+	Limit int
+}
+
+// EscapeLike escapes the LIKE wildcards in a search term, with a backslash as
+// the escape character.
+func EscapeLike(term string) string {
+	return strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(term)
+}
+
+// TimeRange is a labelled half-open interval [Start, End) used to bucket the
+// food log, typically one calendar day in the user's time zone.
+type TimeRange struct {
+	Label string
+	Start time.Time
+	End   time.Time
+}
+
+// FoodLogTotals holds food log aggregates per time range and meal type.
+type FoodLogTotals struct {
+	Nutrients []NutrientTotal
+	Entries   []EntryCount
+}
+
+// NutrientTotal is the sum of one nutrient over the entries of a time range
+// and meal type. Confidence is the lowest among the summed values.
+type NutrientTotal struct {
+	Label      string // Label of the TimeRange
+	MealType   string
+	Nutrient   string
+	Name       string
+	Unit       string
+	Value      float64
+	Confidence float64
+}
+
+// EntryCount is the number of entries logged in a time range and meal type.
+type EntryCount struct {
+	Label    string // Label of the TimeRange
+	MealType string
+	Entries  int
 }
@@ -2,9 +2,13 @@ package postgres
 
 import (
 	"dietsense/internal/models"
+	"dietsense/internal/repositories"
+	"strings"
+	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // PostgresDB implements the Database interface for PostgreSQL.
@@ -19,7 +23,7 @@ func NewPostgresDB(dsn string) (*PostgresDB, error) {
 		return nil, err
 	}
 	// Migrate the schema
-	db.AutoMigrate(&models.UserConfig{}, &models.UsageStats{})
+	db.AutoMigrate(&models.UserConfig{}, &models.UsageStats{}, &models.APIKey{}, &models.UserKey{}, &models.Analysis{}, &models.FoodLogEntry{}, &models.FoodLogNutrient{}, &models.Goal{}, &models.Profile{}, &models.WeightEntry{}, &models.MealTemplate{}, &models.AnalysisJob{}, &models.IdempotencyRecord{}, &models.Blob{})
 	return &PostgresDB{db: db}, nil
 }
 
@@ -92,3 +96,305 @@ func (p *PostgresDB) GetAPIKey(key string) (*models.APIKey, error) {
 func (p *PostgresDB) SaveAPIKey(apiKey *models.APIKey) error {
 	return p.db.Save(apiKey).Error
 }
+
+// GetAnalysis retrieves an analysis revision by ID.
+func (p *PostgresDB) GetAnalysis(id string) (*models.Analysis, error) {
+	var analysis models.Analysis
+	if err := p.db.First(&analysis, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &analysis, nil
+}
+
+// SaveAnalysis saves an analysis revision.
+func (p *PostgresDB) SaveAnalysis(analysis *models.Analysis) error {
+	return p.db.Save(analysis).Error
+}
+
+// ClearAnalysisImagesBefore drops the full images of analyses created before
+// the given time, keeping their hashes and thumbnails.
+func (p *PostgresDB) ClearAnalysisImagesBefore(before time.Time) error {
+	return p.db.Model(&models.Analysis{}).Where("created_at < ? AND image IS NOT NULL", before).Update("image", nil).Error
+}
+
+// GetFoodLogEntry retrieves a food log entry with its nutrients.
+func (p *PostgresDB) GetFoodLogEntry(apiKey, id string) (*models.FoodLogEntry, error) {
+	var entry models.FoodLogEntry
+	if err := p.db.Preload("Nutrients").First(&entry, "id = ? AND api_key = ?", id, apiKey).Error; err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// SaveFoodLogEntry saves a food log entry and replaces its nutrients.
+func (p *PostgresDB) SaveFoodLogEntry(entry *models.FoodLogEntry) error {
+	return p.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("entry_id = ?", entry.ID).Delete(&models.FoodLogNutrient{}).Error; err != nil {
+			return err
+		}
+		for i := range entry.Nutrients {
+			entry.Nutrients[i].ID = 0
+			entry.Nutrients[i].EntryID = entry.ID
+		}
+		return tx.Session(&gorm.Session{FullSaveAssociations: true}).Save(entry).Error
+	})
+}
+
+// ListFoodLogEntries lists food log entries matching the filter, newest first.
+func (p *PostgresDB) ListFoodLogEntries(apiKey string, filter repositories.FoodLogFilter) ([]models.FoodLogEntry, error) {
+	query := p.db.Preload("Nutrients").Where("api_key = ?", apiKey)
+	if !filter.From.IsZero() {
+		query = query.Where("eaten_at >= ?", filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("eaten_at < ?", filter.To.UTC())
+	}
+	if filter.MealType != "" {
+		query = query.Where("meal_type = ?", filter.MealType)
+	}
+	if filter.InputType != "" {
+		query = query.Where("input_type = ?", filter.InputType)
+	}
+	if filter.Search != "" {
+		query = query.Where("summary ILIKE ?", "%"+repositories.EscapeLike(filter.Search)+"%")
+	}
+	if filter.AfterID != "" {
+		after := filter.AfterEatenAt.UTC()
+		query = query.Where("eaten_at < ? OR (eaten_at = ? AND id < ?)", after, after, filter.AfterID)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var entries []models.FoodLogEntry
+	if err := query.Order("eaten_at DESC, id DESC").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// DeleteFoodLogEntry deletes a food log entry and its nutrients.
+func (p *PostgresDB) DeleteFoodLogEntry(apiKey, id string) error {
+	return p.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND api_key = ?", id, apiKey).Delete(&models.FoodLogEntry{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return repositories.ErrNotFound
+		}
+		return tx.Where("entry_id = ?", id).Delete(&models.FoodLogNutrient{}).Error
+	})
+}
+
+// SumFoodLog totals the nutrients and counts the entries logged in each time
+// range, per meal type. The ranges are joined as an inline table so bucketing
+// follows the caller's calendar, including daylight saving shifts.
+func (p *PostgresDB) SumFoodLog(apiKey string, ranges []repositories.TimeRange) (*repositories.FoodLogTotals, error) {
+	totals := &repositories.FoodLogTotals{}
+	if len(ranges) == 0 {
+		return totals, nil
+	}
+
+	rows := make([]string, 0, len(ranges))
+	args := make([]interface{}, 0, len(ranges)*3+1)
+	for _, r := range ranges {
+		rows = append(rows, "(CAST(? AS TEXT), CAST(? AS TIMESTAMPTZ), CAST(? AS TIMESTAMPTZ))")
+		args = append(args, r.Label, r.Start.UTC(), r.End.UTC())
+	}
+	args = append(args, apiKey)
+	buckets := "WITH buckets(label, start_at, end_at) AS (VALUES " + strings.Join(rows, ", ") + ") "
+	join := "FROM buckets b JOIN food_log_entries e ON e.eaten_at >= b.start_at AND e.eaten_at < b.end_at "
+
+	err := p.db.Raw(buckets+
+		"SELECT b.label AS label, e.meal_type AS meal_type, n.nutrient AS nutrient, MAX(n.name) AS name, n.unit AS unit, "+
+		"SUM(n.value) AS value, MIN(n.confidence) AS confidence "+
+		join+"JOIN food_log_nutrients n ON n.entry_id = e.id "+
+		"WHERE e.api_key = ? GROUP BY b.label, e.meal_type, n.nutrient, n.unit", args...).Scan(&totals.Nutrients).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.db.Raw(buckets+
+		"SELECT b.label AS label, e.meal_type AS meal_type, COUNT(*) AS entries "+
+		join+"WHERE e.api_key = ? GROUP BY b.label, e.meal_type", args...).Scan(&totals.Entries).Error
+	if err != nil {
+		return nil, err
+	}
+	return totals, nil
+}
+
+// GetGoals lists the nutrition goals of an API key.
+func (p *PostgresDB) GetGoals(apiKey string) ([]models.Goal, error) {
+	var goals []models.Goal
+	if err := p.db.Where("api_key = ?", apiKey).Order("nutrient, type").Find(&goals).Error; err != nil {
+		return nil, err
+	}
+	return goals, nil
+}
+
+// GetGoal retrieves a nutrition goal.
+func (p *PostgresDB) GetGoal(apiKey, id string) (*models.Goal, error) {
+	var goal models.Goal
+	if err := p.db.First(&goal, "id = ? AND api_key = ?", id, apiKey).Error; err != nil {
+		return nil, err
+	}
+	return &goal, nil
+}
+
+// SaveGoal saves a nutrition goal.
+func (p *PostgresDB) SaveGoal(goal *models.Goal) error {
+	return p.db.Save(goal).Error
+}
+
+// DeleteGoal deletes a nutrition goal.
+func (p *PostgresDB) DeleteGoal(apiKey, id string) error {
+	result := p.db.Where("id = ? AND api_key = ?", id, apiKey).Delete(&models.Goal{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// GetProfile retrieves the body profile of an API key.
+func (p *PostgresDB) GetProfile(apiKey string) (*models.Profile, error) {
+	var profile models.Profile
+	if err := p.db.First(&profile, "api_key = ?", apiKey).Error; err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// SaveProfile saves a body profile.
+func (p *PostgresDB) SaveProfile(profile *models.Profile) error {
+	return p.db.Save(profile).Error
+}
+
+// ListWeightEntries lists the weight history of an API key, newest first.
+func (p *PostgresDB) ListWeightEntries(apiKey string, limit int) ([]models.WeightEntry, error) {
+	query := p.db.Where("api_key = ?", apiKey).Order("measured_at DESC, id DESC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	var entries []models.WeightEntry
+	if err := query.Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// SaveWeightEntry saves a weight history entry.
+func (p *PostgresDB) SaveWeightEntry(entry *models.WeightEntry) error {
+	return p.db.Save(entry).Error
+}
+
+// GetMealTemplates lists the meal templates of an API key by name.
+func (p *PostgresDB) GetMealTemplates(apiKey string) ([]models.MealTemplate, error) {
+	var templates []models.MealTemplate
+	if err := p.db.Where("api_key = ?", apiKey).Order("name, id").Find(&templates).Error; err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+// GetMealTemplate retrieves a meal template.
+func (p *PostgresDB) GetMealTemplate(apiKey, id string) (*models.MealTemplate, error) {
+	var template models.MealTemplate
+	if err := p.db.First(&template, "id = ? AND api_key = ?", id, apiKey).Error; err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+// SaveMealTemplate saves a meal template.
+func (p *PostgresDB) SaveMealTemplate(template *models.MealTemplate) error {
+	return p.db.Save(template).Error
+}
+
+// DeleteMealTemplate deletes a meal template.
+func (p *PostgresDB) DeleteMealTemplate(apiKey, id string) error {
+	result := p.db.Where("id = ? AND api_key = ?", id, apiKey).Delete(&models.MealTemplate{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// GetAnalysisJob retrieves an analysis job owned by an API key.
+func (p *PostgresDB) GetAnalysisJob(apiKey, id string) (*models.AnalysisJob, error) {
+	var job models.AnalysisJob
+	if err := p.db.First(&job, "id = ? AND api_key = ?", id, apiKey).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// SaveAnalysisJob saves an analysis job.
+func (p *PostgresDB) SaveAnalysisJob(job *models.AnalysisJob) error {
+	return p.db.Save(job).Error
+}
+
+// ListUnfinishedAnalysisJobs lists the queued and running jobs of all API
+// keys, oldest first.
+func (p *PostgresDB) ListUnfinishedAnalysisJobs() ([]models.AnalysisJob, error) {
+	var jobs []models.AnalysisJob
+	err := p.db.Where("status IN ?", []string{models.JobQueued, models.JobRunning}).Order("created_at, id").Find(&jobs).Error
+	return jobs, err
+}
+
+// CreateIdempotencyRecord saves a record unless one already exists for its
+// scope and key, and reports whether it was saved.
+func (p *PostgresDB) CreateIdempotencyRecord(record *models.IdempotencyRecord) (bool, error) {
+	result := p.db.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+	return result.RowsAffected == 1, result.Error
+}
+
+// GetIdempotencyRecord retrieves the record of an idempotency key.
+func (p *PostgresDB) GetIdempotencyRecord(scope, key string) (*models.IdempotencyRecord, error) {
+	var record models.IdempotencyRecord
+	if err := p.db.Where(map[string]interface{}{"scope": scope, "key": key}).First(&record).Error; err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// CompleteIdempotencyRecord stores the response of an idempotency record.
+func (p *PostgresDB) CompleteIdempotencyRecord(record *models.IdempotencyRecord) error {
+	return p.db.Model(record).Where(map[string]interface{}{"scope": record.Scope, "key": record.Key}).
+		Select("status_code", "header", "body").Updates(record).Error
+}
+
+// DeleteIdempotencyRecord deletes the record of an idempotency key.
+func (p *PostgresDB) DeleteIdempotencyRecord(scope, key string) error {
+	return p.db.Where(map[string]interface{}{"scope": scope, "key": key}).Delete(&models.IdempotencyRecord{}).Error
+}
+
+// DeleteIdempotencyRecordsBefore deletes the records created before a time.
+func (p *PostgresDB) DeleteIdempotencyRecordsBefore(before time.Time) error {
+	return p.db.Where("created_at < ?", before).Delete(&models.IdempotencyRecord{}).Error
+}
+
+// SaveBlob saves an uploaded blob.
+func (p *PostgresDB) SaveBlob(blob *models.Blob) error {
+	return p.db.Create(blob).Error
+}
+
+// GetBlob retrieves a blob owned by an API key.
+func (p *PostgresDB) GetBlob(apiKey, id string) (*models.Blob, error) {
+	var blob models.Blob
+	if err := p.db.First(&blob, "id = ? AND api_key = ?", id, apiKey).Error; err != nil {
+		return nil, err
+	}
+	return &blob, nil
+}
+
+// DeleteBlobsBefore deletes the blobs uploaded before a time.
+func (p *PostgresDB) DeleteBlobsBefore(before time.Time) error {
+	return p.db.Where("created_at < ?", before).Delete(&models.Blob{}).Error
+}
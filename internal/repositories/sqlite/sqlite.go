@@ -2,9 +2,13 @@ package sqlite
 
 import (
 	"dietsense/internal/models"
+	"dietsense/internal/repositories"
+	"strings"
+	"time"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // SQLiteDB implements the Database interface for SQLite.
@@ -19,7 +23,7 @@ func NewSQLiteDB(dsn string) (*SQLiteDB, error) {
 		return nil, err
 	}
 	// Migrate the schema
-	db.AutoMigrate(&models.UserConfig{}, &models.UsageStats{})
+	db.AutoMigrate(&models.UserConfig{}, &models.UsageStats{}, &models.APIKey{}, &models.UserKey{}, &models.Analysis{}, &models.FoodLogEntry{}, &models.FoodLogNutrient{}, &models.Goal{}, &models.Profile{}, &models.WeightEntry{}, &models.MealTemplate{}, &models.AnalysisJob{}, &models.IdempotencyRecord{}, &models.Blob{})
 	return &SQLiteDB{db: db}, nil
 }
 
@@ -83,3 +87,306 @@ func (s *SQLiteDB) GetAPIKey(key string) (*models.APIKey, error) {
 func (s *SQLiteDB) SaveAPIKey(apiKey *models.APIKey) error {
 	return s.db.Save(apiKey).Error
 }
+
+// GetAnalysis retrieves an analysis revision by ID.
+func (s *SQLiteDB) GetAnalysis(id string) (*models.Analysis, error) {
+	var analysis models.Analysis
+	if err := s.db.First(&analysis, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &analysis, nil
+}
+
+// SaveAnalysis saves an analysis revision.
+func (s *SQLiteDB) SaveAnalysis(analysis *models.Analysis) error {
+	return s.db.Save(analysis).Error
+}
+
+// ClearAnalysisImagesBefore drops the full images of analyses created before
+// the given time, keeping their hashes and thumbnails.
+func (s *SQLiteDB) ClearAnalysisImagesBefore(before time.Time) error {
+	return s.db.Model(&models.Analysis{}).Where("created_at < ? AND image IS NOT NULL", before).Update("image", nil).Error
+}
+
+// GetFoodLogEntry retrieves a food log entry with its nutrients.
+func (s *SQLiteDB) GetFoodLogEntry(apiKey, id string) (*models.FoodLogEntry, error) {
+	var entry models.FoodLogEntry
+	if err := s.db.Preload("Nutrients").First(&entry, "id = ? AND api_key = ?", id, apiKey).Error; err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// SaveFoodLogEntry saves a food log entry and replaces its nutrients.
+func (s *SQLiteDB) SaveFoodLogEntry(entry *models.FoodLogEntry) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("entry_id = ?", entry.ID).Delete(&models.FoodLogNutrient{}).Error; err != nil {
+			return err
+		}
+		for i := range entry.Nutrients {
+			entry.Nutrients[i].ID = 0
+			entry.Nutrients[i].EntryID = entry.ID
+		}
+		return tx.Session(&gorm.Session{FullSaveAssociations: true}).Save(entry).Error
+	})
+}
+
+// ListFoodLogEntries lists food log entries matching the filter, newest first.
+func (s *SQLiteDB) ListFoodLogEntries(apiKey string, filter repositories.FoodLogFilter) ([]models.FoodLogEntry, error) {
+	query := s.db.Preload("Nutrients").Where("api_key = ?", apiKey)
+	if !filter.From.IsZero() {
+		query = query.Where("eaten_at >= ?", filter.From.UTC())
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("eaten_at < ?", filter.To.UTC())
+	}
+	if filter.MealType != "" {
+		query = query.Where("meal_type = ?", filter.MealType)
+	}
+	if filter.InputType != "" {
+		query = query.Where("input_type = ?", filter.InputType)
+	}
+	if filter.Search != "" {
+		// LIKE is case-insensitive for ASCII in SQLite, but has no default escape character
+		query = query.Where("summary LIKE ? ESCAPE '\\'", "%"+repositories.EscapeLike(filter.Search)+"%")
+	}
+	if filter.AfterID != "" {
+		after := filter.AfterEatenAt.UTC()
+		query = query.Where("eaten_at < ? OR (eaten_at = ? AND id < ?)", after, after, filter.AfterID)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var entries []models.FoodLogEntry
+	if err := query.Order("eaten_at DESC, id DESC").Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// DeleteFoodLogEntry deletes a food log entry and its nutrients.
+func (s *SQLiteDB) DeleteFoodLogEntry(apiKey, id string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ? AND api_key = ?", id, apiKey).Delete(&models.FoodLogEntry{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return repositories.ErrNotFound
+		}
+		return tx.Where("entry_id = ?", id).Delete(&models.FoodLogNutrient{}).Error
+	})
+}
+
+// SumFoodLog totals the nutrients and counts the entries logged in each time
+// range, per meal type. The ranges are joined as an inline table so bucketing
+// follows the caller's calendar, including daylight saving shifts.
+func (s *SQLiteDB) SumFoodLog(apiKey string, ranges []repositories.TimeRange) (*repositories.FoodLogTotals, error) {
+	totals := &repositories.FoodLogTotals{}
+	if len(ranges) == 0 {
+		return totals, nil
+	}
+
+	rows := make([]string, 0, len(ranges))
+	args := make([]interface{}, 0, len(ranges)*3+1)
+	for _, r := range ranges {
+		rows = append(rows, "(?, ?, ?)")
+		args = append(args, r.Label, r.Start.UTC(), r.End.UTC())
+	}
+	args = append(args, apiKey)
+	buckets := "WITH buckets(label, start_at, end_at) AS (VALUES " + strings.Join(rows, ", ") + ") "
+	join := "FROM buckets b JOIN food_log_entries e ON e.eaten_at >= b.start_at AND e.eaten_at < b.end_at "
+
+	err := s.db.Raw(buckets+
+		"SELECT b.label AS label, e.meal_type AS meal_type, n.nutrient AS nutrient, MAX(n.name) AS name, n.unit AS unit, "+
+		"SUM(n.value) AS value, MIN(n.confidence) AS confidence "+
+		join+"JOIN food_log_nutrients n ON n.entry_id = e.id "+
+		"WHERE e.api_key = ? GROUP BY b.label, e.meal_type, n.nutrient, n.unit", args...).Scan(&totals.Nutrients).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Raw(buckets+
+		"SELECT b.label AS label, e.meal_type AS meal_type, COUNT(*) AS entries "+
+		join+"WHERE e.api_key = ? GROUP BY b.label, e.meal_type", args...).Scan(&totals.Entries).Error
+	if err != nil {
+		return nil, err
+	}
+	return totals, nil
+}
+
+// GetGoals lists the nutrition goals of an API key.
+func (s *SQLiteDB) GetGoals(apiKey string) ([]models.Goal, error) {
+	var goals []models.Goal
+	if err := s.db.Where("api_key = ?", apiKey).Order("nutrient, type").Find(&goals).Error; err != nil {
+		return nil, err
+	}
+	return goals, nil
+}
+
+// GetGoal retrieves a nutrition goal.
+func (s *SQLiteDB) GetGoal(apiKey, id string) (*models.Goal, error) {
+	var goal models.Goal
+	if err := s.db.First(&goal, "id = ? AND api_key = ?", id, apiKey).Error; err != nil {
+		return nil, err
+	}
+	return &goal, nil
+}
+
+// SaveGoal saves a nutrition goal.
+func (s *SQLiteDB) SaveGoal(goal *models.Goal) error {
+	return s.db.Save(goal).Error
+}
+
+// DeleteGoal deletes a nutrition goal.
+func (s *SQLiteDB) DeleteGoal(apiKey, id string) error {
+	result := s.db.Where("id = ? AND api_key = ?", id, apiKey).Delete(&models.Goal{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// GetProfile retrieves the body profile of an API key.
+func (s *SQLiteDB) GetProfile(apiKey string) (*models.Profile, error) {
+	var profile models.Profile
+	if err := s.db.First(&profile, "api_key = ?", apiKey).Error; err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// SaveProfile saves a body profile.
+func (s *SQLiteDB) SaveProfile(profile *models.Profile) error {
+	return s.db.Save(profile).Error
+}
+
+// ListWeightEntries lists the weight history of an API key, newest first.
+func (s *SQLiteDB) ListWeightEntries(apiKey string, limit int) ([]models.WeightEntry, error) {
+	query := s.db.Where("api_key = ?", apiKey).Order("measured_at DESC, id DESC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	var entries []models.WeightEntry
+	if err := query.Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// SaveWeightEntry saves a weight history entry.
+func (s *SQLiteDB) SaveWeightEntry(entry *models.WeightEntry) error {
+	return s.db.Save(entry).Error
+}
+
+// GetMealTemplates lists the meal templates of an API key by name.
+func (s *SQLiteDB) GetMealTemplates(apiKey string) ([]models.MealTemplate, error) {
+	var templates []models.MealTemplate
+	if err := s.db.Where("api_key = ?", apiKey).Order("name, id").Find(&templates).Error; err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+// GetMealTemplate retrieves a meal template.
+func (s *SQLiteDB) GetMealTemplate(apiKey, id string) (*models.MealTemplate, error) {
+	var template models.MealTemplate
+	if err := s.db.First(&template, "id = ? AND api_key = ?", id, apiKey).Error; err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+// SaveMealTemplate saves a meal template.
+func (s *SQLiteDB) SaveMealTemplate(template *models.MealTemplate) error {
+	return s.db.Save(template).Error
+}
+
+// DeleteMealTemplate deletes a meal template.
+func (s *SQLiteDB) DeleteMealTemplate(apiKey, id string) error {
+	result := s.db.Where("id = ? AND api_key = ?", id, apiKey).Delete(&models.MealTemplate{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return repositories.ErrNotFound
+	}
+	return nil
+}
+
+// GetAnalysisJob retrieves an analysis job owned by an API key.
+func (s *SQLiteDB) GetAnalysisJob(apiKey, id string) (*models.AnalysisJob, error) {
+	var job models.AnalysisJob
+	if err := s.db.First(&job, "id = ? AND api_key = ?", id, apiKey).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// SaveAnalysisJob saves an analysis job.
+func (s *SQLiteDB) SaveAnalysisJob(job *models.AnalysisJob) error {
+	return s.db.Save(job).Error
+}
+
+// ListUnfinishedAnalysisJobs lists the queued and running jobs of all API
+// keys, oldest first.
+func (s *SQLiteDB) ListUnfinishedAnalysisJobs() ([]models.AnalysisJob, error) {
+	var jobs []models.AnalysisJob
+	err := s.db.Where("status IN ?", []string{models.JobQueued, models.JobRunning}).Order("created_at, id").Find(&jobs).Error
+	return jobs, err
+}
+
+// CreateIdempotencyRecord saves a record unless one already exists for its
+// scope and key, and reports whether it was saved.
+func (s *SQLiteDB) CreateIdempotencyRecord(record *models.IdempotencyRecord) (bool, error) {
+	result := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(record)
+	return result.RowsAffected == 1, result.Error
+}
+
+// GetIdempotencyRecord retrieves the record of an idempotency key.
+func (s *SQLiteDB) GetIdempotencyRecord(scope, key string) (*models.IdempotencyRecord, error) {
+	var record models.IdempotencyRecord
+	if err := s.db.Where(map[string]interface{}{"scope": scope, "key": key}).First(&record).Error; err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// CompleteIdempotencyRecord stores the response of an idempotency record.
+func (s *SQLiteDB) CompleteIdempotencyRecord(record *models.IdempotencyRecord) error {
+	return s.db.Model(record).Where(map[string]interface{}{"scope": record.Scope, "key": record.Key}).
+		Select("status_code", "header", "body").Updates(record).Error
+}
+
+// DeleteIdempotencyRecord deletes the record of an idempotency key.
+func (s *SQLiteDB) DeleteIdempotencyRecord(scope, key string) error {
+	return s.db.Where(map[string]interface{}{"scope": scope, "key": key}).Delete(&models.IdempotencyRecord{}).Error
+}
+
+// DeleteIdempotencyRecordsBefore deletes the records created before a time.
+func (s *SQLiteDB) DeleteIdempotencyRecordsBefore(before time.Time) error {
+	return s.db.Where("created_at < ?", before).Delete(&models.IdempotencyRecord{}).Error
+}
+
+// SaveBlob saves an uploaded blob.
+func (s *SQLiteDB) SaveBlob(blob *models.Blob) error {
+	return s.db.Create(blob).Error
+}
+
+// GetBlob retrieves a blob owned by an API key.
+func (s *SQLiteDB) GetBlob(apiKey, id string) (*models.Blob, error) {
+	var blob models.Blob
+	if err := s.db.First(&blob, "id = ? AND api_key = ?", id, apiKey).Error; err != nil {
+		return nil, err
+	}
+	return &blob, nil
+}
+
+// DeleteBlobsBefore deletes the blobs uploaded before a time.
+func (s *SQLiteDB) DeleteBlobsBefore(before time.Time) error {
+	return s.db.Where("created_at < ?", before).Delete(&models.Blob{}).Error
+}
@@ -1,7 +1,10 @@
 package services
 
 import (
+	"dietsense/internal/nutrition"
+	"fmt"
 	"io"
+	"strings"
 )
 
 // InputType represents the type of input for analysis
@@ -15,13 +18,46 @@ const (
 	InputTypeText
 )
 
+// inputTypeNames are the names used for input types in stored records and
+// request parameters.
+var inputTypeNames = map[InputType]string{
+	InputTypeUnknown:        "unknown",
+	InputTypeFoodImage:      "food_image",
+	InputTypeNutritionLabel: "nutrition_label",
+	InputTypeBarcode:        "barcode",
+	InputTypeText:           "text",
+}
+
+// String returns the name of the input type.
+func (t InputType) String() string {
+	if name, ok := inputTypeNames[t]; ok {
+		return name
+	}
+	return inputTypeNames[InputTypeUnknown]
+}
+
+// ParseInputType returns the input type with the given name.
+func ParseInputType(name string) (InputType, bool) {
+	for inputType, inputTypeName := range inputTypeNames {
+		if inputTypeName == name {
+			return inputType, true
+		}
+	}
+	return InputTypeUnknown, false
+}
+
 // AnalysisResult represents the standardized result of an analysis
 type AnalysisResult struct {
-	NutritionInfo map[string]interface{} `json:"nutrition_info"`
-	Summary       string                 `json:"summary"`
-	Confidence    float64                `json:"confidence"`
-	InputType     InputType              `json:"input_type"`
-	Service       string                 `json:"service"`
+	NutritionInfo nutrition.Facts          `json:"nutrition_info"`
+	Summary       string                   `json:"summary"`
+	Confidence    float64                  `json:"confidence"`
+	InputType     InputType                `json:"input_type"`
+	Service       string                   `json:"service"`
+	Model         string                   `json:"model,omitempty"`
+	Ingredients   []string                 `json:"ingredients,omitempty"`
+	Allergens     []nutrition.AllergenFlag `json:"allergens"`
+	Diets         []nutrition.DietFlag     `json:"diets"`
+	RawResponse   string                   `json:"-"`
 }
 
 // ImageClassifier defines the interface for classifying images
@@ -29,9 +65,114 @@ type ImageClassifier interface {
 	ClassifyImage(file io.Reader) (InputType, error)
 }
 
+// PromptContext is the context an analysis is requested with. Instructions
+// are trusted, coming from the configuration and the caller's stored profile
+// and preferences, and are sent as system instructions. Text is what the
+// caller typed and is sent as user content, so it cannot override them.
+type PromptContext struct {
+	Instructions string
+	Text         string
+}
+
 // FoodAnalysisService defines the interface for an image analysis service.
 type FoodAnalysisService interface {
 	ImageClassifier
-	AnalyzeFood(file io.Reader, context string, inputType InputType) (*AnalysisResult, error)
-	AnalyzeFoodText(context string) (*AnalysisResult, error)
+	AnalyzeFood(file io.Reader, context PromptContext, inputType InputType) (*AnalysisResult, error)
+	AnalyzeFoodText(context PromptContext) (*AnalysisResult, error)
+}
+
+// LabeledImage is one of several images of the same food item, with its
+// input type and how that was decided.
+type LabeledImage struct {
+	Data         []byte
+	InputType    InputType
+	ClassifiedBy string
+}
+
+// How the input type of an image was decided, as reported in responses.
+const (
+	ClassifiedByHint       = "hint"       // Given by the client as input_type
+	ClassifiedByClassifier = "classifier" // Asked of the image classifier service
+	ClassifiedByAnalyzer   = "analyzer"   // Left unknown, for the analyzer to work out
+	ClassifiedByLocal      = "local"      // Decided by the local classifier's heuristics
+)
+
+// MultiImageAnalyzer is implemented by services that can analyze several
+// images of one food item, such as the front and back of a package, in a
+// single request.
+type MultiImageAnalyzer interface {
+	AnalyzeFoodImages(images []LabeledImage, context PromptContext) (*AnalysisResult, error)
+}
+
+// imagePrecedence ranks input types by how far their data is trusted: what
+// is printed on a label over estimates from a photo.
+var imagePrecedence = map[InputType]int{
+	InputTypeNutritionLabel: 3,
+	InputTypeBarcode:        2,
+	InputTypeFoodImage:      1,
+}
+
+// PrimaryImage returns the index of the image whose data takes precedence,
+// the first one among equals.
+func PrimaryImage(images []LabeledImage) int {
+	primary := 0
+	for i, image := range images {
+		if imagePrecedence[image.InputType] > imagePrecedence[images[primary].InputType] {
+			primary = i
+		}
+	}
+	return primary
+}
+
+// imageLabel introduces an image of a multi-image analysis to the model.
+func imageLabel(index int, inputType InputType) string {
+	return fmt.Sprintf("Image %d: %s", index+1, strings.ReplaceAll(inputType.String(), "_", " "))
+}
+
+// RefinementTurn is one round of a refinement conversation: the answer the
+// model gave and the correction the user sent in reply to it.
+type RefinementTurn struct {
+	Answer     string
+	Correction string
+}
+
+// AnalysisRefiner continues an earlier analysis with the user's corrections.
+// The file is nil when the original analysis was text-only.
+type AnalysisRefiner interface {
+	RefineFood(file io.Reader, context PromptContext, inputType InputType, turns []RefinementTurn) (*AnalysisResult, error)
+}
+
+// imagePromptName returns the prompt used to analyze an image of the given type.
+func imagePromptName(inputType InputType) string {
+	switch inputType {
+	case InputTypeFoodImage:
+		return "food_image_prompt"
+	case InputTypeNutritionLabel:
+		return "nutrition_label_prompt"
+	case InputTypeBarcode:
+		return "barcode_prompt"
+	default:
+		return "default_image_prompt"
+	}
+}
+
+// userText returns the user content of a text analysis. Providers reject
+// empty messages, so a missing description is stated explicitly.
+func userText(text string) string {
+	if strings.TrimSpace(text) == "" {
+		return "No description was provided."
+	}
+	return text
+}
+
+// InsightNarrator is implemented by services that can turn rule-based
+// insights into a short narrative for the user.
+type InsightNarrator interface {
+	NarrateInsights(insights []Insight) (string, error)
+}
+
+// IngredientEstimator is implemented by services that can estimate the weight
+// and nutrients of recipe ingredients, one estimate per line in order.
+type IngredientEstimator interface {
+	EstimateIngredients(lines []string) ([]IngredientEstimate, error)
 }
@@ -2,12 +2,15 @@ package services
 
 import (
 	"context"
+	"dietsense/pkg/apperrors"
 	"dietsense/pkg/config"
 	"dietsense/pkg/logging"
-	"dietsense/pkg/utils"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"strings"
 
 	"github.com/liushuangls/go-anthropic/v2"
 )
@@ -55,11 +58,14 @@ func (s *ClaudeService) ClassifyImage(file io.Reader) (InputType, error) {
 		MaxTokens: 100,
 	})
 	if err != nil {
-		return InputTypeUnknown, fmt.Errorf("classification error: %w", err)
+		return InputTypeUnknown, fmt.Errorf("classification error: %w", claudeError(err))
 	}
 
-	content := resp.Content[0].Text
-	switch *content {
+	content, err := claudeText(&resp)
+	if err != nil {
+		return InputTypeUnknown, err
+	}
+	switch content {
 	case "food photo":
 		return InputTypeFoodImage, nil
 	case "nutrition label":
@@ -71,7 +77,7 @@ func (s *ClaudeService) ClassifyImage(file io.Reader) (InputType, error) {
 	}
 }
 
-func (s *ClaudeService) AnalyzeFood(file io.Reader, userContext string, inputType InputType) (*AnalysisResult, error) {
+func (s *ClaudeService) AnalyzeFood(file io.Reader, userContext PromptContext, inputType InputType) (*AnalysisResult, error) {
 	client := anthropic.NewClient(s.APIKey)
 	logging.Log.Info("Claude Service: Analyzing food, model: " + s.ModelType)
 
@@ -80,108 +86,277 @@ func (s *ClaudeService) AnalyzeFood(file io.Reader, userContext string, inputTyp
 		return nil, fmt.Errorf("failed to read image file: %w", err)
 	}
 
-	var promptString string
-	switch inputType {
-	case InputTypeFoodImage:
-		promptString = "food_image_prompt"
-	case InputTypeNutritionLabel:
-		promptString = "nutrition_label_prompt"
-	case InputTypeBarcode:
-		promptString = "barcode_prompt"
-	default:
-		promptString = "default_image_prompt"
-	}
-	prompt := s.Config.GetPrompt("claude", promptString)
-	jsonFormatInstruction := s.Config.GetPrompt("claude", "json_format_instruction")
-	fullContext := fmt.Sprintf("%s\n%s\n%s", prompt, userContext, jsonFormatInstruction)
-
 	resp, err := client.CreateMessages(context.Background(), anthropic.MessagesRequest{
-		Model: s.ModelType,
+		Model:  s.ModelType,
+		System: s.systemPrompt(imagePromptName(inputType), userContext.Instructions),
 		Messages: []anthropic.Message{
-			{
-				Role: anthropic.RoleUser,
-				Content: []anthropic.MessageContent{
-					anthropic.NewImageMessageContent(anthropic.MessageContentImageSource{
-						Type:      "base64",
-						MediaType: "image/jpeg",
-						Data:      imageData,
-					}),
-					anthropic.NewTextMessageContent(fullContext),
-				},
-			},
+			s.createImageMessage(imageData, userContext.Text),
 		},
 		MaxTokens: 1000,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("analysis error: %w", err)
+		return nil, fmt.Errorf("analysis error: %w", claudeError(err))
 	}
 
 	return s.parseClaudeResponse(&resp, inputType)
 }
 
-func (s *ClaudeService) AnalyzeFoodText(userContext string) (*AnalysisResult, error) {
+func (s *ClaudeService) AnalyzeFoodText(userContext PromptContext) (*AnalysisResult, error) {
 	client := anthropic.NewClient(s.APIKey)
 	logging.Log.Info("Claude Service: Analyzing food description, model: " + s.ModelType)
 
-	fullContext := fmt.Sprintf("%s\n%s", userContext, "Analyze this food description and provide nutritional information in JSON format with 'summary' and 'nutrition' fields.")
-
 	resp, err := client.CreateMessages(context.Background(), anthropic.MessagesRequest{
-		Model: s.ModelType,
+		Model:  s.ModelType,
+		System: s.systemPrompt("text_analysis_prompt", userContext.Instructions),
 		Messages: []anthropic.Message{
-			{
-				Role: anthropic.RoleUser,
-				Content: []anthropic.MessageContent{
-					anthropic.NewTextMessageContent(fullContext),
-				},
-			},
+			anthropic.NewUserTextMessage(userText(userContext.Text)),
 		},
 		MaxTokens: 1000,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("text analysis error: %w", err)
+		return nil, fmt.Errorf("text analysis error: %w", claudeError(err))
 	}
 
 	return s.parseClaudeResponse(&resp, InputTypeText)
 }
 
-func (s *ClaudeService) parseClaudeResponse(resp *anthropic.MessagesResponse, inputType InputType) (*AnalysisResult, error) {
-	content := resp.Content[0].Text
-	logging.Log.Infof("Claude Response: %s", *content)
-	normalizedContent := utils.NormalizeJSON(*content)
+// AnalyzeFoodImages sends several images of one food item in one user
+// message, each after a line with its label.
+func (s *ClaudeService) AnalyzeFoodImages(images []LabeledImage, userContext PromptContext) (*AnalysisResult, error) {
+	client := anthropic.NewClient(s.APIKey)
+	logging.Log.Info("Claude Service: Analyzing food images, model: " + s.ModelType)
 
-	if normalizedContent == "" {
-		return nil, fmt.Errorf("failed to extract valid JSON content")
+	resp, err := client.CreateMessages(context.Background(), anthropic.MessagesRequest{
+		Model:     s.ModelType,
+		System:    s.systemPrompt("multi_image_prompt", userContext.Instructions),
+		Messages:  []anthropic.Message{s.createMultiImageMessage(images, userContext.Text)},
+		MaxTokens: 1000,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("multi-image analysis error: %w", claudeError(err))
 	}
 
-	var data map[string]interface{}
-	if err := json.Unmarshal([]byte(normalizedContent), &data); err != nil {
-		return nil, fmt.Errorf("failed to parse embedded JSON: %w", err)
-	}
+	return s.parseClaudeResponse(&resp, images[PrimaryImage(images)].InputType)
+}
 
-	result := &AnalysisResult{
-		NutritionInfo: make(map[string]interface{}),
-		Service:       "claude",
-		InputType:     inputType,
-	}
+// StreamFood implements the StreamingAnalyzer interface with a streamed
+// message, passing on the text of each content delta.
+func (s *ClaudeService) StreamFood(ctx context.Context, images []LabeledImage, userContext PromptContext, onText func(string)) (*AnalysisResult, error) {
+	client := anthropic.NewClient(s.APIKey)
+	logging.Log.Info("Claude Service: Streaming food analysis, model: " + s.ModelType)
 
-	if summary, ok := data["summary"].(string); ok {
-		result.Summary = summary
+	inputType := InputTypeText
+	request := anthropic.MessagesRequest{Model: s.ModelType, MaxTokens: 1000}
+	switch len(images) {
+	case 0:
+		request.System = s.systemPrompt("text_analysis_prompt", userContext.Instructions)
+		request.Messages = []anthropic.Message{anthropic.NewUserTextMessage(userText(userContext.Text))}
+	case 1:
+		inputType = images[0].InputType
+		request.System = s.systemPrompt(imagePromptName(inputType), userContext.Instructions)
+		request.Messages = []anthropic.Message{s.createImageMessage(images[0].Data, userContext.Text)}
+	default:
+		inputType = images[PrimaryImage(images)].InputType
+		request.System = s.systemPrompt("multi_image_prompt", userContext.Instructions)
+		request.Messages = []anthropic.Message{s.createMultiImageMessage(images, userContext.Text)}
 	}
 
-	if nutrition, ok := data["nutrition"].([]interface{}); ok {
-		for _, item := range nutrition {
-			if detail, ok := item.(map[string]interface{}); ok {
-				component := detail["component"].(string)
-				result.NutritionInfo[component] = map[string]interface{}{
-					"value":      detail["value"],
-					"unit":       detail["unit"],
-					"confidence": detail["confidence"],
-				}
+	resp, err := client.CreateMessagesStream(ctx, anthropic.MessagesStreamRequest{
+		MessagesRequest: request,
+		OnContentBlockDelta: func(data anthropic.MessagesEventContentBlockDeltaData) {
+			if text := data.Delta.GetText(); text != "" {
+				onText(text)
 			}
+		},
+	})
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("streaming analysis error: %w", claudeError(err))
+	}
+
+	return s.parseClaudeResponse(&resp, inputType)
+}
+
+// RefineFood replays the original request and the previous answers as a
+// conversation, with each correction sent as a follow-up user turn.
+func (s *ClaudeService) RefineFood(file io.Reader, userContext PromptContext, inputType InputType, turns []RefinementTurn) (*AnalysisResult, error) {
+	client := anthropic.NewClient(s.APIKey)
+	logging.Log.Info("Claude Service: Refining analysis, model: " + s.ModelType)
+
+	var messages []anthropic.Message
+	promptName := "text_analysis_prompt"
+	if file == nil {
+		inputType = InputTypeText
+		messages = append(messages, anthropic.NewUserTextMessage(userText(userContext.Text)))
+	} else {
+		imageData, err := io.ReadAll(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image file: %w", err)
 		}
+		promptName = imagePromptName(inputType)
+		messages = append(messages, s.createImageMessage(imageData, userContext.Text))
+	}
+
+	refinePrompt := s.Config.GetPrompt("claude", "refine_prompt")
+	jsonFormatInstruction := s.Config.GetPrompt("claude", "json_format_instruction")
+	for _, turn := range turns {
+		messages = append(messages,
+			anthropic.NewAssistantTextMessage(turn.Answer),
+			anthropic.NewUserTextMessage(fmt.Sprintf("%s\n%s\n%s", refinePrompt, turn.Correction, jsonFormatInstruction)),
+		)
+	}
+
+	resp, err := client.CreateMessages(context.Background(), anthropic.MessagesRequest{
+		Model:     s.ModelType,
+		System:    s.systemPrompt(promptName, userContext.Instructions),
+		Messages:  messages,
+		MaxTokens: 1000,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("refinement error: %w", claudeError(err))
+	}
+
+	return s.parseClaudeResponse(&resp, inputType)
+}
+
+// NarrateInsights asks the model to explain rule-based insights in a few
+// sentences. The insights are sent as JSON after the instructions.
+func (s *ClaudeService) NarrateInsights(insights []Insight) (string, error) {
+	client := anthropic.NewClient(s.APIKey)
+	logging.Log.Info("Claude Service: Narrating insights, model: " + s.ModelType)
+
+	data, err := json.Marshal(insights)
+	if err != nil {
+		return "", err
+	}
+	prompt := fmt.Sprintf("%s\n%s", s.Config.GetPrompt("claude", "insights_prompt"), data)
+	resp, err := client.CreateMessages(context.Background(), anthropic.MessagesRequest{
+		Model:     s.ModelType,
+		Messages:  []anthropic.Message{anthropic.NewUserTextMessage(prompt)},
+		MaxTokens: 500,
+	})
+	if err != nil {
+		return "", fmt.Errorf("insight narration error: %w", claudeError(err))
+	}
+	content, err := claudeText(&resp)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(content), nil
+}
+
+// EstimateIngredients asks the model to weigh recipe ingredients and estimate
+// their nutrients. The lines are sent as JSON in the user message.
+func (s *ClaudeService) EstimateIngredients(lines []string) ([]IngredientEstimate, error) {
+	client := anthropic.NewClient(s.APIKey)
+	logging.Log.Info("Claude Service: Estimating recipe ingredients, model: " + s.ModelType)
+
+	data, err := json.Marshal(lines)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.CreateMessages(context.Background(), anthropic.MessagesRequest{
+		Model:     s.ModelType,
+		System:    s.Config.GetPrompt("claude", "recipe_ingredients_prompt") + "\n" + s.Config.GetPrompt("claude", "user_text_guard"),
+		Messages:  []anthropic.Message{anthropic.NewUserTextMessage(string(data))},
+		MaxTokens: 4096,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ingredient estimation error: %w", claudeError(err))
+	}
+	content, err := claudeText(&resp)
+	if err != nil {
+		return nil, err
+	}
+	return parseIngredientEstimates(content)
+}
+
+// systemPrompt joins the task prompt, the response format, the trusted
+// instructions and a reminder that user content is only data.
+func (s *ClaudeService) systemPrompt(promptName, instructions string) string {
+	parts := []string{s.Config.GetPrompt("claude", promptName), s.Config.GetPrompt("claude", "json_format_instruction")}
+	if instructions != "" {
+		parts = append(parts, instructions)
+	}
+	parts = append(parts, s.Config.GetPrompt("claude", "user_text_guard"))
+	return strings.Join(parts, "\n")
+}
+
+// createImageMessage builds a user message with an image and, when given,
+// the user's text about it.
+func (s *ClaudeService) createImageMessage(imageData []byte, text string) anthropic.Message {
+	content := []anthropic.MessageContent{
+		anthropic.NewImageMessageContent(anthropic.MessageContentImageSource{
+			Type:      "base64",
+			MediaType: "image/jpeg",
+			Data:      imageData,
+		}),
+	}
+	if text != "" {
+		content = append(content, anthropic.NewTextMessageContent(text))
+	}
+	return anthropic.Message{
+		Role:    anthropic.RoleUser,
+		Content: content,
+	}
+}
+
+// createMultiImageMessage builds a user message with several images, each
+// after a line with its label, and the user's text when given.
+func (s *ClaudeService) createMultiImageMessage(images []LabeledImage, text string) anthropic.Message {
+	var content []anthropic.MessageContent
+	for i, image := range images {
+		content = append(content,
+			anthropic.NewTextMessageContent(imageLabel(i, image.InputType)),
+			anthropic.NewImageMessageContent(anthropic.MessageContentImageSource{
+				Type:      "base64",
+				MediaType: "image/jpeg",
+				Data:      image.Data,
+			}),
+		)
+	}
+	if text != "" {
+		content = append(content, anthropic.NewTextMessageContent(text))
 	}
+	return anthropic.Message{Role: anthropic.RoleUser, Content: content}
+}
 
-	result.Confidence = 0.8 // Default confidence
+func (s *ClaudeService) parseClaudeResponse(resp *anthropic.MessagesResponse, inputType InputType) (*AnalysisResult, error) {
+	content, err := claudeText(resp)
+	if err != nil {
+		return nil, err
+	}
+	logging.Log.Infof("Claude Response: %s", content)
+	return parseAnalysisContent(content, "claude", s.ModelType, inputType)
+}
 
-	return result, nil
+// claudeText returns the text of the first content block of a message, or a
+// parse error when the answer does not start with text.
+func claudeText(resp *anthropic.MessagesResponse) (string, error) {
+	if len(resp.Content) == 0 || resp.Content[0].Text == nil {
+		return "", parseError(errors.New("the answer has no text content"))
+	}
+	return *resp.Content[0].Text, nil
+}
+
+// claudeError classifies an error of the Anthropic API: rate limits are passed
+// on as such, and failures of the API itself mean it is unavailable.
+func claudeError(err error) error {
+	var apiErr *anthropic.APIError
+	if errors.As(err, &apiErr) {
+		switch {
+		case apiErr.IsRateLimitErr():
+			return apperrors.New(apperrors.RateLimited, "The analysis provider is rate limiting requests, try again later").WithCause(err)
+		case apiErr.IsApiErr(), apiErr.IsOverloadedErr():
+			return apperrors.New(apperrors.ProviderUnavailable, "The analysis provider failed to answer").WithCause(err)
+		}
+		return err
+	}
+	var requestErr *anthropic.RequestError
+	if errors.As(err, &requestErr) && requestErr.StatusCode == http.StatusTooManyRequests {
+		return apperrors.New(apperrors.RateLimited, "The analysis provider is rate limiting requests, try again later").WithCause(err)
+	}
+	return apperrors.New(apperrors.ProviderUnavailable, "The analysis provider could not be reached").WithCause(err)
 }
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"dietsense/internal/models"
+	"fmt"
+	"math"
+	"slices"
+)
+
+// BMR equations.
+const (
+	FormulaMifflinStJeor  = "mifflin_st_jeor"
+	FormulaHarrisBenedict = "harris_benedict" // Revised by Roza and Shizgal, 1984
+)
+
+// BMRFormulas lists the supported BMR equations; the first is the default.
+var BMRFormulas = []string{FormulaMifflinStJeor, FormulaHarrisBenedict}
+
+// Daily calorie adjustments per objective, and the floor suggestions never
+// go below.
+var (
+	objectiveCalories   = map[string]float64{"lose": -500, "maintain": 0, "gain": 300}
+	minimumCalories     = map[string]float64{"male": 1500, "female": 1200}
+	proteinPerKg        = map[string]float64{"lose": 1.8, "maintain": 1.4, "gain": 1.7}
+	suggestedFatShare   = 30.0 // Percent of calories
+	fiberPer1000Kcal    = 14.0
+	sodiumCeiling       = 2300.0 // mg
+	addedSugarShare     = 10.0   // Percent of calories
+	suggestionTolerance = 10.0
+)
+
+// EnergyEstimate is a profile's daily energy expenditure and calorie target.
+type EnergyEstimate struct {
+	Formula        string  `json:"formula"`
+	BMR            float64 `json:"bmr"`
+	ActivityFactor float64 `json:"activity_factor"`
+	TDEE           float64 `json:"tdee"`
+	TargetCalories float64 `json:"target_calories"` // TDEE adjusted for the objective
+}
+
+// ValidateProfile checks that a profile has what the energy equations need.
+func ValidateProfile(profile *models.Profile) error {
+	switch {
+	case profile.Age < 18 || profile.Age > 120:
+		return fmt.Errorf("age must be between 18 and 120")
+	case !slices.Contains(models.Sexes, profile.Sex):
+		return fmt.Errorf("sex must be one of %v", models.Sexes)
+	case profile.HeightCm < 100 || profile.HeightCm > 250:
+		return fmt.Errorf("height_cm must be between 100 and 250")
+	case profile.WeightKg < 30 || profile.WeightKg > 350:
+		return fmt.Errorf("weight_kg must be between 30 and 350")
+	case models.ActivityLevels[profile.ActivityLevel] == 0:
+		return fmt.Errorf("unknown activity_level %q", profile.ActivityLevel)
+	case !slices.Contains(models.Objectives, profile.Objective):
+		return fmt.Errorf("objective must be one of %v", models.Objectives)
+	case profile.Formula != "" && !slices.Contains(BMRFormulas, profile.Formula):
+		return fmt.Errorf("formula must be one of %v", BMRFormulas)
+	}
+	return nil
+}
+
+// EstimateEnergy computes BMR and TDEE for a valid profile. An empty formula
+// uses the profile's, then the default.
+func EstimateEnergy(profile *models.Profile, formula string) (*EnergyEstimate, error) {
+	if formula == "" {
+		formula = profile.Formula
+	}
+	if formula == "" {
+		formula = BMRFormulas[0]
+	}
+
+	w, h, a := profile.WeightKg, profile.HeightCm, float64(profile.Age)
+	male := profile.Sex == "male"
+	var bmr float64
+	switch formula {
+	case FormulaMifflinStJeor:
+		bmr = 10*w + 6.25*h - 5*a - 161
+		if male {
+			bmr = 10*w + 6.25*h - 5*a + 5
+		}
+	case FormulaHarrisBenedict:
+		bmr = 447.593 + 9.247*w + 3.098*h - 4.330*a
+		if male {
+			bmr = 88.362 + 13.397*w + 4.799*h - 5.677*a
+		}
+	default:
+		return nil, fmt.Errorf("formula must be one of %v", BMRFormulas)
+	}
+
+	factor := models.ActivityLevels[profile.ActivityLevel]
+	tdee := bmr * factor
+	target := math.Max(tdee+objectiveCalories[profile.Objective], minimumCalories[profile.Sex])
+	return &EnergyEstimate{
+		Formula:        formula,
+		BMR:            math.Round(bmr),
+		ActivityFactor: factor,
+		TDEE:           math.Round(tdee),
+		TargetCalories: math.Round(target/10) * 10,
+	}, nil
+}
+
+// SuggestGoals proposes daily goals for a profile: its calorie target, a
+// macro split with protein scaled to body weight and fat at 30% of calories,
+// a fiber floor, and sodium and added sugar ceilings. The goals have no ID or
+// owner yet.
+func SuggestGoals(profile *models.Profile, estimate *EnergyEstimate) ([]models.Goal, error) {
+	calories := estimate.TargetCalories
+	proteinShare := math.Round(proteinPerKg[profile.Objective] * profile.WeightKg * 4 / calories * 100)
+	proteinShare = math.Min(proteinShare, 100-suggestedFatShare-20) // Leave room for carbohydrates
+
+	goals := []models.Goal{
+		{Nutrient: "calories", Type: models.GoalTarget, Value: calories, Tolerance: suggestionTolerance},
+		{Nutrient: "protein", Type: models.GoalEnergyShare, Value: proteinShare},
+		{Nutrient: "total_fat", Type: models.GoalEnergyShare, Value: suggestedFatShare},
+		{Nutrient: "carbohydrates", Type: models.GoalEnergyShare, Value: 100 - proteinShare - suggestedFatShare},
+		{Nutrient: "fiber", Type: models.GoalMinimum, Value: math.Round(calories / 1000 * fiberPer1000Kcal)},
+		{Nutrient: "sodium", Type: models.GoalMaximum, Value: sodiumCeiling},
+		{Nutrient: "added_sugars", Type: models.GoalMaximum, Value: math.Round(calories * addedSugarShare / 100 / 4)},
+	}
+	for i := range goals {
+		// Fills in units and tolerances
+		if err := NormalizeGoal(&goals[i]); err != nil {
+			return nil, fmt.Errorf("suggested %s goal: %w", goals[i].Nutrient, err)
+		}
+	}
+	return goals, nil
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"dietsense/internal/models"
+	"dietsense/internal/nutrition"
+	"fmt"
+	"math"
+)
+
+// Goal progress statuses.
+const (
+	GoalUnder    = "under"
+	GoalMet      = "met"
+	GoalExceeded = "exceeded"
+	GoalUnknown  = "unknown" // Not enough data, e.g. an energy share without calories
+)
+
+// Default tolerances: percent of the value for targets, percentage points
+// for energy shares.
+const (
+	DefaultTargetTolerance      = 10
+	DefaultEnergyShareTolerance = 5
+)
+
+// GoalProgress reports how a day's intake compares with one goal.
+type GoalProgress struct {
+	Goal      models.Goal `json:"goal"`
+	Actual    float64     `json:"actual"`
+	Remaining float64     `json:"remaining"` // Goal value minus actual; negative once intake is above it
+	Percent   float64     `json:"percent"`   // Actual as a percent of the goal value
+	Status    string      `json:"status"`
+}
+
+// NormalizeGoal validates a goal and converts it to its nutrient's canonical
+// ID and unit, filling in the default tolerance.
+func NormalizeGoal(goal *models.Goal) error {
+	nutrient, ok := nutrition.Lookup(goal.Nutrient)
+	if !ok {
+		return fmt.Errorf("unknown nutrient %q", goal.Nutrient)
+	}
+	goal.Nutrient = nutrient.ID
+	if goal.Value <= 0 {
+		return fmt.Errorf("value must be positive")
+	}
+	if goal.Tolerance < 0 {
+		return fmt.Errorf("tolerance must not be negative")
+	}
+
+	switch goal.Type {
+	case models.GoalEnergyShare:
+		if _, ok := nutrition.EnergyPerGram[nutrient.ID]; !ok {
+			return fmt.Errorf("energy shares are only supported for protein, carbohydrates and total_fat")
+		}
+		if goal.Value > 100 {
+			return fmt.Errorf("an energy share cannot exceed 100 percent")
+		}
+		goal.Unit = nutrition.UnitPercent
+		if goal.Tolerance == 0 {
+			goal.Tolerance = DefaultEnergyShareTolerance
+		}
+	case models.GoalTarget, models.GoalMaximum, models.GoalMinimum:
+		if goal.Unit == "" {
+			goal.Unit = nutrient.Unit
+		}
+		value, err := nutrition.Convert(goal.Value, goal.Unit, nutrient.Unit, nutrient)
+		if err != nil {
+			return err
+		}
+		goal.Value, goal.Unit = math.Round(value*1000)/1000, nutrient.Unit
+		if goal.Type == models.GoalTarget && goal.Tolerance == 0 {
+			goal.Tolerance = DefaultTargetTolerance
+		}
+	default:
+		return fmt.Errorf("unknown goal type %q, expected one of %v", goal.Type, models.GoalTypes)
+	}
+	return nil
+}
+
+// EvaluateGoals compares a day's nutrient totals with the goals. Nutrients
+// that were not logged count as zero.
+func EvaluateGoals(goals []models.Goal, totals nutrition.Facts) []GoalProgress {
+	progress := make([]GoalProgress, 0, len(goals))
+	for _, goal := range goals {
+		p := GoalProgress{Goal: goal, Status: GoalUnknown}
+		actual := totals[goal.Nutrient].Value
+		known := true
+		if goal.Type == models.GoalEnergyShare {
+			actual, known = totals.EnergyShare(goal.Nutrient)
+		}
+		if known {
+			p.Actual = actual
+			p.Remaining = math.Round((goal.Value-actual)*1000) / 1000
+			p.Percent = math.Round(actual/goal.Value*1000) / 10
+			p.Status = goalStatus(goal, actual)
+		}
+		progress = append(progress, p)
+	}
+	return progress
+}
+
+func goalStatus(goal models.Goal, actual float64) string {
+	switch goal.Type {
+	case models.GoalMaximum:
+		if actual > goal.Value {
+			return GoalExceeded
+		}
+		return GoalMet
+	case models.GoalMinimum:
+		if actual >= goal.Value {
+			return GoalMet
+		}
+		return GoalUnder
+	case models.GoalEnergyShare:
+		return toleranceStatus(actual, goal.Value, goal.Tolerance)
+	default:
+		return toleranceStatus(actual, goal.Value, goal.Value*goal.Tolerance/100)
+	}
+}
+
+// toleranceStatus judges a value against a target give or take tolerance.
+func toleranceStatus(actual, target, tolerance float64) string {
+	switch {
+	case actual > target+tolerance:
+		return GoalExceeded
+	case actual < target-tolerance:
+		return GoalUnder
+	default:
+		return GoalMet
+	}
+}
@@ -0,0 +1,267 @@
+package services
+
+import (
+	"dietsense/internal/models"
+	"dietsense/internal/nutrition"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Insight severities, in the order insights are reported.
+const (
+	SeverityWarning  = "warning"
+	SeverityInfo     = "info"
+	SeverityPositive = "positive"
+)
+
+var severityOrder = map[string]int{SeverityWarning: 0, SeverityInfo: 1, SeverityPositive: 2}
+
+// Insight is a pattern found in a user's recent food log.
+type Insight struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	Nutrient string `json:"nutrient,omitempty"`
+	MealType string `json:"meal_type,omitempty"`
+	Days     int    `json:"days"`   // Days the pattern held
+	OutOf    int    `json:"out_of"` // Days it was checked on
+}
+
+// InsightInput is what the insight rules look at: one summary per calendar
+// day, oldest first and including days without entries, and the user's goals.
+type InsightInput struct {
+	Days  []*NutritionSummary
+	Goals []models.Goal
+}
+
+// InsightRule finds one kind of pattern. Rules are pure functions of their
+// input so insights are reproducible.
+type InsightRule struct {
+	Name  string
+	Check func(input InsightInput) []Insight
+}
+
+// InsightRules lists the rules GenerateInsights applies, in order.
+var InsightRules = []InsightRule{
+	{Name: "goal_missed", Check: checkGoalsMissed},
+	{Name: "low_meal_protein", Check: checkLowMealProtein},
+	{Name: "logging_gaps", Check: checkLoggingGaps},
+	{Name: "goal_kept", Check: checkGoalsKept},
+}
+
+// Thresholds of the insight rules.
+const (
+	minPatternDays    = 2    // A pattern needs at least this many days
+	missedGoalShare   = 0.5  // ... on at least this share of the logged days
+	consistentShare   = 0.75 // "Consistently" means on this share of the days checked
+	minConsistentDays = 3
+	lowMealProtein    = 15.0 // Grams of protein in a main meal
+)
+
+// defaultInsightGoals apply when the user has no goal of their own for the
+// nutrient, so common excesses are flagged for everyone. Values follow the
+// FDA Daily Values.
+var defaultInsightGoals = []models.Goal{
+	{Nutrient: "sodium", Type: models.GoalMaximum, Value: 2300, Unit: nutrition.UnitMilligram},
+	{Nutrient: "saturated_fat", Type: models.GoalMaximum, Value: 20, Unit: nutrition.UnitGram},
+	{Nutrient: "added_sugars", Type: models.GoalMaximum, Value: 50, Unit: nutrition.UnitGram},
+	{Nutrient: "fiber", Type: models.GoalMinimum, Value: 28, Unit: nutrition.UnitGram},
+}
+
+// GenerateInsights applies every insight rule and orders the findings by
+// severity, keeping rule order within a severity.
+func GenerateInsights(input InsightInput) []Insight {
+	insights := []Insight{}
+	for _, rule := range InsightRules {
+		for _, insight := range rule.Check(input) {
+			insight.Rule = rule.Name
+			insights = append(insights, insight)
+		}
+	}
+	sort.SliceStable(insights, func(i, j int) bool {
+		return severityOrder[insights[i].Severity] < severityOrder[insights[j].Severity]
+	})
+	return insights
+}
+
+// checkGoalsMissed reports goals missed on at least half of the logged days
+// that report the nutrient, e.g. "Sodium above limit on 4 of the last 7 days".
+func checkGoalsMissed(input InsightInput) []Insight {
+	logged := loggedDays(input.Days)
+	var insights []Insight
+	for _, goal := range effectiveGoals(input.Goals) {
+		checked, missed := 0, 0
+		direction := ""
+		for _, day := range logged {
+			status := dayGoalStatus(goal, day)
+			if status != GoalUnknown {
+				checked++
+			}
+			switch status {
+			case GoalExceeded:
+				missed++
+				direction = "above"
+			case GoalUnder:
+				// Falling short of a calorie target or ceiling is not a problem
+				if goal.Type == models.GoalMinimum || goal.Type == models.GoalEnergyShare {
+					missed++
+					direction = "below"
+				}
+			}
+		}
+		if missed < minPatternDays || float64(missed) < missedGoalShare*float64(checked) {
+			continue
+		}
+		message := fmt.Sprintf("%s %s %s on %d of the last %d days", nutrientName(goal.Nutrient), direction, goalNoun(goal), missed, len(input.Days))
+		if goal.Type == models.GoalEnergyShare {
+			message = fmt.Sprintf("%s share of calories off target on %d of the last %d days", nutrientName(goal.Nutrient), missed, len(input.Days))
+		}
+		insights = append(insights, Insight{
+			Severity: SeverityWarning,
+			Message:  message,
+			Nutrient: goal.Nutrient,
+			Days:     missed,
+			OutOf:    checked,
+		})
+	}
+	return insights
+}
+
+// checkLowMealProtein reports main meals that are consistently low in
+// protein, e.g. "Protein consistently low at breakfast". Meals that report no
+// protein are not counted.
+func checkLowMealProtein(input InsightInput) []Insight {
+	var insights []Insight
+	for _, mealType := range []string{"breakfast", "lunch", "dinner"} {
+		eaten, low := 0, 0
+		for _, day := range input.Days {
+			meal, ok := day.Meals[mealType]
+			if !ok || meal.Entries == 0 {
+				continue
+			}
+			protein, reported := meal.Totals["protein"]
+			if !reported {
+				continue
+			}
+			eaten++
+			if protein.Value < lowMealProtein {
+				low++
+			}
+		}
+		if low < minConsistentDays || float64(low) < consistentShare*float64(eaten) {
+			continue
+		}
+		insights = append(insights, Insight{
+			Severity: SeverityWarning,
+			Message:  fmt.Sprintf("Protein consistently low at %s: under %g g on %d of %d days", mealType, lowMealProtein, low, eaten),
+			Nutrient: "protein",
+			MealType: mealType,
+			Days:     low,
+			OutOf:    eaten,
+		})
+	}
+	return insights
+}
+
+// checkLoggingGaps notes days without entries, which make the other insights
+// less reliable.
+func checkLoggingGaps(input InsightInput) []Insight {
+	missing := len(input.Days) - len(loggedDays(input.Days))
+	if missing < minPatternDays {
+		return nil
+	}
+	return []Insight{{
+		Severity: SeverityInfo,
+		Message:  fmt.Sprintf("Nothing logged on %d of the last %d days, so these insights may be incomplete", missing, len(input.Days)),
+		Days:     missing,
+		OutOf:    len(input.Days),
+	}}
+}
+
+// checkGoalsKept acknowledges the user's own goals met consistently on the
+// logged days that report the nutrient.
+func checkGoalsKept(input InsightInput) []Insight {
+	logged := loggedDays(input.Days)
+	var insights []Insight
+	for _, goal := range input.Goals {
+		checked, met := 0, 0
+		for _, day := range logged {
+			status := dayGoalStatus(goal, day)
+			if status != GoalUnknown {
+				checked++
+			}
+			if status == GoalMet {
+				met++
+			}
+		}
+		if met < minConsistentDays || float64(met) < consistentShare*float64(checked) {
+			continue
+		}
+		insights = append(insights, Insight{
+			Severity: SeverityPositive,
+			Message:  fmt.Sprintf("%s %s met on %d of the last %d days", nutrientName(goal.Nutrient), goalNoun(goal), met, len(input.Days)),
+			Nutrient: goal.Nutrient,
+			Days:     met,
+			OutOf:    checked,
+		})
+	}
+	return insights
+}
+
+// effectiveGoals adds the default goals for nutrients the user has set no
+// amount goal for.
+func effectiveGoals(goals []models.Goal) []models.Goal {
+	effective := append([]models.Goal{}, goals...)
+	for _, fallback := range defaultInsightGoals {
+		covered := false
+		for _, goal := range goals {
+			if goal.Nutrient == fallback.Nutrient && goal.Type != models.GoalEnergyShare {
+				covered = true
+			}
+		}
+		if !covered {
+			effective = append(effective, fallback)
+		}
+	}
+	return effective
+}
+
+// dayGoalStatus evaluates a goal against a day's totals. Days that report no
+// amount of the nutrient are GoalUnknown rather than counted as zero.
+func dayGoalStatus(goal models.Goal, day *NutritionSummary) string {
+	if _, reported := day.Totals[goal.Nutrient]; !reported {
+		return GoalUnknown
+	}
+	return EvaluateGoals([]models.Goal{goal}, day.Totals)[0].Status
+}
+
+func loggedDays(days []*NutritionSummary) []*NutritionSummary {
+	var logged []*NutritionSummary
+	for _, day := range days {
+		if day.Entries > 0 {
+			logged = append(logged, day)
+		}
+	}
+	return logged
+}
+
+func nutrientName(id string) string {
+	if nutrient, ok := nutrition.Get(id); ok {
+		return nutrient.Name
+	}
+	return strings.ReplaceAll(id, "_", " ")
+}
+
+func goalNoun(goal models.Goal) string {
+	switch goal.Type {
+	case models.GoalMaximum:
+		return "limit"
+	case models.GoalMinimum:
+		return "minimum"
+	case models.GoalEnergyShare:
+		return "share of calories target"
+	default:
+		return "target"
+	}
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"bytes"
+	"dietsense/pkg/logging"
+	"dietsense/pkg/utils"
+	"image"
+	"io"
+	"math"
+)
+
+// Thresholds of the local classifier's heuristics, tuned on 8-bit channels.
+const (
+	labelMaxColorfulness = 20   // Labels are dark print on a plain background
+	foodMinColorfulness  = 35   // Food photos are clearly colored
+	labelMinEdgeDensity  = 0.06 // Share of sharp horizontal transitions in text
+	labelMinRules        = 3    // Horizontal rules between the rows of a table
+	ruleMinDarkShare     = 0.7  // Share of a row that is dark on a rule
+	localSampleSize      = 400  // Longest side the heuristics sample the image at
+)
+
+// LocalClassifier classifies images without calling a model: a barcode that
+// decodes locally makes a barcode image, dense print with the rules of a table
+// makes a nutrition label, and anything else is taken for a food photo.
+type LocalClassifier struct{}
+
+// NewLocalClassifier creates a new instance of LocalClassifier.
+func NewLocalClassifier() *LocalClassifier {
+	return &LocalClassifier{}
+}
+
+// ClassifyImage implements the ImageClassifier interface. Images it is unsure
+// about are taken for food photos.
+func (c *LocalClassifier) ClassifyImage(file io.Reader) (InputType, error) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return InputTypeUnknown, err
+	}
+	inputType, _ := c.Classify(data)
+	return inputType, nil
+}
+
+// Classify returns the input type of an image and whether the heuristics are
+// sure of it. Images that do not decode, or are too large to decode, are
+// never sure.
+func (c *LocalClassifier) Classify(data []byte) (InputType, bool) {
+	if err := utils.CheckImageSize(data); err != nil {
+		return InputTypeFoodImage, false
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return InputTypeFoodImage, false
+	}
+	if code, ok := utils.DecodeEAN13(img); ok {
+		logging.Log.Debug("Local classifier: decoded barcode ", code)
+		return InputTypeBarcode, true
+	}
+
+	stats := measureImage(img)
+	printed := stats.colorfulness < labelMaxColorfulness && stats.edgeDensity >= labelMinEdgeDensity
+	switch {
+	case printed && stats.rules >= labelMinRules:
+		return InputTypeNutritionLabel, true
+	case printed:
+		// Print without a table may be a label or any other packaging
+		return InputTypeNutritionLabel, false
+	case stats.colorfulness >= foodMinColorfulness && stats.edgeDensity < labelMinEdgeDensity:
+		return InputTypeFoodImage, true
+	default:
+		return InputTypeFoodImage, false
+	}
+}
+
+// imageStats are the measures the local heuristics decide on.
+type imageStats struct {
+	colorfulness float64 // Hasler and Süsstrunk's colorfulness metric
+	edgeDensity  float64 // Share of neighboring pixels that differ sharply in brightness
+	rules        int     // Rows of the image that are dark almost all the way across
+}
+
+// measureImage samples an image on a grid at most localSampleSize across.
+func measureImage(img image.Image) imageStats {
+	bounds := img.Bounds()
+	step := max(1, max(bounds.Dx(), bounds.Dy())/localSampleSize)
+	width, height := bounds.Dx()/step, bounds.Dy()/step
+
+	var stats imageStats
+	var sumRG, sumYB, sumRG2, sumYB2 float64
+	var edges, pairs int
+	onRule := false
+	for y := 0; y < height; y++ {
+		dark := 0
+		prev := -1.0
+		for x := 0; x < width; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x*step, bounds.Min.Y+y*step).RGBA()
+			rf, gf, bf := float64(r>>8), float64(g>>8), float64(b>>8)
+			rg, yb := rf-gf, (rf+gf)/2-bf
+			sumRG, sumYB, sumRG2, sumYB2 = sumRG+rg, sumYB+yb, sumRG2+rg*rg, sumYB2+yb*yb
+
+			luma := 0.299*rf + 0.587*gf + 0.114*bf
+			if luma < 96 {
+				dark++
+			}
+			if prev >= 0 {
+				pairs++
+				if math.Abs(luma-prev) > 64 {
+					edges++
+				}
+			}
+			prev = luma
+		}
+		isRule := width > 0 && float64(dark) >= ruleMinDarkShare*float64(width)
+		if isRule && !onRule {
+			stats.rules++
+		}
+		onRule = isRule
+	}
+
+	if n := float64(width * height); n > 0 {
+		meanRG, meanYB := sumRG/n, sumYB/n
+		varRG, varYB := sumRG2/n-meanRG*meanRG, sumYB2/n-meanYB*meanYB
+		stats.colorfulness = math.Sqrt(max(0, varRG)+max(0, varYB)) + 0.3*math.Hypot(meanRG, meanYB)
+	}
+	if pairs > 0 {
+		stats.edgeDensity = float64(edges) / float64(pairs)
+	}
+	return stats
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"dietsense/internal/models"
+	"dietsense/internal/nutrition"
+	"fmt"
+	"strings"
+)
+
+// ScaledTemplate is a meal template and the portion of it eaten, where 1 is
+// the saved portion.
+type ScaledTemplate struct {
+	Template *models.MealTemplate
+	Scale    float64
+}
+
+// ComposeMeal combines saved items into one meal: nutrients are added up,
+// ingredients and allergens merged. A diet any item rules out is ruled out; a
+// diet is only said to hold when every item has a verdict that it does. Only
+// the computed fields of the result are set.
+func ComposeMeal(parts []ScaledTemplate) *models.MealTemplate {
+	meal := &models.MealTemplate{NutritionInfo: nutrition.Facts{}}
+	names := make([]string, 0, len(parts))
+	seenIngredients := map[string]bool{}
+	allergens := map[string]int{}
+	diets := map[string]int{}
+	dietCounts := map[string]int{}
+
+	for _, part := range parts {
+		template := part.Template
+		meal.NutritionInfo = meal.NutritionInfo.Add(template.NutritionInfo.Scale(part.Scale))
+		meal.Components = append(meal.Components, models.MealComponent{TemplateID: template.ID, Name: template.Name, Scale: part.Scale})
+		if part.Scale == 1 {
+			names = append(names, template.Name)
+		} else {
+			names = append(names, fmt.Sprintf("%s (x%g)", template.Name, part.Scale))
+		}
+
+		for _, ingredient := range template.Ingredients {
+			if key := strings.ToLower(ingredient); !seenIngredients[key] {
+				seenIngredients[key] = true
+				meal.Ingredients = append(meal.Ingredients, ingredient)
+			}
+		}
+
+		// An allergen is present if any item has it, with the surest verdict
+		for _, flag := range template.Allergens {
+			if !flag.Present {
+				continue
+			}
+			i, ok := allergens[flag.ID]
+			if !ok {
+				allergens[flag.ID] = len(meal.Allergens)
+				meal.Allergens = append(meal.Allergens, flag)
+			} else if flag.Confidence > meal.Allergens[i].Confidence {
+				meal.Allergens[i] = flag
+			}
+		}
+
+		// A diet is ruled out if any item rules it out, with the surest such
+		// verdict. It holds only if it holds for every item, with the weakest.
+		for _, flag := range template.Diets {
+			dietCounts[flag.ID]++
+			flag.Evidence = append([]string(nil), flag.Evidence...)
+			i, ok := diets[flag.ID]
+			if !ok {
+				diets[flag.ID] = len(meal.Diets)
+				meal.Diets = append(meal.Diets, flag)
+				continue
+			}
+			existing := &meal.Diets[i]
+			switch {
+			case existing.Compatible && !flag.Compatible:
+				*existing = flag
+				continue
+			case !existing.Compatible && flag.Compatible:
+				continue
+			case flag.Compatible:
+				existing.Confidence = min(existing.Confidence, flag.Confidence)
+			default:
+				existing.Confidence = max(existing.Confidence, flag.Confidence)
+			}
+			existing.Evidence = append(existing.Evidence, flag.Evidence...)
+			if existing.Source != flag.Source {
+				existing.Source = nutrition.SourceCombined
+			}
+		}
+	}
+
+	// A diet an item has no verdict for may not hold for the meal
+	kept := meal.Diets[:0]
+	for _, flag := range meal.Diets {
+		if !flag.Compatible || dietCounts[flag.ID] == len(parts) {
+			kept = append(kept, flag)
+		}
+	}
+	meal.Diets = kept
+	meal.Summary = "Made of " + strings.Join(names, ", ")
+	return meal
+}
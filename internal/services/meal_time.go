@@ -0,0 +1,73 @@
+package services
+
+import (
+	"strings"
+	"time"
+)
+
+// MealWindow is the part of the day, in minutes after local midnight, when a
+// meal is usually eaten.
+type MealWindow struct {
+	MealType string
+	Start    int
+	End      int
+}
+
+// defaultMealWindows apply to locales without their own schedule. Times
+// outside every window are snacks.
+var defaultMealWindows = []MealWindow{
+	{MealType: "breakfast", Start: 5 * 60, End: 10*60 + 30},
+	{MealType: "lunch", Start: 11 * 60, End: 14*60 + 30},
+	{MealType: "dinner", Start: 17*60 + 30, End: 21*60 + 30},
+}
+
+// localeMealWindows holds schedules for locales whose meals are usually eaten
+// at other times, keyed by language tag or base language.
+var localeMealWindows = map[string][]MealWindow{
+	"es": {
+		{MealType: "breakfast", Start: 6 * 60, End: 11 * 60},
+		{MealType: "lunch", Start: 13 * 60, End: 16*60 + 30},
+		{MealType: "dinner", Start: 20 * 60, End: 23*60 + 30},
+	},
+	"pt": {
+		{MealType: "breakfast", Start: 6 * 60, End: 10*60 + 30},
+		{MealType: "lunch", Start: 12 * 60, End: 15 * 60},
+		{MealType: "dinner", Start: 19*60 + 30, End: 22*60 + 30},
+	},
+	"it": {
+		{MealType: "breakfast", Start: 6 * 60, End: 10*60 + 30},
+		{MealType: "lunch", Start: 12 * 60, End: 15 * 60},
+		{MealType: "dinner", Start: 19*60 + 30, End: 22*60 + 30},
+	},
+	"de": {
+		{MealType: "breakfast", Start: 5 * 60, End: 10 * 60},
+		{MealType: "lunch", Start: 11*60 + 30, End: 14 * 60},
+		{MealType: "dinner", Start: 17*60 + 30, End: 20*60 + 30},
+	},
+}
+
+// MealWindows returns the meal schedule for a language tag such as "es-AR",
+// falling back to its base language and then to the default schedule.
+func MealWindows(locale string) []MealWindow {
+	locale = strings.ToLower(locale)
+	if windows, ok := localeMealWindows[locale]; ok {
+		return windows
+	}
+	base, _, _ := strings.Cut(locale, "-")
+	if windows, ok := localeMealWindows[base]; ok {
+		return windows
+	}
+	return defaultMealWindows
+}
+
+// InferMealType guesses the meal from the local time of day of t, using the
+// schedule of the given locale.
+func InferMealType(t time.Time, locale string) string {
+	minute := t.Hour()*60 + t.Minute()
+	for _, window := range MealWindows(locale) {
+		if minute >= window.Start && minute < window.End {
+			return window.MealType
+		}
+	}
+	return "snack"
+}
@@ -1,8 +1,14 @@
 package services
 
 import (
+	"bytes"
+	"context"
+	"dietsense/internal/nutrition"
 	"dietsense/pkg/logging"
+	"encoding/json"
+	"fmt"
 	"io"
+	"strings"
 )
 
 // MockImageAnalysisService is a mock implementation of the ImageAnalysisService
@@ -69,6 +75,9 @@ var mockNutritionData = []map[string]interface{}{
 	},
 }
 
+// mockIngredients is the ingredient list reported by the mock service
+var mockIngredients = []string{"wakame", "sesame seeds", "soy sauce (water, soybeans, wheat, salt)", "rice vinegar", "sugar", "carrot"}
+
 // NewMockImageAnalysisService creates a new instance of MockImageAnalysisService.
 func NewMockImageAnalysisService(modelType string) *MockImageAnalysisService {
 	return &MockImageAnalysisService{
@@ -83,25 +92,136 @@ func (s *MockImageAnalysisService) ClassifyImage(file io.Reader) (InputType, err
 }
 
 // AnalyzeFood implements the FoodAnalysisService interface.
-func (s *MockImageAnalysisService) AnalyzeFood(file io.Reader, context string, inputType InputType) (*AnalysisResult, error) {
+func (s *MockImageAnalysisService) AnalyzeFood(file io.Reader, context PromptContext, inputType InputType) (*AnalysisResult, error) {
 	logging.Log.Info("Mock Service: Analyzing food image, model: " + s.ModelType)
-	return &AnalysisResult{
-		NutritionInfo: mockNutritionData[0], // Just use the first item for simplicity
-		Summary:       "This is a mock summary for testing purposes. It describes a healthy seaweed salad containing wakame, sprouts, sesame seeds, and grated carrots or daikon radish.",
-		Confidence:    0.8,
-		InputType:     inputType,
-		Service:       "mock",
-	}, nil
+	return s.newResult(mockNutritionInfo(), "This is a mock summary for testing purposes. It describes a healthy seaweed salad containing wakame, sprouts, sesame seeds, and grated carrots or daikon radish.", inputType), nil
 }
 
 // AnalyzeFoodText implements the FoodAnalysisService interface.
-func (s *MockImageAnalysisService) AnalyzeFoodText(context string) (*AnalysisResult, error) {
+func (s *MockImageAnalysisService) AnalyzeFoodText(context PromptContext) (*AnalysisResult, error) {
 	logging.Log.Info("Mock Service: Analyzing food description, model: " + s.ModelType)
-	return &AnalysisResult{
-		NutritionInfo: mockNutritionData[0], // Just use the first item for simplicity
-		Summary:       "This is a mock summary for text-only analysis. It describes a hypothetical meal based on the provided context.",
+	return s.newResult(mockNutritionInfo(), "This is a mock summary for text-only analysis. It describes a hypothetical meal based on the provided context.", InputTypeText), nil
+}
+
+// AnalyzeFoodImages implements the MultiImageAnalyzer interface. The result
+// has the input type of the image that takes precedence.
+func (s *MockImageAnalysisService) AnalyzeFoodImages(images []LabeledImage, context PromptContext) (*AnalysisResult, error) {
+	logging.Log.Info("Mock Service: Analyzing food images, model: " + s.ModelType)
+	summary := fmt.Sprintf("This is a mock summary combining %d images of the same food item.", len(images))
+	return s.newResult(mockNutritionInfo(), summary, images[PrimaryImage(images)].InputType), nil
+}
+
+// mockStreamChunk is the size in characters of the pieces the mock streams.
+const mockStreamChunk = 16
+
+// StreamFood implements the StreamingAnalyzer interface. It streams the mock
+// result as a JSON answer in fixed-size pieces, so the events are the same
+// on every run, and stops between pieces once ctx is done.
+func (s *MockImageAnalysisService) StreamFood(ctx context.Context, images []LabeledImage, userContext PromptContext, onText func(string)) (*AnalysisResult, error) {
+	var result *AnalysisResult
+	switch len(images) {
+	case 0:
+		result, _ = s.AnalyzeFoodText(userContext)
+	case 1:
+		result, _ = s.AnalyzeFood(bytes.NewReader(images[0].Data), userContext, images[0].InputType)
+	default:
+		result, _ = s.AnalyzeFoodImages(images, userContext)
+	}
+
+	answer, err := json.Marshal(struct {
+		Summary     string                   `json:"summary"`
+		Nutrition   []map[string]interface{} `json:"nutrition"`
+		Ingredients []string                 `json:"ingredients"`
+	}{result.Summary, mockNutritionData, result.Ingredients})
+	if err != nil {
+		return nil, err
+	}
+	text := []rune(string(answer))
+	for start := 0; start < len(text); start += mockStreamChunk {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		onText(string(text[start:min(start+mockStreamChunk, len(text))]))
+	}
+	return result, nil
+}
+
+// RefineFood implements the AnalysisRefiner interface. Each correction
+// lowers the mock calories by 10% so revisions produce a visible change.
+func (s *MockImageAnalysisService) RefineFood(file io.Reader, context PromptContext, inputType InputType, turns []RefinementTurn) (*AnalysisResult, error) {
+	logging.Log.Info("Mock Service: Refining analysis, model: " + s.ModelType)
+	if file == nil {
+		inputType = InputTypeText
+	}
+	info := mockNutritionInfo()
+	calories := info["calories"]
+	for range turns {
+		calories.Value *= 0.9
+	}
+	info["calories"] = calories
+
+	summary := "This is a mock refined summary."
+	if len(turns) > 0 {
+		summary = fmt.Sprintf("This is a mock refined summary taking into account: %s", turns[len(turns)-1].Correction)
+	}
+	return s.newResult(info, summary, inputType), nil
+}
+
+// NarrateInsights returns a deterministic narrative listing the insights.
+func (s *MockImageAnalysisService) NarrateInsights(insights []Insight) (string, error) {
+	logging.Log.Info("Mock Service: Narrating insights, model: " + s.ModelType)
+	if len(insights) == 0 {
+		return "This is a mock narrative: nothing stands out in your recent food log.", nil
+	}
+	messages := make([]string, 0, len(insights))
+	for _, insight := range insights {
+		messages = append(messages, insight.Message)
+	}
+	return "This is a mock narrative: " + strings.Join(messages, "; ") + ".", nil
+}
+
+// EstimateIngredients implements the IngredientEstimator interface with a
+// fixed estimate of 100 g and 150 kcal per ingredient.
+func (s *MockImageAnalysisService) EstimateIngredients(lines []string) ([]IngredientEstimate, error) {
+	logging.Log.Info("Mock Service: Estimating recipe ingredients, model: " + s.ModelType)
+	estimates := make([]IngredientEstimate, len(lines))
+	for i, line := range lines {
+		estimates[i] = IngredientEstimate{
+			Ingredient: line,
+			Grams:      100,
+			NutritionInfo: nutrition.Normalize([]nutrition.Component{
+				{Name: "Calories", Value: 150, Unit: "kcal", Confidence: 0.5},
+				{Name: "Protein", Value: 5, Unit: "g", Confidence: 0.5},
+				{Name: "Total Fat", Value: 5, Unit: "g", Confidence: 0.5},
+				{Name: "Carbohydrates", Value: 20, Unit: "g", Confidence: 0.5},
+			}),
+		}
+	}
+	return estimates, nil
+}
+
+// newResult builds a mock result, running the same dietary rules as the real
+// services over the mock ingredients.
+func (s *MockImageAnalysisService) newResult(info nutrition.Facts, summary string, inputType InputType) *AnalysisResult {
+	result := &AnalysisResult{
+		NutritionInfo: info,
+		Summary:       summary,
 		Confidence:    0.8,
-		InputType:     InputTypeText,
+		InputType:     inputType,
 		Service:       "mock",
-	}, nil
+		Model:         s.ModelType,
+		Ingredients:   mockIngredients,
+	}
+	applyDietaryRules(result, nil, nil)
+	return result
+}
+
+// mockNutritionInfo returns the mock nutrition data normalized the same way
+// the real services normalize provider answers.
+func mockNutritionInfo() nutrition.Facts {
+	components := make([]nutrition.Component, 0, len(mockNutritionData))
+	for _, item := range mockNutritionData {
+		components = append(components, componentFromMap(item))
+	}
+	return nutrition.Normalize(components)
 }
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"dietsense/internal/nutrition"
+	"math"
+	"sort"
+)
+
+// NutrientChange describes how a single nutrient moved between two revisions
+// of an analysis. Before or After is nil when the nutrient was added or removed.
+type NutrientChange struct {
+	Nutrient string   `json:"nutrient"`
+	Name     string   `json:"name"`
+	Unit     string   `json:"unit,omitempty"`
+	Before   *float64 `json:"before"`
+	After    *float64 `json:"after"`
+	Delta    float64  `json:"delta"`
+}
+
+// DiffNutrition compares the nutrients of two revisions and returns the ones
+// that were added, removed or changed value, sorted by nutrient ID.
+func DiffNutrition(before, after nutrition.Facts) []NutrientChange {
+	ids := make(map[string]struct{}, len(before)+len(after))
+	for id := range before {
+		ids[id] = struct{}{}
+	}
+	for id := range after {
+		ids[id] = struct{}{}
+	}
+
+	changes := []NutrientChange{}
+	for id := range ids {
+		oldAmount, hadOld := before[id]
+		newAmount, hasNew := after[id]
+
+		change := NutrientChange{Nutrient: id, Name: newAmount.Name, Unit: newAmount.Unit}
+		switch {
+		case hadOld && hasNew:
+			if oldAmount.Value == newAmount.Value && oldAmount.Unit == newAmount.Unit {
+				continue
+			}
+			change.Before, change.After = &oldAmount.Value, &newAmount.Value
+			change.Delta = math.Round((newAmount.Value-oldAmount.Value)*1000) / 1000
+		case hadOld:
+			change.Name, change.Unit = oldAmount.Name, oldAmount.Unit
+			change.Before = &oldAmount.Value
+			change.Delta = -oldAmount.Value
+		default:
+			change.After = &newAmount.Value
+			change.Delta = newAmount.Value
+		}
+		changes = append(changes, change)
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Nutrient < changes[j].Nutrient
+	})
+	return changes
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"dietsense/internal/nutrition"
+	"dietsense/internal/repositories"
+	"fmt"
+	"time"
+)
+
+// SummaryPeriod is the calendar unit food log summaries are grouped by.
+type SummaryPeriod string
+
+const (
+	PeriodDay   SummaryPeriod = "day"
+	PeriodWeek  SummaryPeriod = "week" // Weeks start on Monday
+	PeriodMonth SummaryPeriod = "month"
+)
+
+// SummaryPeriods lists the supported summary periods.
+var SummaryPeriods = []SummaryPeriod{PeriodDay, PeriodWeek, PeriodMonth}
+
+// dateLayout labels calendar days.
+const dateLayout = "2006-01-02"
+
+// UnassignedMeal groups food log entries logged without a meal type.
+const UnassignedMeal = "unassigned"
+
+// ParseSummaryPeriod returns the period with the given name.
+func ParseSummaryPeriod(name string) (SummaryPeriod, error) {
+	for _, period := range SummaryPeriods {
+		if string(period) == name {
+			return period, nil
+		}
+	}
+	return "", fmt.Errorf("unknown period %q, expected day, week or month", name)
+}
+
+// Start returns midnight on the first day of the period containing t, in t's
+// location.
+func (p SummaryPeriod) Start(t time.Time) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch p {
+	case PeriodWeek:
+		return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+	case PeriodMonth:
+		return day.AddDate(0, 0, 1-day.Day())
+	default:
+		return day
+	}
+}
+
+// Next returns the start of the period following the one starting at start.
+func (p SummaryPeriod) Next(start time.Time) time.Time {
+	switch p {
+	case PeriodWeek:
+		return start.AddDate(0, 0, 7)
+	case PeriodMonth:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.AddDate(0, 0, 1)
+	}
+}
+
+// CalendarDays splits [from, to) into calendar days in loc, labelled
+// YYYY-MM-DD. Days are computed from local midnights, so they are 23 or 25
+// hours long across daylight saving changes.
+func CalendarDays(from, to time.Time, loc *time.Location) []repositories.TimeRange {
+	var days []repositories.TimeRange
+	start := PeriodDay.Start(from.In(loc))
+	for start.Before(to) {
+		end := start.AddDate(0, 0, 1)
+		days = append(days, repositories.TimeRange{Label: start.Format(dateLayout), Start: start, End: end})
+		start = end
+	}
+	return days
+}
+
+// NutritionSummary aggregates the food log over one period.
+type NutritionSummary struct {
+	Start        string                  `json:"start"`
+	End          string                  `json:"end"` // Last day, inclusive
+	Entries      int                     `json:"entries"`
+	DaysLogged   int                     `json:"days_logged"`
+	Totals       nutrition.Facts         `json:"totals"`
+	DailyAverage nutrition.Facts         `json:"daily_average"` // Over the days with entries
+	Meals        map[string]*MealSummary `json:"meals"`
+}
+
+// MealSummary aggregates the entries of one meal type within a period.
+type MealSummary struct {
+	Entries int             `json:"entries"`
+	Totals  nutrition.Facts `json:"totals"`
+	Average nutrition.Facts `json:"average"` // Per entry
+}
+
+// SummarizeNutrition rolls the per-day totals returned by the database up
+// into periods. The days must be consecutive, as returned by CalendarDays;
+// every period they touch gets a summary, including empty ones.
+func SummarizeNutrition(period SummaryPeriod, days []repositories.TimeRange, totals *repositories.FoodLogTotals) []*NutritionSummary {
+	byDay := make(map[string]*NutritionSummary, len(days))
+	var summaries []*NutritionSummary
+	var current *NutritionSummary
+	for _, day := range days {
+		start := period.Start(day.Start)
+		if current == nil || current.Start != start.Format(dateLayout) {
+			current = newNutritionSummary(start, period.Next(start).AddDate(0, 0, -1))
+			summaries = append(summaries, current)
+		}
+		byDay[day.Label] = current
+	}
+
+	daysLogged := make(map[string]bool)
+	for _, count := range totals.Entries {
+		summary, ok := byDay[count.Label]
+		if !ok {
+			continue
+		}
+		summary.Entries += count.Entries
+		summary.meal(count.MealType).Entries += count.Entries
+		if !daysLogged[count.Label] {
+			daysLogged[count.Label] = true
+			summary.DaysLogged++
+		}
+	}
+	for _, total := range totals.Nutrients {
+		summary, ok := byDay[total.Label]
+		if !ok {
+			continue
+		}
+		facts := nutrition.Facts{total.Nutrient: {Name: total.Name, Value: total.Value, Unit: total.Unit, Confidence: total.Confidence}}
+		summary.Totals.Add(facts)
+		meal := summary.meal(total.MealType)
+		meal.Totals = meal.Totals.Add(facts)
+	}
+
+	for _, summary := range summaries {
+		summary.finish()
+	}
+	return summaries
+}
+
+// CombineSummaries merges period summaries into one covering all of them.
+func CombineSummaries(summaries []*NutritionSummary) *NutritionSummary {
+	if len(summaries) == 0 {
+		return nil
+	}
+	combined := &NutritionSummary{
+		Start:  summaries[0].Start,
+		End:    summaries[len(summaries)-1].End,
+		Totals: nutrition.Facts{},
+		Meals:  map[string]*MealSummary{},
+	}
+	for _, summary := range summaries {
+		combined.Entries += summary.Entries
+		combined.DaysLogged += summary.DaysLogged
+		combined.Totals.Add(summary.Totals)
+		for mealType, meal := range summary.Meals {
+			combinedMeal := combined.meal(mealType)
+			combinedMeal.Entries += meal.Entries
+			combinedMeal.Totals = combinedMeal.Totals.Add(meal.Totals)
+		}
+	}
+	combined.finish()
+	return combined
+}
+
+func newNutritionSummary(first, last time.Time) *NutritionSummary {
+	return &NutritionSummary{
+		Start:  first.Format(dateLayout),
+		End:    last.Format(dateLayout),
+		Totals: nutrition.Facts{},
+		Meals:  map[string]*MealSummary{},
+	}
+}
+
+func (s *NutritionSummary) meal(mealType string) *MealSummary {
+	if mealType == "" {
+		mealType = UnassignedMeal
+	}
+	meal, ok := s.Meals[mealType]
+	if !ok {
+		meal = &MealSummary{Totals: nutrition.Facts{}}
+		s.Meals[mealType] = meal
+	}
+	return meal
+}
+
+// finish computes the averages once all totals are in.
+func (s *NutritionSummary) finish() {
+	s.DailyAverage = nutrition.Facts{}
+	if s.DaysLogged > 0 {
+		s.DailyAverage = s.Totals.Scale(1 / float64(s.DaysLogged))
+	}
+	for _, meal := range s.Meals {
+		meal.Average = nutrition.Facts{}
+		if meal.Entries > 0 {
+			meal.Average = meal.Totals.Scale(1 / float64(meal.Entries))
+		}
+	}
+}
@@ -1,12 +1,16 @@
 package services
 
 import (
+	"bytes"
+	"context"
 	"dietsense/pkg/config"
 	"dietsense/pkg/logging"
 	"dietsense/pkg/utils"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"strings"
 )
 
 type OpenAIService struct {
@@ -28,14 +32,17 @@ func (s *OpenAIService) ClassifyImage(file io.Reader) (InputType, error) {
 	logging.Log.Info("OpenAI Service: Classifying image, model: " + s.ModelType)
 
 	prompt := s.Config.GetPrompt("openai", "classify_image_prompt")
-	payload := s.createPayload(encodedImage, prompt)
+	payload := s.createPayload([]map[string]interface{}{s.createImageMessage(encodedImage, prompt)})
 
 	responseData, err := utils.SendHTTPRequest("https://api.openai.com/v1/chat/completions", s.APIKey, payload)
 	if err != nil {
 		return InputTypeUnknown, fmt.Errorf("failed to classify image: %w", err)
 	}
 
-	content := responseData["choices"].([]interface{})[0].(map[string]interface{})["message"].(map[string]interface{})["content"].(string)
+	content, err := openAIContent(responseData)
+	if err != nil {
+		return InputTypeUnknown, err
+	}
 
 	switch content {
 	case "food photo":
@@ -49,25 +56,11 @@ func (s *OpenAIService) ClassifyImage(file io.Reader) (InputType, error) {
 	}
 }
 
-func (s *OpenAIService) AnalyzeFood(file io.Reader, context string, inputType InputType) (*AnalysisResult, error) {
+func (s *OpenAIService) AnalyzeFood(file io.Reader, context PromptContext, inputType InputType) (*AnalysisResult, error) {
 	encodedImage := utils.EncodeToBase64(file)
 	logging.Log.Info("OpenAI Service: Analyzing food, model: " + s.ModelType)
 
-	var promptName string
-	switch inputType {
-	case InputTypeFoodImage:
-		promptName = "food_image_prompt"
-	case InputTypeNutritionLabel:
-		promptName = "nutrition_label_prompt"
-	case InputTypeBarcode:
-		promptName = "barcode_prompt"
-	default:
-		promptName = "default_image_prompt"
-	}
-	prompt := s.Config.GetPrompt("openai", promptName)
-	jsonFormatInstruction := s.Config.GetPrompt("openai", "json_format_instruction")
-	fullContext := fmt.Sprintf("%s\n%s\n%s", prompt, context, jsonFormatInstruction)
-	payload := s.createPayload(encodedImage, fullContext)
+	payload := s.createPayload(s.analysisMessages(encodedImage, context, inputType))
 	responseData, err := utils.SendHTTPRequest("https://api.openai.com/v1/chat/completions", s.APIKey, payload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to analyze food: %w", err)
@@ -76,10 +69,9 @@ func (s *OpenAIService) AnalyzeFood(file io.Reader, context string, inputType In
 	return s.parseOpenAIResponse(responseData, inputType)
 }
 
-func (s *OpenAIService) AnalyzeFoodText(context string) (*AnalysisResult, error) {
+func (s *OpenAIService) AnalyzeFoodText(context PromptContext) (*AnalysisResult, error) {
 	logging.Log.Info("OpenAI Service: Analyzing food description, model: " + s.ModelType)
-	fullContext := fmt.Sprintf("%s\n%s", context, "Analyze this food description and provide nutritional information in JSON format with 'summary' and 'nutrition' fields.")
-	payload := s.createTextPayload(fullContext)
+	payload := s.createPayload(s.analysisMessages("", context, InputTypeText))
 	responseData, err := utils.SendHTTPRequest("https://api.openai.com/v1/chat/completions", s.APIKey, payload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to analyze food text: %w", err)
@@ -88,80 +80,265 @@ func (s *OpenAIService) AnalyzeFoodText(context string) (*AnalysisResult, error)
 	return s.parseOpenAIResponse(responseData, InputTypeText)
 }
 
-func (s *OpenAIService) parseOpenAIResponse(response map[string]interface{}, inputType InputType) (*AnalysisResult, error) {
-	content := response["choices"].([]interface{})[0].(map[string]interface{})["message"].(map[string]interface{})["content"].(string)
-	normalizedContent := utils.NormalizeJSON(content)
+// AnalyzeFoodImages sends several images of one food item in one user
+// message, each after a line with its label.
+func (s *OpenAIService) AnalyzeFoodImages(images []LabeledImage, context PromptContext) (*AnalysisResult, error) {
+	logging.Log.Info("OpenAI Service: Analyzing food images, model: " + s.ModelType)
 
-	if normalizedContent == "" {
-		return nil, fmt.Errorf("failed to extract valid JSON content")
+	payload := s.createPayload(s.multiImageMessages(images, context))
+	responseData, err := utils.SendHTTPRequest("https://api.openai.com/v1/chat/completions", s.APIKey, payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to analyze food images: %w", err)
 	}
 
-	var data map[string]interface{}
-	if err := json.Unmarshal([]byte(normalizedContent), &data); err != nil {
-		return nil, fmt.Errorf("failed to parse embedded JSON: %w", err)
-	}
+	return s.parseOpenAIResponse(responseData, images[PrimaryImage(images)].InputType)
+}
 
-	result := &AnalysisResult{
-		NutritionInfo: make(map[string]interface{}),
-		Service:       "openAI",
-		InputType:     inputType,
-	}
+// StreamFood implements the StreamingAnalyzer interface with a streamed chat
+// completion, passing on the content of each delta.
+func (s *OpenAIService) StreamFood(ctx context.Context, images []LabeledImage, userContext PromptContext, onText func(string)) (*AnalysisResult, error) {
+	logging.Log.Info("OpenAI Service: Streaming food analysis, model: " + s.ModelType)
 
-	if summary, ok := data["summary"].(string); ok {
-		result.Summary = summary
+	inputType := InputTypeText
+	var messages []map[string]interface{}
+	switch len(images) {
+	case 0:
+		messages = s.analysisMessages("", userContext, inputType)
+	case 1:
+		inputType = images[0].InputType
+		messages = s.analysisMessages(utils.EncodeToBase64(bytes.NewReader(images[0].Data)), userContext, inputType)
+	default:
+		inputType = images[PrimaryImage(images)].InputType
+		messages = s.multiImageMessages(images, userContext)
 	}
 
-	if nutrition, ok := data["nutrition"].([]interface{}); ok {
-		for _, item := range nutrition {
-			if detail, ok := item.(map[string]interface{}); ok {
-				component := detail["component"].(string)
-				result.NutritionInfo[component] = map[string]interface{}{
-					"value":      detail["value"],
-					"unit":       detail["unit"],
-					"confidence": detail["confidence"],
-				}
+	payload := s.createPayload(messages)
+	payload["stream"] = true
+	var answer strings.Builder
+	err := utils.StreamHTTPRequest(ctx, "https://api.openai.com/v1/chat/completions", s.APIKey, payload, func(data []byte) error {
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return parseError(fmt.Errorf("failed to decode stream chunk: %w", err))
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != "" {
+				answer.WriteString(choice.Delta.Content)
+				onText(choice.Delta.Content)
 			}
 		}
+		return nil
+	})
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stream food analysis: %w", err)
+	}
+
+	return parseAnalysisContent(answer.String(), "openAI", s.ModelType, inputType)
+}
+
+// RefineFood replays the original request and the previous answers as a
+// conversation, with each correction sent as a follow-up user turn.
+func (s *OpenAIService) RefineFood(file io.Reader, context PromptContext, inputType InputType, turns []RefinementTurn) (*AnalysisResult, error) {
+	logging.Log.Info("OpenAI Service: Refining analysis, model: " + s.ModelType)
+
+	var encodedImage string
+	if file == nil {
+		inputType = InputTypeText
+	} else {
+		encodedImage = utils.EncodeToBase64(file)
+	}
+	messages := s.analysisMessages(encodedImage, context, inputType)
+
+	refinePrompt := s.Config.GetPrompt("openai", "refine_prompt")
+	jsonFormatInstruction := s.Config.GetPrompt("openai", "json_format_instruction")
+	for _, turn := range turns {
+		messages = append(messages,
+			map[string]interface{}{
+				"role":    "assistant",
+				"content": turn.Answer,
+			},
+			map[string]interface{}{
+				"role":    "user",
+				"content": fmt.Sprintf("%s\n%s\n%s", refinePrompt, turn.Correction, jsonFormatInstruction),
+			},
+		)
 	}
 
-	result.Confidence = 0.8 // Default confidence
+	responseData, err := utils.SendHTTPRequest("https://api.openai.com/v1/chat/completions", s.APIKey, s.createPayload(messages))
+	if err != nil {
+		return nil, fmt.Errorf("failed to refine analysis: %w", err)
+	}
 
-	return result, nil
+	return s.parseOpenAIResponse(responseData, inputType)
 }
 
-func (s *OpenAIService) createPayload(encodedImage, context string) map[string]interface{} {
-	return map[string]interface{}{
-		"model": s.ModelType,
-		"messages": []map[string]interface{}{
-			{
-				"role": "user",
-				"content": []map[string]interface{}{
-					{
-						"type": "text",
-						"text": context,
-					},
-					{
-						"type": "image_url",
-						"image_url": map[string]string{
-							"url": fmt.Sprintf("data:image/jpeg;base64,%s", encodedImage),
-						},
-					},
+// NarrateInsights asks the model to explain rule-based insights in a few
+// sentences. The insights are sent as JSON after the instructions.
+func (s *OpenAIService) NarrateInsights(insights []Insight) (string, error) {
+	logging.Log.Info("OpenAI Service: Narrating insights, model: " + s.ModelType)
+
+	data, err := json.Marshal(insights)
+	if err != nil {
+		return "", err
+	}
+	prompt := fmt.Sprintf("%s\n%s", s.Config.GetPrompt("openai", "insights_prompt"), data)
+	responseData, err := utils.SendHTTPRequest("https://api.openai.com/v1/chat/completions", s.APIKey, s.createTextPayload(prompt))
+	if err != nil {
+		return "", fmt.Errorf("failed to narrate insights: %w", err)
+	}
+
+	content, err := openAIContent(responseData)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(content), nil
+}
+
+// EstimateIngredients asks the model to weigh recipe ingredients and estimate
+// their nutrients. The lines are sent as JSON in the user message.
+func (s *OpenAIService) EstimateIngredients(lines []string) ([]IngredientEstimate, error) {
+	logging.Log.Info("OpenAI Service: Estimating recipe ingredients, model: " + s.ModelType)
+
+	data, err := json.Marshal(lines)
+	if err != nil {
+		return nil, err
+	}
+	system := s.Config.GetPrompt("openai", "recipe_ingredients_prompt") + "\n" + s.Config.GetPrompt("openai", "user_text_guard")
+	payload := s.createPayload([]map[string]interface{}{
+		{"role": "system", "content": system},
+		{"role": "user", "content": string(data)},
+	})
+	responseData, err := utils.SendHTTPRequest("https://api.openai.com/v1/chat/completions", s.APIKey, payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to estimate ingredients: %w", err)
+	}
+
+	content, err := openAIContent(responseData)
+	if err != nil {
+		return nil, err
+	}
+	return parseIngredientEstimates(content)
+}
+
+// analysisMessages builds the system and user messages of an analysis. An
+// empty encodedImage makes it a text analysis.
+func (s *OpenAIService) analysisMessages(encodedImage string, context PromptContext, inputType InputType) []map[string]interface{} {
+	promptName := "text_analysis_prompt"
+	if inputType != InputTypeText {
+		promptName = imagePromptName(inputType)
+	}
+	system := map[string]interface{}{
+		"role":    "system",
+		"content": s.systemPrompt(promptName, context.Instructions),
+	}
+	if encodedImage == "" {
+		return []map[string]interface{}{system, {"role": "user", "content": userText(context.Text)}}
+	}
+	return []map[string]interface{}{system, s.createImageMessage(encodedImage, context.Text)}
+}
+
+// multiImageMessages builds the system and user messages of an analysis of
+// several images, each after a line with its label.
+func (s *OpenAIService) multiImageMessages(images []LabeledImage, context PromptContext) []map[string]interface{} {
+	content := []map[string]interface{}{}
+	if context.Text != "" {
+		content = append(content, map[string]interface{}{"type": "text", "text": context.Text})
+	}
+	for i, image := range images {
+		content = append(content,
+			map[string]interface{}{"type": "text", "text": imageLabel(i, image.InputType)},
+			map[string]interface{}{
+				"type": "image_url",
+				"image_url": map[string]string{
+					"url": fmt.Sprintf("data:image/jpeg;base64,%s", utils.EncodeToBase64(bytes.NewReader(image.Data))),
 				},
 			},
-		},
-		"max_tokens": 4096,
+		)
+	}
+	return []map[string]interface{}{
+		{"role": "system", "content": s.systemPrompt("multi_image_prompt", context.Instructions)},
+		{"role": "user", "content": content},
 	}
 }
 
-func (s *OpenAIService) createTextPayload(context string) map[string]interface{} {
+// systemPrompt joins the task prompt, the response format, the trusted
+// instructions and a reminder that user content is only data.
+func (s *OpenAIService) systemPrompt(promptName, instructions string) string {
+	parts := []string{s.Config.GetPrompt("openai", promptName), s.Config.GetPrompt("openai", "json_format_instruction")}
+	if instructions != "" {
+		parts = append(parts, instructions)
+	}
+	parts = append(parts, s.Config.GetPrompt("openai", "user_text_guard"))
+	return strings.Join(parts, "\n")
+}
+
+func (s *OpenAIService) parseOpenAIResponse(response map[string]interface{}, inputType InputType) (*AnalysisResult, error) {
+	content, err := openAIContent(response)
+	if err != nil {
+		return nil, err
+	}
+	return parseAnalysisContent(content, "openAI", s.ModelType, inputType)
+}
+
+// openAIContent returns the message content of the first choice of a chat
+// completion, or a parse error when the answer does not have one.
+func openAIContent(response map[string]interface{}) (string, error) {
+	choices, _ := response["choices"].([]interface{})
+	if len(choices) == 0 {
+		return "", parseError(errors.New("the answer has no choices"))
+	}
+	choice, _ := choices[0].(map[string]interface{})
+	message, _ := choice["message"].(map[string]interface{})
+	content, ok := message["content"].(string)
+	if !ok {
+		return "", parseError(errors.New("the first choice has no message content"))
+	}
+	return content, nil
+}
+
+func (s *OpenAIService) createPayload(messages []map[string]interface{}) map[string]interface{} {
 	return map[string]interface{}{
-		"model": s.ModelType,
-		"messages": []map[string]interface{}{
-			{
-				"role":    "user",
-				"content": context,
-			},
-		},
+		"model":      s.ModelType,
+		"messages":   messages,
 		"max_tokens": 4096,
 	}
 }
+
+// createImageMessage builds a user message with an image and, when given,
+// the user's text about it.
+func (s *OpenAIService) createImageMessage(encodedImage, text string) map[string]interface{} {
+	content := []map[string]interface{}{}
+	if text != "" {
+		content = append(content, map[string]interface{}{
+			"type": "text",
+			"text": text,
+		})
+	}
+	content = append(content, map[string]interface{}{
+		"type": "image_url",
+		"image_url": map[string]string{
+			"url": fmt.Sprintf("data:image/jpeg;base64,%s", encodedImage),
+		},
+	})
+	return map[string]interface{}{
+		"role":    "user",
+		"content": content,
+	}
+}
+
+func (s *OpenAIService) createTextPayload(context string) map[string]interface{} {
+	return s.createPayload([]map[string]interface{}{
+		{
+			"role":    "user",
+			"content": context,
+		},
+	})
+}
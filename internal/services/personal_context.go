@@ -0,0 +1,105 @@
+package services
+
+import (
+	"dietsense/internal/models"
+	"dietsense/internal/nutrition"
+	"fmt"
+	"strings"
+)
+
+// PersonalContext is what an analysis is told about the caller, taken from
+// their stored profile, goals and preferences.
+type PersonalContext struct {
+	Profile   *models.Profile
+	Progress  []GoalProgress // Today's progress against the caller's goals
+	Allergies []string       // Allergen IDs
+	Units     string
+	Language  string
+}
+
+var (
+	activityDescriptions  = map[string]string{"sedentary": "sedentary", "light": "lightly active", "moderate": "moderately active", "active": "active", "very_active": "very active"}
+	objectiveDescriptions = map[string]string{"lose": "aiming to lose weight", "maintain": "aiming to maintain their weight", "gain": "aiming to gain weight"}
+)
+
+// Instructions renders the personal context as prompt instructions, or an
+// empty string when there is nothing to tell.
+func (p PersonalContext) Instructions() string {
+	var lines []string
+	if p.Profile != nil {
+		lines = append(lines, fmt.Sprintf("- Profile: %d-year-old %s, %g cm, %g kg, %s, %s.",
+			p.Profile.Age, p.Profile.Sex, p.Profile.HeightCm, p.Profile.WeightKg,
+			activityDescriptions[p.Profile.ActivityLevel], objectiveDescriptions[p.Profile.Objective]))
+	}
+	if len(p.Allergies) > 0 {
+		names := make([]string, 0, len(p.Allergies))
+		for _, id := range p.Allergies {
+			if allergen, ok := nutrition.LookupAllergen(id); ok {
+				names = append(names, allergen.Name)
+			}
+		}
+		lines = append(lines, fmt.Sprintf("- Allergies: %s. Say clearly in the summary if the food may contain any of these.", strings.Join(names, ", ")))
+	}
+	if len(p.Progress) > 0 {
+		goals := make([]string, 0, len(p.Progress))
+		for _, progress := range p.Progress {
+			goals = append(goals, describeGoalProgress(progress))
+		}
+		lines = append(lines,
+			fmt.Sprintf("- Daily goals, with what is left today before this food: %s.", strings.Join(goals, "; ")),
+			"- Relate the food to these goals in the summary where relevant, e.g. whether it exceeds a remaining budget.")
+	}
+	if p.Units == "imperial" {
+		lines = append(lines, "- Give portion sizes in the summary in imperial units (ounces, cups); keep nutrient units as in the JSON format.")
+	}
+	if p.Language != "" {
+		lines = append(lines, fmt.Sprintf("- Write the summary in the language with tag %q; keep the JSON keys and nutrient names in English.", p.Language))
+	}
+
+	if len(lines) == 0 {
+		return ""
+	}
+	return "About the user, from their saved settings:\n" + strings.Join(lines, "\n")
+}
+
+// describeGoalProgress renders a goal and its progress, e.g. "Sugars limit
+// 50 g (12 g left)".
+func describeGoalProgress(progress GoalProgress) string {
+	goal := progress.Goal
+	if goal.Type == models.GoalEnergyShare {
+		return fmt.Sprintf("%s %g%% of calories", nutrientName(goal.Nutrient), goal.Value)
+	}
+
+	description := fmt.Sprintf("%s %s %g %s", nutrientName(goal.Nutrient), goalNoun(goal), goal.Value, goal.Unit)
+	switch {
+	case progress.Status == GoalUnknown:
+		return description
+	case progress.Remaining > 0 && goal.Type == models.GoalMinimum:
+		return fmt.Sprintf("%s (%g %s to go)", description, progress.Remaining, goal.Unit)
+	case progress.Remaining > 0:
+		return fmt.Sprintf("%s (%g %s left)", description, progress.Remaining, goal.Unit)
+	case goal.Type == models.GoalMinimum:
+		return fmt.Sprintf("%s (already reached)", description)
+	case progress.Remaining == 0:
+		return fmt.Sprintf("%s (nothing left)", description)
+	default:
+		return fmt.Sprintf("%s (already exceeded by %g %s)", description, -progress.Remaining, goal.Unit)
+	}
+}
+
+// AllergyAlerts returns the allergens found in a result that the caller has
+// declared an allergy to.
+func AllergyAlerts(found []nutrition.AllergenFlag, allergies []string) []nutrition.AllergenFlag {
+	var alerts []nutrition.AllergenFlag
+	for _, flag := range found {
+		if !flag.Present {
+			continue
+		}
+		for _, id := range allergies {
+			if flag.ID == id {
+				alerts = append(alerts, flag)
+			}
+		}
+	}
+	return alerts
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"dietsense/internal/nutrition"
+	"math"
+	"strings"
+)
+
+// Where the nutrients of a recipe ingredient came from.
+const (
+	IngredientSourceTable      = "table"
+	IngredientSourceModel      = "model"
+	IngredientSourceUnresolved = "unresolved"
+)
+
+// IngredientEstimate is a provider's estimate for one ingredient line.
+type IngredientEstimate struct {
+	Ingredient    string // The line as given, which matches the estimate to it
+	Grams         float64
+	NutritionInfo nutrition.Facts
+}
+
+// RecipeIngredient is one ingredient of an analyzed recipe and its
+// contribution to the recipe's nutrients.
+type RecipeIngredient struct {
+	nutrition.IngredientQuantity
+	Grams         float64         `json:"grams,omitempty"`
+	FoodID        string          `json:"food_id,omitempty"` // Entry of the food composition table
+	Source        string          `json:"source"`
+	NutritionInfo nutrition.Facts `json:"nutrition_info,omitempty"`
+	CalorieShare  float64         `json:"calorie_share"` // Percent of the recipe's calories
+}
+
+// RecipeAnalysis is the nutrition of a whole recipe and of one serving.
+type RecipeAnalysis struct {
+	Servings    int                `json:"servings"`
+	Ingredients []RecipeIngredient `json:"ingredients"`
+	Total       nutrition.Facts    `json:"total"`
+	PerServing  nutrition.Facts    `json:"per_serving"`
+	Unresolved  int                `json:"unresolved"` // Ingredients left out of the totals
+	ModelError  error              `json:"-"`          // Why the estimator failed, if it did
+}
+
+// AnalyzeRecipe resolves each ingredient against the food composition table
+// and, for those it cannot weigh or find, asks the estimator when one is
+// given. Ingredients neither can resolve, including lines the estimator
+// leaves out, are reported but left out of the totals.
+func AnalyzeRecipe(quantities []nutrition.IngredientQuantity, servings int, estimator IngredientEstimator) *RecipeAnalysis {
+	analysis := &RecipeAnalysis{Servings: max(servings, 1), Ingredients: make([]RecipeIngredient, len(quantities))}
+
+	var pending []int
+	for i, quantity := range quantities {
+		ingredient := RecipeIngredient{IngredientQuantity: quantity, Source: IngredientSourceUnresolved}
+		if food, ok := nutrition.LookupFood(quantity.Food); ok {
+			if grams, ok := quantity.GramsOf(food); ok {
+				ingredient.Grams = math.Round(grams*10) / 10
+				ingredient.FoodID = food.ID
+				ingredient.Source = IngredientSourceTable
+				ingredient.NutritionInfo = food.FactsFor(grams)
+			}
+		}
+		if ingredient.Source == IngredientSourceUnresolved {
+			pending = append(pending, i)
+		}
+		analysis.Ingredients[i] = ingredient
+	}
+
+	if len(pending) > 0 && estimator != nil {
+		lines := make([]string, len(pending))
+		for j, i := range pending {
+			lines[j] = quantities[i].Text
+		}
+		estimates, err := estimator.EstimateIngredients(lines)
+		if err != nil {
+			analysis.ModelError = err
+		}
+		// Providers may skip or reorder lines, so estimates are matched to
+		// lines by text, in order for repeated lines
+		byLine := make(map[string][]int, len(pending))
+		for _, i := range pending {
+			key := ingredientKey(quantities[i].Text)
+			byLine[key] = append(byLine[key], i)
+		}
+		for _, estimate := range estimates {
+			key := ingredientKey(estimate.Ingredient)
+			matches := byLine[key]
+			if len(matches) == 0 || len(estimate.NutritionInfo) == 0 {
+				continue
+			}
+			byLine[key] = matches[1:]
+			ingredient := &analysis.Ingredients[matches[0]]
+			ingredient.Grams = estimate.Grams
+			ingredient.Source = IngredientSourceModel
+			ingredient.NutritionInfo = estimate.NutritionInfo
+		}
+	}
+
+	analysis.Total = nutrition.Facts{}
+	for _, ingredient := range analysis.Ingredients {
+		if ingredient.Source == IngredientSourceUnresolved {
+			analysis.Unresolved++
+			continue
+		}
+		analysis.Total = analysis.Total.Add(ingredient.NutritionInfo)
+	}
+	if calories := analysis.Total["calories"].Value; calories > 0 {
+		for i := range analysis.Ingredients {
+			share := analysis.Ingredients[i].NutritionInfo["calories"].Value / calories * 100
+			analysis.Ingredients[i].CalorieShare = math.Round(share*10) / 10
+		}
+	}
+	analysis.PerServing = analysis.Total.Scale(1 / float64(analysis.Servings))
+	return analysis
+}
+
+// ingredientKey normalizes an ingredient line for matching estimates to it.
+func ingredientKey(line string) string {
+	return strings.Join(strings.Fields(strings.ToLower(line)), " ")
+}
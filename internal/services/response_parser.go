@@ -0,0 +1,170 @@
+package services
+
+import (
+	"dietsense/internal/nutrition"
+	"dietsense/pkg/apperrors"
+	"dietsense/pkg/utils"
+	"encoding/json"
+	"fmt"
+)
+
+// parseAnalysisContent turns the text answer of a provider into an
+// AnalysisResult, normalizing the reported nutrients to the canonical catalog.
+func parseAnalysisContent(content, service, model string, inputType InputType) (*AnalysisResult, error) {
+	normalizedContent := utils.NormalizeJSON(content)
+
+	if normalizedContent == "" {
+		return nil, parseError(fmt.Errorf("failed to extract valid JSON content"))
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(normalizedContent), &data); err != nil {
+		return nil, parseError(fmt.Errorf("failed to parse embedded JSON: %w", err))
+	}
+
+	result := &AnalysisResult{
+		Service:     service,
+		Model:       model,
+		InputType:   inputType,
+		RawResponse: content,
+	}
+
+	if summary, ok := data["summary"].(string); ok {
+		result.Summary = summary
+	}
+
+	var components []nutrition.Component
+	if items, ok := data["nutrition"].([]interface{}); ok {
+		for _, item := range items {
+			if detail, ok := item.(map[string]interface{}); ok {
+				components = append(components, componentFromMap(detail))
+			}
+		}
+	}
+	result.NutritionInfo = nutrition.Normalize(components)
+
+	if items, ok := data["ingredients"].([]interface{}); ok {
+		for _, item := range items {
+			if ingredient, ok := item.(string); ok && ingredient != "" {
+				result.Ingredients = append(result.Ingredients, ingredient)
+			}
+		}
+	}
+	applyDietaryRules(result, parseAllergenFlags(data["allergens"]), parseDietFlags(data["diets"]))
+
+	result.Confidence = 0.8 // Default confidence
+
+	return result, nil
+}
+
+// applyDietaryRules merges the allergen and diet flags reported by the model
+// with the deterministic ingredient-keyword and nutrient rules. Ingredients
+// transcribed from a label are trusted more than ones estimated from a photo.
+func applyDietaryRules(result *AnalysisResult, modelAllergens []nutrition.AllergenFlag, modelDiets []nutrition.DietFlag) {
+	confidence := nutrition.EstimatedIngredientConfidence
+	if result.InputType == InputTypeNutritionLabel {
+		confidence = nutrition.LabelIngredientConfidence
+	}
+	result.Allergens = nutrition.MergeAllergens(modelAllergens, nutrition.DetectAllergens(result.Ingredients, confidence))
+	result.Diets = nutrition.MergeDiets(modelDiets, nutrition.AssessDiets(result.Ingredients, result.Allergens, result.NutritionInfo, confidence))
+}
+
+// parseAllergenFlags reads the "allergens" array of a provider answer,
+// skipping allergens outside the catalog.
+func parseAllergenFlags(value interface{}) []nutrition.AllergenFlag {
+	items, _ := value.([]interface{})
+	var flags []nutrition.AllergenFlag
+	for _, item := range items {
+		detail, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := detail["allergen"].(string)
+		allergen, ok := nutrition.LookupAllergen(name)
+		if !ok {
+			continue
+		}
+		present, ok := detail["present"].(bool)
+		if !ok {
+			present = true
+		}
+		confidence, _, _ := nutrition.ParseValue(detail["confidence"])
+		flags = append(flags, nutrition.AllergenFlag{ID: allergen.ID, Name: allergen.Name, Present: present, Confidence: confidence, Source: nutrition.SourceModel})
+	}
+	return flags
+}
+
+// parseDietFlags reads the "diets" array of a provider answer, skipping diets
+// outside the catalog.
+func parseDietFlags(value interface{}) []nutrition.DietFlag {
+	items, _ := value.([]interface{})
+	var flags []nutrition.DietFlag
+	for _, item := range items {
+		detail, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := detail["diet"].(string)
+		diet, ok := nutrition.LookupDiet(name)
+		if !ok {
+			continue
+		}
+		compatible, ok := detail["compatible"].(bool)
+		if !ok {
+			continue
+		}
+		confidence, _, _ := nutrition.ParseValue(detail["confidence"])
+		flags = append(flags, nutrition.DietFlag{ID: diet.ID, Name: diet.Name, Compatible: compatible, Confidence: confidence, Source: nutrition.SourceModel})
+	}
+	return flags
+}
+
+// componentFromMap reads a nutrient entry in the json_format_instruction shape.
+func componentFromMap(detail map[string]interface{}) nutrition.Component {
+	component := nutrition.Component{Value: detail["value"]}
+	component.Name, _ = detail["component"].(string)
+	component.Unit, _ = detail["unit"].(string)
+	if confidence, _, ok := nutrition.ParseValue(detail["confidence"]); ok {
+		component.Confidence = confidence
+	}
+	return component
+}
+
+// parseIngredientEstimates reads a provider's answer to the recipe
+// ingredients prompt, normalizing each ingredient's nutrients.
+func parseIngredientEstimates(content string) ([]IngredientEstimate, error) {
+	normalizedContent := utils.NormalizeJSON(content)
+	if normalizedContent == "" {
+		return nil, parseError(fmt.Errorf("failed to extract valid JSON content"))
+	}
+
+	var data struct {
+		Ingredients []struct {
+			Ingredient string                   `json:"ingredient"`
+			Grams      interface{}              `json:"grams"`
+			Nutrition  []map[string]interface{} `json:"nutrition"`
+		} `json:"ingredients"`
+	}
+	if err := json.Unmarshal([]byte(normalizedContent), &data); err != nil {
+		return nil, parseError(fmt.Errorf("failed to parse embedded JSON: %w", err))
+	}
+
+	estimates := make([]IngredientEstimate, 0, len(data.Ingredients))
+	for _, item := range data.Ingredients {
+		estimate := IngredientEstimate{Ingredient: item.Ingredient}
+		estimate.Grams, _, _ = nutrition.ParseValue(item.Grams)
+		components := make([]nutrition.Component, 0, len(item.Nutrition))
+		for _, detail := range item.Nutrition {
+			components = append(components, componentFromMap(detail))
+		}
+		estimate.NutritionInfo = nutrition.Normalize(components)
+		estimates = append(estimates, estimate)
+	}
+	return estimates, nil
+}
+
+// parseError marks an answer of a provider that could not be read as an
+// analysis.
+func parseError(err error) error {
+	return apperrors.New(apperrors.ParseFailed, "The analysis provider's answer could not be read").WithCause(err)
+}
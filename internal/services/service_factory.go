@@ -1,8 +1,10 @@
 package services
 
 import (
+	"dietsense/pkg/apperrors"
 	"dietsense/pkg/config"
 	"fmt"
+	"strings"
 )
 
 type ServiceFactory struct {
@@ -19,8 +21,12 @@ func (f *ServiceFactory) GetImageClassifierService() (ImageClassifier, error) {
 		return NewOpenAIService(f.Config.OpenaiKey, f.Config.OpenAIModelForClassification, f.Config), nil
 	case "claude":
 		return NewClaudeService(f.Config.ClaudeKey, f.Config.ClaudeModelForClassification, f.Config), nil
+	case "local":
+		return NewLocalClassifier(), nil
+	case "mock":
+		return NewMockImageAnalysisService(f.Config.MockServiceType), nil
 	default:
-		return nil, fmt.Errorf("unknown image classifier service: %s", f.Config.ImageClassifierService)
+		return nil, unknownServiceError("image classifier", f.Config.ImageClassifierService)
 	}
 }
 
@@ -47,6 +53,37 @@ func (f *ServiceFactory) GetAnalyzerService(inputType InputType) (FoodAnalysisSe
 	case "mock":
 		return NewMockImageAnalysisService(f.Config.MockServiceType), nil
 	default:
-		return nil, fmt.Errorf("unknown analyzer service: %s", serviceType)
+		return nil, unknownServiceError("analyzer", serviceType)
 	}
 }
+
+// GetServiceByName returns the analyzer for a provider name as reported in
+// AnalysisResult.Service. An empty model falls back to the configured
+// analysis model for that provider.
+func (f *ServiceFactory) GetServiceByName(name, model string) (FoodAnalysisService, error) {
+	switch strings.ToLower(name) {
+	case "openai":
+		if model == "" {
+			model = f.Config.OpenAIModelForAnalysis
+		}
+		return NewOpenAIService(f.Config.OpenaiKey, model, f.Config), nil
+	case "claude":
+		if model == "" {
+			model = f.Config.ClaudeModelForAnalysis
+		}
+		return NewClaudeService(f.Config.ClaudeKey, model, f.Config), nil
+	case "mock":
+		if model == "" {
+			model = f.Config.MockServiceType
+		}
+		return NewMockImageAnalysisService(model), nil
+	default:
+		return nil, unknownServiceError("analyzer", name)
+	}
+}
+
+// unknownServiceError reports a provider name the factory does not know, so
+// no provider is available for the request.
+func unknownServiceError(kind, name string) error {
+	return apperrors.New(apperrors.ProviderUnavailable, "No "+kind+" service is available").WithCause(fmt.Errorf("unknown %s service: %s", kind, name))
+}
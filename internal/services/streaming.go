@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"dietsense/internal/nutrition"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+)
+
+// StreamingAnalyzer is implemented by services that can stream an analysis
+// while the model writes it. onText receives each piece of the answer as it
+// arrives, and the result is parsed from the whole answer at the end. With
+// no images the context text is analyzed; several images are analyzed
+// together as by MultiImageAnalyzer. Streaming stops with ctx's error once
+// ctx is done, such as when the client disconnects.
+type StreamingAnalyzer interface {
+	StreamFood(ctx context.Context, images []LabeledImage, userContext PromptContext, onText func(string)) (*AnalysisResult, error)
+}
+
+// StreamUpdate is what a piece of a streamed answer revealed.
+type StreamUpdate struct {
+	Summary   string          // Text added to the summary
+	Nutrients nutrition.Facts // Nutrition entries completed, by canonical ID
+}
+
+var (
+	summaryKey   = regexp.MustCompile(`"summary"\s*:\s*"`)
+	nutritionKey = regexp.MustCompile(`"nutrition"\s*:\s*\[`)
+)
+
+// StreamDecoder reads the parts of an answer in the json_format_instruction
+// shape that can be shown before it is complete: the summary as it is
+// written and each entry of the nutrition list once it is closed.
+type StreamDecoder struct {
+	answer []byte
+
+	summaryStart int // Offset of the summary string's first character, 0 until found
+	summarySent  int // Length of the decoded summary already returned
+	summaryDone  bool
+
+	nutritionNext int // Offset where scanning of the nutrition list resumes, 0 until found
+	nutritionDone bool
+	entryStart    int // Offset of the open entry's "{"
+	depth         int // Nesting of braces in the nutrition list
+	inString      bool
+	escaped       bool
+}
+
+// Write adds the next piece of the answer and returns what it revealed.
+func (d *StreamDecoder) Write(text string) StreamUpdate {
+	d.answer = append(d.answer, text...)
+	return StreamUpdate{Summary: d.readSummary(), Nutrients: d.readNutrients()}
+}
+
+// readSummary returns the summary text decoded since the last call. A
+// trailing escape sequence or character that is not complete yet is held
+// back until it is.
+func (d *StreamDecoder) readSummary() string {
+	if d.summaryDone {
+		return ""
+	}
+	if d.summaryStart == 0 {
+		loc := summaryKey.FindIndex(d.answer)
+		if loc == nil {
+			return ""
+		}
+		d.summaryStart = loc[1]
+	}
+
+	raw := d.answer[d.summaryStart:]
+	end := 0
+	for end < len(raw) {
+		c := raw[end]
+		if c == '"' {
+			d.summaryDone = true
+			break
+		}
+		if c != '\\' {
+			if !utf8.FullRune(raw[end:]) {
+				break
+			}
+			_, size := utf8.DecodeRune(raw[end:])
+			end += size
+			continue
+		}
+		length := 2
+		if end+1 < len(raw) && raw[end+1] == 'u' {
+			length = 6
+			// A high surrogate needs the low one that follows it
+			if end+6 <= len(raw) && isHighSurrogate(raw[end+2:end+6]) {
+				length = 12
+			}
+		}
+		if end+length > len(raw) {
+			break
+		}
+		end += length
+	}
+
+	var summary string
+	if err := json.Unmarshal(append(append([]byte{'"'}, raw[:end]...), '"'), &summary); err != nil || len(summary) < d.summarySent {
+		return ""
+	}
+	added := summary[d.summarySent:]
+	d.summarySent = len(summary)
+	return added
+}
+
+// readNutrients returns the nutrition entries closed since the last call,
+// normalized like a full answer.
+func (d *StreamDecoder) readNutrients() nutrition.Facts {
+	if d.nutritionDone {
+		return nil
+	}
+	if d.nutritionNext == 0 {
+		loc := nutritionKey.FindIndex(d.answer)
+		if loc == nil {
+			return nil
+		}
+		d.nutritionNext = loc[1]
+	}
+
+	var components []nutrition.Component
+	i := d.nutritionNext
+	for ; i < len(d.answer) && !d.nutritionDone; i++ {
+		c := d.answer[i]
+		switch {
+		case d.escaped:
+			d.escaped = false
+		case d.inString && c == '\\':
+			d.escaped = true
+		case c == '"':
+			d.inString = !d.inString
+		case d.inString:
+		case c == '{':
+			if d.depth == 0 {
+				d.entryStart = i
+			}
+			d.depth++
+		case c == '}':
+			d.depth--
+			if d.depth == 0 {
+				var detail map[string]interface{}
+				if json.Unmarshal(d.answer[d.entryStart:i+1], &detail) == nil {
+					components = append(components, componentFromMap(detail))
+				}
+			}
+		case c == ']' && d.depth == 0:
+			d.nutritionDone = true
+		}
+	}
+	d.nutritionNext = i
+	if len(components) == 0 {
+		return nil
+	}
+	return nutrition.Normalize(components)
+}
+
+// isHighSurrogate reports whether the four hex digits of a \u escape are the
+// first half of a UTF-16 surrogate pair.
+func isHighSurrogate(hex []byte) bool {
+	value, err := strconv.ParseUint(string(hex), 16, 16)
+	return err == nil && value >= 0xD800 && value <= 0xDBFF
+}
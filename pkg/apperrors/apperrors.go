@@ -0,0 +1,128 @@
+// Package apperrors defines the errors the API reports to clients. Each
+// carries a stable code clients can switch on, a message safe to show them,
+// and optionally the underlying cause, which is only shown outside
+// production.
+package apperrors
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Code identifies the kind of an error. Codes are part of the API and do not
+// change once published.
+type Code string
+
+const (
+	InvalidInput         Code = "invalid_input"
+	UnsupportedMediaType Code = "unsupported_media_type"
+	Unauthorized         Code = "unauthorized"
+	Forbidden            Code = "forbidden"
+	NotFound             Code = "not_found"
+	Conflict             Code = "conflict"
+	PayloadTooLarge      Code = "payload_too_large"
+	Unprocessable        Code = "unprocessable"
+	RateLimited          Code = "rate_limited"
+	ProviderUnavailable  Code = "provider_unavailable"
+	ParseFailed          Code = "parse_failed"
+	Unavailable          Code = "unavailable"
+	Internal             Code = "internal"
+)
+
+// Codes lists every code, in the order they are documented.
+var Codes = []Code{
+	InvalidInput, UnsupportedMediaType, Unauthorized, Forbidden, NotFound, Conflict,
+	PayloadTooLarge, Unprocessable, RateLimited, ProviderUnavailable, ParseFailed, Unavailable, Internal,
+}
+
+// statuses maps each code to the HTTP status it is reported with.
+var statuses = map[Code]int{
+	InvalidInput:         http.StatusBadRequest,
+	UnsupportedMediaType: http.StatusUnsupportedMediaType,
+	Unauthorized:         http.StatusUnauthorized,
+	Forbidden:            http.StatusForbidden,
+	NotFound:             http.StatusNotFound,
+	Conflict:             http.StatusConflict,
+	PayloadTooLarge:      http.StatusRequestEntityTooLarge,
+	Unprocessable:        http.StatusUnprocessableEntity,
+	RateLimited:          http.StatusTooManyRequests,
+	ProviderUnavailable:  http.StatusBadGateway,
+	ParseFailed:          http.StatusBadGateway,
+	Unavailable:          http.StatusServiceUnavailable,
+	Internal:             http.StatusInternalServerError,
+}
+
+// Status returns the HTTP status a code is reported with.
+func Status(code Code) int {
+	if status, ok := statuses[code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Error is an error with a code and a client-facing message. Fields are added
+// to the response alongside the message, for errors that tell the client how
+// to fix the request.
+type Error struct {
+	Code    Code
+	Message string
+	Err     error
+	Fields  map[string]interface{}
+}
+
+// New returns an error with a code and message.
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Wrap returns an error with a message caused by err. The code is taken from
+// the first *Error in err's chain, so a provider's rate limit stays a rate
+// limit when a handler adds its own message; other causes are Internal.
+func Wrap(err error, message string) *Error {
+	code := Internal
+	var coded *Error
+	if errors.As(err, &coded) {
+		code = coded.Code
+	}
+	return &Error{Code: code, Message: message, Err: err}
+}
+
+// WithCause sets the underlying cause of an error and returns it.
+func (e *Error) WithCause(err error) *Error {
+	e.Err = err
+	return e
+}
+
+// With adds a field to the response of an error and returns it.
+func (e *Error) With(key string, value interface{}) *Error {
+	if e.Fields == nil {
+		e.Fields = map[string]interface{}{}
+	}
+	e.Fields[key] = value
+	return e
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// From returns err as an *Error. Errors without a code are Internal.
+func From(err error) *Error {
+	var coded *Error
+	if errors.As(err, &coded) {
+		return coded
+	}
+	return &Error{Code: Internal, Message: "Internal server error", Err: err}
+}
+
+// CodeOf returns the code of err, or Internal when it has none.
+func CodeOf(err error) Code {
+	return From(err).Code
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -26,6 +27,12 @@ type AppConfig struct {
 	OpenAIModelForClassification string `mapstructure:"openai_model_for_classification"`
 	ClaudeModelForClassification string `mapstructure:"claude_model_for_classification"`
 
+	// How images sent without an input_type are classified: "classifier" asks
+	// the image classifier service, "analyzer" skips it and leaves it to the
+	// analyzer, saving a call per image, and "local" tries the local
+	// heuristics first and only asks the classifier service when unsure
+	ClassificationStrategy string `mapstructure:"classification_strategy"`
+
 	BarcodeAnalyzerService        string `mapstructure:"barcode_analyzer_service"`
 	FoodImageAnalyzerService      string `mapstructure:"food_image_analyzer_service"`
 	NutritionLabelAnalyzerService string `mapstructure:"nutrition_label_analyzer_service"`
@@ -35,6 +42,35 @@ type AppConfig struct {
 	OpenAIModelForAnalysis string `mapstructure:"openai_model_for_analysis"`
 	ClaudeModelForAnalysis string `mapstructure:"claude_model_for_analysis"`
 
+	// Percent Daily Value reference intakes, by region and canonical nutrient ID.
+	// Entries extend or override the built-in "fda", "eu" and "ca" tables.
+	DefaultDailyValueRegion string                        `mapstructure:"default_daily_value_region"`
+	DailyValues             map[string]map[string]float64 `mapstructure:"daily_values"`
+
+	// Longest side in pixels of the thumbnails stored with food log entries, 0 disables them
+	ThumbnailSize int `mapstructure:"thumbnail_size"`
+
+	// Items analyzed at the same time, and items allowed, per batch request
+	BatchWorkers  int `mapstructure:"batch_workers"`
+	BatchMaxItems int `mapstructure:"batch_max_items"`
+
+	// Background analyses run at the same time, and how many may wait
+	JobWorkers   int `mapstructure:"job_workers"`
+	JobQueueSize int `mapstructure:"job_queue_size"`
+
+	// Lets webhooks reach loopback and private network addresses
+	AllowPrivateWebhooks bool `mapstructure:"allow_private_webhooks"`
+
+	// How long responses to requests with an Idempotency-Key are replayed
+	IdempotencyWindow time.Duration `mapstructure:"idempotency_window"`
+
+	// How long the full image of an analysis is kept for refinement; 0 keeps
+	// only its hash and thumbnail
+	AnalysisImageRetention time.Duration `mapstructure:"analysis_image_retention"`
+
+	// How long images uploaded to /blobs can be referred to
+	BlobRetention time.Duration `mapstructure:"blob_retention"`
+
 	// Field for mock service configuration
 	MockServiceType string `mapstructure:"mock_service_type"`
 
@@ -94,12 +130,23 @@ func setDefaults() {
 	viper.SetDefault("claude_model_for_classification", "claude-3-opus-20240229")
 	viper.SetDefault("barcode_analyzer_service", "openai")
 	viper.SetDefault("food_image_analyzer_service", "openai")
+	viper.SetDefault("classification_strategy", "classifier")
 	viper.SetDefault("nutrition_label_analyzer_service", "openai")
 	viper.SetDefault("text_analyzer_service", "openai")
 	viper.SetDefault("default_analyzer_service", "openai")
 	viper.SetDefault("openai_model_for_analysis", "gpt-4-vision-preview")
 	viper.SetDefault("claude_model_for_analysis", "claude-3-opus-20240229")
 	viper.SetDefault("mock_service_type", "default")
+	viper.SetDefault("default_daily_value_region", "fda")
+	viper.SetDefault("thumbnail_size", 160)
+	viper.SetDefault("batch_workers", 4)
+	viper.SetDefault("batch_max_items", 20)
+	viper.SetDefault("job_workers", 2)
+	viper.SetDefault("job_queue_size", 100)
+	viper.SetDefault("allow_private_webhooks", false)
+	viper.SetDefault("idempotency_window", "24h")
+	viper.SetDefault("analysis_image_retention", 0)
+	viper.SetDefault("blob_retention", "24h")
 
 	// Set default for prompts
 	viper.SetDefault("prompts", map[string]LLMPrompts{
@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"image"
+	"math"
+)
+
+// eanDigitWidths are the module widths of the runs of each EAN digit in its
+// L encoding, which R shares with colors swapped. G is L reversed.
+var eanDigitWidths = [10][4]int{
+	{3, 2, 1, 1}, {2, 2, 2, 1}, {2, 1, 2, 2}, {1, 4, 1, 1}, {1, 1, 3, 2},
+	{1, 2, 3, 1}, {1, 1, 1, 4}, {1, 3, 1, 2}, {1, 2, 1, 3}, {3, 1, 1, 2},
+}
+
+// eanFirstDigit maps the L/G parities of the six left digits, G as a set bit
+// from the first digit down, to the implied first digit of an EAN-13.
+var eanFirstDigit = map[int]int{
+	0b000000: 0, 0b001011: 1, 0b001101: 2, 0b001110: 3, 0b010011: 4,
+	0b011001: 5, 0b011100: 6, 0b010101: 7, 0b010110: 8, 0b011010: 9,
+}
+
+// eanScanLines is how many rows across the image are tried.
+const eanScanLines = 24
+
+// DecodeEAN13 looks for an EAN-13 or UPC-A barcode along rows of the image,
+// either way up, and returns its 13 digits. UPC-A codes start with 0. Only
+// codes whose check digit matches are returned.
+func DecodeEAN13(img image.Image) (string, bool) {
+	bounds := img.Bounds()
+	if bounds.Dx() < 95 || bounds.Dy() == 0 {
+		return "", false
+	}
+	row := make([]uint8, bounds.Dx())
+	for line := 1; line <= eanScanLines; line++ {
+		y := bounds.Min.Y + line*bounds.Dy()/(eanScanLines+1)
+		for x := range row {
+			row[x] = luminance(img, bounds.Min.X+x, y)
+		}
+		if code, ok := decodeEANRuns(darkRuns(row)); ok {
+			return code, true
+		}
+	}
+	return "", false
+}
+
+// luminance returns the 8-bit luma of a pixel.
+func luminance(img image.Image, x, y int) uint8 {
+	r, g, b, _ := img.At(x, y).RGBA()
+	return uint8((19595*r + 38470*g + 7471*b + 1<<15) >> 24)
+}
+
+// run is a stretch of pixels of one color along a row.
+type run struct {
+	dark  bool
+	width int
+}
+
+// darkRuns thresholds a row halfway between its darkest and lightest pixels
+// and returns its runs.
+func darkRuns(row []uint8) []run {
+	lo, hi := uint8(255), uint8(0)
+	for _, v := range row {
+		lo, hi = min(lo, v), max(hi, v)
+	}
+	if hi-lo < 64 {
+		return nil
+	}
+	threshold := (int(lo) + int(hi)) / 2
+	var runs []run
+	for _, v := range row {
+		dark := int(v) < threshold
+		if len(runs) > 0 && runs[len(runs)-1].dark == dark {
+			runs[len(runs)-1].width++
+		} else {
+			runs = append(runs, run{dark: dark, width: 1})
+		}
+	}
+	return runs
+}
+
+// decodeEANRuns tries each bar of a row as the start of a code: a guard of
+// three module-wide runs after a quiet zone, six left digits, a middle guard
+// of five runs, six right digits and an end guard.
+func decodeEANRuns(runs []run) (string, bool) {
+	const codeRuns = 3 + 24 + 5 + 24 + 3
+	for start := 1; start+codeRuns <= len(runs); start++ {
+		if !runs[start].dark {
+			continue
+		}
+		module := float64(runs[start].width+runs[start+1].width+runs[start+2].width) / 3
+		if !isGuard(runs[start:start+3], module) || float64(runs[start-1].width) < 3*module {
+			continue
+		}
+		if code, ok := decodeEANDigits(runs[start+3:start+codeRuns], module); ok {
+			return code, true
+		}
+	}
+	return "", false
+}
+
+// decodeEANDigits decodes the runs from after the start guard to the end
+// guard. A code upside down reads mirrored: its right digits come first,
+// each reversed, and its left digits last, with their parities swapped.
+func decodeEANDigits(runs []run, module float64) (string, bool) {
+	if !isGuard(runs[24:29], module) || !isGuard(runs[53:56], module) {
+		return "", false
+	}
+	var digits [12]int
+	var reversed [12]bool
+	for i := range digits {
+		offset := i * 4
+		if i >= 6 {
+			offset += 5 // The middle guard
+		}
+		var ok bool
+		if digits[i], reversed[i], ok = matchEANDigit(runs[offset : offset+4]); !ok {
+			return "", false
+		}
+	}
+
+	mirrored := true
+	for _, r := range reversed[:6] {
+		mirrored = mirrored && r
+	}
+	if mirrored {
+		for i, j := 0, 11; i < j; i, j = i+1, j-1 {
+			digits[i], digits[j] = digits[j], digits[i]
+			reversed[i], reversed[j] = !reversed[j], !reversed[i]
+		}
+	}
+	parities := 0
+	for i, r := range reversed {
+		if i < 6 && r {
+			parities |= 1 << (5 - i)
+		}
+		if i >= 6 && r {
+			return "", false
+		}
+	}
+	first, ok := eanFirstDigit[parities]
+	if !ok {
+		return "", false
+	}
+	return eanCode(first, digits)
+}
+
+// eanCode returns the 13 digits of a code if its check digit matches.
+func eanCode(first int, digits [12]int) (string, bool) {
+	code := append([]int{first}, digits[:]...)
+	sum := 0
+	for i, digit := range code[:12] {
+		sum += digit * (1 + 2*(i%2))
+	}
+	if (10-sum%10)%10 != code[12] {
+		return "", false
+	}
+	text := make([]byte, len(code))
+	for i, digit := range code {
+		text[i] = byte('0' + digit)
+	}
+	return string(text), true
+}
+
+// matchEANDigit finds the digit whose widths are closest to four runs, seven
+// modules in all, and whether it matched them reversed (the G encoding).
+func matchEANDigit(runs []run) (digit int, reversed bool, ok bool) {
+	total := 0
+	for _, r := range runs {
+		total += r.width
+	}
+	module := float64(total) / 7
+	best := math.MaxFloat64
+	for d, widths := range eanDigitWidths {
+		var forward, backward float64
+		for i, r := range runs {
+			w := float64(r.width) / module
+			forward += math.Abs(w - float64(widths[i]))
+			backward += math.Abs(w - float64(widths[3-i]))
+		}
+		if forward < best {
+			best, digit, reversed = forward, d, false
+		}
+		if backward < best {
+			best, digit, reversed = backward, d, true
+		}
+	}
+	return digit, reversed, best < 1.5
+}
+
+// isGuard reports whether runs are each about one module wide.
+func isGuard(runs []run, module float64) bool {
+	for _, r := range runs {
+		if w := float64(r.width) / module; w < 0.5 || w > 1.6 {
+			return false
+		}
+	}
+	return true
+}
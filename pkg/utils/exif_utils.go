@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"time"
+)
+
+// EXIF tags read by ImageCaptureTime.
+const (
+	exifTagDateTime           = 0x0132
+	exifTagExifIFD            = 0x8769
+	exifTagDateTimeOriginal   = 0x9003
+	exifTagOffsetTimeOriginal = 0x9011
+	exifTypeASCII             = 2
+	exifTypeLong              = 4
+)
+
+// ImageCaptureTime reads when a JPEG photo was taken from its EXIF metadata,
+// preferring DateTimeOriginal over DateTime. EXIF times are wall clock times;
+// they are placed in loc unless the image also records its UTC offset. The
+// second result is false when the image has no usable capture time.
+func ImageCaptureTime(data []byte, loc *time.Location) (time.Time, bool) {
+	tiff := jpegExifSegment(data)
+	if tiff == nil {
+		return time.Time{}, false
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return time.Time{}, false
+	}
+
+	ifd0 := readIFD(tiff, order, order.Uint32(tiff[4:8]))
+	value, offset := ifd0[exifTagDateTime], ""
+	if pointer := exifLong(ifd0[exifTagExifIFD], order); pointer > 0 {
+		exif := readIFD(tiff, order, pointer)
+		if original, ok := exif[exifTagDateTimeOriginal]; ok {
+			value, offset = original, exifASCII(exif[exifTagOffsetTimeOriginal])
+		}
+	}
+
+	text := exifASCII(value)
+	if text == "" {
+		return time.Time{}, false
+	}
+	if offset != "" {
+		if t, err := time.Parse("2006:01:02 15:04:05-07:00", text+offset); err == nil {
+			return t, true
+		}
+	}
+	t, err := time.ParseInLocation("2006:01:02 15:04:05", text, loc)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// jpegExifSegment returns the TIFF data of a JPEG's EXIF APP1 segment, or nil.
+func jpegExifSegment(data []byte) []byte {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil
+	}
+	for pos := 2; pos+4 <= len(data); {
+		if data[pos] != 0xFF {
+			return nil
+		}
+		marker := data[pos+1]
+		if marker == 0xDA || marker == 0xD9 {
+			// Metadata segments all come before the image data
+			return nil
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2 : pos+4]))
+		end := pos + 2 + length
+		if length < 2 || end > len(data) {
+			return nil
+		}
+		segment := data[pos+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) && len(segment) >= 14 {
+			return segment[6:]
+		}
+		pos = end
+	}
+	return nil
+}
+
+// exifEntry is the raw type and value of one IFD entry. Values longer than
+// four bytes are resolved from their offset.
+type exifEntry struct {
+	kind  uint16
+	value []byte
+}
+
+// readIFD reads the ASCII and LONG entries of the IFD at offset.
+func readIFD(tiff []byte, order binary.ByteOrder, offset uint32) map[uint16]exifEntry {
+	entries := map[uint16]exifEntry{}
+	if int(offset)+2 > len(tiff) {
+		return entries
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		start := int(offset) + 2 + i*12
+		if start+12 > len(tiff) {
+			break
+		}
+		entry := tiff[start : start+12]
+		tag, kind, n := order.Uint16(entry[0:2]), order.Uint16(entry[2:4]), order.Uint32(entry[4:8])
+
+		var size uint32
+		switch kind {
+		case exifTypeASCII:
+			size = n
+		case exifTypeLong:
+			size = 4 * n
+		default:
+			continue
+		}
+		value := entry[8:12]
+		if size > 4 {
+			at := order.Uint32(entry[8:12])
+			if uint64(at)+uint64(size) > uint64(len(tiff)) {
+				continue
+			}
+			value = tiff[at : at+size]
+		}
+		entries[tag] = exifEntry{kind: kind, value: value[:min(size, uint32(len(value)))]}
+	}
+	return entries
+}
+
+func exifASCII(entry exifEntry) string {
+	if entry.kind != exifTypeASCII {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(entry.value), "\x00"))
+}
+
+func exifLong(entry exifEntry, order binary.ByteOrder) uint32 {
+	if entry.kind != exifTypeLong || len(entry.value) < 4 {
+		return 0
+	}
+	return order.Uint32(entry.value)
+}
@@ -1,7 +1,10 @@
 package utils
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"dietsense/pkg/apperrors"
 	"dietsense/pkg/logging"
 	"encoding/base64"
 	"encoding/json"
@@ -57,18 +60,88 @@ func SendHTTPRequest(url, apiKey string, payload map[string]interface{}) (map[st
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, apperrors.New(apperrors.ProviderUnavailable, "The analysis provider could not be reached").WithCause(err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API request failed with status %d", resp.StatusCode)
+		return nil, providerStatusError(resp.StatusCode)
 	}
 
 	var response map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, fmt.Errorf("failed to decode API response: %w", err)
+		return nil, apperrors.New(apperrors.ParseFailed, "The analysis provider's response could not be read").WithCause(err)
 	}
 	logging.Log.Info("Response: ", response)
 	return response, nil
 }
+
+// streamClient makes streaming requests. It has no overall timeout, as
+// answers are read while the model writes them; the caller's context ends
+// them instead.
+var streamClient = &http.Client{}
+
+// StreamHTTPRequest sends a JSON POST request to an endpoint that answers
+// with server-sent events, and passes the data of each event to onData until
+// the stream ends, onData returns an error, a "[DONE]" event arrives or ctx
+// is done, which also closes the connection.
+func StreamHTTPRequest(ctx context.Context, url, apiKey string, payload map[string]interface{}, onData func(data []byte) error) error {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode request payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payloadBytes))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	resp, err := streamClient.Do(req)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return apperrors.New(apperrors.ProviderUnavailable, "The analysis provider could not be reached").WithCause(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return providerStatusError(resp.StatusCode)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		data = strings.TrimSpace(data)
+		if data == "[DONE]" {
+			return nil
+		}
+		if err := onData([]byte(data)); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read event stream: %w", err)
+	}
+	return nil
+}
+
+// providerStatusError classifies a failed response of a provider API: a rate
+// limit is passed on as such, anything else means the provider is unavailable.
+func providerStatusError(status int) error {
+	cause := fmt.Errorf("API request failed with status %d", status)
+	if status == http.StatusTooManyRequests {
+		return apperrors.New(apperrors.RateLimited, "The analysis provider is rate limiting requests, try again later").WithCause(cause)
+	}
+	return apperrors.New(apperrors.ProviderUnavailable, "The analysis provider failed to answer").WithCause(cause)
+}
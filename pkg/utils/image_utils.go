@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif" // Register GIF decoding for thumbnails
+	"image/jpeg"
+	_ "image/png" // Register PNG decoding for thumbnails
+)
+
+// MaxDecodePixels is the largest image, in pixels, decoded in full. A small
+// compressed file can declare dimensions that would take gigabytes to decode.
+const MaxDecodePixels = 40_000_000
+
+// ErrImageTooLarge is returned for images with more than MaxDecodePixels.
+var ErrImageTooLarge = errors.New("image is too large to decode")
+
+// CheckImageSize reads only the header of an image and returns
+// ErrImageTooLarge when it declares more than MaxDecodePixels.
+func CheckImageSize(data []byte) error {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > MaxDecodePixels {
+		return ErrImageTooLarge
+	}
+	return nil
+}
+
+// HashImage returns the hex-encoded SHA-256 of the image bytes.
+func HashImage(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// MakeThumbnail decodes an image and returns a JPEG scaled down so its longest
+// side is at most maxSize pixels. Images already small enough are re-encoded
+// at their original size. Images over MaxDecodePixels are refused.
+func MakeThumbnail(data []byte, maxSize int) ([]byte, error) {
+	if err := CheckImageSize(data); err != nil {
+		return nil, err
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > maxSize || height > maxSize {
+		if width >= height {
+			width, height = maxSize, max(1, height*maxSize/width)
+		} else {
+			width, height = max(1, width*maxSize/height), maxSize
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		// Average the block of source pixels covered by each thumbnail pixel
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*bounds.Dy()/height)
+		for x := 0; x < width; x++ {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := max(x0+1, bounds.Min.X+(x+1)*bounds.Dx()/width)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a, n = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa), n+1
+				}
+			}
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: 75}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"bytes"
+	"dietsense/internal/api"
+	"dietsense/internal/services"
+	"dietsense/pkg/config"
+	"dietsense/pkg/logging"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyzeValidation(t *testing.T) {
+	logging.Setup()
+	gin.SetMode(gin.TestMode)
+
+	allowedIPs := config.Config.AllowedIPs
+	config.Config.AllowedIPs = []string{"192.0.2.1"} // httptest.NewRequest's client address
+	defer func() { config.Config.AllowedIPs = allowedIPs }()
+
+	router := gin.New()
+	factory := services.NewServiceFactory(&config.AppConfig{
+		ImageClassifierService:   "mock",
+		FoodImageAnalyzerService: "mock",
+		TextAnalyzerService:      "mock",
+		MockServiceType:          "default",
+	})
+	api.SetupRoutes(router, factory, nil, nil)
+
+	type file struct {
+		name string
+		data []byte
+	}
+	analyze := func(fields map[string]string, files ...file) (*httptest.ResponseRecorder, map[string]interface{}) {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		for name, value := range fields {
+			form.WriteField(name, value)
+		}
+		for _, f := range files {
+			part, _ := form.CreateFormFile("image", f.name)
+			part.Write(f.data)
+		}
+		form.Close()
+		req := httptest.NewRequest("POST", "/api/v1/analyze", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		var result map[string]interface{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result), resp.Body.String())
+		return resp, result
+	}
+	fieldProblem := func(result map[string]interface{}, field string) interface{} {
+		fields, _ := result["fields"].(map[string]interface{})
+		return fields[field]
+	}
+
+	// An empty request is not analyzed
+	req := httptest.NewRequest("POST", "/api/v1/analyze", nil)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Contains(t, resp.Body.String(), `"code":"invalid_input"`)
+
+	// Nor is one with only blank context
+	resp, result := analyze(map[string]string{"context": "  \n "})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Equal(t, "is required when no image is sent", fieldProblem(result, "context"))
+
+	// Context is limited in length
+	resp, result = analyze(map[string]string{"context": strings.Repeat("é", 4001)})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Equal(t, "Invalid context", result["error"])
+	assert.Equal(t, "must be at most 4000 characters", fieldProblem(result, "context"))
+	resp, _ = analyze(map[string]string{"context": strings.Repeat("é", 4000)})
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// Only known input types are accepted
+	resp, result = analyze(map[string]string{"context": "toast", "input_type": "screenshot"})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Equal(t, "must be one of food_image, nutrition_label, barcode, text", fieldProblem(result, "input_type"))
+	resp, _ = analyze(map[string]string{"context": "toast", "input_type": "text"})
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// Several invalid fields are reported together
+	resp, result = analyze(map[string]string{"context": strings.Repeat("a", 4001), "input_type": "screenshot"})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Equal(t, "Invalid context, input_type", result["error"])
+
+	// Meal fields name the field too
+	resp, result = analyze(map[string]string{"context": "toast", "eaten_at": "yesterday"})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.NotEmpty(t, fieldProblem(result, "eaten_at"))
+
+	// Images are limited in size and may not be empty
+	resp, result = analyze(nil, file{"huge.jpg", make([]byte, 10<<20+1)})
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+	assert.Equal(t, "payload_too_large", result["code"])
+	assert.Equal(t, "must be at most 10 MB", fieldProblem(result, "image"))
+	resp, result = analyze(nil, file{"empty.jpg", nil})
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.NotEmpty(t, fieldProblem(result, "image"))
+	resp, _ = analyze(nil, file{"meal.jpg", []byte("meal")})
+	assert.Equal(t, http.StatusOK, resp.Code)
+
+	// JSON bodies of other endpoints name the invalid fields the same way
+	req = httptest.NewRequest("POST", "/api/v1/generate-api-key", strings.NewReader(`{"email": "a@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result))
+	assert.Equal(t, "is required", fieldProblem(result, "rate_limit_per_hour"))
+
+	// Recipe texts are cut off at the JSON body limit before they are parsed
+	huge := `{"text": "` + strings.Repeat(`1 g rice\n`, 8<<20) + `"}`
+	req = httptest.NewRequest("POST", "/api/v1/analyze/recipe", strings.NewReader(huge))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code, resp.Body.String())
+}
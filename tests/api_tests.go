@@ -8,6 +8,8 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/gin-gonic/gin"
@@ -23,12 +25,16 @@ func TestAnalyzeEndpointWithMock(t *testing.T) {
 		ServiceType:            "mock",
 		MockServiceType:        "default",
 		DefaultAnalyzerService: "mock",
+		TextAnalyzerService:    "mock",
 	}
 
 	factory := services.NewServiceFactory(mockConfig)
-	api.SetupRoutes(router, factory, nil)
+	api.SetupRoutes(router, factory, nil, nil)
 
-	req, _ := http.NewRequest("POST", "/api/v1/analyze", nil)
+	// An empty request is rejected, so send something to analyze
+	form := url.Values{"context": {"A bowl of wakame salad"}}
+	req, _ := http.NewRequest("POST", "/api/v1/analyze", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	resp := httptest.NewRecorder()
 	router.ServeHTTP(resp, req)
 
@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"bytes"
+	"dietsense/internal/api"
+	"dietsense/internal/services"
+	"dietsense/pkg/config"
+	"dietsense/pkg/logging"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyzeBatch(t *testing.T) {
+	logging.Setup()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	factory := services.NewServiceFactory(&config.AppConfig{
+		ImageClassifierService:   "mock",
+		FoodImageAnalyzerService: "mock",
+		TextAnalyzerService:      "mock",
+		MockServiceType:          "default",
+		BatchWorkers:             2,
+	})
+	api.SetupRoutes(router, factory, nil, nil)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("items", `[
+		{"image": "breakfast", "meal_type": "breakfast"},
+		{"context": "a bowl of ramen"},
+		{"context": "toast", "meal_type": "brunch"},
+		{"image": "missing"},
+		{}
+	]`)
+	file, _ := form.CreateFormFile("breakfast", "breakfast.jpg")
+	file.Write([]byte("not really a jpeg"))
+	form.Close()
+
+	req, _ := http.NewRequest("POST", "/api/v1/analyze/batch", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+	var result struct {
+		Items []struct {
+			Index     int    `json:"index"`
+			Status    int    `json:"status"`
+			Error     string `json:"error"`
+			InputType int    `json:"input_type"`
+			MealType  string `json:"meal_type"`
+		} `json:"items"`
+		Succeeded int `json:"succeeded"`
+		Failed    int `json:"failed"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result))
+	require.Len(t, result.Items, 5)
+	assert.Equal(t, 2, result.Succeeded)
+	assert.Equal(t, 3, result.Failed)
+
+	for i, item := range result.Items {
+		assert.Equal(t, i, item.Index, "results keep the order of the items")
+	}
+	assert.Equal(t, http.StatusOK, result.Items[0].Status)
+	assert.Equal(t, int(services.InputTypeFoodImage), result.Items[0].InputType)
+	assert.Equal(t, "breakfast", result.Items[0].MealType)
+	assert.Equal(t, http.StatusOK, result.Items[1].Status)
+	assert.Equal(t, int(services.InputTypeText), result.Items[1].InputType)
+	assert.Equal(t, http.StatusBadRequest, result.Items[2].Status)
+	assert.Equal(t, "Invalid meal_type", result.Items[2].Error)
+	assert.Equal(t, http.StatusBadRequest, result.Items[3].Status)
+	assert.Equal(t, http.StatusBadRequest, result.Items[4].Status)
+
+	// Text-only batches can be sent as JSON; an empty batch is rejected
+	req, _ = http.NewRequest("POST", "/api/v1/analyze/batch", bytes.NewBufferString(`{"items": []}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"bytes"
+	"dietsense/internal/api"
+	"dietsense/internal/services"
+	"dietsense/pkg/config"
+	"dietsense/pkg/logging"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassificationPaths(t *testing.T) {
+	logging.Setup()
+	gin.SetMode(gin.TestMode)
+
+	// No classifier is available, so only requests that skip it succeed
+	cfg := &config.AppConfig{
+		ImageClassifierService:        "nope",
+		FoodImageAnalyzerService:      "mock",
+		NutritionLabelAnalyzerService: "mock",
+		BarcodeAnalyzerService:        "mock",
+		TextAnalyzerService:           "mock",
+		DefaultAnalyzerService:        "mock",
+		MockServiceType:               "default",
+	}
+	router := gin.New()
+	api.SetupRoutes(router, services.NewServiceFactory(cfg), nil, nil)
+
+	analyze := func(fields map[string]string, images int) (*httptest.ResponseRecorder, map[string]interface{}) {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		for name, value := range fields {
+			form.WriteField(name, value)
+		}
+		for i := 0; i < images; i++ {
+			file, _ := form.CreateFormFile("image", "label.jpg")
+			file.Write([]byte("a photo"))
+		}
+		form.Close()
+		req := httptest.NewRequest("POST", "/api/v1/analyze", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		var result map[string]interface{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result), resp.Body.String())
+		return resp, result
+	}
+
+	// Images are classified by default
+	resp, _ := analyze(nil, 1)
+	assert.Equal(t, http.StatusBadGateway, resp.Code)
+
+	// The input type given by the client applies to every image
+	resp, result := analyze(map[string]string{"input_type": "nutrition_label"}, 2)
+	require.Equal(t, http.StatusOK, resp.Code, result)
+	assert.Equal(t, "hint", result["classification"])
+	assert.EqualValues(t, services.InputTypeNutritionLabel, result["input_type"])
+	for _, image := range result["images"].([]interface{}) {
+		assert.Equal(t, "hint", image.(map[string]interface{})["classified_by"])
+		assert.EqualValues(t, services.InputTypeNutritionLabel, image.(map[string]interface{})["input_type"])
+	}
+
+	// The analyzer strategy skips the classifier for all requests
+	cfg.ClassificationStrategy = "analyzer"
+	resp, result = analyze(nil, 1)
+	require.Equal(t, http.StatusOK, resp.Code, result)
+	assert.Equal(t, "analyzer", result["classification"])
+	assert.EqualValues(t, services.InputTypeUnknown, result["input_type"])
+	cfg.ClassificationStrategy = "classifier"
+
+	// Text has no classification
+	resp, result = analyze(map[string]string{"context": "toast", "input_type": "text"}, 0)
+	require.Equal(t, http.StatusOK, resp.Code, result)
+	assert.NotContains(t, result, "classification")
+
+	// The input type must fit the images sent
+	resp, result = analyze(map[string]string{"input_type": "text"}, 1)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Equal(t, "must be an image type when images are sent", result["fields"].(map[string]interface{})["input_type"])
+	resp, _ = analyze(map[string]string{"context": "toast", "input_type": "barcode"}, 0)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+}
+
+// panickingTransport makes every provider call panic, as a bug in reading
+// an unexpected answer would.
+type panickingTransport struct{}
+
+func (panickingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	panic("unexpected answer")
+}
+
+func TestClassifierPanic(t *testing.T) {
+	logging.Setup()
+	gin.SetMode(gin.TestMode)
+
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = panickingTransport{}
+	defer func() { http.DefaultTransport = defaultTransport }()
+
+	router := gin.New()
+	api.SetupRoutes(router, services.NewServiceFactory(&config.AppConfig{
+		ImageClassifierService:   "openai",
+		FoodImageAnalyzerService: "mock",
+		MockServiceType:          "default",
+	}), nil, nil)
+
+	// A classifier panicking in its own goroutine fails the request, not the process
+	for _, images := range []int{1, 2} {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		for i := 0; i < images; i++ {
+			file, _ := form.CreateFormFile("image", "meal.jpg")
+			file.Write([]byte("a photo"))
+		}
+		form.Close()
+		req := httptest.NewRequest("POST", "/api/v1/analyze", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusBadGateway, resp.Code, resp.Body.String())
+		var result map[string]interface{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result))
+		assert.Equal(t, "parse_failed", result["code"])
+	}
+}
@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"bytes"
+	"dietsense/internal/api"
+	"dietsense/internal/middleware"
+	"dietsense/internal/models"
+	"dietsense/internal/nutrition"
+	"dietsense/internal/repositories/sqlite"
+	"dietsense/internal/services"
+	"dietsense/pkg/apperrors"
+	"dietsense/pkg/config"
+	"dietsense/pkg/logging"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReferenceIntakes(t *testing.T) {
+	overrides := map[string]map[string]float64{"uk": {"calories": 2000}, "fda": {"sodium": 2000}}
+	assert.Equal(t, []string{"ca", "eu", "fda", "uk"}, nutrition.ReferenceRegions(overrides))
+
+	table, ok := nutrition.LookupReferenceIntakes(" FDA ", overrides)
+	require.True(t, ok)
+	assert.Equal(t, 2000.0, table["sodium"])
+	assert.Equal(t, 2000.0, table["calories"])
+	_, ok = nutrition.LookupReferenceIntakes("mars", overrides)
+	assert.False(t, ok)
+
+	facts := nutrition.Facts{
+		"calories": {Value: 500, Unit: nutrition.UnitKcal},
+		"sodium":   {Value: 1.15, Unit: nutrition.UnitGram},
+		"protein":  {Value: 25, Unit: nutrition.UnitGram},
+	}
+	annotated := facts.WithDailyValues(table)
+	require.NotNil(t, annotated["calories"].DailyValue)
+	assert.Equal(t, 25.0, *annotated["calories"].DailyValue)
+	assert.Equal(t, 50.0, *annotated["protein"].DailyValue)
+	assert.Nil(t, annotated["sodium"].DailyValue, "amounts outside the catalog unit get no %DV")
+	assert.Nil(t, facts["calories"].DailyValue, "the facts themselves are left untouched")
+}
+
+func TestDailyValuePreferences(t *testing.T) {
+	logging.Setup()
+	gin.SetMode(gin.TestMode)
+
+	dailyValues, defaultRegion := config.Config.DailyValues, config.Config.DefaultDailyValueRegion
+	config.Config.DailyValues = map[string]map[string]float64{"uk": {"calories": 2000}}
+	config.Config.DefaultDailyValueRegion = ""
+	defer func() { config.Config.DailyValues, config.Config.DefaultDailyValueRegion = dailyValues, defaultRegion }()
+
+	db, err := sqlite.NewSQLiteDB("file:" + t.TempDir() + "/daily_values.db")
+	require.NoError(t, err)
+	require.NoError(t, db.SaveAPIKey(&models.APIKey{Key: "key"}))
+
+	router := gin.New()
+	factory := services.NewServiceFactory(&config.AppConfig{
+		ImageClassifierService:   "mock",
+		FoodImageAnalyzerService: "mock",
+		TextAnalyzerService:      "mock",
+		MockServiceType:          "default",
+	})
+	api.SetupRoutes(router, factory, db, nil)
+
+	call := func(req *http.Request) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req.Header.Set(middleware.APIKeyHeader, "key")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body), resp.Body.String())
+		return resp, body
+	}
+	putConfig := func(body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := httptest.NewRequest("PUT", "/api/v1/config", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		return call(req)
+	}
+	analyze := func(query string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		file, _ := form.CreateFormFile("image", "meal.png")
+		file.Write(encodePNG(t, photoImage()))
+		form.Close()
+		req := httptest.NewRequest("POST", "/api/v1/analyze"+query, &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		return call(req)
+	}
+	allowed := []interface{}{"ca", "eu", "fda", "uk"}
+
+	// Without a preference or default there is no %DV
+	resp, body := analyze("")
+	require.Equal(t, http.StatusOK, resp.Code, body)
+	assert.NotContains(t, body, "daily_value_region")
+
+	// Configured regions are accepted, unknown ones list the known regions
+	resp, body = putConfig(`{"daily_value_region": "uk"}`)
+	require.Equal(t, http.StatusOK, resp.Code, body)
+	resp, body = call(httptest.NewRequest("GET", "/api/v1/config", nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "uk", body["daily_value_region"])
+
+	resp, body = putConfig(`{"daily_value_region": "mars"}`)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Equal(t, "Unknown daily value region: mars", body["error"])
+	assert.Equal(t, allowed, body["allowed"])
+
+	// The saved region applies unless the request names another
+	resp, body = analyze("")
+	require.Equal(t, http.StatusOK, resp.Code, body)
+	assert.Equal(t, "uk", body["daily_value_region"])
+	resp, body = analyze("?region=eu")
+	require.Equal(t, http.StatusOK, resp.Code, body)
+	assert.Equal(t, "eu", body["daily_value_region"])
+	calories := body["nutrition_info"].(map[string]interface{})["calories"].(map[string]interface{})
+	assert.Contains(t, calories, "daily_value_percent")
+
+	resp, body = analyze("?region=mars")
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Equal(t, string(apperrors.InvalidInput), body["code"])
+	assert.Equal(t, allowed, body["allowed"])
+}
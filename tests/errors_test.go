@@ -0,0 +1,255 @@
+package tests
+
+import (
+	"dietsense/internal/api"
+	"dietsense/internal/middleware"
+	"dietsense/internal/models"
+	"dietsense/internal/repositories/sqlite"
+	"dietsense/internal/services"
+	"dietsense/pkg/apperrors"
+	"dietsense/pkg/config"
+	"dietsense/pkg/logging"
+	"dietsense/pkg/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrorEnvelope(t *testing.T) {
+	logging.Setup()
+	gin.SetMode(gin.TestMode)
+
+	environment := config.Config.Environment
+	defer func() { config.Config.Environment = environment }()
+
+	db, err := sqlite.NewSQLiteDB("file:" + t.TempDir() + "/errors.db")
+	require.NoError(t, err)
+	require.NoError(t, db.SaveAPIKey(&models.APIKey{Key: "key"}))
+
+	router := gin.New()
+	factory := services.NewServiceFactory(&config.AppConfig{TextAnalyzerService: "nope"})
+	api.SetupRoutes(router, factory, db, nil)
+
+	call := func(req *http.Request) (*httptest.ResponseRecorder, map[string]interface{}) {
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body), resp.Body.String())
+		return resp, body
+	}
+	analyze := func() (*httptest.ResponseRecorder, map[string]interface{}) {
+		form := url.Values{"context": {"a bowl of porridge"}}
+		req := httptest.NewRequest("POST", "/api/v1/analyze", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return call(req)
+	}
+
+	// Errors carry a stable code and the ID of the request, which is also
+	// sent as a header
+	resp, body := call(httptest.NewRequest("GET", "/api/v1/logs", nil))
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.Equal(t, "API key required", body["error"])
+	assert.Equal(t, "unauthorized", body["code"])
+	assert.NotEmpty(t, body["request_id"])
+	assert.Equal(t, resp.Header().Get(middleware.RequestIDHeader), body["request_id"])
+
+	// A request ID chosen by the client is kept, one that is unsafe is not
+	req := httptest.NewRequest("GET", "/api/v1/logs/missing", nil)
+	req.Header.Set(middleware.APIKeyHeader, "key")
+	req.Header.Set(middleware.RequestIDHeader, "trace-42")
+	resp, body = call(req)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	assert.Equal(t, "not_found", body["code"])
+	assert.Equal(t, "trace-42", body["request_id"])
+	assert.Equal(t, "trace-42", resp.Header().Get(middleware.RequestIDHeader))
+	req = httptest.NewRequest("GET", "/ping", nil)
+	req.Header.Set(middleware.RequestIDHeader, "bad id\n")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.NotEqual(t, "bad id\n", resp.Header().Get(middleware.RequestIDHeader))
+	assert.NotEmpty(t, resp.Header().Get(middleware.RequestIDHeader))
+
+	// Fields that help fix the request are kept alongside
+	req = httptest.NewRequest("GET", "/api/v1/logs?meal_type=brunch", nil)
+	req.Header.Set(middleware.APIKeyHeader, "key")
+	resp, body = call(req)
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Equal(t, "invalid_input", body["code"])
+	assert.NotEmpty(t, body["allowed"])
+
+	// Outside production the cause is given as details
+	config.Config.Environment = "development"
+	resp, body = analyze()
+	assert.Equal(t, http.StatusBadGateway, resp.Code)
+	assert.Equal(t, "provider_unavailable", body["code"])
+	assert.Equal(t, "Failed to get analyzer service", body["error"])
+	assert.Contains(t, body["details"], "unknown analyzer service: nope")
+
+	// In production it is left out
+	config.Config.Environment = "production"
+	resp, body = analyze()
+	assert.Equal(t, http.StatusBadGateway, resp.Code)
+	assert.Equal(t, "provider_unavailable", body["code"])
+	assert.NotContains(t, body, "details")
+}
+
+// failingTransport stands in for a provider that cannot be reached, with a
+// cause naming an internal address.
+type failingTransport struct{}
+
+func (failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("dial tcp 10.20.30.40:443: connection refused")
+}
+
+func TestPartialFailureEnvelope(t *testing.T) {
+	logging.Setup()
+	gin.SetMode(gin.TestMode)
+
+	environment := config.Config.Environment
+	config.Config.Environment = "production"
+	defer func() { config.Config.Environment = environment }()
+	defaultTransport := http.DefaultTransport
+	http.DefaultTransport = failingTransport{}
+	defer func() { http.DefaultTransport = defaultTransport }()
+
+	db, err := sqlite.NewSQLiteDB("file:" + t.TempDir() + "/partial.db")
+	require.NoError(t, err)
+	require.NoError(t, db.SaveAPIKey(&models.APIKey{Key: "key"}))
+
+	router := gin.New()
+	api.SetupRoutes(router, services.NewServiceFactory(&config.AppConfig{TextAnalyzerService: "openai"}), db, nil)
+	call := func(req *http.Request) map[string]interface{} {
+		req.Header.Set(middleware.APIKeyHeader, "key")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		assert.NotContains(t, resp.Body.String(), "10.20.30.40")
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		return body
+	}
+
+	// A provider failure that leaves the rest of the response useful is
+	// reported as an error envelope, without its cause in production
+	insights := call(httptest.NewRequest("GET", "/api/v1/insights?narrative=true", nil))
+	require.Contains(t, insights, "narrative_error")
+	narrativeError := insights["narrative_error"].(map[string]interface{})
+	assert.Equal(t, "Failed to narrate insights", narrativeError["error"])
+	assert.Equal(t, "provider_unavailable", narrativeError["code"])
+	assert.NotEmpty(t, narrativeError["request_id"])
+	assert.NotContains(t, narrativeError, "details")
+
+	req := httptest.NewRequest("POST", "/api/v1/analyze/recipe", strings.NewReader(`{"text": "1 pinch of something unusual"}`))
+	req.Header.Set("Content-Type", "application/json")
+	recipe := call(req)
+	require.Contains(t, recipe, "model_error")
+	modelError := recipe["model_error"].(map[string]interface{})
+	assert.Equal(t, "Failed to estimate unknown ingredients", modelError["error"])
+	assert.Equal(t, "provider_unavailable", modelError["code"])
+	assert.NotContains(t, modelError, "details")
+}
+
+// cannedTransport stands in for a provider that answers every request with
+// the same body.
+type cannedTransport struct {
+	body string
+}
+
+func (c cannedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(c.body)),
+		Request:    req,
+	}, nil
+}
+
+func TestMalformedProviderAnswers(t *testing.T) {
+	logging.Setup()
+	gin.SetMode(gin.TestMode)
+
+	defaultTransport := http.DefaultTransport
+	defer func() { http.DefaultTransport = defaultTransport }()
+
+	db, err := sqlite.NewSQLiteDB("file:" + t.TempDir() + "/malformed.db")
+	require.NoError(t, err)
+	require.NoError(t, db.SaveAPIKey(&models.APIKey{Key: "key"}))
+
+	// Answers without the expected content are parse failures, not panics,
+	// and the rest of the response is still given
+	call := func(router *gin.Engine, req *http.Request) map[string]interface{} {
+		req.Header.Set(middleware.APIKeyHeader, "key")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		return body
+	}
+	for provider, answers := range map[string][]string{
+		"openai": {`{}`, `{"choices": []}`, `{"choices": [{"message": {"content": null}}]}`},
+		"claude": {`{"id": "msg", "type": "message", "role": "assistant", "content": []}`},
+	} {
+		router := gin.New()
+		api.SetupRoutes(router, services.NewServiceFactory(&config.AppConfig{TextAnalyzerService: provider}), db, nil)
+		for _, answer := range answers {
+			http.DefaultTransport = cannedTransport{answer}
+			req := httptest.NewRequest("POST", "/api/v1/analyze/recipe", strings.NewReader(`{"text": "1 pinch of something unusual"}`))
+			req.Header.Set("Content-Type", "application/json")
+			body := call(router, req)
+			require.Contains(t, body, "model_error", provider+": "+answer)
+			assert.Equal(t, "parse_failed", body["model_error"].(map[string]interface{})["code"], provider+": "+answer)
+
+			body = call(router, httptest.NewRequest("GET", "/api/v1/insights?narrative=true", nil))
+			assert.Contains(t, body, "insights")
+			require.Contains(t, body, "narrative_error", provider+": "+answer)
+			assert.Equal(t, "parse_failed", body["narrative_error"].(map[string]interface{})["code"], provider+": "+answer)
+		}
+	}
+}
+
+func TestProviderErrorCodes(t *testing.T) {
+	logging.Setup()
+
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/limited":
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "/down":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			fmt.Fprint(w, "not json")
+		}
+	}))
+	defer provider.Close()
+
+	for path, code := range map[string]apperrors.Code{
+		"/limited": apperrors.RateLimited,
+		"/down":    apperrors.ProviderUnavailable,
+		"/garbled": apperrors.ParseFailed,
+	} {
+		_, err := utils.SendHTTPRequest(provider.URL+path, "key", map[string]interface{}{})
+		assert.Equal(t, code, apperrors.CodeOf(err), path)
+	}
+
+	// Handlers keep the code of the cause when they add their own message
+	cause := fmt.Errorf("failed to analyze food: %w", apperrors.New(apperrors.RateLimited, "Slow down"))
+	wrapped := apperrors.Wrap(cause, "Failed to analyze")
+	assert.Equal(t, apperrors.RateLimited, wrapped.Code)
+	assert.Equal(t, http.StatusTooManyRequests, apperrors.Status(wrapped.Code))
+	assert.True(t, errors.Is(wrapped, cause))
+	assert.Equal(t, apperrors.Internal, apperrors.Wrap(errors.New("disk full"), "Failed to save").Code)
+	for _, code := range apperrors.Codes {
+		assert.NotZero(t, apperrors.Status(code), code)
+	}
+}
@@ -0,0 +1,276 @@
+package tests
+
+import (
+	"bytes"
+	"dietsense/internal/api"
+	"dietsense/internal/middleware"
+	"dietsense/internal/models"
+	"dietsense/internal/nutrition"
+	"dietsense/internal/repositories"
+	"dietsense/internal/repositories/sqlite"
+	"dietsense/internal/services"
+	"dietsense/pkg/config"
+	"dietsense/pkg/logging"
+	"dietsense/pkg/utils"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageDigest(t *testing.T) {
+	assert.Equal(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", utils.HashImage(nil))
+	assert.Len(t, utils.HashImage([]byte("photo")), 64)
+
+	wide := image.NewRGBA(image.Rect(0, 0, 200, 50))
+	for x := 0; x < 200; x++ {
+		for y := 0; y < 50; y++ {
+			wide.Set(x, y, color.RGBA{R: 200, G: 100, B: 50, A: 255})
+		}
+	}
+	thumbnail, err := utils.MakeThumbnail(encodePNG(t, wide), 40)
+	require.NoError(t, err)
+	decoded, err := jpeg.Decode(bytes.NewReader(thumbnail))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 40, 10), decoded.Bounds())
+	r, g, b, _ := decoded.At(20, 5).RGBA()
+	assert.InDelta(t, 200, r>>8, 8, "pixels are averaged, not replaced")
+	assert.InDelta(t, 100, g>>8, 8)
+	assert.InDelta(t, 50, b>>8, 8)
+
+	small, err := utils.MakeThumbnail(encodePNG(t, image.NewRGBA(image.Rect(0, 0, 8, 12))), 40)
+	require.NoError(t, err)
+	decoded, err = jpeg.Decode(bytes.NewReader(small))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 8, 12), decoded.Bounds(), "small images keep their size")
+
+	_, err = utils.MakeThumbnail([]byte("not an image"), 40)
+	assert.Error(t, err)
+
+	// Images are measured before they are decoded
+	assert.NoError(t, utils.CheckImageSize(encodePNG(t, wide)))
+	assert.ErrorIs(t, utils.CheckImageSize(oversizedPNG(t)), utils.ErrImageTooLarge)
+	_, err = utils.MakeThumbnail(oversizedPNG(t), 40)
+	assert.ErrorIs(t, err, utils.ErrImageTooLarge)
+}
+
+func TestAnalyzeSavesToFoodLog(t *testing.T) {
+	logging.Setup()
+	gin.SetMode(gin.TestMode)
+
+	thumbnailSize := config.Config.ThumbnailSize
+	config.Config.ThumbnailSize = 32
+	defer func() { config.Config.ThumbnailSize = thumbnailSize }()
+
+	db, err := sqlite.NewSQLiteDB("file:" + t.TempDir() + "/food_log.db")
+	require.NoError(t, err)
+	require.NoError(t, db.SaveAPIKey(&models.APIKey{Key: "key"}))
+
+	router := gin.New()
+	factory := services.NewServiceFactory(&config.AppConfig{
+		ImageClassifierService:   "mock",
+		FoodImageAnalyzerService: "mock",
+		TextAnalyzerService:      "mock",
+		MockServiceType:          "default",
+	})
+	api.SetupRoutes(router, factory, db, nil)
+
+	photo := encodePNG(t, photoImage())
+	analyze := func(apiKey string, fields map[string]string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		for name, value := range fields {
+			form.WriteField(name, value)
+		}
+		file, _ := form.CreateFormFile("image", "meal.png")
+		file.Write(photo)
+		form.Close()
+		req := httptest.NewRequest("POST", "/api/v1/analyze", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		if apiKey != "" {
+			req.Header.Set(middleware.APIKeyHeader, apiKey)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		var decoded map[string]interface{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &decoded), resp.Body.String())
+		return resp, decoded
+	}
+	logged := func() []models.FoodLogEntry {
+		entries, err := db.ListFoodLogEntries("key", repositories.FoodLogFilter{})
+		require.NoError(t, err)
+		return entries
+	}
+
+	// Nothing is logged unless asked for
+	resp, body := analyze("key", nil)
+	require.Equal(t, http.StatusOK, resp.Code, body)
+	assert.NotContains(t, body, "log_entry_id")
+	assert.Empty(t, logged())
+
+	// Saving needs an owner
+	resp, body = analyze("", map[string]string{"save": "true"})
+	assert.Equal(t, http.StatusUnauthorized, resp.Code, body)
+
+	resp, body = analyze("key", map[string]string{"save": "true", "meal_type": "lunch"})
+	require.Equal(t, http.StatusOK, resp.Code, body)
+	entryID := body["log_entry_id"].(string)
+
+	entry, err := db.GetFoodLogEntry("key", entryID)
+	require.NoError(t, err)
+	assert.Equal(t, body["analysis_id"], entry.AnalysisID)
+	assert.Equal(t, "lunch", entry.MealType)
+	assert.Equal(t, services.InputTypeFoodImage.String(), entry.InputType)
+	assert.Equal(t, body["summary"], entry.Summary)
+	assert.Equal(t, utils.HashImage(photo), entry.ImageHash)
+	assert.NotEmpty(t, entry.Thumbnail)
+
+	// Each nutrient of the response is a row of its own
+	nutritionInfo := body["nutrition_info"].(map[string]interface{})
+	require.NotEmpty(t, entry.Nutrients)
+	assert.Len(t, entry.Nutrients, len(nutritionInfo))
+	for _, nutrient := range entry.Nutrients {
+		assert.Equal(t, entryID, nutrient.EntryID)
+		require.Contains(t, nutritionInfo, nutrient.Nutrient)
+		amount := nutritionInfo[nutrient.Nutrient].(map[string]interface{})
+		assert.Equal(t, amount["value"], nutrient.Value, nutrient.Nutrient)
+		assert.Equal(t, amount["unit"], nutrient.Unit, nutrient.Nutrient)
+	}
+	assert.Len(t, logged(), 1)
+}
+
+func TestEscapeLike(t *testing.T) {
+	assert.Equal(t, `100\% \_raw\\`, repositories.EscapeLike(`100% _raw\`))
+}
+
+func TestFoodLogEntries(t *testing.T) {
+	logging.Setup()
+	gin.SetMode(gin.TestMode)
+
+	db, err := sqlite.NewSQLiteDB("file:" + t.TempDir() + "/food_log.db")
+	require.NoError(t, err)
+	require.NoError(t, db.SaveAPIKey(&models.APIKey{Key: "key"}))
+	require.NoError(t, db.SaveAPIKey(&models.APIKey{Key: "other"}))
+
+	router := gin.New()
+	api.SetupRoutes(router, services.NewServiceFactory(&config.AppConfig{}), db, nil)
+
+	day := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	seed := []struct {
+		id, mealType, inputType, summary string
+		eatenAt                          time.Time
+	}{
+		{"a", "breakfast", "food_image", "Porridge with berries", day.Add(8 * time.Hour)},
+		{"b", "lunch", "food_image", "100% rye bread", day.Add(12 * time.Hour)},
+		{"c", "lunch", "nutrition_label", "1000 island dressing", day.Add(12 * time.Hour)},
+		{"d", "dinner", "text", "pasta_bake", day.Add(19 * time.Hour)},
+		{"e", "snack", "text", "Pasta salad", day.Add(24 * time.Hour)},
+	}
+	for _, s := range seed {
+		entry := &models.FoodLogEntry{ID: s.id, APIKey: "key", MealType: s.mealType, InputType: s.inputType, Summary: s.summary}
+		entry.SetEatenAt(s.eatenAt)
+		entry.SetFacts(nutrition.Facts{"calories": {Name: "Calories", Value: 300, Unit: nutrition.UnitKcal, Confidence: 0.8}})
+		require.NoError(t, db.SaveFoodLogEntry(entry))
+	}
+
+	call := func(method, path, body, apiKey string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(middleware.APIKeyHeader, apiKey)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		var decoded map[string]interface{}
+		if resp.Code != http.StatusNoContent {
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &decoded), resp.Body.String())
+		}
+		return resp, decoded
+	}
+	list := func(query string) []string {
+		resp, body := call("GET", "/api/v1/logs?"+query, "", "key")
+		require.Equal(t, http.StatusOK, resp.Code, body)
+		var ids []string
+		for _, entry := range body["entries"].([]interface{}) {
+			ids = append(ids, entry.(map[string]interface{})["id"].(string))
+		}
+		return ids
+	}
+
+	// Pages follow each other through the cursor, newest first, ties by ID
+	var pages []string
+	path := "/api/v1/logs?limit=2"
+	for path != "" {
+		resp, body := call("GET", path, "", "key")
+		require.Equal(t, http.StatusOK, resp.Code, body)
+		for _, entry := range body["entries"].([]interface{}) {
+			pages = append(pages, entry.(map[string]interface{})["id"].(string))
+		}
+		path = ""
+		if cursor, ok := body["next_cursor"].(string); ok {
+			path = "/api/v1/logs?limit=2&cursor=" + cursor
+		}
+	}
+	assert.Equal(t, []string{"e", "d", "c", "b", "a"}, pages)
+
+	// Filters
+	assert.Equal(t, []string{"c", "b"}, list("meal_type=lunch"))
+	assert.Equal(t, []string{"e", "d"}, list("input_type=text"))
+	assert.Equal(t, []string{"d", "c", "b", "a"}, list("from=2024-03-10&to=2024-03-10"))
+	assert.Equal(t, []string{"e", "d"}, list("from=2024-03-10T18:00:00Z"))
+	assert.Equal(t, []string{"e", "d"}, list("q=PASTA"))
+	assert.Equal(t, []string{"b"}, list("q=100%25"), "% is matched literally")
+	assert.Equal(t, []string{"d"}, list("q=_"), "_ is matched literally")
+	assert.Equal(t, []string{"c", "b", "a"}, list("from=2024-03-10&to=2024-03-10&tz=Asia/Tokyo"))
+	for _, query := range []string{"meal_type=brunch", "input_type=video", "limit=0", "limit=101", "from=yesterday", "cursor=bogus", "tz=Mars/Base"} {
+		resp, _ := call("GET", "/api/v1/logs?"+query, "", "key")
+		assert.Equal(t, http.StatusBadRequest, resp.Code, query)
+	}
+
+	// Entries of other keys are not found
+	resp, _ := call("GET", "/api/v1/logs/a", "", "other")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	resp, _ = call("PATCH", "/api/v1/logs/a", `{"summary": "Mine now"}`, "other")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	resp, _ = call("DELETE", "/api/v1/logs/a", "", "other")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	assert.Empty(t, list("q=Mine"))
+
+	// Updates merge nutrients by name, null removes one
+	resp, body := call("PATCH", "/api/v1/logs/a", `{"summary": "Porridge", "meal_type": "snack", "nutrients": {"protein": {"value": 12, "unit": "g"}, "calories": null}}`, "key")
+	require.Equal(t, http.StatusOK, resp.Code, body)
+	assert.Equal(t, "Porridge", body["summary"])
+	assert.Equal(t, "snack", body["meal_type"])
+	entry, err := db.GetFoodLogEntry("key", "a")
+	require.NoError(t, err)
+	require.Len(t, entry.Nutrients, 1)
+	assert.Equal(t, "protein", entry.Nutrients[0].Nutrient)
+	assert.Equal(t, 12.0, entry.Nutrients[0].Value)
+	assert.Equal(t, 1.0, entry.Nutrients[0].Confidence)
+
+	resp, _ = call("PATCH", "/api/v1/logs/a", `{"meal_type": "brunch"}`, "key")
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	resp, _ = call("PATCH", "/api/v1/logs/a", `{"nutrients": {"protein": {"value": 1, "unit": "kcal"}}}`, "key")
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+
+	// Deleted entries are gone with their nutrients
+	resp, _ = call("DELETE", "/api/v1/logs/a", "", "key")
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+	resp, _ = call("GET", "/api/v1/logs/a", "", "key")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	resp, _ = call("DELETE", "/api/v1/logs/a", "", "key")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	totals, err := db.SumFoodLog("key", []repositories.TimeRange{{Label: "all", Start: day.AddDate(0, 0, -1), End: day.AddDate(0, 0, 2)}})
+	require.NoError(t, err)
+	for _, total := range totals.Nutrients {
+		assert.NotEqual(t, "protein", total.Nutrient, "nutrient rows of deleted entries are removed")
+	}
+}
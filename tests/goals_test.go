@@ -0,0 +1,149 @@
+package tests
+
+import (
+	"dietsense/internal/api"
+	"dietsense/internal/middleware"
+	"dietsense/internal/models"
+	"dietsense/internal/nutrition"
+	"dietsense/internal/repositories/sqlite"
+	"dietsense/internal/services"
+	"dietsense/pkg/config"
+	"dietsense/pkg/logging"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeGoalConvertsUnits(t *testing.T) {
+	goal := &models.Goal{Nutrient: "Natrium", Type: models.GoalMaximum, Value: 2.3, Unit: "g"}
+	require.NoError(t, services.NormalizeGoal(goal))
+	assert.Equal(t, "sodium", goal.Nutrient)
+	assert.Equal(t, 2300.0, goal.Value)
+	assert.Equal(t, nutrition.UnitMilligram, goal.Unit)
+
+	assert.Error(t, services.NormalizeGoal(&models.Goal{Nutrient: "sodium", Type: models.GoalEnergyShare, Value: 10}))
+	assert.Error(t, services.NormalizeGoal(&models.Goal{Nutrient: "sodium", Type: "ceiling", Value: 10}))
+}
+
+func TestEvaluateGoals(t *testing.T) {
+	goals := []models.Goal{
+		{Nutrient: "calories", Type: models.GoalTarget, Value: 2000, Tolerance: 10},
+		{Nutrient: "sodium", Type: models.GoalMaximum, Value: 2300},
+		{Nutrient: "fiber", Type: models.GoalMinimum, Value: 30},
+		{Nutrient: "protein", Type: models.GoalEnergyShare, Value: 20, Tolerance: 5},
+		{Nutrient: "sugars", Type: models.GoalMaximum, Value: 50},
+	}
+	totals := nutrition.Facts{
+		"calories": {Value: 1900, Unit: nutrition.UnitKcal},
+		"sodium":   {Value: 2500, Unit: nutrition.UnitMilligram},
+		"fiber":    {Value: 12, Unit: nutrition.UnitGram},
+		"protein":  {Value: 95, Unit: nutrition.UnitGram},
+	}
+
+	progress := services.EvaluateGoals(goals, totals)
+	require.Len(t, progress, len(goals))
+	statuses := make([]string, len(progress))
+	for i, p := range progress {
+		statuses[i] = p.Status
+	}
+	assert.Equal(t, []string{services.GoalMet, services.GoalExceeded, services.GoalUnder, services.GoalMet, services.GoalMet}, statuses)
+	assert.Equal(t, 100.0, progress[0].Remaining)
+	assert.Equal(t, -200.0, progress[1].Remaining)
+	assert.Equal(t, 20.0, progress[3].Actual)
+	assert.Equal(t, 0.0, progress[4].Actual, "Nutrients that were not logged count as zero")
+}
+
+func TestEstimateEnergy(t *testing.T) {
+	profile := &models.Profile{Age: 30, Sex: "male", HeightCm: 180, WeightKg: 80, ActivityLevel: "sedentary", Objective: "maintain"}
+	require.NoError(t, services.ValidateProfile(profile))
+
+	mifflin, err := services.EstimateEnergy(profile, "")
+	require.NoError(t, err)
+	assert.Equal(t, services.FormulaMifflinStJeor, mifflin.Formula)
+	assert.Equal(t, 1780.0, mifflin.BMR)
+	assert.Equal(t, 2136.0, mifflin.TDEE)
+	assert.Equal(t, 2140.0, mifflin.TargetCalories)
+
+	harris, err := services.EstimateEnergy(profile, services.FormulaHarrisBenedict)
+	require.NoError(t, err)
+	assert.Equal(t, 1854.0, harris.BMR)
+
+	profile.Objective = "lose"
+	estimate, err := services.EstimateEnergy(profile, "")
+	require.NoError(t, err)
+	goals, err := services.SuggestGoals(profile, estimate)
+	require.NoError(t, err)
+	shares := 0.0
+	for _, goal := range goals {
+		require.NoError(t, services.NormalizeGoal(&goal))
+		if goal.Type == models.GoalEnergyShare {
+			shares += goal.Value
+		}
+	}
+	assert.Equal(t, 100.0, shares, "The macro split should cover all calories")
+	assert.Equal(t, 1640.0, goals[0].Value)
+}
+
+func TestAddWeightEntry(t *testing.T) {
+	logging.Setup()
+	gin.SetMode(gin.TestMode)
+
+	db, err := sqlite.NewSQLiteDB("file:" + t.TempDir() + "/profile.db")
+	require.NoError(t, err)
+	require.NoError(t, db.SaveAPIKey(&models.APIKey{Key: "key"}))
+	router := gin.New()
+	api.SetupRoutes(router, services.NewServiceFactory(&config.AppConfig{}), db, nil)
+
+	call := func(method, path, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(middleware.APIKeyHeader, "key")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp.Code
+	}
+	weight := func() float64 {
+		profile, err := db.GetProfile("key")
+		require.NoError(t, err)
+		return profile.WeightKg
+	}
+	require.Equal(t, http.StatusOK, call("PUT", "/api/v1/profile",
+		`{"age": 35, "sex": "female", "height_cm": 168, "weight_kg": 62, "activity_level": "moderate", "objective": "maintain"}`))
+
+	// A weight the profile cannot take is refused before anything is saved
+	entries, err := db.ListWeightEntries("key", 0)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, call("POST", "/api/v1/profile/weights", `{"weight_kg": 400}`))
+	after, err := db.ListWeightEntries("key", 0)
+	require.NoError(t, err)
+	assert.Equal(t, entries, after)
+	assert.Equal(t, 62.0, weight())
+
+	// Only the latest measurement updates the profile
+	addWeight := func(kg float64, measuredAt time.Time) int {
+		return call("POST", "/api/v1/profile/weights", fmt.Sprintf(`{"weight_kg": %g, "measured_at": %q}`, kg, measuredAt.Format(time.RFC3339)))
+	}
+	now := time.Now()
+	assert.Equal(t, http.StatusCreated, addWeight(61, now.Add(time.Minute)), "small clock skew is allowed")
+	assert.Equal(t, 61.0, weight())
+	assert.Equal(t, http.StatusCreated, addWeight(65, now.AddDate(0, 0, -30)))
+	assert.Equal(t, 61.0, weight())
+	assert.Equal(t, http.StatusCreated, addWeight(400, now.AddDate(0, 0, -60)), "older entries are history only")
+	after, err = db.ListWeightEntries("key", 0)
+	require.NoError(t, err)
+	assert.Len(t, after, len(entries)+3)
+
+	// Measurements cannot be in the future
+	assert.Equal(t, http.StatusBadRequest, addWeight(58, now.Add(time.Hour)))
+	assert.Equal(t, 61.0, weight())
+	future, err := db.ListWeightEntries("key", 0)
+	require.NoError(t, err)
+	assert.Equal(t, after, future)
+}
@@ -0,0 +1,192 @@
+package tests
+
+import (
+	"bytes"
+	"dietsense/internal/api"
+	"dietsense/internal/middleware"
+	"dietsense/internal/models"
+	"dietsense/internal/repositories/sqlite"
+	"dietsense/internal/services"
+	"dietsense/pkg/config"
+	"dietsense/pkg/logging"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	logging.Setup()
+	gin.SetMode(gin.TestMode)
+
+	allowedIPs := config.Config.AllowedIPs
+	config.Config.AllowedIPs = []string{"192.0.2.1"} // httptest.NewRequest's client address
+	defer func() { config.Config.AllowedIPs = allowedIPs }()
+
+	db, err := sqlite.NewSQLiteDB("file:" + t.TempDir() + "/idempotency.db")
+	require.NoError(t, err)
+	require.NoError(t, db.SaveAPIKey(&models.APIKey{Key: "key"}))
+	require.NoError(t, db.SaveAPIKey(&models.APIKey{Key: "other"}))
+
+	router := gin.New()
+	factory := services.NewServiceFactory(&config.AppConfig{TextAnalyzerService: "mock", MockServiceType: "default"})
+	api.SetupRoutes(router, factory, db, nil)
+
+	generate := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/generate-api-key", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(middleware.IdempotencyKeyHeader, key)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// A retried key generation returns the same key instead of a second one
+	first := generate("retry-1", `{"email": "a@example.com", "rate_limit_per_hour": 10}`)
+	require.Equal(t, http.StatusOK, first.Code, first.Body.String())
+	assert.Empty(t, first.Header().Get(middleware.IdempotentReplayedHeader))
+	second := generate("retry-1", `{"email": "a@example.com", "rate_limit_per_hour": 10}`)
+	require.Equal(t, http.StatusOK, second.Code)
+	assert.Equal(t, "true", second.Header().Get(middleware.IdempotentReplayedHeader))
+	assert.Equal(t, first.Body.String(), second.Body.String())
+	assert.Contains(t, second.Header().Get("Content-Type"), "application/json")
+
+	// The key cannot be reused for a different request
+	other := generate("retry-1", `{"email": "b@example.com", "rate_limit_per_hour": 10}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, other.Code)
+
+	// Client errors are replayed too
+	invalid := generate("retry-2", `{}`)
+	assert.Equal(t, http.StatusBadRequest, invalid.Code)
+	assert.Equal(t, "true", generate("retry-2", `{}`).Header().Get(middleware.IdempotentReplayedHeader))
+
+	analyze := func(idempotencyKey, apiKey string) *httptest.ResponseRecorder {
+		// Each form is encoded with a new random boundary, as a retrying client would
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		form.WriteField("context", "a bowl of porridge")
+		form.WriteField("save", "true")
+		form.Close()
+		req := httptest.NewRequest("POST", "/api/v1/analyze", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		req.Header.Set(middleware.IdempotencyKeyHeader, idempotencyKey)
+		req.Header.Set(middleware.APIKeyHeader, apiKey)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	analysisID := func(resp *httptest.ResponseRecorder) string {
+		var result struct {
+			AnalysisID string `json:"analysis_id"`
+		}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result), resp.Body.String())
+		return result.AnalysisID
+	}
+
+	// A retried analysis is not run and saved again
+	firstAnalysis := analyze("meal-1", "key")
+	require.Equal(t, http.StatusOK, firstAnalysis.Code, firstAnalysis.Body.String())
+	retried := analyze("meal-1", "key")
+	assert.Equal(t, "true", retried.Header().Get(middleware.IdempotentReplayedHeader))
+	assert.Equal(t, analysisID(firstAnalysis), analysisID(retried))
+
+	// Keys belong to the caller: another caller's request with it is processed
+	otherCaller := analyze("meal-1", "other")
+	require.Equal(t, http.StatusOK, otherCaller.Code, otherCaller.Body.String())
+	assert.Empty(t, otherCaller.Header().Get(middleware.IdempotentReplayedHeader))
+	assert.NotEqual(t, analysisID(firstAnalysis), analysisID(otherCaller))
+
+	// Without an API key, keys belong to the client's address
+	anonymous := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/v1/analyze", strings.NewReader(`{"context": "a bowl of porridge"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(middleware.IdempotencyKeyHeader, "meal-1")
+		req.RemoteAddr = remoteAddr
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	firstAnonymous := anonymous("198.51.100.1:1234")
+	require.Equal(t, http.StatusOK, firstAnonymous.Code, firstAnonymous.Body.String())
+	assert.Empty(t, firstAnonymous.Header().Get(middleware.IdempotentReplayedHeader), "API key scopes are not shared")
+	assert.Equal(t, "true", anonymous("198.51.100.1:5678").Header().Get(middleware.IdempotentReplayedHeader))
+	otherClient := anonymous("198.51.100.2:1234")
+	require.Equal(t, http.StatusOK, otherClient.Code)
+	assert.Empty(t, otherClient.Header().Get(middleware.IdempotentReplayedHeader))
+}
+
+func TestIdempotencyKeyAfterPanic(t *testing.T) {
+	logging.Setup()
+	gin.SetMode(gin.TestMode)
+
+	db, err := sqlite.NewSQLiteDB("file:" + t.TempDir() + "/idempotency_panic.db")
+	require.NoError(t, err)
+
+	calls := 0
+	router := gin.New()
+	router.Use(gin.CustomRecovery(func(c *gin.Context, _ interface{}) {
+		c.AbortWithStatus(http.StatusInternalServerError)
+	}))
+	router.POST("/flaky", middleware.Idempotent(db, time.Hour, 1<<20), func(c *gin.Context) {
+		calls++
+		if calls == 1 {
+			panic("handler failed")
+		}
+		c.JSON(http.StatusOK, gin.H{"calls": calls})
+	})
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/flaky", strings.NewReader("{}"))
+		req.Header.Set(middleware.IdempotencyKeyHeader, "retry")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// The key of a request that panicked is released, so the retry runs
+	assert.Equal(t, http.StatusInternalServerError, send().Code)
+	retried := send()
+	assert.Equal(t, http.StatusOK, retried.Code, retried.Body.String())
+	assert.Empty(t, retried.Header().Get(middleware.IdempotentReplayedHeader))
+	assert.Equal(t, 2, calls)
+}
+
+func TestIdempotencyKeyBodyLimit(t *testing.T) {
+	logging.Setup()
+	gin.SetMode(gin.TestMode)
+
+	db, err := sqlite.NewSQLiteDB("file:" + t.TempDir() + "/idempotency_limit.db")
+	require.NoError(t, err)
+
+	called := false
+	router := gin.New()
+	router.Use(middleware.RequestID(), middleware.HandleErrors())
+	router.POST("/upload", middleware.Idempotent(db, time.Hour, 1<<10), func(c *gin.Context) {
+		called = true
+		c.Status(http.StatusNoContent)
+	})
+	send := func(size int) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/upload", strings.NewReader(strings.Repeat("a", size)))
+		req.Header.Set(middleware.IdempotencyKeyHeader, "upload")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// Bodies are only read up to the limit to fingerprint them
+	resp := send(1<<10 + 1)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.Code)
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body), resp.Body.String())
+	assert.Equal(t, "payload_too_large", body["code"])
+	assert.False(t, called)
+
+	assert.Equal(t, http.StatusNoContent, send(1<<10).Code)
+	assert.True(t, called)
+}
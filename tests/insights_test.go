@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"dietsense/internal/models"
+	"dietsense/internal/nutrition"
+	"dietsense/internal/services"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// insightDay builds a day summary with a single breakfast entry, or an empty
+// day when facts is nil.
+func insightDay(facts nutrition.Facts) *services.NutritionSummary {
+	day := &services.NutritionSummary{Totals: nutrition.Facts{}, Meals: map[string]*services.MealSummary{}}
+	if facts != nil {
+		day.Entries, day.DaysLogged, day.Totals = 1, 1, facts
+		day.Meals["breakfast"] = &services.MealSummary{Entries: 1, Totals: facts}
+	}
+	return day
+}
+
+func TestGenerateInsights(t *testing.T) {
+	salty := nutrition.Facts{
+		"calories": {Value: 2000, Unit: nutrition.UnitKcal},
+		"sodium":   {Value: 3100, Unit: nutrition.UnitMilligram},
+		"protein":  {Value: 10, Unit: nutrition.UnitGram},
+		"fiber":    {Value: 30, Unit: nutrition.UnitGram},
+	}
+	balanced := nutrition.Facts{
+		"calories": {Value: 1950, Unit: nutrition.UnitKcal},
+		"sodium":   {Value: 1800, Unit: nutrition.UnitMilligram},
+		"protein":  {Value: 12, Unit: nutrition.UnitGram},
+		"fiber":    {Value: 30, Unit: nutrition.UnitGram},
+	}
+	input := services.InsightInput{
+		Days: []*services.NutritionSummary{
+			insightDay(salty), insightDay(nil), insightDay(salty), insightDay(balanced),
+			insightDay(salty), insightDay(salty), insightDay(nil),
+		},
+		Goals: []models.Goal{{Nutrient: "calories", Type: models.GoalTarget, Value: 2000, Tolerance: 10}},
+	}
+
+	insights := services.GenerateInsights(input)
+	require.Len(t, insights, 4)
+
+	assert.Equal(t, "goal_missed", insights[0].Rule)
+	assert.Equal(t, "Sodium above limit on 4 of the last 7 days", insights[0].Message)
+	assert.Equal(t, "low_meal_protein", insights[1].Rule)
+	assert.Equal(t, "breakfast", insights[1].MealType)
+	assert.Equal(t, 5, insights[1].Days)
+	assert.Equal(t, services.SeverityInfo, insights[2].Severity)
+	assert.Equal(t, 2, insights[2].Days)
+	assert.Equal(t, services.SeverityPositive, insights[3].Severity)
+	assert.Equal(t, "Calories target met on 5 of the last 7 days", insights[3].Message)
+
+	assert.Equal(t, insights, services.GenerateInsights(input), "Insights should be deterministic")
+}
+
+func TestGenerateInsightsEmptyLog(t *testing.T) {
+	insights := services.GenerateInsights(services.InsightInput{Days: []*services.NutritionSummary{insightDay(nil)}})
+	assert.Empty(t, insights)
+}
+
+func TestGenerateInsightsSkipsUnreportedNutrients(t *testing.T) {
+	caloriesOnly := nutrition.Facts{"calories": {Value: 1900, Unit: nutrition.UnitKcal}}
+	lowFiber := nutrition.Facts{
+		"calories": {Value: 1900, Unit: nutrition.UnitKcal},
+		"fiber":    {Value: 10, Unit: nutrition.UnitGram},
+	}
+	days := []*services.NutritionSummary{
+		insightDay(caloriesOnly), insightDay(caloriesOnly), insightDay(caloriesOnly), insightDay(caloriesOnly),
+	}
+	assert.Empty(t, services.GenerateInsights(services.InsightInput{Days: days}),
+		"Missing fiber and protein are not a low intake")
+
+	// Only the days that report fiber decide whether its minimum is missed
+	days = append(days, insightDay(lowFiber), insightDay(lowFiber))
+	insights := services.GenerateInsights(services.InsightInput{Days: days})
+	require.Len(t, insights, 1)
+	assert.Equal(t, "Dietary Fiber below minimum on 2 of the last 6 days", insights[0].Message)
+	assert.Equal(t, 2, insights[0].OutOf)
+}
+
+func TestGenerateInsightsSkipsUnreportedEnergyShares(t *testing.T) {
+	caloriesOnly := nutrition.Facts{"calories": {Value: 1900, Unit: nutrition.UnitKcal}}
+	_, ok := caloriesOnly.EnergyShare("protein")
+	assert.False(t, ok, "a missing macronutrient has no share rather than 0%")
+
+	// Days with calories but no macronutrients do not miss an energy share
+	days := []*services.NutritionSummary{
+		insightDay(caloriesOnly), insightDay(caloriesOnly), insightDay(caloriesOnly), insightDay(caloriesOnly),
+	}
+	goals := []models.Goal{{Nutrient: "total_fat", Type: models.GoalEnergyShare, Value: 30, Tolerance: 5}}
+	assert.Empty(t, services.GenerateInsights(services.InsightInput{Days: days, Goals: goals}))
+}
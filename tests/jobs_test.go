@@ -0,0 +1,190 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"dietsense/internal/api"
+	"dietsense/internal/api/handlers"
+	"dietsense/internal/jobs"
+	"dietsense/internal/middleware"
+	"dietsense/internal/models"
+	"dietsense/internal/repositories/sqlite"
+	"dietsense/internal/services"
+	"dietsense/pkg/config"
+	"dietsense/pkg/logging"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSign(t *testing.T) {
+	// Reference value from: printf '1700000000.{"a":1}' | openssl dgst -sha256 -hmac secret
+	assert.Equal(t, "sha256=49f24e537407743fa4a0242bb63b94b9a47ee99cbbe071ccd8a22550ae411686",
+		jobs.Sign("secret", 1700000000, []byte(`{"a":1}`)))
+}
+
+func TestAnalysisJobWithWebhook(t *testing.T) {
+	logging.Setup()
+	gin.SetMode(gin.TestMode)
+
+	type delivery struct {
+		body      []byte
+		signature string
+		timestamp string
+	}
+	allowPrivate := config.Config.AllowPrivateWebhooks
+	config.Config.AllowPrivateWebhooks = true // the receiver listens on loopback
+	defer func() { config.Config.AllowPrivateWebhooks = allowPrivate }()
+
+	deliveries := make(chan delivery, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		deliveries <- delivery{body, r.Header.Get(jobs.SignatureHeader), r.Header.Get(jobs.TimestampHeader)}
+	}))
+	defer receiver.Close()
+
+	db, err := sqlite.NewSQLiteDB("file:" + t.TempDir() + "/jobs.db")
+	require.NoError(t, err)
+	require.NoError(t, db.SaveAPIKey(&models.APIKey{Key: "key", CallbackURL: receiver.URL, WebhookSecret: "secret"}))
+
+	router := gin.New()
+	factory := services.NewServiceFactory(&config.AppConfig{TextAnalyzerService: "mock", MockServiceType: "default"})
+	queue := jobs.NewQueue(db, 1, 10, handlers.RunAnalysisJob(factory, db))
+	queue.Start()
+	defer queue.Stop(context.Background())
+	api.SetupRoutes(router, factory, db, queue)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("context", "a bowl of porridge")
+	form.WriteField("async", "true")
+	form.Close()
+	req, _ := http.NewRequest("POST", "/api/v1/analyze", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set("X-API-Key", "key")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusAccepted, resp.Code, resp.Body.String())
+
+	var accepted struct {
+		JobID     string `json:"job_id"`
+		StatusURL string `json:"status_url"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &accepted))
+	assert.Equal(t, accepted.StatusURL, resp.Header().Get("Location"))
+
+	// The finished job is posted to the callback URL, signed with the secret
+	var received delivery
+	select {
+	case received = <-deliveries:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook was delivered")
+	}
+	timestamp, err := strconv.ParseInt(received.timestamp, 10, 64)
+	require.NoError(t, err)
+	assert.Equal(t, jobs.Sign("secret", timestamp, received.body), received.signature)
+
+	var event struct {
+		Event  string                 `json:"event"`
+		JobID  string                 `json:"job_id"`
+		Status string                 `json:"status"`
+		Result map[string]interface{} `json:"result"`
+	}
+	require.NoError(t, json.Unmarshal(received.body, &event))
+	assert.Equal(t, accepted.JobID, event.JobID)
+	assert.Equal(t, models.JobSucceeded, event.Status)
+	assert.NotEmpty(t, event.Result["analysis_id"])
+
+	// Polling returns the same outcome, once the delivery is recorded
+	require.Eventually(t, func() bool {
+		job, err := db.GetAnalysisJob("key", accepted.JobID)
+		return err == nil && job.WebhookStatus == models.WebhookDelivered
+	}, 5*time.Second, 10*time.Millisecond)
+	req, _ = http.NewRequest("GET", accepted.StatusURL, nil)
+	req.Header.Set("X-API-Key", "key")
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	var polled models.AnalysisJob
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &polled))
+	assert.Equal(t, models.JobSucceeded, polled.Status)
+	assert.Equal(t, event.Result["summary"], polled.Result["summary"])
+	assert.NotNil(t, polled.FinishedAt)
+}
+
+func TestPrivateCallbackURLs(t *testing.T) {
+	logging.Setup()
+	gin.SetMode(gin.TestMode)
+
+	allowPrivate := config.Config.AllowPrivateWebhooks
+	config.Config.AllowPrivateWebhooks = false
+	defer func() { config.Config.AllowPrivateWebhooks = allowPrivate }()
+
+	received := make(chan struct{}, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+	}))
+	defer receiver.Close()
+
+	db, err := sqlite.NewSQLiteDB("file:" + t.TempDir() + "/private_callbacks.db")
+	require.NoError(t, err)
+	require.NoError(t, db.SaveAPIKey(&models.APIKey{Key: "key"}))
+
+	router := gin.New()
+	factory := services.NewServiceFactory(&config.AppConfig{TextAnalyzerService: "mock", MockServiceType: "default"})
+	queue := jobs.NewQueue(db, 1, 10, handlers.RunAnalysisJob(factory, db))
+	queue.Start()
+	defer queue.Stop(context.Background())
+	api.SetupRoutes(router, factory, db, queue)
+
+	putWebhook := func(callbackURL string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PUT", "/api/v1/webhook", strings.NewReader(`{"callback_url": "`+callbackURL+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(middleware.APIKeyHeader, "key")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+	for _, callbackURL := range []string{
+		"http://127.0.0.1:8080/hook",
+		"http://localhost/hook",
+		"http://[::1]/hook",
+		"http://10.0.0.5/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::ffff:192.168.1.1]/hook",
+	} {
+		resp := putWebhook(callbackURL)
+		assert.Equal(t, http.StatusBadRequest, resp.Code, callbackURL)
+		assert.Contains(t, resp.Body.String(), "private or internal address", callbackURL)
+	}
+	assert.Equal(t, http.StatusOK, putWebhook("https://203.0.113.10/hook").Code)
+
+	// URLs saved before the check, or resolving elsewhere later, are refused
+	// when dialed, without retrying
+	apiKey, err := db.GetAPIKey("key")
+	require.NoError(t, err)
+	apiKey.CallbackURL = receiver.URL
+	require.NoError(t, db.SaveAPIKey(apiKey))
+
+	job := &models.AnalysisJob{ID: "private", APIKey: "key", Input: models.AnalysisJobInput{Context: "a bowl of porridge"}, CreatedAt: time.Now()}
+	require.NoError(t, queue.Enqueue(job))
+	require.Eventually(t, func() bool {
+		saved, err := db.GetAnalysisJob("key", job.ID)
+		return err == nil && saved.WebhookStatus == models.WebhookFailed
+	}, time.Second, 10*time.Millisecond)
+	select {
+	case <-received:
+		t.Fatal("the webhook reached a loopback address")
+	default:
+	}
+}
@@ -0,0 +1,121 @@
+package tests
+
+import (
+	"bytes"
+	"dietsense/internal/api"
+	"dietsense/internal/middleware"
+	"dietsense/internal/models"
+	"dietsense/internal/repositories/sqlite"
+	"dietsense/internal/services"
+	"dietsense/pkg/config"
+	"dietsense/pkg/logging"
+	"encoding/base64"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnalyzeJSON(t *testing.T) {
+	logging.Setup()
+	gin.SetMode(gin.TestMode)
+
+	db, err := sqlite.NewSQLiteDB("file:" + t.TempDir() + "/json.db")
+	require.NoError(t, err)
+	require.NoError(t, db.SaveAPIKey(&models.APIKey{Key: "key"}))
+	require.NoError(t, db.SaveAPIKey(&models.APIKey{Key: "other"}))
+
+	router := gin.New()
+	factory := services.NewServiceFactory(&config.AppConfig{
+		ImageClassifierService:   "mock",
+		FoodImageAnalyzerService: "mock",
+		TextAnalyzerService:      "mock",
+		MockServiceType:          "default",
+	})
+	api.SetupRoutes(router, factory, db, nil)
+
+	call := func(req *http.Request, apiKey string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		if apiKey != "" {
+			req.Header.Set(middleware.APIKeyHeader, apiKey)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body), resp.Body.String())
+		return resp, body
+	}
+	analyze := func(body, apiKey string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := httptest.NewRequest("POST", "/api/v1/analyze", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		return call(req, apiKey)
+	}
+	upload := func(image []byte, apiKey string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		file, _ := form.CreateFormFile("image", "meal.jpg")
+		file.Write(image)
+		form.Close()
+		req := httptest.NewRequest("POST", "/api/v1/blobs", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		return call(req, apiKey)
+	}
+	photo := base64.StdEncoding.EncodeToString([]byte("a photo"))
+
+	// Images can be sent base64 encoded, plain or as a data URL, alongside
+	// the same fields as forms
+	resp, body := analyze(`{"image": "`+photo+`", "context": "lunch", "meal_type": "lunch"}`, "")
+	assert.Equal(t, http.StatusOK, resp.Code, body)
+	assert.Equal(t, "lunch", body["meal_type"])
+	resp, body = analyze(`{"images": [{"data": "data:image/jpeg;base64,`+photo+`"}, {"data": "`+photo+`"}]}`, "")
+	assert.Equal(t, http.StatusOK, resp.Code, body)
+	assert.Len(t, body["images"], 2)
+
+	// Or uploaded first and referred to by ID
+	resp, blob := upload([]byte("a photo"), "key")
+	require.Equal(t, http.StatusCreated, resp.Code, blob)
+	assert.EqualValues(t, 7, blob["size"])
+	blobID := blob["blob_id"].(string)
+	resp, body = analyze(`{"blob_id": "`+blobID+`"}`, "key")
+	assert.Equal(t, http.StatusOK, resp.Code, body)
+
+	// Blobs are only visible to the key that uploaded them, and need one
+	resp, body = analyze(`{"blob_id": "`+blobID+`"}`, "other")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	assert.Equal(t, "not_found", body["code"])
+	resp, _ = analyze(`{"blob_id": "`+blobID+`"}`, "")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	resp, _ = upload([]byte("a photo"), "")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+
+	// Expired blobs are gone
+	require.NoError(t, db.SaveBlob(&models.Blob{ID: "old", APIKey: "key", Data: []byte("a photo"), CreatedAt: time.Now().Add(-48 * time.Hour)}))
+	resp, _ = analyze(`{"blob_id": "old"}`, "key")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	// Invalid images name their field
+	resp, body = analyze(`{"image": "not base64!"}`, "")
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Equal(t, "must be base64 encoded", body["fields"].(map[string]interface{})["image"])
+	resp, body = analyze(`{"images": [{}]}`, "")
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.NotEmpty(t, body["fields"].(map[string]interface{})["images"])
+	resp, _ = analyze(`{"image": ""}`, "")
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	resp, body = analyze(`{"image": "data:text/plain;base64,`+photo+`"}`, "")
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.Code)
+	assert.Equal(t, "unsupported_media_type", body["code"])
+
+	// Other content types are refused
+	req := httptest.NewRequest("POST", "/api/v1/analyze", strings.NewReader(`<image/>`))
+	req.Header.Set("Content-Type", "application/xml")
+	resp, body = call(req, "")
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.Code)
+	assert.Equal(t, "unsupported_media_type", body["code"])
+}
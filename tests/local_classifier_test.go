@@ -0,0 +1,219 @@
+package tests
+
+import (
+	"bytes"
+	"dietsense/internal/api"
+	"dietsense/internal/services"
+	"dietsense/pkg/config"
+	"dietsense/pkg/logging"
+	"dietsense/pkg/utils"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// eanPatterns are the L encodings of the EAN digits, 1 for a dark module.
+var eanPatterns = [10]string{
+	"0001101", "0011001", "0010011", "0111101", "0100011",
+	"0110001", "0101111", "0111011", "0110111", "0001011",
+}
+
+// eanParities are the L/G parities of the left digits by first digit.
+var eanParities = [10]string{
+	"LLLLLL", "LLGLGG", "LLGGLG", "LLGGGL", "LGLLGG",
+	"LGGLLG", "LGGGLL", "LGLGLG", "LGLGGL", "LGGLGL",
+}
+
+// eanImage draws an EAN-13 barcode with a quiet zone, scale pixels a module.
+func eanImage(code string, scale int, upsideDown bool) image.Image {
+	invert := func(p string) string {
+		out := []byte(p)
+		for i := range out {
+			out[i] = '0' + '1' - out[i]
+		}
+		return string(out)
+	}
+	reverse := func(p string) string {
+		out := []byte(p)
+		for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+			out[i], out[j] = out[j], out[i]
+		}
+		return string(out)
+	}
+	modules := "000000000101"
+	for i := 1; i <= 6; i++ {
+		pattern := eanPatterns[code[i]-'0']
+		if eanParities[code[0]-'0'][i-1] == 'G' {
+			pattern = reverse(invert(pattern))
+		}
+		modules += pattern
+	}
+	modules += "01010"
+	for i := 7; i <= 12; i++ {
+		modules += invert(eanPatterns[code[i]-'0'])
+	}
+	modules += "101000000000"
+	if upsideDown {
+		modules = reverse(modules)
+	}
+
+	img := image.NewGray(image.Rect(0, 0, len(modules)*scale, 60))
+	for x := 0; x < img.Bounds().Dx(); x++ {
+		shade := color.Gray{Y: 250}
+		if modules[x/scale] == '1' {
+			shade = color.Gray{Y: 20}
+		}
+		for y := 0; y < 60; y++ {
+			img.SetGray(x, y, shade)
+		}
+	}
+	return img
+}
+
+// labelImage draws a table of printed rows between dark rules.
+func labelImage() image.Image {
+	img := image.NewGray(image.Rect(0, 0, 300, 400))
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 300; x++ {
+			shade := uint8(245)
+			switch {
+			case y%40 < 3:
+				shade = 10 // A rule
+			case y%40 > 12 && y%40 < 28 && x > 10 && x < 200 && (x/2)%2 == 0:
+				shade = 10 // Strokes of text
+			}
+			img.SetGray(x, y, color.Gray{Y: shade})
+		}
+	}
+	return img
+}
+
+// photoImage draws a smooth, colorful plate of food.
+func photoImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 320, 240))
+	for y := 0; y < 240; y++ {
+		for x := 0; x < 320; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(120 + x/3), G: uint8(60 + y/2), B: 30, A: 255})
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+// oversizedPNG returns a PNG whose header declares 100000 by 100000 pixels,
+// as a decompression bomb would, with a valid header checksum.
+func oversizedPNG(t *testing.T) []byte {
+	data := encodePNG(t, photoImage())
+	binary.BigEndian.PutUint32(data[16:], 100000) // IHDR width
+	binary.BigEndian.PutUint32(data[20:], 100000) // IHDR height
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestLocalClassifier(t *testing.T) {
+	logging.Setup()
+
+	// Barcodes decode either way up, if their check digit matches
+	for _, code := range []string{"4006381333931", "0012345678905", "5901234123457"} {
+		decoded, ok := utils.DecodeEAN13(eanImage(code, 3, false))
+		assert.True(t, ok, code)
+		assert.Equal(t, code, decoded)
+		decoded, ok = utils.DecodeEAN13(eanImage(code, 2, true))
+		assert.True(t, ok, code)
+		assert.Equal(t, code, decoded)
+	}
+	_, ok := utils.DecodeEAN13(eanImage("4006381333932", 3, false))
+	assert.False(t, ok)
+
+	classifier := services.NewLocalClassifier()
+	for name, test := range map[string]struct {
+		data      []byte
+		inputType services.InputType
+		sure      bool
+	}{
+		"barcode":   {encodePNG(t, eanImage("4006381333931", 3, false)), services.InputTypeBarcode, true},
+		"label":     {encodePNG(t, labelImage()), services.InputTypeNutritionLabel, true},
+		"photo":     {encodePNG(t, photoImage()), services.InputTypeFoodImage, true},
+		"unknown":   {[]byte("not an image"), services.InputTypeFoodImage, false},
+		"too large": {oversizedPNG(t), services.InputTypeFoodImage, false},
+		"no table":  {encodePNG(t, image.NewGray(image.Rect(0, 0, 10, 10))), services.InputTypeFoodImage, false},
+	} {
+		inputType, sure := classifier.Classify(test.data)
+		assert.Equal(t, test.inputType, inputType, name)
+		assert.Equal(t, test.sure, sure, name)
+	}
+
+	// Standalone, images it is unsure of are food photos
+	inputType, err := classifier.ClassifyImage(bytes.NewReader([]byte("not an image")))
+	require.NoError(t, err)
+	assert.Equal(t, services.InputTypeFoodImage, inputType)
+}
+
+func TestLocalClassificationStrategy(t *testing.T) {
+	logging.Setup()
+	gin.SetMode(gin.TestMode)
+
+	// No model classifier is available, so only images the local classifier
+	// is sure of can be analyzed
+	cfg := &config.AppConfig{
+		ImageClassifierService:        "nope",
+		ClassificationStrategy:        "local",
+		FoodImageAnalyzerService:      "mock",
+		NutritionLabelAnalyzerService: "mock",
+		BarcodeAnalyzerService:        "mock",
+		MockServiceType:               "default",
+	}
+	router := gin.New()
+	api.SetupRoutes(router, services.NewServiceFactory(cfg), nil, nil)
+
+	analyze := func(images ...[]byte) (*httptest.ResponseRecorder, map[string]interface{}) {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		for _, image := range images {
+			file, _ := form.CreateFormFile("image", "image.png")
+			file.Write(image)
+		}
+		form.Close()
+		req := httptest.NewRequest("POST", "/api/v1/analyze", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		var result map[string]interface{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result), resp.Body.String())
+		return resp, result
+	}
+
+	resp, result := analyze(encodePNG(t, eanImage("4006381333931", 3, false)), encodePNG(t, photoImage()))
+	require.Equal(t, http.StatusOK, resp.Code, result)
+	assert.Equal(t, "local", result["classification"])
+	assert.EqualValues(t, services.InputTypeBarcode, result["input_type"])
+	images := result["images"].([]interface{})
+	assert.EqualValues(t, services.InputTypeFoodImage, images[1].(map[string]interface{})["input_type"])
+
+	// Images it is unsure of escalate to the classifier service
+	resp, _ = analyze([]byte("not an image"))
+	assert.Equal(t, http.StatusBadGateway, resp.Code)
+
+	// The local classifier can also be the classifier service
+	cfg.ClassificationStrategy, cfg.ImageClassifierService = "classifier", "local"
+	resp, result = analyze([]byte("not an image"))
+	require.Equal(t, http.StatusOK, resp.Code, result)
+	assert.Equal(t, "classifier", result["classification"])
+	assert.EqualValues(t, services.InputTypeFoodImage, result["input_type"])
+}
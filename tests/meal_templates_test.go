@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"dietsense/internal/api"
+	"dietsense/internal/middleware"
+	"dietsense/internal/models"
+	"dietsense/internal/nutrition"
+	"dietsense/internal/repositories/sqlite"
+	"dietsense/internal/services"
+	"dietsense/pkg/config"
+	"dietsense/pkg/logging"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComposeMeal(t *testing.T) {
+	oatmeal := &models.MealTemplate{
+		ID:            "oatmeal",
+		Name:          "Oatmeal",
+		NutritionInfo: nutrition.Facts{"calories": {Name: "Calories", Value: 300, Unit: nutrition.UnitKcal, Confidence: 0.9}},
+		Ingredients:   []string{"oats", "milk"},
+		Allergens:     []nutrition.AllergenFlag{{ID: "milk", Present: true, Confidence: 0.6}},
+		Diets: []nutrition.DietFlag{
+			{ID: "vegetarian", Compatible: true, Confidence: 0.8, Source: nutrition.SourceModel},
+			{ID: "vegan", Compatible: false, Confidence: 0.9, Source: nutrition.SourceIngredients},
+		},
+	}
+	banana := &models.MealTemplate{
+		ID:            "banana",
+		Name:          "Banana",
+		NutritionInfo: nutrition.Facts{"calories": {Name: "Calories", Value: 100, Unit: nutrition.UnitKcal, Confidence: 0.7}},
+		Ingredients:   []string{"Banana", "Milk"},
+		Allergens:     []nutrition.AllergenFlag{{ID: "milk", Present: true, Confidence: 0.9}},
+		Diets:         []nutrition.DietFlag{{ID: "vegetarian", Compatible: true, Confidence: 0.95, Source: nutrition.SourceModel}},
+	}
+
+	meal := services.ComposeMeal([]services.ScaledTemplate{{Template: oatmeal, Scale: 1}, {Template: banana, Scale: 0.5}})
+
+	assert.Equal(t, 350.0, meal.NutritionInfo["calories"].Value)
+	assert.Equal(t, 0.7, meal.NutritionInfo["calories"].Confidence)
+	assert.Equal(t, []string{"oats", "milk", "Banana"}, meal.Ingredients)
+	require.Len(t, meal.Allergens, 1)
+	assert.Equal(t, 0.9, meal.Allergens[0].Confidence, "The surest allergen verdict is kept")
+	require.Len(t, meal.Diets, 2)
+	assert.Equal(t, "vegetarian", meal.Diets[0].ID)
+	assert.True(t, meal.Diets[0].Compatible)
+	assert.Equal(t, 0.8, meal.Diets[0].Confidence, "A diet holds with the weakest verdict")
+	assert.Equal(t, "vegan", meal.Diets[1].ID)
+	assert.False(t, meal.Diets[1].Compatible, "An item that rules a diet out rules it out for the meal")
+	assert.Equal(t, 0.9, meal.Diets[1].Confidence)
+	assert.Equal(t, "Made of Oatmeal, Banana (x0.5)", meal.Summary)
+	assert.Equal(t, []models.MealComponent{{TemplateID: "oatmeal", Name: "Oatmeal", Scale: 1}, {TemplateID: "banana", Name: "Banana", Scale: 0.5}}, meal.Components)
+
+	// Diets that hold for some items only are dropped, those ruled out stay
+	// ruled out whatever the other items say
+	salad := &models.MealTemplate{ID: "salad", Name: "Salad", Diets: []nutrition.DietFlag{
+		{ID: "vegan", Compatible: true, Confidence: 0.9, Source: nutrition.SourceModel},
+		{ID: "gluten_free", Compatible: true, Confidence: 0.9, Source: nutrition.SourceModel},
+	}}
+	meal = services.ComposeMeal([]services.ScaledTemplate{{Template: salad, Scale: 1}, {Template: oatmeal, Scale: 1}})
+	require.Len(t, meal.Diets, 1)
+	assert.Equal(t, "vegan", meal.Diets[0].ID)
+	assert.False(t, meal.Diets[0].Compatible)
+	assert.Equal(t, nutrition.SourceIngredients, meal.Diets[0].Source)
+}
+
+func TestMealTemplateEndpoints(t *testing.T) {
+	logging.Setup()
+	gin.SetMode(gin.TestMode)
+
+	db, err := sqlite.NewSQLiteDB("file:" + t.TempDir() + "/templates.db")
+	require.NoError(t, err)
+	require.NoError(t, db.SaveAPIKey(&models.APIKey{Key: "key"}))
+	require.NoError(t, db.SaveAPIKey(&models.APIKey{Key: "other"}))
+	router := gin.New()
+	api.SetupRoutes(router, services.NewServiceFactory(&config.AppConfig{}), db, nil)
+
+	logEntry := func(id string, calories float64, diets []nutrition.DietFlag) {
+		entry := &models.FoodLogEntry{ID: id, APIKey: "key", Summary: id, InputType: "food_image", Diets: diets}
+		entry.SetEatenAt(time.Now())
+		entry.SetFacts(nutrition.Facts{"calories": {Name: "Calories", Value: calories, Unit: nutrition.UnitKcal, Confidence: 0.8}})
+		require.NoError(t, db.SaveFoodLogEntry(entry))
+	}
+	logEntry("oatmeal", 300, []nutrition.DietFlag{{ID: "vegan", Compatible: false, Confidence: 0.9, Source: nutrition.SourceIngredients}})
+	logEntry("banana", 100, []nutrition.DietFlag{{ID: "vegan", Compatible: true, Confidence: 0.9, Source: nutrition.SourceModel}})
+
+	call := func(method, path, body, apiKey string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(middleware.APIKeyHeader, apiKey)
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		var decoded map[string]interface{}
+		if resp.Code != http.StatusNoContent {
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &decoded), resp.Body.String())
+		}
+		return resp, decoded
+	}
+	calories := func(body map[string]interface{}) float64 {
+		return body["nutrition_info"].(map[string]interface{})["calories"].(map[string]interface{})["value"].(float64)
+	}
+
+	// Templates are made from log entries, with names unique regardless of case
+	resp, oatmeal := call("POST", "/api/v1/templates", `{"name": "Oatmeal", "log_entry_id": "oatmeal"}`, "key")
+	require.Equal(t, http.StatusCreated, resp.Code, oatmeal)
+	assert.Equal(t, 300.0, calories(oatmeal))
+	resp, body := call("POST", "/api/v1/templates", `{"name": " oatmeal ", "log_entry_id": "banana"}`, "key")
+	assert.Equal(t, http.StatusConflict, resp.Code)
+	assert.Equal(t, oatmeal["id"], body["template_id"])
+	resp, banana := call("POST", "/api/v1/templates", `{"name": "Banana", "log_entry_id": "banana"}`, "key")
+	require.Equal(t, http.StatusCreated, resp.Code, banana)
+
+	for _, invalid := range []string{
+		`{"name": "Nothing"}`,
+		`{"name": "Both", "log_entry_id": "banana", "analysis_id": "a"}`,
+		`{"name": "Brunch", "log_entry_id": "banana", "meal_type": "brunch"}`,
+		`{"name": "Huge", "components": [{"template_id": "` + banana["id"].(string) + `", "scale": 21}]}`,
+	} {
+		resp, _ := call("POST", "/api/v1/templates", invalid, "key")
+		assert.Equal(t, http.StatusBadRequest, resp.Code, invalid)
+	}
+	resp, _ = call("POST", "/api/v1/templates", `{"name": "Stolen", "log_entry_id": "banana"}`, "other")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	// Composed meals add up their scaled parts and keep diets ruled out
+	resp, bowl := call("POST", "/api/v1/templates", `{"name": "Breakfast bowl", "meal_type": "breakfast", "components": [
+		{"template_id": "`+oatmeal["id"].(string)+`"}, {"template_id": "`+banana["id"].(string)+`", "scale": 0.5}]}`, "key")
+	require.Equal(t, http.StatusCreated, resp.Code, bowl)
+	assert.Equal(t, 350.0, calories(bowl))
+	assert.Equal(t, "Made of Oatmeal, Banana (x0.5)", bowl["summary"])
+	assert.Len(t, bowl["components"], 2)
+	diets := bowl["diets"].([]interface{})
+	require.Len(t, diets, 1)
+	assert.Equal(t, false, diets[0].(map[string]interface{})["compatible"])
+	resp, _ = call("POST", "/api/v1/templates", `{"name": "Ghost", "components": [{"template_id": "missing"}]}`, "key")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	// Logging a template scales it
+	bowlPath := "/api/v1/templates/" + bowl["id"].(string)
+	resp, entry := call("POST", bowlPath+"/log", `{"scale": 2, "eaten_at": "2026-10-01T08:00:00+02:00"}`, "key")
+	require.Equal(t, http.StatusCreated, resp.Code, entry)
+	assert.Equal(t, 700.0, calories(entry))
+	assert.Equal(t, "breakfast", entry["meal_type"])
+	assert.Equal(t, bowl["id"], entry["template_id"])
+	assert.Equal(t, "Made of Oatmeal, Banana (x0.5) (x2)", entry["summary"])
+	stored, err := db.GetFoodLogEntry("key", entry["id"].(string))
+	require.NoError(t, err)
+	assert.Equal(t, 7200, stored.UTCOffset)
+
+	resp, _ = call("POST", bowlPath+"/log", "", "key")
+	assert.Equal(t, http.StatusCreated, resp.Code, "the body is optional")
+	resp, _ = call("POST", bowlPath+"/log", `{"scale": -1}`, "key")
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	resp, _ = call("POST", bowlPath+"/log", `{}`, "other")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	resp, _ = call("GET", bowlPath, "", "other")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+
+	// Renames keep names unique, deletes leave composed meals alone
+	resp, _ = call("PATCH", bowlPath, `{"name": "BANANA"}`, "key")
+	assert.Equal(t, http.StatusConflict, resp.Code)
+	resp, body = call("PATCH", bowlPath, `{"name": "Bowl", "meal_type": "snack"}`, "key")
+	require.Equal(t, http.StatusOK, resp.Code, body)
+	assert.Equal(t, "Bowl", body["name"])
+	resp, _ = call("DELETE", "/api/v1/templates/"+banana["id"].(string), "", "key")
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+	resp, body = call("GET", "/api/v1/templates", "", "key")
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Len(t, body["templates"], 2)
+	resp, body = call("GET", bowlPath, "", "key")
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, 350.0, calories(body))
+}
@@ -0,0 +1,86 @@
+package tests
+
+import (
+	"bytes"
+	"dietsense/internal/services"
+	"dietsense/pkg/utils"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// exifJPEG builds a JPEG header with an EXIF segment holding DateTimeOriginal
+// and, when given, OffsetTimeOriginal.
+func exifJPEG(dateTime, offset string) []byte {
+	order := binary.LittleEndian
+	tiff := &bytes.Buffer{}
+	tiff.WriteString("II")
+	binary.Write(tiff, order, uint16(42))
+	binary.Write(tiff, order, uint32(8))
+
+	entry := func(buf *bytes.Buffer, tag, kind uint16, count, value uint32) {
+		binary.Write(buf, order, tag)
+		binary.Write(buf, order, kind)
+		binary.Write(buf, order, count)
+		binary.Write(buf, order, value)
+	}
+
+	// IFD0 with a single pointer to the Exif IFD
+	exifIFD := uint32(8 + 2 + 12 + 4)
+	binary.Write(tiff, order, uint16(1))
+	entry(tiff, 0x8769, 4, 1, exifIFD)
+	binary.Write(tiff, order, uint32(0))
+
+	values := []string{dateTime + "\x00"}
+	tags := []uint16{0x9003}
+	if offset != "" {
+		values = append(values, offset+"\x00")
+		tags = append(tags, 0x9011)
+	}
+	at := exifIFD + 2 + uint32(12*len(tags)) + 4
+	binary.Write(tiff, order, uint16(len(tags)))
+	for i, tag := range tags {
+		entry(tiff, tag, 2, uint32(len(values[i])), at)
+		at += uint32(len(values[i]))
+	}
+	binary.Write(tiff, order, uint32(0))
+	for _, value := range values {
+		tiff.WriteString(value)
+	}
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	jpeg := []byte{0xFF, 0xD8, 0xFF, 0xE1}
+	jpeg = binary.BigEndian.AppendUint16(jpeg, uint16(len(segment)+2))
+	jpeg = append(jpeg, segment...)
+	return append(jpeg, 0xFF, 0xD9)
+}
+
+func TestImageCaptureTime(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	require.NoError(t, err)
+
+	taken, ok := utils.ImageCaptureTime(exifJPEG("2024:05:01 12:30:00", ""), tokyo)
+	require.True(t, ok)
+	assert.True(t, taken.Equal(time.Date(2024, 5, 1, 12, 30, 0, 0, tokyo)))
+
+	taken, ok = utils.ImageCaptureTime(exifJPEG("2024:05:01 12:30:00", "+02:00"), tokyo)
+	require.True(t, ok)
+	assert.True(t, taken.Equal(time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)), "The recorded offset wins over the caller's time zone")
+
+	_, ok = utils.ImageCaptureTime([]byte{0xFF, 0xD8, 0xFF, 0xD9}, tokyo)
+	assert.False(t, ok)
+}
+
+func TestInferMealType(t *testing.T) {
+	at := func(hour, minute int) time.Time { return time.Date(2024, 5, 1, hour, minute, 0, 0, time.UTC) }
+
+	assert.Equal(t, "breakfast", services.InferMealType(at(7, 45), ""))
+	assert.Equal(t, "lunch", services.InferMealType(at(12, 30), "en-US"))
+	assert.Equal(t, "snack", services.InferMealType(at(16, 0), ""))
+	assert.Equal(t, "dinner", services.InferMealType(at(19, 0), ""))
+	assert.Equal(t, "snack", services.InferMealType(at(19, 0), "es-ES"), "Dinner is later in Spain")
+	assert.Equal(t, "lunch", services.InferMealType(at(15, 0), "es"))
+}
@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"bytes"
+	"dietsense/internal/api"
+	"dietsense/internal/services"
+	"dietsense/pkg/config"
+	"dietsense/pkg/logging"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrimaryImage(t *testing.T) {
+	images := []services.LabeledImage{
+		{InputType: services.InputTypeFoodImage},
+		{InputType: services.InputTypeUnknown},
+		{InputType: services.InputTypeNutritionLabel},
+		{InputType: services.InputTypeBarcode},
+		{InputType: services.InputTypeNutritionLabel},
+	}
+	assert.Equal(t, 2, services.PrimaryImage(images), "the first nutrition label takes precedence")
+	assert.Equal(t, 0, services.PrimaryImage(images[3:4]), "a single image is its own primary")
+	assert.Equal(t, 0, services.PrimaryImage(images[:2]), "a food photo beats an unknown image")
+}
+
+func TestAnalyzeSeveralImages(t *testing.T) {
+	logging.Setup()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	factory := services.NewServiceFactory(&config.AppConfig{
+		ImageClassifierService:   "mock",
+		FoodImageAnalyzerService: "mock",
+		MockServiceType:          "default",
+	})
+	api.SetupRoutes(router, factory, nil, nil)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for _, name := range []string{"front.jpg", "back.jpg"} {
+		file, _ := form.CreateFormFile("images", name)
+		file.Write([]byte(name))
+	}
+	form.Close()
+
+	req, _ := http.NewRequest("POST", "/api/v1/analyze", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+	var result struct {
+		Summary string `json:"summary"`
+		Images  []struct {
+			Index     int `json:"index"`
+			InputType int `json:"input_type"`
+		} `json:"images"`
+	}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &result))
+	assert.Contains(t, result.Summary, "2 images", "both images go to the analyzer together")
+	require.Len(t, result.Images, 2)
+	assert.Equal(t, 1, result.Images[1].Index)
+	assert.Equal(t, int(services.InputTypeFoodImage), result.Images[1].InputType)
+}
@@ -0,0 +1,143 @@
+package tests
+
+import (
+	"dietsense/internal/nutrition"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupResolvesSynonyms(t *testing.T) {
+	for _, name := range []string{"Total Carbohydrates", "Carbs", "carbohydrate", "Kohlenhydrate", "Glucides", "of which sugars"} {
+		nutrient, ok := nutrition.Lookup(name)
+		assert.True(t, ok, "Expected %q to resolve", name)
+		if name == "of which sugars" {
+			assert.Equal(t, "sugars", nutrient.ID)
+		} else {
+			assert.Equal(t, "carbohydrates", nutrient.ID, "Unexpected nutrient for %q", name)
+		}
+	}
+
+	_, ok := nutrition.Lookup("Unobtainium")
+	assert.False(t, ok, "Unknown names should not resolve")
+}
+
+func TestConvertUnits(t *testing.T) {
+	vitaminD, _ := nutrition.Get("vitamin_d")
+
+	cases := []struct {
+		value    float64
+		from, to string
+		nutrient *nutrition.Nutrient
+		want     float64
+	}{
+		{418.4, "kJ", "kcal", nil, 100},
+		{1500, "milligrams", "g", nil, 1.5},
+		{2, "mg", "mcg", nil, 2000},
+		{400, "IU", "µg", vitaminD, 10},
+		{10, "μg", "IU", vitaminD, 400},
+	}
+	for _, c := range cases {
+		got, err := nutrition.Convert(c.value, c.from, c.to, c.nutrient)
+		assert.NoError(t, err)
+		assert.InDelta(t, c.want, got, 1e-9, "%v %s -> %s", c.value, c.from, c.to)
+	}
+
+	_, err := nutrition.Convert(1, "g", "kcal", nil)
+	assert.Error(t, err, "Mass cannot be converted to energy")
+	_, err = nutrition.Convert(1, "IU", "mg", nil)
+	assert.Error(t, err, "IU needs a nutrient")
+}
+
+func TestNormalizeMergesAndConverts(t *testing.T) {
+	facts := nutrition.Normalize([]nutrition.Component{
+		{Name: "Energy", Value: 836.8, Unit: "kJ", Confidence: 0.9},
+		{Name: "Calories", Value: 150, Unit: "kcal", Confidence: 0.5},
+		{Name: "Sodium", Value: "0.4 g", Confidence: 0.8},
+		{Name: "Vitamin C", Value: 10, Unit: "%", Confidence: 0.8},
+		{Name: "Omega 3", Value: 1.2, Unit: "grams", Confidence: 0.4},
+	})
+
+	assert.Equal(t, nutrition.Amount{Name: "Calories", Value: 200, Unit: "kcal", Confidence: 0.9}, facts["calories"])
+	assert.Equal(t, 400.0, facts["sodium"].Value)
+	assert.Equal(t, "mg", facts["sodium"].Unit)
+	assert.NotContains(t, facts, "vitamin_c", "Percent values cannot be converted to an amount")
+	assert.Equal(t, nutrition.Amount{Name: "Omega 3", Value: 1.2, Unit: "g", Confidence: 0.4}, facts["omega_3"])
+}
+
+func TestParseValueSeparators(t *testing.T) {
+	cases := []struct {
+		value string
+		want  float64
+		unit  string
+	}{
+		{"12,5 g", 12.5, "g"},
+		{"0,250 g", 0.25, "g"},
+		{"1.5g", 1.5, "g"},
+		{"1,250 kJ", 1250, "kJ"},
+		{"1,000", 1000, ""},
+		{"1,250.5 mg", 1250.5, "mg"},
+		{"1.250,5 mg", 1250.5, "mg"},
+		{"1.000.000 IU", 1000000, "IU"},
+		{"2 000 kJ", 2000, "kJ"},
+		{"2\u2009000 kJ", 2000, "kJ"},
+		{"<1 g", 1, "g"},
+		{"~250kcal", 250, "kcal"},
+		{"3 g", 3, "g"},
+	}
+	for _, c := range cases {
+		got, unit, ok := nutrition.ParseValue(c.value)
+		assert.True(t, ok, c.value)
+		assert.Equal(t, c.want, got, c.value)
+		assert.Equal(t, c.unit, unit, c.value)
+	}
+
+	_, _, ok := nutrition.ParseValue("traces")
+	assert.False(t, ok)
+}
+
+func TestDetectAllergensFromLabel(t *testing.T) {
+	ingredients := []string{
+		"Oat flakes", "sugar", "cocoa butter", "coconut milk", "skimmed milk powder", "gluten-free wheat starch",
+		"May contain traces of hazelnuts and peanuts.",
+	}
+	flags := nutrition.DetectAllergens(ingredients, nutrition.LabelIngredientConfidence)
+
+	byID := make(map[string]nutrition.AllergenFlag)
+	for _, flag := range flags {
+		byID[flag.ID] = flag
+	}
+	assert.Equal(t, []string{"wheat", "oat"}, byID["gluten"].Evidence, "Only the word before 'free' is negated")
+	assert.Equal(t, []string{"milk"}, byID["milk"].Evidence, "Cocoa butter and coconut milk are not dairy")
+	assert.Equal(t, nutrition.LabelIngredientConfidence, byID["milk"].Confidence)
+	assert.Equal(t, 0.5, byID["tree_nuts"].Confidence, "Precautionary statements lower the confidence")
+	assert.Contains(t, byID, "peanuts")
+	assert.Equal(t, []string{"wheat"}, byID["wheat"].Evidence, "Gluten-free wheat starch still contains wheat")
+}
+
+func TestAssessDiets(t *testing.T) {
+	ingredients := []string{"chickpeas", "tahini", "olive oil", "lemon juice", "honey"}
+	allergens := nutrition.DetectAllergens(ingredients, nutrition.LabelIngredientConfidence)
+	facts := nutrition.Facts{
+		"calories":      {Value: 200, Unit: "kcal", Confidence: 0.9},
+		"carbohydrates": {Value: 20, Unit: "g", Confidence: 0.9},
+		"fiber":         {Value: 5, Unit: "g", Confidence: 0.9},
+	}
+	flags := nutrition.AssessDiets(ingredients, allergens, facts, nutrition.LabelIngredientConfidence)
+
+	byID := make(map[string]nutrition.DietFlag)
+	for _, flag := range flags {
+		byID[flag.ID] = flag
+	}
+	assert.False(t, byID["vegan"].Compatible, "Honey is not vegan")
+	assert.True(t, byID["vegetarian"].Compatible)
+	assert.True(t, byID["gluten_free"].Compatible)
+	assert.False(t, byID["keto_friendly"].Compatible, "30% of energy from net carbs is not keto")
+
+	merged := nutrition.MergeDiets([]nutrition.DietFlag{{ID: "vegan", Compatible: true, Confidence: 0.9, Source: nutrition.SourceModel}}, flags)
+	for _, flag := range merged {
+		if flag.ID == "vegan" {
+			assert.False(t, flag.Compatible, "Ingredient evidence overrides the model")
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"dietsense/internal/api"
+	"dietsense/internal/api/handlers"
+	"dietsense/internal/jobs"
+	"dietsense/internal/middleware"
+	"dietsense/internal/models"
+	"dietsense/internal/repositories/sqlite"
+	"dietsense/internal/services"
+	"dietsense/pkg/config"
+	"dietsense/pkg/logging"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api.SetupRoutes(router, services.NewServiceFactory(&config.AppConfig{}), nil, nil)
+	spec := api.Spec()
+
+	routed := map[string]bool{}
+	for _, route := range router.Routes() {
+		routed[route.Method+" "+route.Path] = true
+		assert.NotNil(t, spec.Operation(route.Method, route.Path), "%s %s is not documented", route.Method, route.Path)
+	}
+	for path, item := range spec.Paths {
+		for method := range item {
+			ginPath := strings.ReplaceAll(path, "{id}", ":id")
+			assert.True(t, routed[strings.ToUpper(method)+" "+ginPath], "%s %s is documented but not routed", method, path)
+		}
+	}
+
+	// The served document is the one checked here
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest("GET", "/api/v1/openapi.json", nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+	expected, _ := json.Marshal(spec)
+	assert.JSONEq(t, string(expected), resp.Body.String())
+
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest("GET", "/api/v1/docs", nil))
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Body.String(), "openapi.json")
+}
+
+// contractClient sends requests to the API and checks every response against
+// the OpenAPI document.
+type contractClient struct {
+	t      *testing.T
+	router *gin.Engine
+	apiKey string
+}
+
+// call sends a request to a route, with its parameters filled in path, and
+// returns the decoded response after checking it against the document.
+func (cc *contractClient) call(method, route, path string, contentType string, body io.Reader, status int) map[string]interface{} {
+	cc.t.Helper()
+	req := httptest.NewRequest(method, path, body)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if cc.apiKey != "" {
+		req.Header.Set(middleware.APIKeyHeader, cc.apiKey)
+	}
+	resp := httptest.NewRecorder()
+	cc.router.ServeHTTP(resp, req)
+	require.Equal(cc.t, status, resp.Code, "%s %s: %s", method, path, resp.Body.String())
+	require.NoError(cc.t, api.Spec().ValidateResponse(method, route, resp.Code, resp.Body.Bytes()), "%s %s: %s", method, path, resp.Body.String())
+
+	var decoded map[string]interface{}
+	if resp.Body.Len() > 0 {
+		require.NoError(cc.t, json.Unmarshal(resp.Body.Bytes(), &decoded))
+	}
+	return decoded
+}
+
+func (cc *contractClient) json(method, route, path, body string, status int) map[string]interface{} {
+	cc.t.Helper()
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	return cc.call(method, route, path, "application/json", reader, status)
+}
+
+func (cc *contractClient) form(route, path string, fields map[string]string, image []byte, status int) map[string]interface{} {
+	cc.t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, value := range fields {
+		form.WriteField(name, value)
+	}
+	if image != nil {
+		file, _ := form.CreateFormFile("image", "meal.jpg")
+		file.Write(image)
+	}
+	form.Close()
+	return cc.call("POST", route, path, form.FormDataContentType(), &body, status)
+}
+
+func TestOpenAPIContract(t *testing.T) {
+	logging.Setup()
+	gin.SetMode(gin.TestMode)
+
+	allowedIPs := config.Config.AllowedIPs
+	config.Config.AllowedIPs = []string{"192.0.2.1"} // httptest.NewRequest's client address
+	defer func() { config.Config.AllowedIPs = allowedIPs }()
+
+	db, err := sqlite.NewSQLiteDB("file:" + t.TempDir() + "/contract.db")
+	require.NoError(t, err)
+	router := gin.New()
+	factory := services.NewServiceFactory(&config.AppConfig{
+		ImageClassifierService:   "mock",
+		FoodImageAnalyzerService: "mock",
+		TextAnalyzerService:      "mock",
+		DefaultAnalyzerService:   "mock",
+		MockServiceType:          "default",
+	})
+	queue := jobs.NewQueue(db, 1, 10, handlers.RunAnalysisJob(factory, db))
+	queue.Start()
+	defer queue.Stop(context.Background())
+	api.SetupRoutes(router, factory, db, queue)
+	cc := &contractClient{t: t, router: router}
+
+	cc.json("GET", "/ping", "/ping", "", http.StatusOK)
+	key := cc.json("POST", "/api/v1/generate-api-key", "/api/v1/generate-api-key",
+		`{"email": "contract@example.com", "rate_limit_per_hour": 100, "callback_url": "http://203.0.113.10/hook"}`, http.StatusOK)
+	cc.apiKey = key["api_key"].(string)
+
+	// Preferences and webhook
+	cc.json("PUT", "/api/v1/config", "/api/v1/config", `{"daily_value_region": "fda", "allergies": ["milk"]}`, http.StatusOK)
+	cc.json("GET", "/api/v1/config", "/api/v1/config", "", http.StatusOK)
+	cc.json("GET", "/api/v1/webhook", "/api/v1/webhook", "", http.StatusOK)
+	cc.json("PUT", "/api/v1/webhook", "/api/v1/webhook", `{"callback_url": "http://203.0.113.10/other"}`, http.StatusOK)
+	cc.json("DELETE", "/api/v1/webhook", "/api/v1/webhook", "", http.StatusNoContent)
+	cc.json("GET", "/api/v1/webhook", "/api/v1/webhook", "", http.StatusNotFound)
+
+	// Analyses
+	analysis := cc.form("/api/v1/analyze", "/api/v1/analyze", map[string]string{"context": "a glass of milk", "save": "true"}, nil, http.StatusOK)
+	cc.form("/api/v1/analyze", "/api/v1/analyze", map[string]string{"meal_type": "lunch"}, []byte("a photo"), http.StatusOK)
+	cc.form("/api/v1/analyze", "/api/v1/analyze", map[string]string{"input_type": "food_image"}, []byte("a photo"), http.StatusOK)
+	cc.form("/api/v1/analyze", "/api/v1/analyze", map[string]string{"meal_type": "brunch"}, nil, http.StatusBadRequest)
+	job := cc.form("/api/v1/analyze", "/api/v1/analyze", map[string]string{"context": "toast", "async": "true"}, nil, http.StatusAccepted)
+	blob := cc.form("/api/v1/blobs", "/api/v1/blobs", nil, []byte("a photo"), http.StatusCreated)
+	cc.json("POST", "/api/v1/analyze", "/api/v1/analyze", `{"blob_id": "`+blob["blob_id"].(string)+`", "context": "soup"}`, http.StatusOK)
+	cc.json("POST", "/api/v1/analyze", "/api/v1/analyze", `{"images": [{"data": "YSBwaG90bw=="}]}`, http.StatusOK)
+	cc.json("POST", "/api/v1/analyze", "/api/v1/analyze", `{"blob_id": "missing"}`, http.StatusNotFound)
+	require.Eventually(t, func() bool {
+		job, err := db.GetAnalysisJob(cc.apiKey, job["job_id"].(string))
+		return err == nil && job.Finished()
+	}, 5*time.Second, 10*time.Millisecond)
+	cc.json("GET", "/api/v1/jobs/:id", "/api/v1/jobs/"+job["job_id"].(string), "", http.StatusOK)
+	cc.json("POST", "/api/v1/analyze/batch", "/api/v1/analyze/batch",
+		`{"items": [{"context": "an apple"}, {"context": "tea", "meal_type": "never"}]}`, http.StatusOK)
+	cc.json("POST", "/api/v1/analyze/recipe", "/api/v1/analyze/recipe",
+		`{"text": "200 g cooked rice\n1 tbsp olive oil\n1 pinch of something unusual", "servings": 2}`, http.StatusOK)
+	analysisID := analysis["analysis_id"].(string)
+	cc.json("POST", "/api/v1/analyses/:id/refine", "/api/v1/analyses/"+analysisID+"/refine", `{"correction": "it was a large glass"}`, http.StatusOK)
+
+	// Food log
+	entryID := analysis["log_entry_id"].(string)
+	cc.json("GET", "/api/v1/logs", "/api/v1/logs?limit=1", "", http.StatusOK)
+	cc.json("GET", "/api/v1/logs/:id", "/api/v1/logs/"+entryID, "", http.StatusOK)
+	cc.json("PATCH", "/api/v1/logs/:id", "/api/v1/logs/"+entryID, `{"summary": "A large glass of milk"}`, http.StatusOK)
+	cc.json("GET", "/api/v1/summaries", "/api/v1/summaries?period=week", "", http.StatusOK)
+	cc.json("GET", "/api/v1/insights", "/api/v1/insights?narrative=true", "", http.StatusOK)
+
+	// Goals and profile
+	goal := cc.json("POST", "/api/v1/goals", "/api/v1/goals", `{"nutrient": "protein", "type": "min", "value": 60}`, http.StatusCreated)
+	goalID := goal["id"].(string)
+	cc.json("GET", "/api/v1/goals", "/api/v1/goals", "", http.StatusOK)
+	cc.json("GET", "/api/v1/goals/:id", "/api/v1/goals/"+goalID, "", http.StatusOK)
+	cc.json("PUT", "/api/v1/goals/:id", "/api/v1/goals/"+goalID, `{"nutrient": "protein", "type": "min", "value": 70}`, http.StatusOK)
+	cc.json("GET", "/api/v1/goals/progress", "/api/v1/goals/progress", "", http.StatusOK)
+	cc.json("DELETE", "/api/v1/goals/:id", "/api/v1/goals/"+goalID, "", http.StatusNoContent)
+	cc.json("PUT", "/api/v1/profile", "/api/v1/profile",
+		`{"age": 35, "sex": "female", "height_cm": 168, "weight_kg": 62, "activity_level": "moderate", "objective": "maintain"}`, http.StatusOK)
+	cc.json("GET", "/api/v1/profile", "/api/v1/profile", "", http.StatusOK)
+	cc.json("POST", "/api/v1/profile/weights", "/api/v1/profile/weights", `{"weight_kg": 61.5}`, http.StatusCreated)
+	cc.json("GET", "/api/v1/profile/weights", "/api/v1/profile/weights", "", http.StatusOK)
+	cc.json("GET", "/api/v1/profile/suggestions", "/api/v1/profile/suggestions", "", http.StatusOK)
+	cc.json("POST", "/api/v1/profile/suggestions/adopt", "/api/v1/profile/suggestions/adopt", "", http.StatusOK)
+
+	// Meal templates
+	template := cc.json("POST", "/api/v1/templates", "/api/v1/templates", `{"name": "Milk", "log_entry_id": "`+entryID+`"}`, http.StatusCreated)
+	templateID := template["id"].(string)
+	cc.json("GET", "/api/v1/templates", "/api/v1/templates", "", http.StatusOK)
+	cc.json("GET", "/api/v1/templates/:id", "/api/v1/templates/"+templateID, "", http.StatusOK)
+	cc.json("PATCH", "/api/v1/templates/:id", "/api/v1/templates/"+templateID, `{"meal_type": "snack"}`, http.StatusOK)
+	cc.json("POST", "/api/v1/templates/:id/log", "/api/v1/templates/"+templateID+"/log", `{"scale": 2}`, http.StatusCreated)
+	cc.json("DELETE", "/api/v1/templates/:id", "/api/v1/templates/"+templateID, "", http.StatusNoContent)
+
+	cc.json("DELETE", "/api/v1/logs/:id", "/api/v1/logs/"+entryID, "", http.StatusNoContent)
+	cc.json("GET", "/api/v1/logs/:id", "/api/v1/logs/"+entryID, "", http.StatusNotFound)
+}
+
+func TestOpenAPIValidateCatchesDrift(t *testing.T) {
+	spec := api.Spec()
+	goal := `{"id": "g", "nutrient": "protein", "type": "min", "value": 60, "unit": "g", "tolerance": 0,
+		"created_at": "2024-01-01T00:00:00Z", "updated_at": "2024-01-01T00:00:00Z"}`
+	assert.NoError(t, spec.ValidateResponse("GET", "/api/v1/goals/:id", http.StatusOK, []byte(goal)))
+
+	// An undocumented field, a field of the wrong type and a missing one all fail
+	assert.Error(t, spec.ValidateResponse("GET", "/api/v1/goals/:id", http.StatusOK,
+		[]byte(strings.Replace(goal, `"id": "g"`, `"id": "g", "priority": 1`, 1))))
+	assert.Error(t, spec.ValidateResponse("GET", "/api/v1/goals/:id", http.StatusOK,
+		[]byte(strings.Replace(goal, `"value": 60`, `"value": "60"`, 1))))
+	assert.Error(t, spec.ValidateResponse("GET", "/api/v1/webhook", http.StatusOK, []byte(`{}`)))
+	assert.Error(t, spec.ValidateResponse("GET", "/api/v1/unknown", http.StatusOK, []byte(`{}`)))
+
+	var job models.AnalysisJob
+	require.NoError(t, json.Unmarshal([]byte(`{"job_id": "j", "status": "queued"}`), &job))
+	encoded, _ := json.Marshal(job)
+	assert.NoError(t, spec.ValidateResponse("GET", "/api/v1/jobs/:id", http.StatusOK, encoded))
+}
@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"dietsense/internal/nutrition"
+	"dietsense/internal/services"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseIngredient(t *testing.T) {
+	cases := []struct {
+		line   string
+		amount float64
+		unit   string
+		food   string
+	}{
+		{"2 tbsp olive oil", 2, nutrition.UnitTablespoon, "olive oil"},
+		{"1 1/2 cups cooked rice", 1.5, nutrition.UnitCup, "cooked rice"},
+		{"½ onion, chopped", 0.5, "", "onion, chopped"},
+		{"- 200g chicken breast", 200, nutrition.UnitGram, "chicken breast"},
+		{"a pinch of salt", 1, nutrition.UnitPinch, "salt"},
+		{"2-3 cloves garlic", 2.5, nutrition.UnitClove, "garlic"},
+		{"2 large eggs", 2, "", "eggs"},
+		{"0,5 l milk", 0.5, nutrition.UnitLiter, "milk"},
+		{"1,000 g flour", 1000, nutrition.UnitGram, "flour"},
+		{"1 500 g potatoes", 1500, nutrition.UnitGram, "potatoes"},
+		{"salt and pepper to taste", 0, "", "salt and pepper to taste"},
+	}
+	for _, tc := range cases {
+		q := nutrition.ParseIngredient(tc.line)
+		assert.Equal(t, tc.amount, q.Amount, tc.line)
+		assert.Equal(t, tc.unit, q.Unit, tc.line)
+		assert.Equal(t, tc.food, q.Food, tc.line)
+	}
+
+	eggs := nutrition.ParseIngredient("2 large eggs")
+	egg, ok := nutrition.LookupFood(eggs.Food)
+	require.True(t, ok)
+	grams, ok := eggs.GramsOf(egg)
+	require.True(t, ok)
+	assert.Equal(t, 125.0, grams, "Large eggs weigh more than the table's default")
+
+	can := nutrition.ParseIngredient("1 can (400 g) chickpeas, drained")
+	grams, ok = can.GramsOf(nil)
+	require.True(t, ok)
+	assert.Equal(t, 400.0, grams)
+}
+
+func TestLookupFoodPrefersLongestMatch(t *testing.T) {
+	food, ok := nutrition.LookupFood("uncooked white rice")
+	require.True(t, ok)
+	assert.Equal(t, "rice_raw", food.ID)
+
+	food, ok = nutrition.LookupFood("Peanut butter, smooth")
+	require.True(t, ok)
+	assert.Equal(t, "peanut_butter", food.ID)
+
+	_, ok = nutrition.LookupFood("dragon fruit")
+	assert.False(t, ok)
+}
+
+// fixedEstimator estimates 50 g and 100 kcal per line. With answer set it
+// only answers for those lines, in that order.
+type fixedEstimator struct {
+	lines  []string
+	answer []string
+}
+
+func (e *fixedEstimator) EstimateIngredients(lines []string) ([]services.IngredientEstimate, error) {
+	e.lines = lines
+	answer := e.answer
+	if answer == nil {
+		answer = lines
+	}
+	estimates := make([]services.IngredientEstimate, len(answer))
+	for i, line := range answer {
+		estimates[i] = services.IngredientEstimate{Ingredient: line, Grams: 50, NutritionInfo: nutrition.Facts{"calories": {Name: "Calories", Value: 100, Unit: nutrition.UnitKcal, Confidence: 0.5}}}
+	}
+	return estimates, nil
+}
+
+func TestAnalyzeRecipe(t *testing.T) {
+	lines := []string{"2 tbsp olive oil", "1 cup cooked rice", "1 dragon fruit"}
+	quantities := make([]nutrition.IngredientQuantity, len(lines))
+	for i, line := range lines {
+		quantities[i] = nutrition.ParseIngredient(line)
+	}
+
+	analysis := services.AnalyzeRecipe(quantities, 2, nil)
+	assert.Equal(t, 1, analysis.Unresolved)
+	assert.Equal(t, services.IngredientSourceUnresolved, analysis.Ingredients[2].Source)
+	oil := analysis.Ingredients[0]
+	assert.Equal(t, services.IngredientSourceTable, oil.Source)
+	assert.InDelta(t, 26.9, oil.Grams, 0.1)
+	assert.InDelta(t, 238, oil.NutritionInfo["calories"].Value, 1)
+
+	estimator := &fixedEstimator{}
+	analysis = services.AnalyzeRecipe(quantities, 2, estimator)
+	assert.Equal(t, []string{"1 dragon fruit"}, estimator.lines, "Only unresolved ingredients go to the model")
+	assert.Equal(t, 0, analysis.Unresolved)
+	assert.Equal(t, services.IngredientSourceModel, analysis.Ingredients[2].Source)
+
+	total := analysis.Total["calories"].Value
+	assert.InDelta(t, 238+206+100, total, 2)
+	assert.InDelta(t, total/2, analysis.PerServing["calories"].Value, 0.01)
+	var shares float64
+	for _, ingredient := range analysis.Ingredients {
+		shares += ingredient.CalorieShare
+	}
+	assert.InDelta(t, 100, shares, 0.2)
+
+	// Estimates are matched by line, whatever their order, and lines left out
+	// stay unresolved
+	lines = []string{"1 dragon fruit", "3 rambutans", "1 dragon fruit", "2 mangosteens"}
+	quantities = make([]nutrition.IngredientQuantity, len(lines))
+	for i, line := range lines {
+		quantities[i] = nutrition.ParseIngredient(line)
+	}
+	estimator = &fixedEstimator{answer: []string{"2 Mangosteens", "1 dragon fruit", "a durian", "1  dragon fruit"}}
+	analysis = services.AnalyzeRecipe(quantities, 1, estimator)
+	assert.Equal(t, lines, estimator.lines)
+	sources := make([]string, len(analysis.Ingredients))
+	for i, ingredient := range analysis.Ingredients {
+		sources[i] = ingredient.Source
+	}
+	assert.Equal(t, []string{services.IngredientSourceModel, services.IngredientSourceUnresolved, services.IngredientSourceModel, services.IngredientSourceModel}, sources)
+	assert.Equal(t, 1, analysis.Unresolved)
+	assert.Equal(t, 300.0, analysis.Total["calories"].Value)
+}
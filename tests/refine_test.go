@@ -0,0 +1,148 @@
+package tests
+
+import (
+	"bytes"
+	"dietsense/internal/api"
+	"dietsense/internal/middleware"
+	"dietsense/internal/models"
+	"dietsense/internal/repositories/sqlite"
+	"dietsense/internal/services"
+	"dietsense/pkg/apperrors"
+	"dietsense/pkg/config"
+	"dietsense/pkg/logging"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefineAnalysis(t *testing.T) {
+	logging.Setup()
+	gin.SetMode(gin.TestMode)
+
+	thumbnailSize, retention := config.Config.ThumbnailSize, config.Config.AnalysisImageRetention
+	config.Config.ThumbnailSize = 32
+	defer func() { config.Config.ThumbnailSize, config.Config.AnalysisImageRetention = thumbnailSize, retention }()
+
+	db, err := sqlite.NewSQLiteDB("file:" + t.TempDir() + "/refine.db")
+	require.NoError(t, err)
+	require.NoError(t, db.SaveAPIKey(&models.APIKey{Key: "key"}))
+	require.NoError(t, db.SaveAPIKey(&models.APIKey{Key: "other"}))
+
+	router := gin.New()
+	factory := services.NewServiceFactory(&config.AppConfig{
+		ImageClassifierService:   "mock",
+		FoodImageAnalyzerService: "mock",
+		TextAnalyzerService:      "mock",
+		MockServiceType:          "default",
+	})
+	api.SetupRoutes(router, factory, db, nil)
+
+	call := func(req *http.Request, apiKey string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		if apiKey != "" {
+			req.Header.Set(middleware.APIKeyHeader, apiKey)
+		}
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body), resp.Body.String())
+		return resp, body
+	}
+	analyze := func(apiKey string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		file, _ := form.CreateFormFile("image", "meal.png")
+		file.Write(encodePNG(t, photoImage()))
+		form.Close()
+		req := httptest.NewRequest("POST", "/api/v1/analyze", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		return call(req, apiKey)
+	}
+	refine := func(id, correction, apiKey string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		body, _ := json.Marshal(map[string]string{"correction": correction})
+		req := httptest.NewRequest("POST", "/api/v1/analyses/"+id+"/refine", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		return call(req, apiKey)
+	}
+	calories := func(body map[string]interface{}) float64 {
+		return body["nutrition_info"].(map[string]interface{})["calories"].(map[string]interface{})["value"].(float64)
+	}
+
+	// Unknown keys are refused, anonymous callers get no stored analysis
+	resp, body := analyze("unknown")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	assert.Equal(t, "Invalid API key", body["error"])
+	resp, body = analyze("")
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.NotContains(t, body, "analysis_id")
+
+	// Analyses keep the image as a hash and thumbnail only
+	resp, original := analyze("key")
+	require.Equal(t, http.StatusOK, resp.Code)
+	analysisID := original["analysis_id"].(string)
+	stored, err := db.GetAnalysis(analysisID)
+	require.NoError(t, err)
+	assert.Len(t, stored.ImageHash, 64)
+	assert.NotEmpty(t, stored.Thumbnail)
+	assert.Empty(t, stored.Image)
+
+	// Each refinement is a new revision of the previous one, sent with the
+	// thumbnail
+	resp, first := refine(analysisID, "It was a small portion", "key")
+	require.Equal(t, http.StatusOK, resp.Code, first)
+	assert.Equal(t, analysisID, first["parent_id"])
+	assert.EqualValues(t, 1, first["revision"])
+	assert.EqualValues(t, services.InputTypeFoodImage, first["input_type"])
+	assert.Contains(t, first["summary"], "It was a small portion")
+	assert.Less(t, calories(first), calories(original))
+	assert.NotEmpty(t, first["changes"])
+
+	resp, second := refine(first["analysis_id"].(string), "No dressing", "key")
+	require.Equal(t, http.StatusOK, resp.Code, second)
+	assert.Equal(t, first["analysis_id"], second["parent_id"])
+	assert.EqualValues(t, 2, second["revision"])
+	assert.Less(t, calories(second), calories(first))
+
+	// Analyses of other keys, and callers without one, are refused
+	resp, _ = refine(analysisID, "More rice", "other")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	resp, _ = refine("missing", "More rice", "key")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	resp, _ = refine(analysisID, "More rice", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	req := httptest.NewRequest("POST", "/api/v1/analyses/"+analysisID+"/refine", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, body = call(req, "key")
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Equal(t, "is required", body["fields"].(map[string]interface{})["correction"])
+	resp, body = refine(analysisID, strings.Repeat("a", 4001), "key")
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Equal(t, "must be at most 4000 characters", body["fields"].(map[string]interface{})["correction"])
+
+	// With a retention, full images are kept for that long
+	config.Config.AnalysisImageRetention = time.Hour
+	old := &models.Analysis{ID: "old", APIKey: "key", Image: []byte("photo"), CreatedAt: time.Now().Add(-2 * time.Hour)}
+	require.NoError(t, db.SaveAnalysis(old))
+	resp, body = analyze("key")
+	require.Equal(t, http.StatusOK, resp.Code)
+	stored, err = db.GetAnalysis(body["analysis_id"].(string))
+	require.NoError(t, err)
+	assert.NotEmpty(t, stored.Image)
+	old, err = db.GetAnalysis("old")
+	require.NoError(t, err)
+	assert.Empty(t, old.Image)
+
+	// Revisions go back to the provider that made the analysis, by name
+	service, err := factory.GetServiceByName("Mock", "")
+	require.NoError(t, err)
+	assert.Equal(t, "default", service.(*services.MockImageAnalysisService).ModelType)
+	_, err = factory.GetServiceByName("retired", "")
+	assert.Equal(t, apperrors.ProviderUnavailable, apperrors.CodeOf(err))
+}
@@ -0,0 +1,205 @@
+package tests
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"dietsense/internal/api"
+	"dietsense/internal/middleware"
+	"dietsense/internal/models"
+	"dietsense/internal/nutrition"
+	"dietsense/internal/repositories"
+	"dietsense/internal/repositories/sqlite"
+	"dietsense/internal/services"
+	"dietsense/pkg/config"
+	"dietsense/pkg/logging"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamDecoder(t *testing.T) {
+	answer := "```json\n" + `{
+  "summary": "Crème brûlée with a \"burnt\" top\n🍮 and éclair 🍰",
+  "nutrition": [
+    {"component": "Calories", "value": 320, "unit": "kcal", "confidence": 0.8},
+    {"component": "Sugars", "value": 28, "unit": "g", "confidence": 0.6, "note": "} inside a string"},
+    {"component": "Sodium", "value": "0.1 g", "confidence": 0.5}
+  ],
+  "ingredients": ["cream", "sugar"]
+}` + "\n```"
+
+	// Splitting the answer at every byte must not change what is decoded
+	var decoder services.StreamDecoder
+	var summary strings.Builder
+	nutrients := nutrition.Facts{}
+	var order []int
+	for i := 0; i < len(answer); i++ {
+		update := decoder.Write(answer[i : i+1])
+		summary.WriteString(update.Summary)
+		for id, amount := range update.Nutrients {
+			nutrients[id] = amount
+			order = append(order, i)
+		}
+	}
+
+	assert.Equal(t, "Crème brûlée with a \"burnt\" top\n🍮 and éclair 🍰", summary.String())
+	require.Len(t, nutrients, 3)
+	assert.Equal(t, 320.0, nutrients["calories"].Value)
+	assert.Equal(t, 28.0, nutrients["sugars"].Value, "braces inside strings do not close the entry")
+	assert.Equal(t, 100.0, nutrients["sodium"].Value, "values are normalized to the catalog unit")
+	assert.Less(t, order[0], strings.Index(answer, "Sugars"), "a nutrient is reported as soon as its entry closes")
+}
+
+// sseEvent is one server-sent event of a streamed analysis.
+type sseEvent struct {
+	name string
+	data string
+}
+
+func readEvents(t *testing.T, body string) []sseEvent {
+	var events []sseEvent
+	var current sseEvent
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event:"):
+			current.name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			current.data = strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		case line == "" && current.name != "":
+			events = append(events, current)
+			current = sseEvent{}
+		}
+	}
+	require.NoError(t, scanner.Err())
+	return events
+}
+
+func TestAnalyzeFoodStream(t *testing.T) {
+	logging.Setup()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	factory := services.NewServiceFactory(&config.AppConfig{
+		ImageClassifierService:   "mock",
+		FoodImageAnalyzerService: "mock",
+		MockServiceType:          "default",
+	})
+	api.SetupRoutes(router, factory, nil, nil)
+
+	stream := func() *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		file, _ := form.CreateFormFile("image", "salad.jpg")
+		file.Write([]byte("not really a jpeg"))
+		form.Close()
+		req, _ := http.NewRequest("POST", "/api/v1/analyze/stream", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp
+	}
+
+	resp := stream()
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Contains(t, resp.Header().Get("Content-Type"), "text/event-stream")
+
+	events := readEvents(t, resp.Body.String())
+	require.NotEmpty(t, events)
+	assert.Equal(t, "classified", events[0].name)
+	assert.JSONEq(t, `{"input_type": 1, "images": [{"index": 0, "input_type": 1, "classified_by": "classifier"}]}`, events[0].data)
+	assert.Equal(t, "analyzing", events[1].name)
+	assert.Equal(t, "result", events[len(events)-1].name)
+
+	var summary strings.Builder
+	nutrients := map[string]float64{}
+	for _, event := range events[2 : len(events)-1] {
+		var data map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(event.data), &data))
+		switch event.name {
+		case "summary":
+			summary.WriteString(data["text"].(string))
+		case "nutrient":
+			nutrients[data["id"].(string)] = data["value"].(float64)
+		default:
+			t.Fatalf("unexpected event %q", event.name)
+		}
+	}
+
+	// The pieces add up to the final result
+	var result struct {
+		Summary       string          `json:"summary"`
+		NutritionInfo nutrition.Facts `json:"nutrition_info"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(events[len(events)-1].data), &result))
+	assert.Equal(t, result.Summary, summary.String())
+	require.Len(t, nutrients, len(result.NutritionInfo))
+	for id, amount := range result.NutritionInfo {
+		assert.Equal(t, amount.Value, nutrients[id], id)
+	}
+
+	// The mock streams the same events every time
+	events2 := readEvents(t, stream().Body.String())
+	require.Len(t, events2, len(events))
+	for i := range events[:len(events)-1] {
+		assert.Equal(t, events[i], events2[i])
+	}
+}
+
+// disconnectingRecorder cancels the request, as a client hanging up would,
+// once the named event has been written.
+type disconnectingRecorder struct {
+	*httptest.ResponseRecorder
+	event  string
+	cancel context.CancelFunc
+}
+
+func (r *disconnectingRecorder) Write(data []byte) (int, error) {
+	n, err := r.ResponseRecorder.Write(data)
+	if strings.Contains(r.Body.String(), "event:"+r.event+"\n") {
+		r.cancel()
+	}
+	return n, err
+}
+
+func TestAnalyzeFoodStreamDisconnect(t *testing.T) {
+	logging.Setup()
+	gin.SetMode(gin.TestMode)
+
+	db, err := sqlite.NewSQLiteDB("file:" + t.TempDir() + "/stream.db")
+	require.NoError(t, err)
+	require.NoError(t, db.SaveAPIKey(&models.APIKey{Key: "key"}))
+
+	router := gin.New()
+	factory := services.NewServiceFactory(&config.AppConfig{TextAnalyzerService: "mock", MockServiceType: "default"})
+	api.SetupRoutes(router, factory, db, nil)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("context", "a bowl of porridge")
+	form.WriteField("save", "true")
+	form.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "POST", "/api/v1/analyze/stream", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	req.Header.Set(middleware.APIKeyHeader, "key")
+	resp := &disconnectingRecorder{ResponseRecorder: httptest.NewRecorder(), event: "analyzing", cancel: cancel}
+	router.ServeHTTP(resp, req)
+
+	// Streaming stops and the abandoned analysis is not saved
+	events := readEvents(t, resp.Body.String())
+	require.NotEmpty(t, events)
+	assert.Equal(t, "analyzing", events[len(events)-1].name)
+	entries, err := db.ListFoodLogEntries("key", repositories.FoodLogFilter{})
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,149 @@
+package tests
+
+import (
+	"dietsense/internal/api"
+	"dietsense/internal/middleware"
+	"dietsense/internal/models"
+	"dietsense/internal/nutrition"
+	"dietsense/internal/repositories/sqlite"
+	"dietsense/internal/services"
+	"dietsense/pkg/config"
+	"dietsense/pkg/logging"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalendarDaysFollowDaylightSaving(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	require.NoError(t, err)
+
+	from := time.Date(2026, 10, 24, 0, 0, 0, 0, paris)
+	days := services.CalendarDays(from, from.AddDate(0, 0, 3), paris)
+	require.Len(t, days, 3)
+	assert.Equal(t, "2026-10-25", days[1].Label)
+	assert.Equal(t, 25*time.Hour, days[1].End.Sub(days[1].Start), "The day clocks go back lasts 25 hours")
+}
+
+func TestSummarizeFoodLogByWeek(t *testing.T) {
+	db, err := sqlite.NewSQLiteDB("file:" + t.TempDir() + "/summary.db")
+	require.NoError(t, err)
+
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	require.NoError(t, err)
+	logEntry := func(id, mealType string, eatenAt time.Time, calories float64) {
+		entry := &models.FoodLogEntry{ID: id, APIKey: "key", MealType: mealType, EatenAt: eatenAt.UTC()}
+		entry.SetFacts(nutrition.Facts{"calories": {Name: "Calories", Value: calories, Unit: nutrition.UnitKcal, Confidence: 0.8}})
+		require.NoError(t, db.SaveFoodLogEntry(entry))
+	}
+	logEntry("a", "breakfast", time.Date(2026, 10, 12, 8, 0, 0, 0, tokyo), 400)
+	logEntry("b", "lunch", time.Date(2026, 10, 13, 12, 0, 0, 0, tokyo), 600)
+	logEntry("c", "", time.Date(2026, 10, 13, 15, 0, 0, 0, tokyo), 200)
+	logEntry("d", "dinner", time.Date(2026, 10, 18, 20, 0, 0, 0, tokyo), 800)
+	logEntry("e", "dinner", time.Date(2026, 10, 19, 0, 30, 0, 0, tokyo), 300) // Still Sunday in UTC, but next week in Tokyo
+	require.NoError(t, db.SaveFoodLogEntry(&models.FoodLogEntry{ID: "other", APIKey: "other", EatenAt: time.Date(2026, 10, 13, 3, 0, 0, 0, time.UTC)}))
+
+	from := time.Date(2026, 10, 12, 0, 0, 0, 0, tokyo)
+	days := services.CalendarDays(from, from.AddDate(0, 0, 14), tokyo)
+	totals, err := db.SumFoodLog("key", days)
+	require.NoError(t, err)
+
+	summaries := services.SummarizeNutrition(services.PeriodWeek, days, totals)
+	require.Len(t, summaries, 2)
+
+	week := summaries[0]
+	assert.Equal(t, "2026-10-12", week.Start)
+	assert.Equal(t, "2026-10-18", week.End)
+	assert.Equal(t, 4, week.Entries)
+	assert.Equal(t, 3, week.DaysLogged)
+	assert.Equal(t, 2000.0, week.Totals["calories"].Value)
+	assert.InDelta(t, 666.667, week.DailyAverage["calories"].Value, 0.001)
+	assert.Equal(t, 800.0, week.Meals["dinner"].Totals["calories"].Value)
+	assert.Equal(t, 1, week.Meals[services.UnassignedMeal].Entries)
+
+	assert.Equal(t, 1, summaries[1].Entries)
+	assert.Equal(t, 300.0, summaries[1].Totals["calories"].Value)
+
+	overall := services.CombineSummaries(summaries)
+	assert.Equal(t, 5, overall.Entries)
+	assert.Equal(t, 2300.0, overall.Totals["calories"].Value)
+	assert.Equal(t, 550.0, overall.Meals["dinner"].Average["calories"].Value)
+}
+
+func TestSummaryRangeLimit(t *testing.T) {
+	logging.Setup()
+	gin.SetMode(gin.TestMode)
+
+	db, err := sqlite.NewSQLiteDB("file:" + t.TempDir() + "/summary.db")
+	require.NoError(t, err)
+	require.NoError(t, db.SaveAPIKey(&models.APIKey{Key: "key"}))
+	router := gin.New()
+	api.SetupRoutes(router, services.NewServiceFactory(&config.AppConfig{}), db, nil)
+
+	summarize := func(query string) int {
+		req := httptest.NewRequest("GET", "/api/v1/summaries?"+query, nil)
+		req.Header.Set(middleware.APIKeyHeader, "key")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	assert.Equal(t, http.StatusOK, summarize("from=2025-01-01&to=2026-02-04&tz=Europe/Paris"), "400 days")
+	assert.Equal(t, http.StatusBadRequest, summarize("from=2025-01-01&to=2026-02-05&tz=Europe/Paris"), "401 days")
+
+	// Huge ranges are refused without listing their days
+	start := time.Now()
+	assert.Equal(t, http.StatusBadRequest, summarize("from=0001-01-01&to=9999-12-31"))
+	assert.Less(t, time.Since(start), time.Second)
+}
+
+func TestSavedTimeZone(t *testing.T) {
+	logging.Setup()
+	gin.SetMode(gin.TestMode)
+
+	db, err := sqlite.NewSQLiteDB("file:" + t.TempDir() + "/time_zone.db")
+	require.NoError(t, err)
+	require.NoError(t, db.SaveAPIKey(&models.APIKey{Key: "key"}))
+	require.NoError(t, db.SaveUserConfig("key", &models.UserConfig{UserID: "key", TimeZone: "Asia/Tokyo"}))
+	router := gin.New()
+	api.SetupRoutes(router, services.NewServiceFactory(&config.AppConfig{}), db, nil)
+
+	// Eaten on the evening of March 1 in UTC, the morning of March 2 in Tokyo
+	entry := &models.FoodLogEntry{ID: "breakfast", APIKey: "key", MealType: "breakfast", Summary: "Rice and miso soup"}
+	entry.SetEatenAt(time.Date(2026, 3, 1, 22, 0, 0, 0, time.UTC))
+	entry.SetFacts(nutrition.Facts{"calories": {Name: "Calories", Value: 400, Unit: nutrition.UnitKcal, Confidence: 0.8}})
+	require.NoError(t, db.SaveFoodLogEntry(entry))
+
+	get := func(path string) map[string]interface{} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set(middleware.APIKeyHeader, "key")
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+		var body map[string]interface{}
+		require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		return body
+	}
+
+	// Without tz, every day-based endpoint counts days in the saved zone
+	summaries := get("/api/v1/summaries?from=2026-03-02&to=2026-03-02")
+	assert.Equal(t, "Asia/Tokyo", summaries["time_zone"])
+	assert.Equal(t, 1.0, summaries["overall"].(map[string]interface{})["entries"])
+	progress := get("/api/v1/goals/progress?date=2026-03-02")
+	assert.Equal(t, "Asia/Tokyo", progress["time_zone"])
+	assert.Equal(t, 1.0, progress["entries"])
+	assert.Len(t, get("/api/v1/logs?from=2026-03-02&to=2026-03-02")["entries"], 1)
+	assert.Equal(t, "Asia/Tokyo", get("/api/v1/insights")["time_zone"])
+
+	// The query parameter still wins
+	summaries = get("/api/v1/summaries?from=2026-03-02&to=2026-03-02&tz=UTC")
+	assert.Equal(t, "UTC", summaries["time_zone"])
+	assert.Equal(t, 0.0, summaries["overall"].(map[string]interface{})["entries"])
+	assert.Empty(t, get("/api/v1/logs?from=2026-03-02&to=2026-03-02&tz=UTC")["entries"])
+}